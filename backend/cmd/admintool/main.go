@@ -0,0 +1,262 @@
+// Command admintool runs administrative tasks against the database directly,
+// for jobs too large for the admin pages:
+//
+//	admintool import-users [-dry-run] [-passwords email|generate] [-out file] users.csv
+//	admintool export-users [-type student] [-department CS] [-out file]
+//	admintool check-data [-fix] [-json]
+//
+// It reads MONGODB_URI, DB_NAME and the mail settings from the environment or
+// a .env file, like the server.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"classscheduling/controllers"
+	"classscheduling/mailer"
+	"classscheduling/models"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: admintool <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  import-users   create and update users from a CSV file")
+	fmt.Fprintln(os.Stderr, "  export-users   write users as CSV")
+	fmt.Fprintln(os.Stderr, "  check-data     report data consistency problems, and repair them with -fix")
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "import-users":
+		importUsers(os.Args[2:])
+	case "export-users":
+		exportUsers(os.Args[2:])
+	case "check-data":
+		checkData(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+// connect opens the database the server uses
+func connect(ctx context.Context) *mongo.Database {
+	_ = godotenv.Load()
+
+	mongoURI := os.Getenv("MONGODB_URI")
+	if mongoURI == "" {
+		mongoURI = "mongodb://localhost:27017"
+	}
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		log.Fatal(err)
+	}
+
+	dbName := os.Getenv("DB_NAME")
+	if dbName == "" {
+		dbName = "classscheduling"
+	}
+	return client.Database(dbName)
+}
+
+// newMailer builds the mailer configured in the environment; call it after
+// connect, which loads .env
+func newMailer() mailer.Mailer {
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return mail
+}
+
+// output opens the file named by -out, or stdout when there is none
+func output(path string) io.WriteCloser {
+	if path == "" {
+		return os.Stdout
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return file
+}
+
+func importUsers(args []string) {
+	flags := flag.NewFlagSet("import-users", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "check the file without changing anything")
+	passwords := flags.String("passwords", controllers.ImportPasswordsEmail, "email new users a set-password link, or generate temporary passwords")
+	out := flags.String("out", "", "where to write generated passwords (default stdout)")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("usage: admintool import-users [flags] users.csv")
+	}
+	if *passwords != controllers.ImportPasswordsEmail && *passwords != controllers.ImportPasswordsGenerate {
+		log.Fatal("-passwords must be email or generate")
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	rows, err := controllers.ParseUserCSV(file)
+	file.Close()
+	if err != nil {
+		log.Fatalf("%s: %v", flags.Arg(0), err)
+	}
+
+	ctx := context.Background()
+	connectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	db := connect(connectCtx)
+
+	report, err := controllers.NewUserImportController(db, newMailer()).Import(ctx, rows, controllers.UserImportOptions{
+		DryRun:    *dryRun,
+		Passwords: *passwords,
+		Enroll:    true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, row := range report.Rows {
+		for _, message := range row.Errors {
+			log.Printf("line %d: error: %s", row.Line, message)
+		}
+		for _, message := range row.Warnings {
+			log.Printf("line %d: warning: %s", row.Line, message)
+		}
+	}
+	switch {
+	case !report.Valid:
+		log.Fatalf("%d rows have errors, so nothing was imported", report.Failed)
+	case report.DryRun:
+		log.Printf("dry run: %d users would be created and %d updated", report.Created, report.Updated)
+		return
+	default:
+		log.Printf("%d users created, %d updated, %d failed", report.Created, report.Updated, report.Failed)
+	}
+
+	if *passwords == controllers.ImportPasswordsGenerate && report.Created > 0 {
+		w := output(*out)
+		defer w.Close()
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"username", "temporaryPassword"})
+		for _, row := range report.Rows {
+			if row.TemporaryPassword != "" {
+				writer.Write([]string{row.Username, row.TemporaryPassword})
+			}
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func exportUsers(args []string) {
+	flags := flag.NewFlagSet("export-users", flag.ExitOnError)
+	userType := flags.String("type", "", "only export users of this type")
+	department := flags.String("department", "", "only export users in the department with this code")
+	out := flags.String("out", "", "where to write the CSV (default stdout)")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	connectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	db := connect(connectCtx)
+
+	filter := bson.M{"userType": bson.M{"$ne": models.UserTypeService}}
+	if *userType != "" {
+		filter["userType"] = *userType
+	}
+	if *department != "" {
+		var found models.Department
+		err := db.Collection("departments").FindOne(ctx, bson.M{"code": strings.ToUpper(*department)}).Decode(&found)
+		if err != nil {
+			log.Fatalf("department %s: %v", *department, err)
+		}
+		filter["departmentId"] = found.ID
+	}
+
+	w := output(*out)
+	defer w.Close()
+	err := controllers.NewUserImportController(db, newMailer()).Export(ctx, w, filter, bson.D{{Key: "username", Value: 1}})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func checkData(args []string) {
+	flags := flag.NewFlagSet("check-data", flag.ExitOnError)
+	fix := flags.Bool("fix", false, "repair the problems that can be repaired safely")
+	asJSON := flags.Bool("json", false, "write the full report as JSON")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	connectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	db := connect(connectCtx)
+
+	report, err := controllers.NewConsistencyController(db).Check(ctx, controllers.ConsistencyOptions{Fix: *fix})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		for _, check := range report.Checks {
+			if check.Count == 0 {
+				continue
+			}
+			log.Printf("%s: %d (%s)", check.Name, check.Count, check.Description)
+			for _, v := range check.Violations {
+				log.Printf("  %s %s: %s", v.Collection, v.ID.Hex(), v.Detail)
+			}
+			if more := check.Count - len(check.Violations); more > 0 {
+				log.Printf("  ... and %d more", more)
+			}
+			if *fix && check.Fixable {
+				log.Printf("  repaired %d documents", check.Fixed)
+			}
+		}
+		log.Printf("%d violations found", report.Violations)
+	}
+
+	// Exit non-zero while anything is left for a person to look at
+	for _, check := range report.Checks {
+		if check.Count > 0 && (!*fix || !check.Fixable) {
+			os.Exit(1)
+		}
+	}
+}
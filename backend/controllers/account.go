@@ -0,0 +1,345 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"classscheduling/mailer"
+	"classscheduling/middleware"
+	"classscheduling/models"
+)
+
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 48 * time.Hour
+	accountSetupTTL      = 7 * 24 * time.Hour
+
+	// Emails that can be requested per address and per IP in mailRateWindow,
+	// so the endpoints cannot be used to flood a mailbox
+	mailRateWindow       = time.Hour
+	mailAddressRateLimit = 3
+	mailIPRateLimit      = 20
+)
+
+var errInvalidUserToken = errors.New("invalid or expired token")
+
+// appURL returns the public base URL used in emailed links
+func appURL() string {
+	url := os.Getenv("APP_URL")
+	if url == "" {
+		url = "http://localhost:3000"
+	}
+	return strings.TrimRight(url, "/")
+}
+
+// emailVerificationRequired reports whether unverified users are blocked from logging in
+func emailVerificationRequired() bool {
+	return os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true"
+}
+
+// createUserToken invalidates earlier tokens of the same type for the user
+// and stores a new one, returning the plain token to be emailed
+func createUserToken(ctx context.Context, db *mongo.Database, userID primitive.ObjectID, tokenType string, ttl time.Duration) (string, error) {
+	_, err := db.Collection("user_tokens").DeleteMany(ctx, bson.M{
+		"userId": userID,
+		"type":   tokenType,
+		"usedAt": bson.M{"$exists": false},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	token, err := middleware.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = db.Collection("user_tokens").InsertOne(ctx, models.UserToken{
+		UserID:    userID,
+		Type:      tokenType,
+		TokenHash: middleware.HashToken(token),
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeUserToken marks a token as used and returns it, failing if it is
+// unknown, expired or already used
+func consumeUserToken(ctx context.Context, db *mongo.Database, token, tokenType string) (*models.UserToken, error) {
+	now := time.Now()
+	var stored models.UserToken
+	err := db.Collection("user_tokens").FindOneAndUpdate(ctx,
+		bson.M{
+			"tokenHash": middleware.HashToken(token),
+			"type":      tokenType,
+			"usedAt":    bson.M{"$exists": false},
+			"expiresAt": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"usedAt": now}},
+	).Decode(&stored)
+	if err == mongo.ErrNoDocuments {
+		return nil, errInvalidUserToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// sendVerificationEmail emails a fresh verification link to the user
+func sendVerificationEmail(ctx context.Context, db *mongo.Database, mail mailer.Mailer, user models.User) error {
+	token, err := createUserToken(ctx, db, user.ID, "email_verification", emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/frontend/verify-email.html?token=%s", appURL(), token)
+	return mail.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nPlease confirm your email address by opening the link below:\n\n%s\n\nThe link expires in %d hours.\n",
+			user.Username, link, int(emailVerificationTTL.Hours())),
+	})
+}
+
+// sendPasswordResetEmail emails a password reset link to the user
+func sendPasswordResetEmail(ctx context.Context, db *mongo.Database, mail mailer.Mailer, user models.User) error {
+	token, err := createUserToken(ctx, db, user.ID, "password_reset", passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/frontend/reset-password.html?token=%s", appURL(), token)
+	return mail.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nSomeone asked to reset the password for your account. To choose a new password, open the link below:\n\n%s\n\nThe link expires in %d minutes. If you did not ask for this, you can ignore this email.\n",
+			user.Username, link, int(passwordResetTTL.Minutes())),
+	})
+}
+
+// sendAccountSetupEmail emails a user whose account was created for them a
+// link to choose their first password
+func sendAccountSetupEmail(ctx context.Context, db *mongo.Database, mail mailer.Mailer, user models.User) error {
+	token, err := createUserToken(ctx, db, user.ID, "password_reset", accountSetupTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/frontend/reset-password.html?token=%s", appURL(), token)
+	return mail.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Set up your account",
+		Body: fmt.Sprintf("Hello %s,\n\nAn account has been created for you with the username %s. To choose a password, open the link below:\n\n%s\n\nThe link expires in %d days.\n",
+			user.Username, user.Username, link, int(accountSetupTTL.Hours()/24)),
+	})
+}
+
+// checkMailRate applies the per-address and per-IP limits on requesting an
+// email of the given kind and writes a 429 response when either is exceeded
+func (ac *AuthController) checkMailRate(c *gin.Context, kind, email string) bool {
+	keys := []struct {
+		key   string
+		limit int
+	}{
+		{"mail:" + kind + ":ip:" + c.ClientIP(), mailIPRateLimit},
+		{"mail:" + kind + ":address:" + strings.ToLower(email), mailAddressRateLimit},
+	}
+
+	for _, k := range keys {
+		allowed, retryAfter, err := ac.limiter.Allow(context.Background(), k.key, k.limit, mailRateWindow)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return false
+		}
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests. Please try again later"})
+			return false
+		}
+	}
+	return true
+}
+
+// ForgotPassword emails a password reset link. The response is the same
+// whether or not the email is registered so that accounts cannot be probed.
+func (ac *AuthController) ForgotPassword(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if !ac.checkMailRate(c, "password_reset", input.Email) {
+		return
+	}
+
+	ctx := context.Background()
+	var user models.User
+	err := ac.db.Collection("users").FindOne(ctx, bson.M{"email": input.Email, "deletedAt": nil}).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err == nil && !user.Disabled {
+		if err := sendPasswordResetEmail(ctx, ac.db, ac.mail, user); err != nil {
+			log.Printf("Error sending password reset email to user %s: %v", user.ID.Hex(), err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the email is registered, a password reset link has been sent"})
+}
+
+// ResetPassword sets a new password using a token from a reset email and
+// signs the user out everywhere
+func (ac *AuthController) ResetPassword(c *gin.Context) {
+	var input struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required,min=6"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	ctx := context.Background()
+	token, err := consumeUserToken(ctx, ac.db, input.Token, "password_reset")
+	if err != nil {
+		if err == errInvalidUserToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	user := models.User{Password: input.Password}
+	if err := user.HashPassword(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error hashing password"})
+		return
+	}
+
+	// Receiving the reset email also proves ownership of the address
+	now := time.Now()
+	result, err := ac.db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": token.UserID, "deletedAt": nil},
+		bson.M{
+			"$set": bson.M{
+				"password":      user.Password,
+				"emailVerified": true,
+				"failedLogins":  0,
+				"updatedAt":     now,
+			},
+			// The lockout guarded the old password
+			"$unset": bson.M{"lockedUntil": ""},
+		},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating password"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+
+	if err := revokeSessions(ctx, ac.db, bson.M{"userId": token.UserID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password updated but there was an error revoking existing sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+// VerifyEmail confirms a user's email address using a token from a verification email
+func (ac *AuthController) VerifyEmail(c *gin.Context) {
+	var input struct {
+		Token string `json:"token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	ctx := context.Background()
+	token, err := consumeUserToken(ctx, ac.db, input.Token, "email_verification")
+	if err != nil {
+		if err == errInvalidUserToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	now := time.Now()
+	_, err = ac.db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": token.UserID, "deletedAt": nil},
+		bson.M{"$set": bson.M{
+			"emailVerified":   true,
+			"emailVerifiedAt": now,
+			"updatedAt":       now,
+		}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error verifying email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+// ResendVerification emails a new verification link. Like ForgotPassword it
+// does not reveal whether the email is registered.
+func (ac *AuthController) ResendVerification(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if !ac.checkMailRate(c, "email_verification", input.Email) {
+		return
+	}
+
+	ctx := context.Background()
+	var user models.User
+	err := ac.db.Collection("users").FindOne(ctx, bson.M{"email": input.Email, "deletedAt": nil}).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err == nil && !user.EmailVerified && !user.Disabled {
+		if err := sendVerificationEmail(ctx, ac.db, ac.mail, user); err != nil {
+			log.Printf("Error sending verification email to user %s: %v", user.ID.Hex(), err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the email is registered and unverified, a verification link has been sent"})
+}
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"classscheduling/models"
+)
+
+// recordActivity writes an entry to the audit log. Failures are logged rather
+// than returned so that auditing never blocks the action being audited.
+func recordActivity(db *mongo.Database, c *gin.Context, entry models.Activity) {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	if c != nil {
+		if entry.IP == "" {
+			entry.IP = c.ClientIP()
+		}
+		if entry.ActorID == nil {
+			if actorID, ok := c.Get("userId"); ok {
+				id := actorID.(primitive.ObjectID)
+				entry.ActorID = &id
+			}
+		}
+	}
+
+	if _, err := db.Collection("activity").InsertOne(context.Background(), entry); err != nil {
+		log.Printf("Error recording %s activity: %v", entry.Type, err)
+	}
+}
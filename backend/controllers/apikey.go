@@ -0,0 +1,407 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+
+	"classscheduling/middleware"
+	"classscheduling/models"
+)
+
+const (
+	// personalKeyDefaultDays is the lifetime of personal API keys created
+	// without an explicit one; personal keys cannot exceed personalKeyMaxDays
+	personalKeyDefaultDays = 90
+	personalKeyMaxDays     = 365
+)
+
+type APIKeyController struct {
+	db *mongo.Database
+}
+
+func NewAPIKeyController(db *mongo.Database) *APIKeyController {
+	return &APIKeyController{db: db}
+}
+
+type apiKeyRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+// createKey stores a new key for the user and writes it to the response. The
+// key itself is only ever shown here.
+func (kc *APIKeyController) createKey(c *gin.Context, owner models.User, input apiKeyRequest, expiresAt *time.Time) {
+	secret, err := middleware.NewOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating API key"})
+		return
+	}
+	key := models.APIKeyPrefix + secret
+
+	creatorID, _ := c.Get("userId")
+	apiKey := models.APIKey{
+		UserID:    owner.ID,
+		Name:      strings.TrimSpace(input.Name),
+		Prefix:    key[:len(models.APIKeyPrefix)+8],
+		KeyHash:   middleware.HashToken(key),
+		Scopes:    dedupe(input.Scopes),
+		ExpiresAt: expiresAt,
+		CreatedBy: creatorID.(primitive.ObjectID),
+		CreatedAt: time.Now(),
+	}
+
+	result, err := kc.db.Collection("api_keys").InsertOne(context.Background(), apiKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating API key"})
+		return
+	}
+	apiKey.ID = result.InsertedID.(primitive.ObjectID)
+
+	recordActivity(kc.db, c, models.Activity{
+		Type:        "api_key_created",
+		UserID:      &owner.ID,
+		Username:    owner.Username,
+		Description: fmt.Sprintf("API key %q (%s) was created for %s", apiKey.Name, apiKey.Prefix, owner.Username),
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"apiKey": apiKey,
+		"key":    key,
+	})
+}
+
+// listKeys returns the keys of a user, newest first
+func (kc *APIKeyController) listKeys(c *gin.Context, userID primitive.ObjectID) {
+	ctx := context.Background()
+	cursor, err := kc.db.Collection("api_keys").Find(ctx,
+		bson.M{"userId": userID},
+		options.Find().SetSort(bson.M{"createdAt": -1}),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching API keys"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	keys := []models.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// revokeKey revokes a key matching the filter
+func (kc *APIKeyController) revokeKey(c *gin.Context, filter bson.M) {
+	now := time.Now()
+	filter["revokedAt"] = bson.M{"$exists": false}
+
+	var apiKey models.APIKey
+	err := kc.db.Collection("api_keys").FindOneAndUpdate(context.Background(),
+		filter,
+		bson.M{"$set": bson.M{"revokedAt": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&apiKey)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error revoking API key"})
+		return
+	}
+
+	username, _ := c.Get("username")
+	recordActivity(kc.db, c, models.Activity{
+		Type:        "api_key_revoked",
+		UserID:      &apiKey.UserID,
+		Username:    username.(string),
+		Description: fmt.Sprintf("API key %q (%s) was revoked", apiKey.Name, apiKey.Prefix),
+	})
+
+	c.JSON(http.StatusOK, apiKey)
+}
+
+// GetMyAPIKeys returns the current user's personal API keys
+func (kc *APIKeyController) GetMyAPIKeys(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	kc.listKeys(c, userID.(primitive.ObjectID))
+}
+
+// CreateMyAPIKey creates a personal API key. Its scopes must be permissions
+// the user holds, and it expires after at most personalKeyMaxDays.
+func (kc *APIKeyController) CreateMyAPIKey(c *gin.Context) {
+	var input apiKeyRequest
+	if err := c.ShouldBindJSON(&input); err != nil || strings.TrimSpace(input.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A name and at least one scope are required"})
+		return
+	}
+
+	for _, scope := range input.Scopes {
+		if !middleware.HasPermission(c, scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("You do not have the permission %q", scope)})
+			return
+		}
+	}
+
+	days := input.ExpiresInDays
+	if days == 0 {
+		days = personalKeyDefaultDays
+	}
+	if days < 0 || days > personalKeyMaxDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Personal API keys must expire within %d days", personalKeyMaxDays)})
+		return
+	}
+	expiresAt := time.Now().AddDate(0, 0, days)
+
+	userID, _ := c.Get("userId")
+	username, _ := c.Get("username")
+	kc.createKey(c, models.User{ID: userID.(primitive.ObjectID), Username: username.(string)}, input, &expiresAt)
+}
+
+// RevokeMyAPIKey revokes one of the current user's API keys
+func (kc *APIKeyController) RevokeMyAPIKey(c *gin.Context) {
+	keyID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	userID, _ := c.Get("userId")
+	kc.revokeKey(c, bson.M{"_id": keyID, "userId": userID.(primitive.ObjectID)})
+}
+
+// GetServiceAccounts returns the service accounts the admin can manage
+func (kc *APIKeyController) GetServiceAccounts(c *gin.Context) {
+	ctx := context.Background()
+	cursor, err := kc.db.Collection("users").Find(ctx,
+		scopeFilter(c, bson.M{"userType": models.UserTypeService, "deletedAt": nil}),
+		options.Find().SetSort(bson.M{"username": 1}).SetProjection(bson.M{"password": 0}),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching service accounts"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	accounts := []models.User{}
+	if err := cursor.All(ctx, &accounts); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding service accounts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, accounts)
+}
+
+// CreateServiceAccount creates a user for an integration. Service accounts
+// have no usable password and authenticate only with API keys. They hold the
+// service role unless an admin who manages roles picks others.
+func (kc *APIKeyController) CreateServiceAccount(c *gin.Context) {
+	var input struct {
+		Username     string              `json:"username" binding:"required"`
+		Email        string              `json:"email" binding:"required,email"`
+		Description  string              `json:"description"`
+		Roles        []string            `json:"roles"`
+		DepartmentID *primitive.ObjectID `json:"departmentId"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	ctx := context.Background()
+	roles := []string{models.UserTypeService}
+	if len(input.Roles) > 0 {
+		if !middleware.HasPermission(c, models.PermRolesManage) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only admins who manage roles can choose a service account's roles"})
+			return
+		}
+		roles = dedupe(input.Roles)
+		cursor, err := kc.db.Collection("roles").Find(ctx, bson.M{"name": bson.M{"$in": roles}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		var found []models.Role
+		if err := cursor.All(ctx, &found); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if len(found) != len(roles) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+			return
+		}
+		if !canGrantRoles(c, found) {
+			return
+		}
+	}
+
+	department, ok := resolveDepartment(c, kc.db, input.DepartmentID)
+	if !ok {
+		return
+	}
+
+	count, err := kc.db.Collection("users").CountDocuments(ctx, bson.M{
+		"$or": []bson.M{{"username": input.Username}, {"email": input.Email}},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username or email already taken"})
+		return
+	}
+
+	// Nobody knows this password, so the account cannot log in
+	password, err := middleware.NewOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating service account"})
+		return
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating service account"})
+		return
+	}
+
+	now := time.Now()
+	account := models.User{
+		Username:      input.Username,
+		Email:         input.Email,
+		Password:      string(hashedPassword),
+		UserType:      models.UserTypeService,
+		Roles:         roles,
+		Description:   input.Description,
+		DepartmentID:  department,
+		EmailVerified: true,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	result, err := kc.db.Collection("users").InsertOne(ctx, account)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating service account"})
+		return
+	}
+	account.ID = result.InsertedID.(primitive.ObjectID)
+
+	recordActivity(kc.db, c, models.Activity{
+		Type:        "service_account_created",
+		UserID:      &account.ID,
+		Username:    account.Username,
+		Description: fmt.Sprintf("Service account %s was created", account.Username),
+	})
+
+	c.JSON(http.StatusCreated, account)
+}
+
+// findServiceAccount loads the service account in the :id parameter. ok is
+// false once an error has been written.
+func (kc *APIKeyController) findServiceAccount(c *gin.Context) (account models.User, ok bool) {
+	accountID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service account ID"})
+		return account, false
+	}
+
+	err = kc.db.Collection("users").FindOne(context.Background(),
+		scopeFilter(c, bson.M{"_id": accountID, "userType": models.UserTypeService, "deletedAt": nil}),
+	).Decode(&account)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Service account not found"})
+			return account, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching service account"})
+		return account, false
+	}
+	return account, true
+}
+
+// GetServiceAccountKeys returns a service account's API keys
+func (kc *APIKeyController) GetServiceAccountKeys(c *gin.Context) {
+	account, ok := kc.findServiceAccount(c)
+	if !ok {
+		return
+	}
+	kc.listKeys(c, account.ID)
+}
+
+// CreateServiceAccountKey issues an API key for a service account. Scopes
+// must be known permissions; the key can never do more than the account's
+// roles allow. Without expiresInDays the key does not expire.
+func (kc *APIKeyController) CreateServiceAccountKey(c *gin.Context) {
+	account, ok := kc.findServiceAccount(c)
+	if !ok {
+		return
+	}
+
+	var input apiKeyRequest
+	if err := c.ShouldBindJSON(&input); err != nil || strings.TrimSpace(input.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A name and at least one scope are required"})
+		return
+	}
+	for _, scope := range input.Scopes {
+		if _, known := models.Permissions[scope]; !known {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown scope %q", scope)})
+			return
+		}
+	}
+	if input.ExpiresInDays < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expiry"})
+		return
+	}
+
+	var expiresAt *time.Time
+	if input.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, input.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	kc.createKey(c, account, input, expiresAt)
+}
+
+// RevokeAPIKey revokes any API key of a user the admin can manage
+func (kc *APIKeyController) RevokeAPIKey(c *gin.Context) {
+	keyID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	filter := bson.M{"_id": keyID}
+	if departmentScope(c) != nil {
+		// Scoped admins may only revoke keys of users in their department
+		var apiKey models.APIKey
+		err := kc.db.Collection("api_keys").FindOne(context.Background(), filter).Decode(&apiKey)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		count, err := kc.db.Collection("users").CountDocuments(context.Background(), scopeFilter(c, bson.M{"_id": apiKey.UserID}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+	}
+
+	kc.revokeKey(c, filter)
+}
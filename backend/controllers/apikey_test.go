@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"classscheduling/models"
+)
+
+func TestCreateServiceAccountRoleGuard(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	held := map[string]bool{models.PermRolesManage: true, models.PermAPIKeysManage: true, models.PermUsersView: true}
+
+	tests := []struct {
+		name string
+		role models.Role
+	}{
+		{"admin role", models.Role{Name: "admin", Permissions: []string{models.PermUsersView}}},
+		{"permission not held", models.Role{Name: "exporter", Permissions: []string{models.PermUsersView, models.PermReportsExport}}},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			t := mt.T
+			mt.AddMockResponses(found(t, tt.role))
+
+			kc := NewAPIKeyController(mt.Client.Database("test"))
+			router := gin.New()
+			router.POST("/api/admin/service-accounts", func(c *gin.Context) {
+				c.Set("userId", primitive.NewObjectID())
+				c.Set("departmentId", primitive.NewObjectID())
+				c.Set("permissions", held)
+			}, kc.CreateServiceAccount)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/api/admin/service-accounts",
+				strings.NewReader(`{"username": "sync", "email": "sync@example.com", "roles": ["`+tt.role.Name+`"]}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusForbidden {
+				t.Fatalf("status = %d, want 403: %s", rec.Code, rec.Body.String())
+			}
+			if commandSent(mt, "insert", "users") {
+				t.Fatal("the service account was created")
+			}
+		})
+	}
+}
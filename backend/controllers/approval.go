@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"classscheduling/mailer"
+	"classscheduling/models"
+)
+
+// signupApprovalRequired reports whether self-registered accounts of the user
+// type need an admin's approval before they can log in. SIGNUP_APPROVAL is a
+// comma-separated list of user types and defaults to faculty.
+func signupApprovalRequired(userType string) bool {
+	types, ok := os.LookupEnv("SIGNUP_APPROVAL")
+	if !ok {
+		types = "faculty"
+	}
+	for _, t := range strings.Split(types, ",") {
+		if strings.TrimSpace(t) == userType {
+			return true
+		}
+	}
+	return false
+}
+
+type ApprovalController struct {
+	db   *mongo.Database
+	mail mailer.Mailer
+}
+
+func NewApprovalController(db *mongo.Database, mail mailer.Mailer) *ApprovalController {
+	return &ApprovalController{db: db, mail: mail}
+}
+
+// GetApprovals returns the signup approval queue, oldest first. The status
+// query parameter selects pending (default), approved or rejected accounts.
+func (ac *ApprovalController) GetApprovals(c *gin.Context) {
+	status := c.DefaultQuery("status", "pending")
+	if status != "pending" && status != "approved" && status != "rejected" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+		return
+	}
+
+	filter := bson.M{"approvalStatus": status, "deletedAt": nil}
+	if userType := c.Query("type"); userType != "" {
+		filter["userType"] = userType
+	}
+	filter = scopeFilter(c, filter)
+
+	ctx := context.Background()
+	cursor, err := ac.db.Collection("users").Find(ctx, filter,
+		options.Find().
+			SetSort(bson.M{"createdAt": 1}).
+			SetProjection(bson.M{
+				"username":       1,
+				"email":          1,
+				"userType":       1,
+				"rollNumber":     1,
+				"departmentId":   1,
+				"emailVerified":  1,
+				"approvalStatus": 1,
+				"approvalReason": 1,
+				"reviewedBy":     1,
+				"reviewedAt":     1,
+				"createdAt":      1,
+			}),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching approval queue"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding approval queue"})
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+// review records an admin's decision on a pending account and emails the user
+func (ac *ApprovalController) review(c *gin.Context, status, reason string) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	reviewerID, _ := c.Get("userId")
+	reviewer := reviewerID.(primitive.ObjectID)
+	now := time.Now()
+
+	set := bson.M{
+		"approvalStatus": status,
+		"reviewedBy":     reviewer,
+		"reviewedAt":     now,
+		"updatedAt":      now,
+	}
+	update := bson.M{"$set": set}
+	if reason != "" {
+		set["approvalReason"] = reason
+	} else {
+		update["$unset"] = bson.M{"approvalReason": ""}
+	}
+
+	// Only pending accounts can be reviewed, so two admins cannot both decide
+	var user models.User
+	err = ac.db.Collection("users").FindOneAndUpdate(context.Background(),
+		scopeFilter(c, bson.M{"_id": userID, "approvalStatus": "pending", "deletedAt": nil}),
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"password": 0}),
+	).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No pending account with this ID"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating account"})
+		return
+	}
+
+	recordActivity(ac.db, c, models.Activity{
+		Type:        "signup_" + status,
+		UserID:      &user.ID,
+		Username:    user.Username,
+		Description: fmt.Sprintf("%s signup for %s was %s", user.UserType, user.Username, status),
+	})
+
+	var message mailer.Message
+	if status == "approved" {
+		message = mailer.Message{
+			To:      user.Email,
+			Subject: "Your account has been approved",
+			Body: fmt.Sprintf("Hello %s,\n\nYour %s account has been approved. You can now log in at %s/frontend/index.html\n",
+				user.Username, user.UserType, appURL()),
+		}
+	} else {
+		message = mailer.Message{
+			To:      user.Email,
+			Subject: "Your account request was not approved",
+			Body: fmt.Sprintf("Hello %s,\n\nYour request for a %s account was not approved.\n\nReason: %s\n",
+				user.Username, user.UserType, reason),
+		}
+	}
+	if err := ac.mail.Send(context.Background(), message); err != nil {
+		log.Printf("Error sending approval email to user %s: %v", user.ID.Hex(), err)
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// ApproveSignup lets a pending account log in
+func (ac *ApprovalController) ApproveSignup(c *gin.Context) {
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	// The body is optional when approving
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+			return
+		}
+	}
+
+	ac.review(c, "approved", strings.TrimSpace(input.Reason))
+}
+
+// RejectSignup refuses a pending account; a reason is required and shown to
+// the user when they try to log in
+func (ac *ApprovalController) RejectSignup(c *gin.Context) {
+	var input struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil || strings.TrimSpace(input.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required when rejecting an account"})
+		return
+	}
+
+	ac.review(c, "rejected", strings.TrimSpace(input.Reason))
+}
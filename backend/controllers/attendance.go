@@ -9,8 +9,8 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"classscheduling/listing"
 	"classscheduling/models"
 )
 
@@ -43,7 +43,7 @@ func (ac *AttendanceController) MarkAttendance(c *gin.Context) {
 
 	// Verify class exists
 	var class models.Class
-	err = ac.db.Collection("classes").FindOne(context.Background(), bson.M{"_id": classID}).Decode(&class)
+	err = ac.db.Collection("classes").FindOne(context.Background(), bson.M{"_id": classID, "deletedAt": nil}).Decode(&class)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Class not found"})
@@ -73,13 +73,34 @@ func (ac *AttendanceController) MarkAttendance(c *gin.Context) {
 	c.JSON(http.StatusCreated, attendance)
 }
 
-// GetAttendance retrieves attendance records for a class
+// attendanceListSpec is what attendance lists can be filtered and sorted by
+var attendanceListSpec = listing.Spec{
+	Filters: map[string]listing.Field{
+		"classId": {Path: "classId", Kind: listing.ObjectID},
+	},
+	Sorts: map[string]string{
+		"date":  "date",
+		"class": "class.name",
+	},
+	Search:      []string{"class.name"},
+	DefaultSort: "-date",
+}
+
+// GetAttendance retrieves a page of attendance records for a class, newest
+// first
 func (ac *AttendanceController) GetAttendance(c *gin.Context) {
 	classID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class ID"})
 		return
 	}
+	query, ok := parseList(c, listing.Spec{
+		Sorts:       map[string]string{"date": "date"},
+		DefaultSort: attendanceListSpec.DefaultSort,
+	})
+	if !ok {
+		return
+	}
 
 	// Optional date range filter
 	startDate := c.Query("startDate")
@@ -103,93 +124,79 @@ func (ac *AttendanceController) GetAttendance(c *gin.Context) {
 		}
 	}
 
-	opts := options.Find().SetSort(bson.D{{Key: "date", Value: -1}})
-	cursor, err := ac.db.Collection("attendance").Find(context.Background(), filter, opts)
+	var attendance []models.Attendance
+	page, err := query.Find(context.Background(), ac.db.Collection("attendance"), filter, &attendance)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching attendance records"})
 		return
 	}
-	defer cursor.Close(context.Background())
 
-	var attendance []models.Attendance
-	if err := cursor.All(context.Background(), &attendance); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding attendance records"})
-		return
-	}
-
-	c.JSON(http.StatusOK, attendance)
+	c.JSON(http.StatusOK, page)
 }
 
 // GetStudentAttendance retrieves attendance records for a specific student
 func (ac *AttendanceController) GetStudentAttendance(c *gin.Context) {
 	studentID, _ := c.Get("userId")
-	studentObjID := studentID.(primitive.ObjectID)
+	studentAttendance(c, ac.db, studentID.(primitive.ObjectID))
+}
 
-	// Optional class ID filter
-	classID := c.Query("classId")
+// studentAttendance writes a page of the attendance records of a student
+// with their class, optionally filtered by class and by the startDate and
+// endDate query parameters
+func studentAttendance(c *gin.Context, db *mongo.Database, studentObjID primitive.ObjectID) {
+	query, ok := parseList(c, attendanceListSpec)
+	if !ok {
+		return
+	}
 
 	// Optional date range filter
 	startDate := c.Query("startDate")
 	endDate := c.Query("endDate")
 
-	// Build the pipeline for aggregation
-	pipeline := []bson.M{
-		{
-			"$lookup": bson.M{
-				"from":         "classes",
-				"localField":   "classId",
-				"foreignField": "_id",
-				"as":           "class",
-			},
-		},
-		{"$unwind": "$class"},
-	}
-
-	// Add filters to match stage
-	matchStage := bson.M{
+	filter := bson.M{
 		"$or": []bson.M{
 			{"present": studentObjID},
 			{"absent": studentObjID},
 		},
 	}
 
-	if classID != "" {
-		classObjID, err := primitive.ObjectIDFromHex(classID)
-		if err == nil {
-			matchStage["classId"] = classObjID
-		}
-	}
-
 	if startDate != "" && endDate != "" {
 		start, err1 := time.Parse(time.RFC3339, startDate)
 		end, err2 := time.Parse(time.RFC3339, endDate)
 		if err1 == nil && err2 == nil {
-			matchStage["date"] = bson.M{
+			filter["date"] = bson.M{
 				"$gte": start,
 				"$lte": end,
 			}
 		}
 	}
-
-	pipeline = append(pipeline, bson.M{"$match": matchStage})
-
-	// Add sort stage
-	pipeline = append(pipeline, bson.M{
-		"$sort": bson.M{"date": -1},
-	})
-
-	cursor, err := ac.db.Collection("attendance").Aggregate(context.Background(), pipeline)
-	if err != nil {
+	if err := excludeDeletedClasses(context.Background(), db, filter); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching attendance records"})
 		return
 	}
-	defer cursor.Close(context.Background())
+
+	lookup := []bson.M{
+		{
+			"$lookup": bson.M{
+				"from":         "classes",
+				"localField":   "classId",
+				"foreignField": "_id",
+				"as":           "class",
+			},
+		},
+		{"$unwind": "$class"},
+	}
+	join, shape := []bson.M(nil), lookup
+	if query.Uses("class.") {
+		join, shape = lookup, nil
+	}
 
 	var results []bson.M
-	if err := cursor.All(context.Background(), &results); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding attendance records"})
+	page, err := query.Aggregate(context.Background(), db.Collection("attendance"), filter, join, shape, &results)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching attendance records"})
 		return
 	}
 
-	c.JSON(http.StatusOK, results)
+	c.JSON(http.StatusOK, page)
 }
@@ -2,24 +2,35 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 
+	"classscheduling/mailer"
 	"classscheduling/middleware"
 	"classscheduling/models"
+	"classscheduling/ratelimit"
+	"classscheduling/sso"
 )
 
 type AuthController struct {
-	db *mongo.Database
+	db        *mongo.Database
+	mail      mailer.Mailer
+	limiter   ratelimit.Limiter
+	policy    loginPolicy
+	providers map[string]sso.Provider
 }
 
-func NewAuthController(db *mongo.Database) *AuthController {
-	return &AuthController{db: db}
+func NewAuthController(db *mongo.Database, mail mailer.Mailer, limiter ratelimit.Limiter, providers map[string]sso.Provider) *AuthController {
+	return &AuthController{db: db, mail: mail, limiter: limiter, policy: loginPolicyFromEnv(), providers: providers}
 }
 
 type LoginRequest struct {
@@ -37,20 +48,32 @@ func (ac *AuthController) Login(c *gin.Context) {
 	}
 
 	// Validate userType
-	if req.UserType != "admin" && req.UserType != "faculty" && req.UserType != "student" {
+	if !models.ValidUserType(req.UserType) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user type"})
 		return
 	}
 
+	// Rate limit by client IP and by account
+	if !ac.checkLoginRate(c, req.Username) {
+		return
+	}
+
 	// Find user by username and userType
 	var user models.User
 	err := ac.db.Collection("users").FindOne(context.Background(), bson.M{
-		"username": req.Username,
-		"userType": req.UserType,
+		"username":  req.Username,
+		"userType":  req.UserType,
+		"deletedAt": nil,
 	}).Decode(&user)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
+			recordActivity(ac.db, c, models.Activity{
+				Type:        "login_failed",
+				Username:    req.Username,
+				Description: fmt.Sprintf("Failed login for unknown %s %s", req.UserType, req.Username),
+			})
+			time.Sleep(ac.policy.failureDelay(1))
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 			return
 		}
@@ -58,36 +81,101 @@ func (ac *AuthController) Login(c *gin.Context) {
 		return
 	}
 
+	// Refuse locked accounts before looking at the password
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(*user.LockedUntil).Seconds())+1))
+		c.JSON(http.StatusLocked, gin.H{"error": "Account is temporarily locked. Please try again later"})
+		return
+	}
+
 	// Validate password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		failures := ac.recordFailedLogin(c, user)
+		time.Sleep(ac.policy.failureDelay(failures))
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
-	// Generate JWT token
-	token, err := middleware.GenerateToken(user.ID, user.Username, user.UserType)
+	if err := ac.clearFailedLogins(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	ac.finishLogin(c, user)
+}
+
+// finishLogin runs the account checks shared by every way of logging in once
+// the user has been identified, then starts a session or asks for a second
+// factor
+func (ac *AuthController) finishLogin(c *gin.Context, user models.User) {
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	}
+
+	if emailVerificationRequired() && !user.EmailVerified {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Email address has not been verified"})
+		return
+	}
+
+	switch user.ApprovalStatus {
+	case "pending":
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":          "Your account is pending approval by an administrator",
+			"approvalStatus": "pending",
+		})
+		return
+	case "rejected":
+		message := "Your account request was rejected"
+		if user.ApprovalReason != "" {
+			message += ": " + user.ApprovalReason
+		}
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":          message,
+			"approvalStatus": "rejected",
+		})
+		return
+	}
+
+	// Users with two-factor authentication, or whose role requires it, get a
+	// challenge token instead of a session
+	if ac.respondWithChallenge(c, user) {
+		return
+	}
+
+	ac.respondWithSession(c, user)
+}
+
+// respondWithSession starts a session for the user and writes the login response
+func (ac *AuthController) respondWithSession(c *gin.Context, user models.User) {
+	tokens, err := ac.startSession(c, user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"token": token,
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
 		"user": gin.H{
-			"id":       user.ID,
-			"username": user.Username,
-			"email":    user.Email,
-			"userType": user.UserType,
+			"id":            user.ID,
+			"username":      user.Username,
+			"email":         user.Email,
+			"userType":      user.UserType,
+			"emailVerified": user.EmailVerified,
+			"totpEnabled":   user.TOTPEnabled,
 		},
 	})
 }
 
 type SignupRequest struct {
-	Username   string `json:"username" binding:"required"`
-	Email      string `json:"email" binding:"required,email"`
-	Password   string `json:"password" binding:"required,min=6"`
-	UserType   string `json:"userType" binding:"required"`
-	RollNumber string `json:"rollNumber"`
+	Username     string              `json:"username" binding:"required"`
+	Email        string              `json:"email" binding:"required,email"`
+	Password     string              `json:"password" binding:"required,min=6"`
+	UserType     string              `json:"userType" binding:"required"`
+	RollNumber   string              `json:"rollNumber"`
+	DepartmentID *primitive.ObjectID `json:"departmentId"`
 }
 
 // Signup handles new user registration
@@ -98,8 +186,8 @@ func (ac *AuthController) Signup(c *gin.Context) {
 		return
 	}
 
-	// Validate user type
-	if req.UserType != "student" && req.UserType != "faculty" {
+	// Validate user type; admin accounts cannot be self-registered
+	if !models.ValidUserType(req.UserType) || req.UserType == "admin" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user type"})
 		return
 	}
@@ -110,6 +198,19 @@ func (ac *AuthController) Signup(c *gin.Context) {
 		return
 	}
 
+	// Validate department
+	if req.DepartmentID != nil {
+		count, err := ac.db.Collection("departments").CountDocuments(context.Background(), bson.M{"_id": *req.DepartmentID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid department ID"})
+			return
+		}
+	}
+
 	// Check if username is already taken
 	count, err := ac.db.Collection("users").CountDocuments(context.Background(), bson.M{"username": req.Username})
 	if err != nil {
@@ -141,13 +242,17 @@ func (ac *AuthController) Signup(c *gin.Context) {
 
 	// Create new user
 	user := models.User{
-		Username:   req.Username,
-		Email:      req.Email,
-		Password:   string(hashedPassword),
-		UserType:   req.UserType,
-		RollNumber: req.RollNumber,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+		Username:     req.Username,
+		Email:        req.Email,
+		Password:     string(hashedPassword),
+		UserType:     req.UserType,
+		RollNumber:   req.RollNumber,
+		DepartmentID: req.DepartmentID,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+	if signupApprovalRequired(req.UserType) {
+		user.ApprovalStatus = "pending"
 	}
 
 	// Insert user into database
@@ -157,17 +262,55 @@ func (ac *AuthController) Signup(c *gin.Context) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := middleware.GenerateToken(user.ID, user.Username, user.UserType)
+	user.ID = result.InsertedID.(primitive.ObjectID)
+
+	// Send the verification email; a failure here should not undo the signup
+	if err := sendVerificationEmail(context.Background(), ac.db, ac.mail, user); err != nil {
+		log.Printf("Error sending verification email to user %s: %v", user.ID.Hex(), err)
+	}
+
+	if user.ApprovalStatus == "pending" {
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Account created and waiting for approval by an administrator. You will be emailed once it has been reviewed",
+			"user": gin.H{
+				"id":             user.ID,
+				"username":       user.Username,
+				"email":          user.Email,
+				"userType":       user.UserType,
+				"rollNumber":     user.RollNumber,
+				"approvalStatus": user.ApprovalStatus,
+			},
+		})
+		return
+	}
+
+	if emailVerificationRequired() {
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Account created. Please verify your email address before logging in",
+			"user": gin.H{
+				"id":         user.ID,
+				"username":   user.Username,
+				"email":      user.Email,
+				"userType":   user.UserType,
+				"rollNumber": user.RollNumber,
+			},
+		})
+		return
+	}
+
+	// Start a session and generate its tokens
+	tokens, err := ac.startSession(c, user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
-		"token": token,
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
 		"user": gin.H{
-			"id":         result.InsertedID,
+			"id":         user.ID,
 			"username":   user.Username,
 			"email":      user.Email,
 			"userType":   user.UserType,
@@ -175,3 +318,212 @@ func (ac *AuthController) Signup(c *gin.Context) {
 		},
 	})
 }
+
+// sessionTokens is the token pair handed to a client when a session starts or
+// is refreshed
+type sessionTokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int
+}
+
+// startSession creates a new session for the user and issues its first token pair
+func (ac *AuthController) startSession(c *gin.Context, user models.User) (*sessionTokens, error) {
+	now := time.Now()
+	session := models.Session{
+		UserID:     user.ID,
+		UserAgent:  c.Request.UserAgent(),
+		IP:         c.ClientIP(),
+		ExpiresAt:  now.Add(middleware.RefreshTokenTTL()),
+		LastUsedAt: now,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	result, err := ac.db.Collection("sessions").InsertOne(context.Background(), session)
+	if err != nil {
+		return nil, err
+	}
+	session.ID = result.InsertedID.(primitive.ObjectID)
+
+	return ac.issueTokens(session, user)
+}
+
+// issueTokens stores a new refresh token for the session and signs an access token
+func (ac *AuthController) issueTokens(session models.Session, user models.User) (*sessionTokens, error) {
+	refreshToken, err := middleware.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	_, err = ac.db.Collection("refresh_tokens").InsertOne(context.Background(), models.RefreshToken{
+		SessionID: session.ID,
+		UserID:    user.ID,
+		TokenHash: middleware.HashToken(refreshToken),
+		ExpiresAt: session.ExpiresAt,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := middleware.GenerateToken(user.ID, user.Username, user.UserType, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(middleware.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh token.
+// Each refresh token can be used once; presenting a used token again revokes
+// the whole session since it means the token has leaked.
+func (ac *AuthController) Refresh(c *gin.Context) {
+	var input struct {
+		RefreshToken string `json:"refreshToken" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+
+	// Mark the token used atomically so that concurrent refreshes cannot both succeed
+	var stored models.RefreshToken
+	err := ac.db.Collection("refresh_tokens").FindOneAndUpdate(ctx,
+		bson.M{
+			"tokenHash": middleware.HashToken(input.RefreshToken),
+			"usedAt":    bson.M{"$exists": false},
+			"expiresAt": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"usedAt": now}},
+	).Decode(&stored)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		// Reuse of a rotated token: revoke the session it belongs to
+		var reused models.RefreshToken
+		err := ac.db.Collection("refresh_tokens").FindOne(ctx, bson.M{
+			"tokenHash": middleware.HashToken(input.RefreshToken),
+			"usedAt":    bson.M{"$exists": true},
+		}).Decode(&reused)
+		if err == nil {
+			revokeSessions(ctx, ac.db, bson.M{"_id": reused.SessionID})
+		}
+
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	var session models.Session
+	err = ac.db.Collection("sessions").FindOne(ctx, bson.M{
+		"_id":       stored.SessionID,
+		"revokedAt": bson.M{"$exists": false},
+	}).Decode(&session)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	var user models.User
+	err = ac.db.Collection("users").FindOne(ctx, bson.M{"_id": stored.UserID, "deletedAt": nil}).Decode(&user)
+	if err != nil || user.Disabled {
+		revokeSessions(ctx, ac.db, bson.M{"_id": session.ID})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	// Slide the session expiry forward
+	session.ExpiresAt = now.Add(middleware.RefreshTokenTTL())
+	_, err = ac.db.Collection("sessions").UpdateOne(ctx,
+		bson.M{"_id": session.ID},
+		bson.M{"$set": bson.M{
+			"expiresAt":  session.ExpiresAt,
+			"lastUsedAt": now,
+			"updatedAt":  now,
+		}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	tokens, err := ac.issueTokens(session, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
+	})
+}
+
+// Logout revokes the session of the current access token
+func (ac *AuthController) Logout(c *gin.Context) {
+	sessionID, _ := c.Get("sessionId")
+
+	if err := revokeSessions(context.Background(), ac.db, bson.M{"_id": sessionID.(primitive.ObjectID)}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error logging out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// LogoutAll revokes every session of the current user
+func (ac *AuthController) LogoutAll(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	if err := revokeSessions(context.Background(), ac.db, bson.M{"userId": userID.(primitive.ObjectID)}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error logging out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions"})
+}
+
+// revokeSessions revokes the matching sessions and deletes their refresh tokens
+func revokeSessions(ctx context.Context, db *mongo.Database, filter bson.M) error {
+	cursor, err := db.Collection("sessions").Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var sessions []models.Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	ids := make([]primitive.ObjectID, len(sessions))
+	for i, s := range sessions {
+		ids[i] = s.ID
+	}
+
+	now := time.Now()
+	_, err = db.Collection("sessions").UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": now, "updatedAt": now}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("refresh_tokens").DeleteMany(ctx, bson.M{"sessionId": bson.M{"$in": ids}})
+	return err
+}
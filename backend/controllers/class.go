@@ -2,7 +2,9 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -11,6 +13,8 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"classscheduling/listing"
+	"classscheduling/middleware"
 	"classscheduling/models"
 )
 
@@ -22,48 +26,106 @@ func NewClassController(db *mongo.Database) *ClassController {
 	return &ClassController{db: db}
 }
 
-// GetClasses returns a list of all classes
-func (cc *ClassController) GetClasses(c *gin.Context) {
-	ctx := context.Background()
-	collection := cc.db.Collection("classes")
-
-	// Setup options for populating faculty information
-	lookupStage := bson.D{
-		{Key: "$lookup", Value: bson.D{
-			{Key: "from", Value: "users"},
-			{Key: "localField", Value: "facultyId"},
-			{Key: "foreignField", Value: "_id"},
-			{Key: "as", Value: "faculty"},
-		}},
-	}
-	unwindStage := bson.D{{Key: "$unwind", Value: "$faculty"}}
-	projectStage := bson.D{
-		{Key: "$project", Value: bson.D{
-			{Key: "_id", Value: 1},
-			{Key: "name", Value: 1},
-			{Key: "schedule", Value: 1},
-			{Key: "capacity", Value: 1},
-			{Key: "enrolledCount", Value: 1},
-			{Key: "status", Value: 1},
-			{Key: "faculty.username", Value: 1},
-			{Key: "faculty.email", Value: 1},
-		}},
-	}
-
-	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{lookupStage, unwindStage, projectStage})
+// classListSpec is what class lists can be filtered, sorted and searched by
+var classListSpec = listing.Spec{
+	Filters: map[string]listing.Field{
+		"status":       {Path: "status"},
+		"term":         {Path: "term"},
+		"code":         {Path: "code"},
+		"departmentId": {Path: "departmentId", Kind: listing.ObjectID},
+		"facultyId":    {Path: "facultyId", Kind: listing.ObjectID},
+	},
+	Sorts: map[string]string{
+		"name":          "name",
+		"code":          "code",
+		"term":          "term",
+		"capacity":      "capacity",
+		"enrolledCount": "enrolledCount",
+		"status":        "status",
+		"createdAt":     "createdAt",
+		"faculty":       "faculty.username",
+	},
+	Search:      []string{"name", "code", "faculty.username"},
+	DefaultSort: "name",
+}
+
+// listClasses writes a page of the classes matching base with their
+// faculty's username and email
+func listClasses(c *gin.Context, db *mongo.Database, query *listing.Query, base bson.M) {
+	lookup := []bson.M{
+		{
+			"$lookup": bson.M{
+				"from":         "users",
+				"localField":   "facultyId",
+				"foreignField": "_id",
+				"as":           "faculty",
+			},
+		},
+		{"$unwind": bson.M{"path": "$faculty", "preserveNullAndEmptyArrays": true}},
+	}
+	project := bson.M{
+		"$project": bson.M{
+			"_id":              1,
+			"name":             1,
+			"code":             1,
+			"schedule":         1,
+			"term":             1,
+			"departmentId":     1,
+			"facultyId":        1,
+			"capacity":         1,
+			"enrolledCount":    1,
+			"status":           1,
+			"createdAt":        1,
+			"faculty.username": 1,
+			"faculty.email":    1,
+		},
+	}
+
+	// Faculty are only joined in before paging when the request filters,
+	// searches or sorts on them; otherwise only the page's classes are joined
+	var join []bson.M
+	shape := append(lookup, project)
+	if query.Uses("faculty.") {
+		join = lookup
+		shape = []bson.M{project}
+	}
+
+	var classes []bson.M
+	page, err := query.Aggregate(context.Background(), db.Collection("classes"), base, join, shape, &classes)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching classes"})
 		return
 	}
-	defer cursor.Close(ctx)
 
-	var classes []bson.M
-	if err := cursor.All(ctx, &classes); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding classes"})
+	c.JSON(http.StatusOK, page)
+}
+
+// excludeDeletedClasses limits filter to records whose classId is not a
+// deleted class
+func excludeDeletedClasses(ctx context.Context, db *mongo.Database, filter bson.M) error {
+	deleted, err := db.Collection("classes").Distinct(ctx, "_id", bson.M{"deletedAt": bson.M{"$ne": nil}})
+	if err != nil {
+		return err
+	}
+	filter["classId"] = bson.M{"$nin": deleted}
+	return nil
+}
+
+// GetClasses returns a page of classes, optionally filtered by status,
+// term, department or faculty and searched by name, code or faculty
+func (cc *ClassController) GetClasses(c *gin.Context) {
+	query, ok := parseList(c, classListSpec)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, classes)
+	// Class administrators limited to a department only see its classes
+	base := bson.M{"deletedAt": nil}
+	if middleware.HasPermission(c, models.PermClassCreate) {
+		base = scopeFilter(c, base)
+	}
+
+	listClasses(c, cc.db, query, base)
 }
 
 // GetClass returns details of a specific class
@@ -75,7 +137,7 @@ func (cc *ClassController) GetClass(c *gin.Context) {
 	}
 
 	var class models.Class
-	err = cc.db.Collection("classes").FindOne(context.Background(), bson.M{"_id": classID}).Decode(&class)
+	err = cc.db.Collection("classes").FindOne(context.Background(), bson.M{"_id": classID, "deletedAt": nil}).Decode(&class)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Class not found"})
@@ -98,10 +160,13 @@ func (cc *ClassController) GetClass(c *gin.Context) {
 // CreateClass creates a new class
 func (cc *ClassController) CreateClass(c *gin.Context) {
 	var input struct {
-		Name      string             `json:"name" binding:"required"`
-		FacultyID primitive.ObjectID `json:"facultyId" binding:"required"`
-		Schedule  string             `json:"schedule" binding:"required"`
-		Capacity  int                `json:"capacity" binding:"required,min=1"`
+		Name         string              `json:"name" binding:"required"`
+		Code         string              `json:"code"`
+		FacultyID    primitive.ObjectID  `json:"facultyId" binding:"required"`
+		Schedule     string              `json:"schedule" binding:"required"`
+		Term         string              `json:"term"`
+		Capacity     int                 `json:"capacity" binding:"required,min=1"`
+		DepartmentID *primitive.ObjectID `json:"departmentId"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -109,21 +174,36 @@ func (cc *ClassController) CreateClass(c *gin.Context) {
 		return
 	}
 
-	// Verify faculty exists and is of type faculty
+	// Department admins can only create classes in their own department
+	department, ok := resolveDepartment(c, cc.db, input.DepartmentID)
+	if !ok {
+		return
+	}
+
+	// Verify faculty exists, is of type faculty and can be managed by the caller
 	var faculty models.User
-	err := cc.db.Collection("users").FindOne(context.Background(), bson.M{
-		"_id":      input.FacultyID,
-		"userType": "faculty",
-	}).Decode(&faculty)
+	err := cc.db.Collection("users").FindOne(context.Background(), scopeFilter(c, bson.M{
+		"_id":       input.FacultyID,
+		"userType":  "faculty",
+		"deletedAt": nil,
+	})).Decode(&faculty)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid faculty ID"})
 		return
 	}
 
+	code, ok := cc.checkClassCode(c, input.Code, primitive.NilObjectID)
+	if !ok {
+		return
+	}
+
 	class := models.Class{
 		Name:          input.Name,
+		Code:          code,
 		FacultyID:     input.FacultyID,
 		Schedule:      input.Schedule,
+		Term:          input.Term,
+		DepartmentID:  department,
 		Capacity:      input.Capacity,
 		EnrolledCount: 0,
 		Status:        "active",
@@ -142,6 +222,33 @@ func (cc *ClassController) CreateClass(c *gin.Context) {
 	c.JSON(http.StatusCreated, class)
 }
 
+// checkClassCode normalizes a class code and checks that no class other
+// than self uses it. ok is false once an error has been written.
+func (cc *ClassController) checkClassCode(c *gin.Context, code string, self primitive.ObjectID) (string, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return "", true
+	}
+	if strings.ContainsAny(code, " ,;|") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Class codes cannot contain spaces, commas, semicolons or pipes"})
+		return "", false
+	}
+
+	count, err := cc.db.Collection("classes").CountDocuments(context.Background(), bson.M{
+		"code": code,
+		"_id":  bson.M{"$ne": self},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return "", false
+	}
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Class code already in use"})
+		return "", false
+	}
+	return code, true
+}
+
 // UpdateClass updates an existing class
 func (cc *ClassController) UpdateClass(c *gin.Context) {
 	classID, err := primitive.ObjectIDFromHex(c.Param("id"))
@@ -151,11 +258,14 @@ func (cc *ClassController) UpdateClass(c *gin.Context) {
 	}
 
 	var input struct {
-		Name      string             `json:"name"`
-		FacultyID primitive.ObjectID `json:"facultyId"`
-		Schedule  string             `json:"schedule"`
-		Capacity  int                `json:"capacity"`
-		Status    string             `json:"status"`
+		Name         string              `json:"name"`
+		Code         string              `json:"code"`
+		FacultyID    primitive.ObjectID  `json:"facultyId"`
+		Schedule     string              `json:"schedule"`
+		Term         string              `json:"term"`
+		Capacity     int                 `json:"capacity"`
+		Status       string              `json:"status"`
+		DepartmentID *primitive.ObjectID `json:"departmentId"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -170,13 +280,21 @@ func (cc *ClassController) UpdateClass(c *gin.Context) {
 	if input.Name != "" {
 		update["$set"].(bson.M)["name"] = input.Name
 	}
+	if input.Code != "" {
+		code, ok := cc.checkClassCode(c, input.Code, classID)
+		if !ok {
+			return
+		}
+		update["$set"].(bson.M)["code"] = code
+	}
 	if !input.FacultyID.IsZero() {
-		// Verify faculty exists and is of type faculty
+		// Verify faculty exists, is of type faculty and can be managed by the caller
 		var faculty models.User
-		err := cc.db.Collection("users").FindOne(context.Background(), bson.M{
-			"_id":      input.FacultyID,
-			"userType": "faculty",
-		}).Decode(&faculty)
+		err := cc.db.Collection("users").FindOne(context.Background(), scopeFilter(c, bson.M{
+			"_id":       input.FacultyID,
+			"userType":  "faculty",
+			"deletedAt": nil,
+		})).Decode(&faculty)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid faculty ID"})
 			return
@@ -186,16 +304,26 @@ func (cc *ClassController) UpdateClass(c *gin.Context) {
 	if input.Schedule != "" {
 		update["$set"].(bson.M)["schedule"] = input.Schedule
 	}
+	if input.Term != "" {
+		update["$set"].(bson.M)["term"] = input.Term
+	}
 	if input.Capacity > 0 {
 		update["$set"].(bson.M)["capacity"] = input.Capacity
 	}
 	if input.Status != "" {
 		update["$set"].(bson.M)["status"] = input.Status
 	}
+	if input.DepartmentID != nil {
+		department, ok := resolveDepartment(c, cc.db, input.DepartmentID)
+		if !ok {
+			return
+		}
+		update["$set"].(bson.M)["departmentId"] = department
+	}
 
 	result := cc.db.Collection("classes").FindOneAndUpdate(
 		context.Background(),
-		bson.M{"_id": classID},
+		scopeFilter(c, bson.M{"_id": classID, "deletedAt": nil}),
 		update,
 		options.FindOneAndUpdate().SetReturnDocument(options.After),
 	)
@@ -220,25 +348,136 @@ func (cc *ClassController) UpdateClass(c *gin.Context) {
 	c.JSON(http.StatusOK, updatedClass)
 }
 
-// DeleteClass deletes a class
-func (cc *ClassController) DeleteClass(c *gin.Context) {
+// classDeleteImpact is what deleting a class affects
+type classDeleteImpact struct {
+	Enrolled        int   `json:"enrolled"`
+	Attendance      int64 `json:"attendance"`
+	Performance     int64 `json:"performance"`
+	Remarks         int64 `json:"remarks"`
+	RiskFlags       int64 `json:"riskFlags"`
+	ScheduleChanges int64 `json:"scheduleChanges"`
+}
+
+// Dependents is the number of records that refer to the class
+func (i classDeleteImpact) Dependents() int64 {
+	return i.Attendance + i.Performance + i.Remarks + i.RiskFlags + i.ScheduleChanges
+}
+
+// classImpact counts the students and records that refer to a class
+func classImpact(ctx context.Context, db *mongo.Database, class models.Class) (classDeleteImpact, error) {
+	impact := classDeleteImpact{Enrolled: len(class.Enrolled)}
+	counts := map[string]*int64{
+		"attendance":       &impact.Attendance,
+		"performance":      &impact.Performance,
+		"remarks":          &impact.Remarks,
+		"risk_flags":       &impact.RiskFlags,
+		"schedule_changes": &impact.ScheduleChanges,
+	}
+	for collection, count := range counts {
+		n, err := db.Collection(collection).CountDocuments(ctx, bson.M{"classId": class.ID})
+		if err != nil {
+			return impact, err
+		}
+		*count = n
+	}
+	return impact, nil
+}
+
+// GetClassDeleteImpact returns what deleting or purging a class would
+// affect, for the admin to confirm
+func (cc *ClassController) GetClassDeleteImpact(c *gin.Context) {
 	classID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class ID"})
 		return
 	}
 
-	result, err := cc.db.Collection("classes").DeleteOne(context.Background(), bson.M{"_id": classID})
+	ctx := context.Background()
+	var class models.Class
+	err = cc.db.Collection("classes").FindOne(ctx, scopeFilter(c, bson.M{"_id": classID})).Decode(&class)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Class not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching class"})
+		return
+	}
+
+	impact, err := classImpact(ctx, cc.db, class)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting class"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error counting class records"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":      class.ID,
+		"name":    class.Name,
+		"code":    class.Code,
+		"deleted": class.DeletedAt != nil,
+		"impact":  impact,
+	})
+}
+
+// DeleteClass soft-deletes a class. Its enrollments, attendance, grades and
+// remarks are kept until an admin restores or purges it. Without
+// confirm=true nothing is deleted and the impact is returned instead.
+func (cc *ClassController) DeleteClass(c *gin.Context) {
+	classID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class ID"})
+		return
+	}
+
+	ctx := context.Background()
+	if c.Query("confirm") != "true" {
+		var class models.Class
+		err = cc.db.Collection("classes").FindOne(ctx, scopeFilter(c, bson.M{"_id": classID, "deletedAt": nil})).Decode(&class)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Class not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching class"})
+			return
+		}
+		impact, err := classImpact(ctx, cc.db, class)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error counting class records"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  "Deleting a class must be confirmed with confirm=true",
+			"impact": impact,
+		})
 		return
 	}
 
-	if result.DeletedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Class not found"})
+	now := time.Now()
+	set := bson.M{"deletedAt": now, "updatedAt": now}
+	if actorID, ok := c.Get("userId"); ok {
+		set["deletedBy"] = actorID
+	}
+
+	var class models.Class
+	err = cc.db.Collection("classes").FindOneAndUpdate(ctx,
+		scopeFilter(c, bson.M{"_id": classID, "deletedAt": nil}),
+		bson.M{"$set": set},
+	).Decode(&class)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Class not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting class"})
 		return
 	}
 
+	recordActivity(cc.db, c, models.Activity{
+		Type:        "class_deleted",
+		Description: fmt.Sprintf("Class %s deleted", class.Name),
+	})
+
 	c.JSON(http.StatusOK, gin.H{"message": "Class deleted successfully"})
 }
 
@@ -256,8 +495,9 @@ func (cc *ClassController) EnrollInClass(c *gin.Context) {
 	// Check if class exists and has capacity
 	var class models.Class
 	err = cc.db.Collection("classes").FindOne(context.Background(), bson.M{
-		"_id":    classID,
-		"status": "active",
+		"_id":       classID,
+		"status":    "active",
+		"deletedAt": nil,
 	}).Decode(&class)
 
 	if err != nil {
@@ -291,7 +531,7 @@ func (cc *ClassController) EnrollInClass(c *gin.Context) {
 	// Update class enrollment
 	result := cc.db.Collection("classes").FindOneAndUpdate(
 		context.Background(),
-		bson.M{"_id": classID},
+		bson.M{"_id": classID, "deletedAt": nil},
 		bson.M{
 			"$push": bson.M{"enrolled": studentObjID},
 			"$inc":  bson.M{"enrolledCount": 1},
@@ -327,8 +567,9 @@ func (cc *ClassController) DropClass(c *gin.Context) {
 	result := cc.db.Collection("classes").FindOneAndUpdate(
 		context.Background(),
 		bson.M{
-			"_id":      classID,
-			"enrolled": studentObjID,
+			"_id":       classID,
+			"enrolled":  studentObjID,
+			"deletedAt": nil,
 		},
 		bson.M{
 			"$pull": bson.M{"enrolled": studentObjID},
@@ -354,47 +595,147 @@ func (cc *ClassController) DropClass(c *gin.Context) {
 	})
 }
 
-// GetAvailableClasses returns a list of classes that are active and not full
-func (cc *ClassController) GetAvailableClasses(c *gin.Context) {
+// AddEnrollment enrolls a student in a class on their behalf, for admins and
+// integrations such as the registrar sync
+func (cc *ClassController) AddEnrollment(c *gin.Context) {
+	classID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class ID"})
+		return
+	}
+
+	var input struct {
+		StudentID primitive.ObjectID `json:"studentId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
 	ctx := context.Background()
+	count, err := cc.db.Collection("users").CountDocuments(ctx, scopeFilter(c, bson.M{
+		"_id":       input.StudentID,
+		"userType":  "student",
+		"deletedAt": nil,
+	}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching student"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid student ID"})
+		return
+	}
 
-	// Find classes that are active and have space available
-	filter := bson.M{
-		"status": "active",
-		"$expr": bson.M{
-			"$lt": []interface{}{"$enrolledCount", "$capacity"},
+	var class models.Class
+	err = cc.db.Collection("classes").FindOne(ctx, scopeFilter(c, bson.M{"_id": classID, "deletedAt": nil})).Decode(&class)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Class not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching class"})
+		return
+	}
+	for _, enrolled := range class.Enrolled {
+		if enrolled == input.StudentID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Student is already enrolled in this class"})
+			return
+		}
+	}
+
+	// Check capacity and enrollment in the update itself so concurrent
+	// enrollments cannot overfill the class
+	var updatedClass models.Class
+	err = cc.db.Collection("classes").FindOneAndUpdate(ctx,
+		bson.M{
+			"_id":       classID,
+			"enrolled":  bson.M{"$ne": input.StudentID},
+			"deletedAt": nil,
+			"$expr":     bson.M{"$lt": []interface{}{"$enrolledCount", "$capacity"}},
+		},
+		bson.M{
+			"$push": bson.M{"enrolled": input.StudentID},
+			"$inc":  bson.M{"enrolledCount": 1},
+			"$set":  bson.M{"updatedAt": time.Now()},
 		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updatedClass)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Class is full"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating enrollment"})
+		return
 	}
 
-	opts := options.Find().SetSort(bson.M{"createdAt": -1})
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Student enrolled in class",
+		"class":   updatedClass,
+	})
+}
 
-	cursor, err := cc.db.Collection("classes").Find(ctx, filter, opts)
+// RemoveEnrollment removes a student from a class on their behalf
+func (cc *ClassController) RemoveEnrollment(c *gin.Context) {
+	classID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching available classes"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class ID"})
 		return
 	}
-	defer cursor.Close(ctx)
-
-	var classes []models.Class
-	if err := cursor.All(ctx, &classes); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding classes"})
+	studentID, err := primitive.ObjectIDFromHex(c.Param("studentId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid student ID"})
 		return
 	}
 
-	// Populate faculty information for each class
-	for i := range classes {
-		var faculty models.User
-		err := cc.db.Collection("users").FindOne(
-			ctx,
-			bson.M{"_id": classes[i].FacultyID},
-			options.FindOne().SetProjection(bson.M{"password": 0}),
-		).Decode(&faculty)
-		if err == nil {
-			classes[i].Faculty = &faculty
+	var updatedClass models.Class
+	err = cc.db.Collection("classes").FindOneAndUpdate(context.Background(),
+		scopeFilter(c, bson.M{
+			"_id":       classID,
+			"enrolled":  studentID,
+			"deletedAt": nil,
+		}),
+		bson.M{
+			"$pull": bson.M{"enrolled": studentID},
+			"$inc":  bson.M{"enrolledCount": -1},
+			"$set":  bson.M{"updatedAt": time.Now()},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updatedClass)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Student is not enrolled in this class"})
+			return
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating enrollment"})
+		return
 	}
 
-	c.JSON(http.StatusOK, classes)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Student removed from class",
+		"class":   updatedClass,
+	})
+}
+
+// GetAvailableClasses returns a page of classes that are active and not
+// full, newest first unless another order is requested
+func (cc *ClassController) GetAvailableClasses(c *gin.Context) {
+	spec := classListSpec
+	spec.DefaultSort = "-createdAt"
+	query, ok := parseList(c, spec)
+	if !ok {
+		return
+	}
+
+	// Find classes that are active and have space available
+	listClasses(c, cc.db, query, bson.M{
+		"status":    "active",
+		"deletedAt": nil,
+		"$expr": bson.M{
+			"$lt": []interface{}{"$enrolledCount", "$capacity"},
+		},
+	})
 }
 
 // GetFacultyClasses returns all classes taught by the requesting faculty member
@@ -409,7 +750,7 @@ func (cc *ClassController) GetFacultyClasses(c *gin.Context) {
 	}
 
 	// Find all classes for this faculty
-	filter := bson.M{"facultyId": facultyID}
+	filter := bson.M{"facultyId": facultyID, "deletedAt": nil}
 	opts := options.Find().SetSort(bson.M{"createdAt": -1})
 
 	cursor, err := cc.db.Collection("classes").Find(ctx, filter, opts)
@@ -437,6 +778,7 @@ func (cc *ClassController) GetFacultySchedule(c *gin.Context) {
 		{{Key: "$match", Value: bson.M{
 			"facultyId": facultyObjID,
 			"status":    "active",
+			"deletedAt": nil,
 		}}},
 		{{Key: "$project", Value: bson.M{
 			"_id":      1,
@@ -468,8 +810,9 @@ func (cc *ClassController) GetStudentSchedule(c *gin.Context) {
 
 	pipeline := mongo.Pipeline{
 		{{Key: "$match", Value: bson.M{
-			"enrolled": studentObjID,
-			"status":   "active",
+			"enrolled":  studentObjID,
+			"status":    "active",
+			"deletedAt": nil,
 		}}},
 		{{Key: "$project", Value: bson.M{
 			"_id":      1,
@@ -500,14 +843,14 @@ func (cc *ClassController) GetStudentEnrollments(c *gin.Context) {
 	studentObjID := studentID.(primitive.ObjectID)
 
 	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: bson.M{"enrolled": studentObjID}}},
+		{{Key: "$match", Value: bson.M{"enrolled": studentObjID, "deletedAt": nil}}},
 		{{Key: "$lookup", Value: bson.M{
 			"from":         "users",
 			"localField":   "facultyId",
 			"foreignField": "_id",
 			"as":           "faculty",
 		}}},
-		{{Key: "$unwind", Value: "$faculty"}},
+		{{Key: "$unwind", Value: bson.M{"path": "$faculty", "preserveNullAndEmptyArrays": true}}},
 		{{Key: "$project", Value: bson.M{
 			"_id":      1,
 			"name":     1,
@@ -556,6 +899,7 @@ func (cc *ClassController) CancelClass(c *gin.Context) {
 	count, err := cc.db.Collection("classes").CountDocuments(context.Background(), bson.M{
 		"_id":       input.ClassID,
 		"facultyId": facultyObjID,
+		"deletedAt": nil,
 	})
 	if err != nil || count == 0 {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to cancel this class"})
@@ -602,6 +946,7 @@ func (cc *ClassController) RescheduleClass(c *gin.Context) {
 	count, err := cc.db.Collection("classes").CountDocuments(context.Background(), bson.M{
 		"_id":       input.ClassID,
 		"facultyId": facultyObjID,
+		"deletedAt": nil,
 	})
 	if err != nil || count == 0 {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to reschedule this class"})
@@ -636,6 +981,7 @@ func (cc *ClassController) GetScheduleChanges(c *gin.Context) {
 	// Get faculty's classes first
 	classCursor, err := cc.db.Collection("classes").Find(context.Background(), bson.M{
 		"facultyId": facultyObjID,
+		"deletedAt": nil,
 	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching faculty classes"})
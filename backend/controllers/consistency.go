@@ -0,0 +1,413 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"classscheduling/models"
+)
+
+// consistencySampleSize is how many violations of each check are listed;
+// the rest are only counted
+const consistencySampleSize = 100
+
+// ConsistencyViolation is one document that breaks an invariant
+type ConsistencyViolation struct {
+	Collection string             `json:"collection"`
+	ID         primitive.ObjectID `json:"id"`
+	Detail     string             `json:"detail"`
+}
+
+// ConsistencyCheck is the outcome of checking one invariant
+type ConsistencyCheck struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Fixable     bool                   `json:"fixable"`
+	Count       int                    `json:"count"`
+	Violations  []ConsistencyViolation `json:"violations"`
+	Fixed       int64                  `json:"fixed"`
+}
+
+func (check *ConsistencyCheck) add(collection string, id primitive.ObjectID, format string, args ...interface{}) {
+	check.Count++
+	if len(check.Violations) < consistencySampleSize {
+		check.Violations = append(check.Violations, ConsistencyViolation{
+			Collection: collection,
+			ID:         id,
+			Detail:     fmt.Sprintf(format, args...),
+		})
+	}
+}
+
+// ConsistencyReport lists the violations found by a consistency check
+type ConsistencyReport struct {
+	CheckedAt  time.Time           `json:"checkedAt"`
+	Fix        bool                `json:"fix"`
+	Violations int                 `json:"violations"`
+	Fixed      int64               `json:"fixed"`
+	Checks     []*ConsistencyCheck `json:"checks"`
+}
+
+// ConsistencyOptions controls a consistency check
+type ConsistencyOptions struct {
+	Fix     bool                // repair the violations that can be repaired safely
+	ActorID *primitive.ObjectID // who asked for the repair, for the activity log
+}
+
+type ConsistencyController struct {
+	db *mongo.Database
+}
+
+func NewConsistencyController(db *mongo.Database) *ConsistencyController {
+	return &ConsistencyController{db: db}
+}
+
+// consistencyUser is what the checks need to know about a user
+type consistencyUser struct {
+	ID       primitive.ObjectID `bson:"_id"`
+	UserType string             `bson:"userType"`
+	Deleted  *time.Time         `bson:"deletedAt"`
+}
+
+// objectIDSet collects IDs without repeats
+type objectIDSet map[primitive.ObjectID]bool
+
+func (s objectIDSet) list() []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(s))
+	for id := range s {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Check scans users, classes and the records that refer to them for
+// invariant violations and, with opts.Fix, repairs the ones that can be
+// repaired without losing information anyone relies on. Violations that need
+// a decision, such as a class without a faculty member, are only reported.
+func (cc *ConsistencyController) Check(ctx context.Context, opts ConsistencyOptions) (*ConsistencyReport, error) {
+	cursor, err := cc.db.Collection("users").Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"userType": 1, "deletedAt": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var userList []consistencyUser
+	if err := cursor.All(ctx, &userList); err != nil {
+		return nil, err
+	}
+	users := make(map[primitive.ObjectID]consistencyUser, len(userList))
+	for _, user := range userList {
+		users[user.ID] = user
+	}
+	// isStudent reports whether id is a student, deleted or not; records of
+	// deleted students are kept until they are purged
+	isStudent := func(id primitive.ObjectID) bool {
+		user, ok := users[id]
+		return ok && user.UserType == "student"
+	}
+
+	cursor, err = cc.db.Collection("classes").Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"name": 1, "facultyId": 1, "enrolled": 1, "enrolledCount": 1, "deletedAt": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var classes []models.Class
+	if err := cursor.All(ctx, &classes); err != nil {
+		return nil, err
+	}
+	enrolledIn := make(map[primitive.ObjectID]objectIDSet, len(classes))
+
+	invalidEnrollment := &ConsistencyCheck{
+		Name:        "enrollment_invalid_student",
+		Description: "Classes enrolling users that do not exist, are deleted or are not students",
+		Fixable:     true,
+	}
+	enrollmentCount := &ConsistencyCheck{
+		Name:        "enrollment_count",
+		Description: "Classes whose enrolledCount differs from the number of enrolled students",
+		Fixable:     true,
+	}
+	missingFaculty := &ConsistencyCheck{
+		Name:        "class_missing_faculty",
+		Description: "Classes whose faculty member does not exist, is deleted or is not faculty; assign another faculty member",
+	}
+	orphans := &ConsistencyCheck{
+		Name:        "orphaned_class_records",
+		Description: "Attendance, performance, remarks, risk flags and schedule changes of classes that do not exist",
+		Fixable:     true,
+	}
+	attendanceUnknown := &ConsistencyCheck{
+		Name:        "attendance_unknown_student",
+		Description: "Attendance marked for users that do not exist or are not students",
+		Fixable:     true,
+	}
+	attendanceNotEnrolled := &ConsistencyCheck{
+		Name:        "attendance_not_enrolled",
+		Description: "Attendance marked for students not enrolled in the class; they may have dropped it since, so review before removing",
+	}
+	performanceUnknown := &ConsistencyCheck{
+		Name:        "performance_unknown_student",
+		Description: "Performance records of users that do not exist or are not students",
+		Fixable:     true,
+	}
+	performanceNotEnrolled := &ConsistencyCheck{
+		Name:        "performance_not_enrolled",
+		Description: "Performance records of students not enrolled in the class; they may have dropped it since, so review before removing",
+	}
+
+	invalidStudents := objectIDSet{}
+	for _, class := range classes {
+		enrolled := objectIDSet{}
+		for _, studentID := range class.Enrolled {
+			enrolled[studentID] = true
+			user, ok := users[studentID]
+			switch {
+			case !ok:
+				invalidEnrollment.add("classes", class.ID, "%s enrolls %s, which does not exist", class.Name, studentID.Hex())
+			case user.Deleted != nil:
+				invalidEnrollment.add("classes", class.ID, "%s enrolls %s, which is deleted", class.Name, studentID.Hex())
+			case user.UserType != "student":
+				invalidEnrollment.add("classes", class.ID, "%s enrolls %s, which is a %s", class.Name, studentID.Hex(), user.UserType)
+			default:
+				continue
+			}
+			invalidStudents[studentID] = true
+		}
+		enrolledIn[class.ID] = enrolled
+
+		if class.EnrolledCount != len(class.Enrolled) {
+			enrollmentCount.add("classes", class.ID, "%s has enrolledCount %d but %d enrolled students", class.Name, class.EnrolledCount, len(class.Enrolled))
+		}
+
+		if class.DeletedAt == nil {
+			faculty, ok := users[class.FacultyID]
+			switch {
+			case !ok:
+				missingFaculty.add("classes", class.ID, "%s is taught by %s, which does not exist", class.Name, class.FacultyID.Hex())
+			case faculty.Deleted != nil:
+				missingFaculty.add("classes", class.ID, "%s is taught by %s, which is deleted", class.Name, class.FacultyID.Hex())
+			case faculty.UserType != "faculty":
+				missingFaculty.add("classes", class.ID, "%s is taught by %s, which is a %s", class.Name, class.FacultyID.Hex(), faculty.UserType)
+			}
+		}
+	}
+
+	missingClasses := objectIDSet{}
+	unknownInAttendance := objectIDSet{}
+	cursor, err = cc.db.Collection("attendance").Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"classId": 1, "present": 1, "absent": 1}))
+	if err != nil {
+		return nil, err
+	}
+	for cursor.Next(ctx) {
+		var record models.Attendance
+		if err := cursor.Decode(&record); err != nil {
+			cursor.Close(ctx)
+			return nil, err
+		}
+		enrolled, ok := enrolledIn[record.ClassID]
+		if !ok {
+			orphans.add("attendance", record.ID, "class %s does not exist", record.ClassID.Hex())
+			missingClasses[record.ClassID] = true
+			continue
+		}
+		for _, studentID := range append(record.Present, record.Absent...) {
+			switch {
+			case !isStudent(studentID):
+				attendanceUnknown.add("attendance", record.ID, "marks %s, which is not a student", studentID.Hex())
+				unknownInAttendance[studentID] = true
+			case !enrolled[studentID]:
+				attendanceNotEnrolled.add("attendance", record.ID, "marks %s, who is not enrolled in class %s", studentID.Hex(), record.ClassID.Hex())
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		cursor.Close(ctx)
+		return nil, err
+	}
+	cursor.Close(ctx)
+
+	unknownInPerformance := objectIDSet{}
+	cursor, err = cc.db.Collection("performance").Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"classId": 1, "studentId": 1}))
+	if err != nil {
+		return nil, err
+	}
+	for cursor.Next(ctx) {
+		var record models.Performance
+		if err := cursor.Decode(&record); err != nil {
+			cursor.Close(ctx)
+			return nil, err
+		}
+		enrolled, ok := enrolledIn[record.ClassID]
+		switch {
+		case !ok:
+			orphans.add("performance", record.ID, "class %s does not exist", record.ClassID.Hex())
+			missingClasses[record.ClassID] = true
+		case !isStudent(record.StudentID):
+			performanceUnknown.add("performance", record.ID, "belongs to %s, which is not a student", record.StudentID.Hex())
+			unknownInPerformance[record.StudentID] = true
+		case !enrolled[record.StudentID]:
+			performanceNotEnrolled.add("performance", record.ID, "belongs to %s, who is not enrolled in class %s", record.StudentID.Hex(), record.ClassID.Hex())
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		cursor.Close(ctx)
+		return nil, err
+	}
+	cursor.Close(ctx)
+
+	for _, collection := range []string{"remarks", "risk_flags", "schedule_changes"} {
+		classIDs, err := cc.db.Collection(collection).Distinct(ctx, "classId", bson.M{})
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range classIDs {
+			classID, ok := value.(primitive.ObjectID)
+			if !ok || enrolledIn[classID] != nil {
+				continue
+			}
+			missingClasses[classID] = true
+			cursor, err := cc.db.Collection(collection).Find(ctx, bson.M{"classId": classID},
+				options.Find().SetProjection(bson.M{"_id": 1}))
+			if err != nil {
+				return nil, err
+			}
+			var records []struct {
+				ID primitive.ObjectID `bson:"_id"`
+			}
+			if err := cursor.All(ctx, &records); err != nil {
+				return nil, err
+			}
+			for _, record := range records {
+				orphans.add(collection, record.ID, "class %s does not exist", classID.Hex())
+			}
+		}
+	}
+
+	report := &ConsistencyReport{
+		CheckedAt: time.Now(),
+		Fix:       opts.Fix,
+		Checks: []*ConsistencyCheck{
+			invalidEnrollment,
+			enrollmentCount,
+			missingFaculty,
+			orphans,
+			attendanceUnknown,
+			attendanceNotEnrolled,
+			performanceUnknown,
+			performanceNotEnrolled,
+		},
+	}
+	for _, check := range report.Checks {
+		report.Violations += check.Count
+	}
+	if !opts.Fix {
+		return report, nil
+	}
+
+	// Invalid enrollments are removed before the counts are recomputed, so
+	// that the counts end up matching the arrays
+	if len(invalidStudents) > 0 {
+		ids := invalidStudents.list()
+		result, err := cc.db.Collection("classes").UpdateMany(ctx,
+			bson.M{"enrolled": bson.M{"$in": ids}},
+			bson.M{"$pull": bson.M{"enrolled": bson.M{"$in": ids}}},
+		)
+		if err != nil {
+			return report, err
+		}
+		invalidEnrollment.Fixed = result.ModifiedCount
+	}
+
+	enrolledSize := bson.M{"$size": bson.M{"$ifNull": []interface{}{"$enrolled", []interface{}{}}}}
+	result, err := cc.db.Collection("classes").UpdateMany(ctx,
+		bson.M{"$expr": bson.M{"$ne": []interface{}{"$enrolledCount", enrolledSize}}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"enrolledCount": enrolledSize}}}},
+	)
+	if err != nil {
+		return report, err
+	}
+	enrollmentCount.Fixed = result.ModifiedCount
+
+	if len(missingClasses) > 0 {
+		ids := missingClasses.list()
+		for _, collection := range []string{"attendance", "performance", "remarks", "risk_flags", "schedule_changes"} {
+			result, err := cc.db.Collection(collection).DeleteMany(ctx, bson.M{"classId": bson.M{"$in": ids}})
+			if err != nil {
+				return report, err
+			}
+			orphans.Fixed += result.DeletedCount
+		}
+	}
+
+	if len(unknownInAttendance) > 0 {
+		ids := unknownInAttendance.list()
+		result, err := cc.db.Collection("attendance").UpdateMany(ctx,
+			bson.M{"$or": []bson.M{{"present": bson.M{"$in": ids}}, {"absent": bson.M{"$in": ids}}}},
+			bson.M{"$pull": bson.M{"present": bson.M{"$in": ids}, "absent": bson.M{"$in": ids}}},
+		)
+		if err != nil {
+			return report, err
+		}
+		attendanceUnknown.Fixed = result.ModifiedCount
+	}
+
+	if len(unknownInPerformance) > 0 {
+		result, err := cc.db.Collection("performance").DeleteMany(ctx,
+			bson.M{"studentId": bson.M{"$in": unknownInPerformance.list()}})
+		if err != nil {
+			return report, err
+		}
+		performanceUnknown.Fixed = result.DeletedCount
+	}
+
+	for _, check := range report.Checks {
+		report.Fixed += check.Fixed
+	}
+	if report.Fixed > 0 {
+		recordActivity(cc.db, nil, models.Activity{
+			Type:        "data_repaired",
+			ActorID:     opts.ActorID,
+			Description: fmt.Sprintf("Repaired %d documents with data consistency violations", report.Fixed),
+		})
+	}
+	return report, nil
+}
+
+// GetConsistencyReport checks the data for invariant violations without
+// changing anything
+func (cc *ConsistencyController) GetConsistencyReport(c *gin.Context) {
+	cc.respondWithCheck(c, ConsistencyOptions{})
+}
+
+// RepairConsistency checks the data and repairs the violations that can be
+// repaired safely
+func (cc *ConsistencyController) RepairConsistency(c *gin.Context) {
+	opts := ConsistencyOptions{Fix: true}
+	if actorID, ok := c.Get("userId"); ok {
+		id := actorID.(primitive.ObjectID)
+		opts.ActorID = &id
+	}
+	cc.respondWithCheck(c, opts)
+}
+
+func (cc *ConsistencyController) respondWithCheck(c *gin.Context, opts ConsistencyOptions) {
+	// Checks cover every department's data
+	if !requireGlobalAdmin(c) {
+		return
+	}
+	report, err := cc.Check(c.Request.Context(), opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking data consistency"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"classscheduling/models"
+)
+
+type DepartmentController struct {
+	db *mongo.Database
+}
+
+func NewDepartmentController(db *mongo.Database) *DepartmentController {
+	return &DepartmentController{db: db}
+}
+
+// departmentScope returns the department the authenticated user's
+// administration is limited to, or nil when it covers every department
+func departmentScope(c *gin.Context) *primitive.ObjectID {
+	departmentID, exists := c.Get("departmentId")
+	if !exists {
+		return nil
+	}
+	id := departmentID.(primitive.ObjectID)
+	return &id
+}
+
+// scopeFilter restricts a query on users or classes to the authenticated
+// user's department
+func scopeFilter(c *gin.Context, filter bson.M) bson.M {
+	if department := departmentScope(c); department != nil {
+		filter["departmentId"] = *department
+	}
+	return filter
+}
+
+// inScope reports whether a record in the given department is within the
+// authenticated user's administrative scope
+func inScope(c *gin.Context, department *primitive.ObjectID) bool {
+	return departmentContains(departmentScope(c), department)
+}
+
+// departmentContains reports whether a record in the given department is
+// within scope, where a nil scope covers every department
+func departmentContains(scope, department *primitive.ObjectID) bool {
+	return scope == nil || (department != nil && *department == *scope)
+}
+
+// resolveDepartment picks the department for a new or moved record. Scoped
+// admins may only use their own department; global admins may pick any
+// existing department or none. ok is false once an error has been written.
+func resolveDepartment(c *gin.Context, db *mongo.Database, requested *primitive.ObjectID) (department *primitive.ObjectID, ok bool) {
+	if scope := departmentScope(c); scope != nil {
+		if requested != nil && *requested != *scope {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only manage your own department"})
+			return nil, false
+		}
+		return scope, true
+	}
+
+	if requested == nil {
+		return nil, true
+	}
+	count, err := db.Collection("departments").CountDocuments(context.Background(), bson.M{"_id": *requested})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+	if count == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid department ID"})
+		return nil, false
+	}
+	return requested, true
+}
+
+// requireGlobalAdmin rejects admins who are limited to one department
+func requireGlobalAdmin(c *gin.Context) bool {
+	if departmentScope(c) != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only institution-wide admins can do this"})
+		return false
+	}
+	return true
+}
+
+// GetDepartments returns all departments
+func (dc *DepartmentController) GetDepartments(c *gin.Context) {
+	ctx := context.Background()
+	cursor, err := dc.db.Collection("departments").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching departments"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	departments := []models.Department{}
+	if err := cursor.All(ctx, &departments); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding departments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, departments)
+}
+
+// CreateDepartment creates a new department
+func (dc *DepartmentController) CreateDepartment(c *gin.Context) {
+	if !requireGlobalAdmin(c) {
+		return
+	}
+
+	var input struct {
+		Name        string `json:"name" binding:"required"`
+		Code        string `json:"code" binding:"required"`
+		Description string `json:"description"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	now := time.Now()
+	department := models.Department{
+		Name:        strings.TrimSpace(input.Name),
+		Code:        strings.ToUpper(strings.TrimSpace(input.Code)),
+		Description: input.Description,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	result, err := dc.db.Collection("departments").InsertOne(context.Background(), department)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Department code already taken"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating department"})
+		return
+	}
+	department.ID = result.InsertedID.(primitive.ObjectID)
+
+	recordActivity(dc.db, c, models.Activity{
+		Type:        "department_created",
+		Description: fmt.Sprintf("Department %s (%s) created", department.Name, department.Code),
+	})
+
+	c.JSON(http.StatusCreated, department)
+}
+
+// UpdateDepartment updates a department's name, code or description
+func (dc *DepartmentController) UpdateDepartment(c *gin.Context) {
+	if !requireGlobalAdmin(c) {
+		return
+	}
+
+	departmentID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid department ID"})
+		return
+	}
+
+	var input struct {
+		Name        string  `json:"name"`
+		Code        string  `json:"code"`
+		Description *string `json:"description"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	update := bson.M{"updatedAt": time.Now()}
+	if input.Name != "" {
+		update["name"] = strings.TrimSpace(input.Name)
+	}
+	if input.Code != "" {
+		update["code"] = strings.ToUpper(strings.TrimSpace(input.Code))
+	}
+	if input.Description != nil {
+		update["description"] = *input.Description
+	}
+
+	var department models.Department
+	err = dc.db.Collection("departments").FindOneAndUpdate(context.Background(),
+		bson.M{"_id": departmentID},
+		bson.M{"$set": update},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&department)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Department not found"})
+			return
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Department code already taken"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating department"})
+		return
+	}
+
+	c.JSON(http.StatusOK, department)
+}
+
+// DeleteDepartment deletes a department that no users or classes belong to
+func (dc *DepartmentController) DeleteDepartment(c *gin.Context) {
+	if !requireGlobalAdmin(c) {
+		return
+	}
+
+	departmentID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid department ID"})
+		return
+	}
+
+	ctx := context.Background()
+	for _, collection := range []string{"users", "classes"} {
+		count, err := dc.db.Collection(collection).CountDocuments(ctx, bson.M{"departmentId": departmentID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Cannot delete a department that still has %s", collection)})
+			return
+		}
+	}
+
+	var department models.Department
+	err = dc.db.Collection("departments").FindOneAndDelete(ctx, bson.M{"_id": departmentID}).Decode(&department)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Department not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting department"})
+		return
+	}
+
+	// Department-specific holidays go with it
+	if _, err := dc.db.Collection("holidays").DeleteMany(ctx, bson.M{"departmentId": departmentID}); err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Department deleted but there was an error removing its holidays"})
+		return
+	}
+
+	recordActivity(dc.db, c, models.Activity{
+		Type:        "department_deleted",
+		Description: fmt.Sprintf("Department %s (%s) deleted", department.Name, department.Code),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Department deleted successfully"})
+}
@@ -0,0 +1,778 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"classscheduling/mailer"
+	"classscheduling/models"
+)
+
+const (
+	// attendanceAlertMinSessions is how many sessions a class must have
+	// held before a shortage is reported, so one early absence is not alarming
+	attendanceAlertMinSessions = 5
+	// attendanceAlertInterval is the least time between two attendance
+	// alerts to a guardian about the same student
+	attendanceAlertInterval = 7 * 24 * time.Hour
+)
+
+// guardianLinkUserProjection is what guardians and students see of each other
+var guardianLinkUserProjection = bson.M{"username": 1, "email": 1, "userType": 1, "rollNumber": 1, "departmentId": 1}
+
+type GuardianController struct {
+	db   *mongo.Database
+	mail mailer.Mailer
+}
+
+func NewGuardianController(db *mongo.Database, mail mailer.Mailer) *GuardianController {
+	return &GuardianController{db: db, mail: mail}
+}
+
+// findLinks returns the guardian links matching filter, newest first, with
+// the guardian and student filled in
+func (gc *GuardianController) findLinks(ctx context.Context, filter bson.M) ([]models.GuardianLink, error) {
+	cursor, err := gc.db.Collection("guardian_links").Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, err
+	}
+	links := []models.GuardianLink{}
+	if err := cursor.All(ctx, &links); err != nil {
+		return nil, err
+	}
+
+	ids := []primitive.ObjectID{}
+	for _, link := range links {
+		ids = append(ids, link.GuardianID, link.StudentID)
+	}
+	cursor, err = gc.db.Collection("users").Find(ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "deletedAt": nil},
+		options.Find().SetProjection(guardianLinkUserProjection),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	byID := make(map[primitive.ObjectID]*models.User, len(users))
+	for i := range users {
+		byID[users[i].ID] = &users[i]
+	}
+	// Links to deleted users are hidden until the user is restored
+	shown := links[:0]
+	for _, link := range links {
+		link.Guardian = byID[link.GuardianID]
+		link.Student = byID[link.StudentID]
+		if link.Guardian != nil && link.Student != nil {
+			shown = append(shown, link)
+		}
+	}
+	return shown, nil
+}
+
+// respondWithLinks writes the links matching filter, limited to the status
+// query parameter when it is given
+func (gc *GuardianController) respondWithLinks(c *gin.Context, filter bson.M) {
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+	links, err := gc.findLinks(context.Background(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching guardian links"})
+		return
+	}
+	c.JSON(http.StatusOK, links)
+}
+
+// changeLink applies update to the link matching filter and returns it. ok
+// is false once an error has been written.
+func (gc *GuardianController) changeLink(c *gin.Context, filter, update bson.M) (link models.GuardianLink, ok bool) {
+	err := gc.db.Collection("guardian_links").FindOneAndUpdate(context.Background(),
+		filter,
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&link)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Guardian link not found"})
+			return link, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating guardian link"})
+		return link, false
+	}
+	return link, true
+}
+
+// approveUpdate activates a link on behalf of the authenticated user
+func approveUpdate(c *gin.Context) bson.M {
+	userID, _ := c.Get("userId")
+	approver := userID.(primitive.ObjectID)
+	now := time.Now()
+	return bson.M{
+		"$set": bson.M{
+			"status":     "active",
+			"approvedBy": approver,
+			"approvedAt": now,
+			"updatedAt":  now,
+		},
+		"$unset": bson.M{"revokedBy": "", "revokedAt": ""},
+	}
+}
+
+// revokeUpdate ends a link on behalf of the authenticated user
+func revokeUpdate(c *gin.Context, status string) bson.M {
+	userID, _ := c.Get("userId")
+	revoker := userID.(primitive.ObjectID)
+	now := time.Now()
+	return bson.M{"$set": bson.M{
+		"status":           status,
+		"revokedBy":        revoker,
+		"revokedAt":        now,
+		"notifyAttendance": false,
+		"updatedAt":        now,
+	}}
+}
+
+// recordLinkActivity audits a change to a guardian link
+func (gc *GuardianController) recordLinkActivity(c *gin.Context, activityType string, link models.GuardianLink) {
+	recordActivity(gc.db, c, models.Activity{
+		Type:        activityType,
+		UserID:      &link.StudentID,
+		Description: fmt.Sprintf("Guardian %s link to student %s is now %s", link.GuardianID.Hex(), link.StudentID.Hex(), link.Status),
+	})
+}
+
+// notify emails a user, logging rather than returning failures
+func (gc *GuardianController) notify(userID primitive.ObjectID, subject, body string) {
+	var user models.User
+	err := gc.db.Collection("users").FindOne(context.Background(),
+		bson.M{"_id": userID, "deletedAt": nil},
+		options.FindOne().SetProjection(bson.M{"username": 1, "email": 1}),
+	).Decode(&user)
+	if err != nil {
+		log.Printf("Error loading user %s to notify: %v", userID.Hex(), err)
+		return
+	}
+	message := mailer.Message{
+		To:      user.Email,
+		Subject: subject,
+		Body:    fmt.Sprintf("Hello %s,\n\n%s", user.Username, body),
+	}
+	if err := gc.mail.Send(context.Background(), message); err != nil {
+		log.Printf("Error sending guardian email to user %s: %v", userID.Hex(), err)
+	}
+}
+
+// GetMyStudents returns the authenticated guardian's links to students
+func (gc *GuardianController) GetMyStudents(c *gin.Context) {
+	guardianID, _ := c.Get("userId")
+	filter := bson.M{"guardianId": guardianID.(primitive.ObjectID)}
+	if c.Query("status") == "" {
+		filter["status"] = bson.M{"$in": []string{"pending", "active"}}
+	}
+	gc.respondWithLinks(c, filter)
+}
+
+// linkRequestAccepted answers every link request, whether or not the roll
+// number belongs to a student
+const linkRequestAccepted = "If a student has this roll number, they have been asked to approve your request"
+
+// RequestStudentLink asks for access to a student's records. The link stays
+// pending until the student or an admin approves it.
+func (gc *GuardianController) RequestStudentLink(c *gin.Context) {
+	var input struct {
+		RollNumber   string `json:"rollNumber" binding:"required"`
+		Relationship string `json:"relationship"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	guardianID, _ := c.Get("userId")
+	guardian := guardianID.(primitive.ObjectID)
+	ctx := context.Background()
+
+	var student models.User
+	err := gc.db.Collection("users").FindOne(ctx,
+		bson.M{"userType": "student", "rollNumber": strings.TrimSpace(input.RollNumber), "deletedAt": nil},
+		options.FindOne().SetProjection(guardianLinkUserProjection),
+	).Decode(&student)
+	if err != nil {
+		// Answer as if the request was sent so roll numbers cannot be probed
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusAccepted, gin.H{"message": linkRequestAccepted})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching student"})
+		return
+	}
+
+	// A student who declined or revoked access is not asked again; an admin
+	// can still link the accounts
+	var existing models.GuardianLink
+	err = gc.db.Collection("guardian_links").FindOne(ctx, bson.M{"guardianId": guardian, "studentId": student.ID}).Decode(&existing)
+	if err != nil && err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching guardian links"})
+		return
+	}
+	if err == nil {
+		switch {
+		case existing.Status == "active":
+			c.JSON(http.StatusConflict, gin.H{"error": "You are already linked to this student"})
+			return
+		case existing.Status == "pending":
+			c.JSON(http.StatusConflict, gin.H{"error": "A request for this student is already pending"})
+			return
+		case existing.Status == "declined" || (existing.RevokedBy != nil && *existing.RevokedBy != guardian):
+			c.JSON(http.StatusConflict, gin.H{"error": "The student has not allowed access; contact an administrator"})
+			return
+		}
+	}
+
+	now := time.Now()
+	var link models.GuardianLink
+	err = gc.db.Collection("guardian_links").FindOneAndUpdate(ctx,
+		bson.M{"guardianId": guardian, "studentId": student.ID},
+		bson.M{
+			"$set": bson.M{
+				"relationship":     strings.TrimSpace(input.Relationship),
+				"status":           "pending",
+				"requestedBy":      guardian,
+				"notifyAttendance": false,
+				"updatedAt":        now,
+			},
+			"$unset":       bson.M{"approvedBy": "", "approvedAt": "", "revokedBy": "", "revokedAt": ""},
+			"$setOnInsert": bson.M{"createdAt": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&link)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error requesting guardian link"})
+		return
+	}
+
+	gc.recordLinkActivity(c, "guardian_link_requested", link)
+	username, _ := c.Get("username")
+	gc.notify(student.ID, "A guardian asked to see your records",
+		fmt.Sprintf("%s has asked to see your attendance, performance and remarks. You can approve or decline the request at %s/frontend/student-dashboard.html\n",
+			username, appURL()))
+
+	c.JSON(http.StatusAccepted, gin.H{"message": linkRequestAccepted})
+}
+
+// RemoveStudentLink withdraws a request or gives up access to a student
+func (gc *GuardianController) RemoveStudentLink(c *gin.Context) {
+	studentID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid student ID"})
+		return
+	}
+	guardianID, _ := c.Get("userId")
+
+	link, ok := gc.changeLink(c,
+		bson.M{"guardianId": guardianID.(primitive.ObjectID), "studentId": studentID, "status": bson.M{"$in": []string{"pending", "active"}}},
+		revokeUpdate(c, "revoked"),
+	)
+	if !ok {
+		return
+	}
+
+	gc.recordLinkActivity(c, "guardian_link_revoked", link)
+	c.JSON(http.StatusOK, gin.H{"message": "Guardian link removed"})
+}
+
+// UpdateStudentNotifications opts in to or out of attendance-shortage emails
+// about a linked student
+func (gc *GuardianController) UpdateStudentNotifications(c *gin.Context) {
+	studentID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid student ID"})
+		return
+	}
+
+	var input struct {
+		Attendance *bool `json:"attendance" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	guardianID, _ := c.Get("userId")
+	link, ok := gc.changeLink(c,
+		bson.M{"guardianId": guardianID.(primitive.ObjectID), "studentId": studentID, "status": "active"},
+		bson.M{"$set": bson.M{"notifyAttendance": *input.Attendance, "updatedAt": time.Now()}},
+	)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, link)
+}
+
+// linkedStudent returns the student in the id path parameter if the
+// authenticated guardian has an active link to them. ok is false once an
+// error has been written.
+func (gc *GuardianController) linkedStudent(c *gin.Context) (studentID primitive.ObjectID, ok bool) {
+	studentID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid student ID"})
+		return studentID, false
+	}
+	guardianID, _ := c.Get("userId")
+
+	count, err := gc.db.Collection("guardian_links").CountDocuments(context.Background(), bson.M{
+		"guardianId": guardianID.(primitive.ObjectID),
+		"studentId":  studentID,
+		"status":     "active",
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching guardian links"})
+		return studentID, false
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Student not found"})
+		return studentID, false
+	}
+
+	count, err = gc.db.Collection("users").CountDocuments(context.Background(), bson.M{"_id": studentID, "deletedAt": nil})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching student"})
+		return studentID, false
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Student not found"})
+		return studentID, false
+	}
+	return studentID, true
+}
+
+// GetStudentAttendance returns a linked student's attendance, as the student
+// sees it
+func (gc *GuardianController) GetStudentAttendance(c *gin.Context) {
+	if studentID, ok := gc.linkedStudent(c); ok {
+		studentAttendance(c, gc.db, studentID)
+	}
+}
+
+// GetStudentPerformance returns a linked student's performance, as the
+// student sees it
+func (gc *GuardianController) GetStudentPerformance(c *gin.Context) {
+	if studentID, ok := gc.linkedStudent(c); ok {
+		studentPerformance(c, gc.db, studentID)
+	}
+}
+
+// GetStudentRemarks returns the remarks about a linked student that faculty
+// shared with guardians
+func (gc *GuardianController) GetStudentRemarks(c *gin.Context) {
+	if studentID, ok := gc.linkedStudent(c); ok {
+		studentRemarks(c, gc.db, bson.M{"studentId": studentID, "visibility": "guardian"})
+	}
+}
+
+// GetMyGuardians returns the guardians linked to, or asking to be linked to,
+// the authenticated student
+func (gc *GuardianController) GetMyGuardians(c *gin.Context) {
+	studentID, _ := c.Get("userId")
+	filter := bson.M{"studentId": studentID.(primitive.ObjectID)}
+	if c.Query("status") == "" {
+		filter["status"] = bson.M{"$in": []string{"pending", "active"}}
+	}
+	gc.respondWithLinks(c, filter)
+}
+
+// studentLinkFilter matches a link of the authenticated student by the id
+// path parameter. ok is false once an error has been written.
+func studentLinkFilter(c *gin.Context, statuses ...string) (filter bson.M, ok bool) {
+	linkID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid link ID"})
+		return nil, false
+	}
+	studentID, _ := c.Get("userId")
+	return bson.M{
+		"_id":       linkID,
+		"studentId": studentID.(primitive.ObjectID),
+		"status":    bson.M{"$in": statuses},
+	}, true
+}
+
+// ApproveGuardian gives a guardian who asked for it access to the
+// authenticated student's records
+func (gc *GuardianController) ApproveGuardian(c *gin.Context) {
+	filter, ok := studentLinkFilter(c, "pending")
+	if !ok {
+		return
+	}
+	link, ok := gc.changeLink(c, filter, approveUpdate(c))
+	if !ok {
+		return
+	}
+
+	gc.recordLinkActivity(c, "guardian_link_approved", link)
+	username, _ := c.Get("username")
+	gc.notify(link.GuardianID, "Your guardian request was approved",
+		fmt.Sprintf("%s approved your request. You can now see their records at %s/frontend/guardian-dashboard.html\n", username, appURL()))
+
+	c.JSON(http.StatusOK, link)
+}
+
+// DeclineGuardian refuses a guardian's request
+func (gc *GuardianController) DeclineGuardian(c *gin.Context) {
+	filter, ok := studentLinkFilter(c, "pending")
+	if !ok {
+		return
+	}
+	link, ok := gc.changeLink(c, filter, revokeUpdate(c, "declined"))
+	if !ok {
+		return
+	}
+
+	gc.recordLinkActivity(c, "guardian_link_declined", link)
+	c.JSON(http.StatusOK, link)
+}
+
+// RevokeGuardian ends a guardian's access to the authenticated student's
+// records
+func (gc *GuardianController) RevokeGuardian(c *gin.Context) {
+	filter, ok := studentLinkFilter(c, "pending", "active")
+	if !ok {
+		return
+	}
+	link, ok := gc.changeLink(c, filter, revokeUpdate(c, "revoked"))
+	if !ok {
+		return
+	}
+
+	gc.recordLinkActivity(c, "guardian_link_revoked", link)
+	c.JSON(http.StatusOK, gin.H{"message": "Guardian access revoked"})
+}
+
+// scopedStudentIDs returns the students within a scoped admin's department,
+// or nil for admins who are not limited to one
+func (gc *GuardianController) scopedStudentIDs(ctx context.Context, c *gin.Context) ([]primitive.ObjectID, error) {
+	if departmentScope(c) == nil {
+		return nil, nil
+	}
+	cursor, err := gc.db.Collection("users").Find(ctx,
+		scopeFilter(c, bson.M{"userType": "student", "deletedAt": nil}),
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var students []models.User
+	if err := cursor.All(ctx, &students); err != nil {
+		return nil, err
+	}
+	ids := []primitive.ObjectID{}
+	for _, student := range students {
+		ids = append(ids, student.ID)
+	}
+	return ids, nil
+}
+
+// GetGuardianLinks returns guardian links, optionally filtered by the
+// status, studentId and guardianId query parameters
+func (gc *GuardianController) GetGuardianLinks(c *gin.Context) {
+	filter := bson.M{}
+	for _, param := range []string{"studentId", "guardianId"} {
+		if value := c.Query(param); value != "" {
+			id, err := primitive.ObjectIDFromHex(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+				return
+			}
+			filter[param] = id
+		}
+	}
+
+	scoped, err := gc.scopedStudentIDs(context.Background(), c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching guardian links"})
+		return
+	}
+	if scoped != nil {
+		filter["$and"] = []bson.M{{"studentId": bson.M{"$in": scoped}}}
+	}
+
+	gc.respondWithLinks(c, filter)
+}
+
+// CreateGuardianLink links a guardian to a student without waiting for the
+// student's consent
+func (gc *GuardianController) CreateGuardianLink(c *gin.Context) {
+	var input struct {
+		GuardianID   primitive.ObjectID `json:"guardianId" binding:"required"`
+		StudentID    primitive.ObjectID `json:"studentId" binding:"required"`
+		Relationship string             `json:"relationship"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	ctx := context.Background()
+	count, err := gc.db.Collection("users").CountDocuments(ctx, bson.M{"_id": input.GuardianID, "userType": "guardian", "deletedAt": nil})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching guardian"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid guardian ID"})
+		return
+	}
+	count, err = gc.db.Collection("users").CountDocuments(ctx, scopeFilter(c, bson.M{"_id": input.StudentID, "userType": "student", "deletedAt": nil}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching student"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid student ID"})
+		return
+	}
+
+	adminID, _ := c.Get("userId")
+	update := approveUpdate(c)
+	set := update["$set"].(bson.M)
+	if relationship := strings.TrimSpace(input.Relationship); relationship != "" {
+		set["relationship"] = relationship
+	}
+	update["$setOnInsert"] = bson.M{
+		"requestedBy":      adminID.(primitive.ObjectID),
+		"notifyAttendance": false,
+		"createdAt":        set["updatedAt"],
+	}
+
+	var link models.GuardianLink
+	err = gc.db.Collection("guardian_links").FindOneAndUpdate(ctx,
+		bson.M{"guardianId": input.GuardianID, "studentId": input.StudentID},
+		update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&link)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating guardian link"})
+		return
+	}
+
+	gc.recordLinkActivity(c, "guardian_link_approved", link)
+	gc.notify(link.GuardianID, "You have been linked to a student",
+		fmt.Sprintf("An administrator linked your account to a student. You can see their records at %s/frontend/guardian-dashboard.html\n", appURL()))
+
+	c.JSON(http.StatusCreated, link)
+}
+
+// adminLinkFilter matches the link in the id path parameter if its student
+// is within the admin's scope. ok is false once an error has been written.
+func (gc *GuardianController) adminLinkFilter(c *gin.Context, statuses ...string) (filter bson.M, ok bool) {
+	linkID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid link ID"})
+		return nil, false
+	}
+	filter = bson.M{"_id": linkID, "status": bson.M{"$in": statuses}}
+
+	scoped, err := gc.scopedStudentIDs(context.Background(), c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching guardian links"})
+		return nil, false
+	}
+	if scoped != nil {
+		filter["studentId"] = bson.M{"$in": scoped}
+	}
+	return filter, true
+}
+
+// ApproveGuardianLink approves a pending request on the student's behalf
+func (gc *GuardianController) ApproveGuardianLink(c *gin.Context) {
+	filter, ok := gc.adminLinkFilter(c, "pending")
+	if !ok {
+		return
+	}
+	link, ok := gc.changeLink(c, filter, approveUpdate(c))
+	if !ok {
+		return
+	}
+
+	gc.recordLinkActivity(c, "guardian_link_approved", link)
+	gc.notify(link.GuardianID, "Your guardian request was approved",
+		fmt.Sprintf("An administrator approved your request. You can now see the student's records at %s/frontend/guardian-dashboard.html\n", appURL()))
+
+	c.JSON(http.StatusOK, link)
+}
+
+// RevokeGuardianLink ends a guardian's access or refuses their request
+func (gc *GuardianController) RevokeGuardianLink(c *gin.Context) {
+	filter, ok := gc.adminLinkFilter(c, "pending", "active")
+	if !ok {
+		return
+	}
+	link, ok := gc.changeLink(c, filter, revokeUpdate(c, "revoked"))
+	if !ok {
+		return
+	}
+
+	gc.recordLinkActivity(c, "guardian_link_revoked", link)
+	c.JSON(http.StatusOK, gin.H{"message": "Guardian access revoked"})
+}
+
+// attendanceShortage is a class in which a student's attendance is below
+// the target
+type attendanceShortage struct {
+	ClassName string
+	Present   int
+	Sessions  int
+}
+
+// attendanceShortages returns the active classes in which a student has
+// attended less than the risk attendance target
+func (gc *GuardianController) attendanceShortages(ctx context.Context, studentID primitive.ObjectID) ([]attendanceShortage, error) {
+	pipeline := []bson.M{
+		{"$match": bson.M{"$or": []bson.M{{"present": studentID}, {"absent": studentID}}}},
+		{"$group": bson.M{
+			"_id":      "$classId",
+			"sessions": bson.M{"$sum": 1},
+			"present": bson.M{"$sum": bson.M{
+				"$cond": []interface{}{bson.M{"$in": []interface{}{studentID, bson.M{"$ifNull": []interface{}{"$present", []interface{}{}}}}}, 1, 0},
+			}},
+		}},
+		{"$lookup": bson.M{
+			"from":         "classes",
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "class",
+		}},
+		{"$unwind": "$class"},
+		{"$match": bson.M{"class.status": "active", "class.deletedAt": nil, "sessions": bson.M{"$gte": attendanceAlertMinSessions}}},
+		{"$sort": bson.M{"class.name": 1}},
+	}
+	cursor, err := gc.db.Collection("attendance").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Sessions int          `bson:"sessions"`
+		Present  int          `bson:"present"`
+		Class    models.Class `bson:"class"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	shortages := []attendanceShortage{}
+	for _, row := range rows {
+		if float64(row.Present)/float64(row.Sessions)*100 < riskAttendanceTarget {
+			shortages = append(shortages, attendanceShortage{ClassName: row.Class.Name, Present: row.Present, Sessions: row.Sessions})
+		}
+	}
+	return shortages, nil
+}
+
+// SendAttendanceAlerts emails opted-in guardians whose students have an
+// attendance shortage, at most once per attendanceAlertInterval per student,
+// and returns the number of emails sent
+func (gc *GuardianController) SendAttendanceAlerts(ctx context.Context) (int, error) {
+	now := time.Now()
+	cursor, err := gc.db.Collection("guardian_links").Find(ctx, bson.M{
+		"status":           "active",
+		"notifyAttendance": true,
+		"$or": []bson.M{
+			{"lastNotifiedAt": bson.M{"$exists": false}},
+			{"lastNotifiedAt": bson.M{"$lt": now.Add(-attendanceAlertInterval)}},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var links []models.GuardianLink
+	if err := cursor.All(ctx, &links); err != nil {
+		return 0, err
+	}
+
+	shortagesByStudent := make(map[primitive.ObjectID][]attendanceShortage)
+	sent := 0
+	for _, link := range links {
+		shortages, checked := shortagesByStudent[link.StudentID]
+		if !checked {
+			shortages, err = gc.attendanceShortages(ctx, link.StudentID)
+			if err != nil {
+				return sent, err
+			}
+			shortagesByStudent[link.StudentID] = shortages
+		}
+		if len(shortages) == 0 {
+			continue
+		}
+
+		var guardian, student models.User
+		if err := gc.db.Collection("users").FindOne(ctx, bson.M{"_id": link.GuardianID, "disabled": bson.M{"$ne": true}, "deletedAt": nil}).Decode(&guardian); err != nil {
+			continue
+		}
+		if err := gc.db.Collection("users").FindOne(ctx, bson.M{"_id": link.StudentID, "deletedAt": nil}).Decode(&student); err != nil {
+			continue
+		}
+
+		var body strings.Builder
+		fmt.Fprintf(&body, "Hello %s,\n\n%s's attendance is below %.0f%% in:\n\n", guardian.Username, student.Username, riskAttendanceTarget)
+		for _, s := range shortages {
+			fmt.Fprintf(&body, "- %s: %.0f%% (%d of %d sessions)\n", s.ClassName, float64(s.Present)/float64(s.Sessions)*100, s.Present, s.Sessions)
+		}
+		fmt.Fprintf(&body, "\nYou can see their attendance, or turn off these emails, at %s/frontend/guardian-dashboard.html\n", appURL())
+
+		message := mailer.Message{
+			To:      guardian.Email,
+			Subject: fmt.Sprintf("Attendance alert for %s", student.Username),
+			Body:    body.String(),
+		}
+		if err := gc.mail.Send(ctx, message); err != nil {
+			log.Printf("Error sending attendance alert to guardian %s: %v", guardian.ID.Hex(), err)
+			continue
+		}
+		if _, err := gc.db.Collection("guardian_links").UpdateOne(ctx,
+			bson.M{"_id": link.ID},
+			bson.M{"$set": bson.M{"lastNotifiedAt": now}},
+		); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// StartAttendanceAlerts runs SendAttendanceAlerts once a day at the given
+// hour (server local time)
+func (gc *GuardianController) StartAttendanceAlerts(hour int) {
+	go func() {
+		for {
+			now := time.Now()
+			next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, now.Location())
+			if !next.After(now) {
+				next = next.Add(24 * time.Hour)
+			}
+			time.Sleep(time.Until(next))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			n, err := gc.SendAttendanceAlerts(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("Guardian attendance alerts failed: %v", err)
+				continue
+			}
+			log.Printf("Sent %d guardian attendance alerts", n)
+		}
+	}()
+}
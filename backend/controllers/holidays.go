@@ -10,6 +10,8 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"classscheduling/listing"
 )
 
 type Holiday struct {
@@ -17,8 +19,10 @@ type Holiday struct {
 	Name        string             `bson:"name" json:"name"`
 	Date        time.Time          `bson:"date" json:"date"`
 	Description string             `bson:"description" json:"description"`
-	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
-	UpdatedAt   time.Time          `bson:"updatedAt" json:"updatedAt"`
+	// DepartmentID limits the holiday to one department; nil applies to all
+	DepartmentID *primitive.ObjectID `bson:"departmentId,omitempty" json:"departmentId,omitempty"`
+	CreatedAt    time.Time           `bson:"createdAt" json:"createdAt"`
+	UpdatedAt    time.Time           `bson:"updatedAt" json:"updatedAt"`
 }
 
 // Fix unexported functions by properly casing struct names and function names
@@ -31,8 +35,27 @@ func NewHolidayController(db *mongo.Database) *HolidayController {
 	return &HolidayController{db: db}
 }
 
-// GetHolidays returns all holidays, optionally filtered by date range
+// holidayListSpec is what the holiday list can be filtered, sorted and
+// searched by
+var holidayListSpec = listing.Spec{
+	Filters: map[string]listing.Field{
+		"departmentId": {Path: "departmentId", Kind: listing.ObjectID},
+	},
+	Sorts: map[string]string{
+		"date": "date",
+		"name": "name",
+	},
+	Search:      []string{"name", "description"},
+	DefaultSort: "date",
+}
+
+// GetHolidays returns a page of holidays, optionally filtered by date range
 func (hc *HolidayController) GetHolidays(c *gin.Context) {
+	query, ok := parseList(c, holidayListSpec)
+	if !ok {
+		return
+	}
+
 	startDate := c.Query("startDate")
 	endDate := c.Query("endDate")
 
@@ -58,28 +81,28 @@ func (hc *HolidayController) GetHolidays(c *gin.Context) {
 		}
 	}
 
-	cursor, err := hc.db.Collection("holidays").Find(context.Background(), filter)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching holidays"})
-		return
+	// Department admins see institution-wide holidays and their own department's
+	if department := departmentScope(c); department != nil {
+		filter["departmentId"] = bson.M{"$in": []interface{}{*department, nil}}
 	}
-	defer cursor.Close(context.Background())
 
 	var holidays []Holiday
-	if err := cursor.All(context.Background(), &holidays); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error processing holidays"})
+	page, err := query.Find(context.Background(), hc.db.Collection("holidays"), filter, &holidays)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching holidays"})
 		return
 	}
 
-	c.JSON(http.StatusOK, holidays)
+	c.JSON(http.StatusOK, page)
 }
 
 // CreateHoliday creates a new holiday
 func (hc *HolidayController) CreateHoliday(c *gin.Context) {
 	var input struct {
-		Name        string `json:"name" binding:"required"`
-		Date        string `json:"date" binding:"required"`
-		Description string `json:"description" binding:"required"`
+		Name         string              `json:"name" binding:"required"`
+		Date         string              `json:"date" binding:"required"`
+		Description  string              `json:"description" binding:"required"`
+		DepartmentID *primitive.ObjectID `json:"departmentId"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -93,12 +116,19 @@ func (hc *HolidayController) CreateHoliday(c *gin.Context) {
 		return
 	}
 
+	// Department admins can only add holidays to their own department
+	department, ok := resolveDepartment(c, hc.db, input.DepartmentID)
+	if !ok {
+		return
+	}
+
 	holiday := Holiday{
-		Name:        input.Name,
-		Date:        date,
-		Description: input.Description,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+		Name:         input.Name,
+		Date:         date,
+		Description:  input.Description,
+		DepartmentID: department,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
 	}
 
 	result, err := hc.db.Collection("holidays").InsertOne(context.Background(), holiday)
@@ -119,7 +149,7 @@ func (hc *HolidayController) DeleteHoliday(c *gin.Context) {
 		return
 	}
 
-	result, err := hc.db.Collection("holidays").DeleteOne(context.Background(), bson.M{"_id": id})
+	result, err := hc.db.Collection("holidays").DeleteOne(context.Background(), scopeFilter(c, bson.M{"_id": id}))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting holiday"})
 		return
@@ -177,7 +207,7 @@ func (hc *HolidayController) UpdateTimetable(c *gin.Context) {
 
 	result := hc.db.Collection("classes").FindOneAndUpdate(
 		context.Background(),
-		bson.M{"_id": classID},
+		scopeFilter(c, bson.M{"_id": classID, "deletedAt": nil}),
 		update,
 		options.FindOneAndUpdate().SetReturnDocument(options.After),
 	)
@@ -0,0 +1,20 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"classscheduling/listing"
+)
+
+// parseList reads the pagination, filter, sort and search parameters of a
+// list request. ok is false once an error has been written.
+func parseList(c *gin.Context, spec listing.Spec) (query *listing.Query, ok bool) {
+	query, err := listing.Parse(c.Request.URL.Query(), spec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return query, true
+}
@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"classscheduling/models"
+)
+
+// loginPolicy holds the brute-force protection settings for Login
+type loginPolicy struct {
+	Window           time.Duration // rate limit window
+	IPLimit          int           // attempts per IP per window
+	AccountLimit     int           // attempts per username per window
+	LockoutThreshold int           // consecutive failures before the account is locked
+	LockoutDuration  time.Duration
+	MaxDelay         time.Duration // cap on the progressive delay after a failure
+}
+
+// loginPolicyFromEnv reads the policy, falling back to defaults for unset values
+func loginPolicyFromEnv() loginPolicy {
+	return loginPolicy{
+		Window:           envDuration("LOGIN_RATE_WINDOW", 15*time.Minute),
+		IPLimit:          envInt("LOGIN_IP_LIMIT", 50),
+		AccountLimit:     envInt("LOGIN_ACCOUNT_LIMIT", 10),
+		LockoutThreshold: envInt("LOCKOUT_THRESHOLD", 5),
+		LockoutDuration:  envDuration("LOCKOUT_DURATION", 15*time.Minute),
+		MaxDelay:         envDuration("LOGIN_MAX_DELAY", 5*time.Second),
+	}
+}
+
+func envInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+// failureDelay is the progressive delay applied after the nth consecutive
+// failure: 250ms, 500ms, 1s, 2s... up to MaxDelay
+func (p loginPolicy) failureDelay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	delay := time.Duration(float64(250*time.Millisecond) * math.Pow(2, float64(failures-1)))
+	if delay > p.MaxDelay || delay <= 0 {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// checkLoginRate applies the per-IP and per-account limits and writes a 429
+// response when either is exceeded
+func (ac *AuthController) checkLoginRate(c *gin.Context, username string) bool {
+	ctx := context.Background()
+	keys := []struct {
+		key   string
+		limit int
+	}{
+		{"login:ip:" + c.ClientIP(), ac.policy.IPLimit},
+		{"login:account:" + strings.ToLower(username), ac.policy.AccountLimit},
+	}
+
+	for _, k := range keys {
+		allowed, retryAfter, err := ac.limiter.Allow(ctx, k.key, k.limit, ac.policy.Window)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return false
+		}
+		if !allowed {
+			recordActivity(ac.db, c, models.Activity{
+				Type:        "login_rate_limited",
+				Username:    username,
+				Description: fmt.Sprintf("Login attempts for %s rate limited", username),
+			})
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many login attempts. Please try again later"})
+			return false
+		}
+	}
+	return true
+}
+
+// recordFailedLogin counts a failed attempt against the user, locks the
+// account once the threshold is reached and returns the failure count
+func (ac *AuthController) recordFailedLogin(c *gin.Context, user models.User) int {
+	ctx := context.Background()
+	var updated models.User
+	err := ac.db.Collection("users").FindOneAndUpdate(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$inc": bson.M{"failedLogins": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		return user.FailedLogins + 1
+	}
+
+	recordActivity(ac.db, c, models.Activity{
+		Type:        "login_failed",
+		UserID:      &user.ID,
+		Username:    user.Username,
+		Description: fmt.Sprintf("Failed login for %s (%d consecutive)", user.Username, updated.FailedLogins),
+	})
+
+	if updated.FailedLogins >= ac.policy.LockoutThreshold {
+		lockedUntil := time.Now().Add(ac.policy.LockoutDuration)
+		_, err := ac.db.Collection("users").UpdateOne(ctx,
+			bson.M{"_id": user.ID},
+			bson.M{"$set": bson.M{"lockedUntil": lockedUntil, "failedLogins": 0}},
+		)
+		if err == nil {
+			recordActivity(ac.db, c, models.Activity{
+				Type:        "account_locked",
+				UserID:      &user.ID,
+				Username:    user.Username,
+				Description: fmt.Sprintf("Account %s locked until %s after %d failed logins", user.Username, lockedUntil.Format(time.RFC3339), updated.FailedLogins),
+			})
+		}
+	}
+
+	return updated.FailedLogins
+}
+
+// clearFailedLogins resets the failure counter after a successful login
+func (ac *AuthController) clearFailedLogins(user models.User) error {
+	if user.FailedLogins == 0 && user.LockedUntil == nil {
+		return nil
+	}
+	_, err := ac.db.Collection("users").UpdateOne(context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$set":   bson.M{"failedLogins": 0},
+			"$unset": bson.M{"lockedUntil": ""},
+		},
+	)
+	return err
+}
+
+// UnlockUser clears a lockout and the failed login counter (admin only)
+func (uc *UserController) UnlockUser(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var user models.User
+	err = uc.db.Collection("users").FindOneAndUpdate(context.Background(),
+		scopeFilter(c, bson.M{"_id": userID, "deletedAt": nil}),
+		bson.M{
+			"$set":   bson.M{"failedLogins": 0, "updatedAt": time.Now()},
+			"$unset": bson.M{"lockedUntil": ""},
+		},
+	).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unlocking user"})
+		return
+	}
+
+	recordActivity(uc.db, c, models.Activity{
+		Type:        "account_unlocked",
+		UserID:      &user.ID,
+		Username:    user.Username,
+		Description: fmt.Sprintf("Account %s unlocked by an admin", user.Username),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked successfully"})
+}
@@ -9,7 +9,9 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"classscheduling/listing"
 	"classscheduling/models"
 )
 
@@ -34,6 +36,7 @@ func (pc *PerformanceController) AddPerformance(c *gin.Context) {
 		AssessmentName string             `json:"assessmentName" binding:"required"`
 		Score          float64            `json:"score" binding:"required"`
 		TotalMarks     float64            `json:"totalMarks" binding:"required"`
+		Weight         float64            `json:"weight"`
 		Date           time.Time          `json:"date" binding:"required"`
 	}
 
@@ -45,8 +48,9 @@ func (pc *PerformanceController) AddPerformance(c *gin.Context) {
 	// Verify class exists
 	var class models.Class
 	err = pc.db.Collection("classes").FindOne(context.Background(), bson.M{
-		"_id":      classID,
-		"enrolled": input.StudentID,
+		"_id":       classID,
+		"enrolled":  input.StudentID,
+		"deletedAt": nil,
 	}).Decode(&class)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -64,6 +68,7 @@ func (pc *PerformanceController) AddPerformance(c *gin.Context) {
 		AssessmentName: input.AssessmentName,
 		Score:          input.Score,
 		TotalMarks:     input.TotalMarks,
+		Weight:         input.Weight,
 		Date:           input.Date,
 		CreatedAt:      time.Now(),
 		UpdatedAt:      time.Now(),
@@ -79,182 +84,206 @@ func (pc *PerformanceController) AddPerformance(c *gin.Context) {
 	c.JSON(http.StatusCreated, performance)
 }
 
-// GetClassPerformance retrieves performance records for all students in a class
-func (pc *PerformanceController) GetClassPerformance(c *gin.Context) {
-	classID, err := primitive.ObjectIDFromHex(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class ID"})
-		return
-	}
+// classPerformanceListSpec is what a class's performance records can be
+// filtered, sorted and searched by
+var classPerformanceListSpec = listing.Spec{
+	Filters: map[string]listing.Field{
+		"studentId":      {Path: "studentId", Kind: listing.ObjectID},
+		"assessmentName": {Path: "assessmentName"},
+	},
+	Sorts: map[string]string{
+		"date":           "date",
+		"score":          "score",
+		"assessmentName": "assessmentName",
+		"student":        "student.username",
+	},
+	Search:      []string{"assessmentName", "student.username"},
+	DefaultSort: "-date",
+}
 
-	// Build the pipeline for aggregation
-	pipeline := []bson.M{
-		{
-			"$match": bson.M{"classId": classID},
-		},
+// studentPerformanceListSpec is what a student's performance records can be
+// filtered, sorted and searched by
+var studentPerformanceListSpec = listing.Spec{
+	Filters: map[string]listing.Field{
+		"classId":        {Path: "classId", Kind: listing.ObjectID},
+		"assessmentName": {Path: "assessmentName"},
+	},
+	Sorts: map[string]string{
+		"date":           "date",
+		"score":          "score",
+		"assessmentName": "assessmentName",
+		"class":          "class.name",
+	},
+	Search:      []string{"assessmentName", "class.name"},
+	DefaultSort: "-date",
+}
+
+// listPerformance returns a page of the performance records matching base,
+// each with the fields of a related document joined in from another
+// collection. ok is false once an error has been written.
+func listPerformance(c *gin.Context, db *mongo.Database, query *listing.Query, base bson.M, from, localField, as string, fields bson.M) (page *listing.Page, ok bool) {
+	lookup := []bson.M{
 		{
 			"$lookup": bson.M{
-				"from":         "users",
-				"localField":   "studentId",
+				"from":         from,
+				"localField":   localField,
 				"foreignField": "_id",
-				"as":           "student",
+				"as":           as,
 			},
 		},
 		{
-			"$unwind": "$student",
+			"$unwind": "$" + as,
 		},
-		{
-			"$project": bson.M{
-				"_id":            1,
-				"assessmentName": 1,
-				"score":          1,
-				"totalMarks":     1,
-				"date":           1,
-				"student": bson.M{
-					"_id":      1,
-					"username": 1,
-					"email":    1,
-				},
-			},
-		},
-		{
-			"$sort": bson.M{"date": -1},
+	}
+	project := bson.M{
+		"$project": bson.M{
+			"_id":            1,
+			"assessmentName": 1,
+			"score":          1,
+			"totalMarks":     1,
+			"date":           1,
+			as:               fields,
 		},
 	}
 
-	cursor, err := pc.db.Collection("performance").Aggregate(context.Background(), pipeline)
+	// The related documents are only joined in before paging when the
+	// request filters, searches or sorts on them
+	var join []bson.M
+	shape := append(lookup, project)
+	if query.Uses(as + ".") {
+		join = lookup
+		shape = []bson.M{project}
+	}
+
+	var results []bson.M
+	page, err := query.Aggregate(context.Background(), db.Collection("performance"), base, join, shape, &results)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching performance records"})
+		return nil, false
+	}
+	return page, true
+}
+
+// GetClassPerformance retrieves a page of performance records for the
+// students in a class, newest first
+func (pc *PerformanceController) GetClassPerformance(c *gin.Context) {
+	classID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class ID"})
+		return
+	}
+	query, ok := parseList(c, classPerformanceListSpec)
+	if !ok {
 		return
 	}
-	defer cursor.Close(context.Background())
 
-	var results []bson.M
-	if err := cursor.All(context.Background(), &results); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding performance records"})
+	page, ok := listPerformance(c, pc.db, query, bson.M{"classId": classID},
+		"users", "studentId", "student", bson.M{"_id": 1, "username": 1, "email": 1})
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, results)
+	c.JSON(http.StatusOK, page)
 }
 
 // GetStudentPerformance retrieves performance records for a specific student
 func (pc *PerformanceController) GetStudentPerformance(c *gin.Context) {
 	studentID, _ := c.Get("userId")
-	studentObjID := studentID.(primitive.ObjectID)
-
-	// Optional class ID filter
-	classID := c.Query("classId")
+	studentPerformance(c, pc.db, studentID.(primitive.ObjectID))
+}
 
-	// Build the pipeline for aggregation
-	pipeline := []bson.M{
-		{
-			"$match": bson.M{"studentId": studentObjID},
-		},
-		{
-			"$lookup": bson.M{
-				"from":         "classes",
-				"localField":   "classId",
-				"foreignField": "_id",
-				"as":           "class",
-			},
-		},
-		{
-			"$unwind": "$class",
-		},
+// studentPerformance writes a page of a student's performance records with
+// per-class statistics over all of them, optionally filtered by class
+func studentPerformance(c *gin.Context, db *mongo.Database, studentObjID primitive.ObjectID) {
+	query, ok := parseList(c, studentPerformanceListSpec)
+	if !ok {
+		return
 	}
 
-	if classID != "" {
-		if classObjID, err := primitive.ObjectIDFromHex(classID); err == nil {
-			pipeline[0]["$match"].(bson.M)["classId"] = classObjID
-		}
+	base := bson.M{"studentId": studentObjID}
+	if err := excludeDeletedClasses(context.Background(), db, base); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching performance records"})
+		return
+	}
+	page, ok := listPerformance(c, db, query, base,
+		"classes", "classId", "class", bson.M{"_id": 1, "name": 1, "schedule": 1})
+	if !ok {
+		return
 	}
 
-	// Add sorting and projection
-	pipeline = append(pipeline,
-		bson.M{
-			"$sort": bson.M{"date": -1},
-		},
-		bson.M{
-			"$project": bson.M{
-				"_id":            1,
-				"assessmentName": 1,
-				"score":          1,
-				"totalMarks":     1,
-				"date":           1,
-				"class": bson.M{
-					"_id":      1,
-					"name":     1,
-					"schedule": 1,
-				},
+	// Statistics cover every record of the student, or of the requested
+	// class, not just the current page
+	statsMatch := bson.M{"studentId": studentObjID, "classId": base["classId"]}
+	if classID, ok := query.Filter["classId"]; ok {
+		statsMatch["$and"] = []bson.M{{"classId": classID}}
+	}
+	cursor, err := db.Collection("performance").Aggregate(context.Background(), []bson.M{
+		{"$match": statsMatch},
+		{
+			"$group": bson.M{
+				"_id":             "$classId",
+				"totalScore":      bson.M{"$sum": "$score"},
+				"totalMarks":      bson.M{"$sum": "$totalMarks"},
+				"assessmentCount": bson.M{"$sum": 1},
 			},
 		},
-	)
-
-	cursor, err := pc.db.Collection("performance").Aggregate(context.Background(), pipeline)
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching performance records"})
 		return
 	}
 	defer cursor.Close(context.Background())
 
-	var results []bson.M
-	if err := cursor.All(context.Background(), &results); err != nil {
+	var classStats []struct {
+		ClassID         primitive.ObjectID `bson:"_id"`
+		TotalScore      float64            `bson:"totalScore"`
+		TotalMarks      float64            `bson:"totalMarks"`
+		AssessmentCount int                `bson:"assessmentCount"`
+	}
+	if err := cursor.All(context.Background(), &classStats); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding performance records"})
 		return
 	}
 
-	// Calculate statistics for each class
-	if len(results) > 0 {
-		classStats := make(map[string]struct {
-			TotalScore      float64
-			TotalMarks      float64
-			AssessmentCount int
-		})
-
-		for _, record := range results {
-			class := record["class"].(bson.M)
-			classID := class["_id"].(primitive.ObjectID).Hex()
-			score := record["score"].(float64)
-			totalMarks := record["totalMarks"].(float64)
-
-			stats := classStats[classID]
-			stats.TotalScore += score
-			stats.TotalMarks += totalMarks
-			stats.AssessmentCount++
-			classStats[classID] = stats
+	stats := make(map[string]gin.H)
+	for _, classData := range classStats {
+		stat := gin.H{
+			"averageScore":    classData.TotalScore / float64(classData.AssessmentCount),
+			"assessmentCount": classData.AssessmentCount,
 		}
-
-		// Add statistics to the response
-		stats := make(map[string]gin.H)
-		for classID, classData := range classStats {
-			stats[classID] = gin.H{
-				"averageScore":    classData.TotalScore / float64(classData.AssessmentCount),
-				"averagePercent":  (classData.TotalScore / classData.TotalMarks) * 100,
-				"assessmentCount": classData.AssessmentCount,
-			}
+		if classData.TotalMarks > 0 {
+			stat["averagePercent"] = (classData.TotalScore / classData.TotalMarks) * 100
 		}
-
-		c.JSON(http.StatusOK, gin.H{
-			"records": results,
-			"stats":   stats,
-		})
-	} else {
-		c.JSON(http.StatusOK, gin.H{
-			"records": []bson.M{},
-			"stats":   gin.H{},
-		})
+		stats[classData.ClassID.Hex()] = stat
 	}
+
+	c.JSON(http.StatusOK, struct {
+		*listing.Page
+		Stats map[string]gin.H `json:"stats"`
+	}{page, stats})
 }
 
+var (
+	validRemarkCategories   = map[string]bool{"academic": true, "behaviour": true, "commendation": true}
+	validRemarkVisibilities = map[string]bool{"student": true, "guardian": true, "faculty": true}
+)
+
 // GetStudentRemarks retrieves remarks for a specific student
 func (pc *PerformanceController) GetStudentRemarks(c *gin.Context) {
 	studentID, _ := c.Get("userId")
-	studentObjID := studentID.(primitive.ObjectID)
 
+	// Faculty-only remarks are never shown to students
+	studentRemarks(c, pc.db, bson.M{
+		"studentId":  studentID.(primitive.ObjectID),
+		"visibility": bson.M{"$ne": "faculty"},
+	})
+}
+
+// studentRemarks writes the remarks matching match with their class and author
+func studentRemarks(c *gin.Context, db *mongo.Database, match bson.M) {
 	pipeline := []bson.M{
 		{
-			"$match": bson.M{"studentId": studentObjID},
+			"$match": match,
 		},
 		{
 			"$lookup": bson.M{
@@ -267,6 +296,9 @@ func (pc *PerformanceController) GetStudentRemarks(c *gin.Context) {
 		{
 			"$unwind": "$class",
 		},
+		{
+			"$match": bson.M{"class.deletedAt": nil},
+		},
 		{
 			"$lookup": bson.M{
 				"from":         "users",
@@ -280,10 +312,15 @@ func (pc *PerformanceController) GetStudentRemarks(c *gin.Context) {
 		},
 		{
 			"$project": bson.M{
-				"_id":     1,
-				"remarks": 1,
-				"date":    1,
+				"_id":            1,
+				"content":        1,
+				"category":       1,
+				"visibility":     1,
+				"replies":        1,
+				"acknowledgedAt": 1,
+				"createdAt":      1,
 				"class": bson.M{
+					"_id":  1,
 					"name": 1,
 				},
 				"faculty": bson.M{
@@ -292,11 +329,11 @@ func (pc *PerformanceController) GetStudentRemarks(c *gin.Context) {
 			},
 		},
 		{
-			"$sort": bson.M{"date": -1},
+			"$sort": bson.M{"createdAt": -1},
 		},
 	}
 
-	cursor, err := pc.db.Collection("remarks").Aggregate(context.Background(), pipeline)
+	cursor, err := db.Collection("remarks").Aggregate(context.Background(), pipeline)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching remarks"})
 		return
@@ -324,8 +361,10 @@ func (pc *PerformanceController) AddRemarks(c *gin.Context) {
 	facultyObjID := facultyID.(primitive.ObjectID)
 
 	var input struct {
-		StudentID primitive.ObjectID `json:"studentId" binding:"required"`
-		Content   string             `json:"content" binding:"required"`
+		StudentID  primitive.ObjectID `json:"studentId" binding:"required"`
+		Content    string             `json:"content" binding:"required"`
+		Category   string             `json:"category"`
+		Visibility string             `json:"visibility"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -333,11 +372,27 @@ func (pc *PerformanceController) AddRemarks(c *gin.Context) {
 		return
 	}
 
+	if input.Category == "" {
+		input.Category = "academic"
+	}
+	if !validRemarkCategories[input.Category] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid remark category"})
+		return
+	}
+	if input.Visibility == "" {
+		input.Visibility = "student"
+	}
+	if !validRemarkVisibilities[input.Visibility] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid remark visibility"})
+		return
+	}
+
 	// Verify class exists and student is enrolled
 	var class models.Class
 	err = pc.db.Collection("classes").FindOne(context.Background(), bson.M{
-		"_id":      classID,
-		"enrolled": input.StudentID,
+		"_id":       classID,
+		"enrolled":  input.StudentID,
+		"deletedAt": nil,
 	}).Decode(&class)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -350,12 +405,15 @@ func (pc *PerformanceController) AddRemarks(c *gin.Context) {
 
 	// Create remark record
 	remark := models.Remark{
-		ClassID:   classID,
-		StudentID: input.StudentID,
-		FacultyID: facultyObjID,
-		Content:   input.Content,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ClassID:    classID,
+		StudentID:  input.StudentID,
+		FacultyID:  facultyObjID,
+		Content:    input.Content,
+		Category:   input.Category,
+		Visibility: input.Visibility,
+		Replies:    []models.RemarkReply{},
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
 	}
 
 	result, err := pc.db.Collection("remarks").InsertOne(context.Background(), remark)
@@ -367,3 +425,257 @@ func (pc *PerformanceController) AddRemarks(c *gin.Context) {
 	remark.ID = result.InsertedID.(primitive.ObjectID)
 	c.JSON(http.StatusCreated, remark)
 }
+
+// GetClassRemarks returns the remarks the requesting faculty has written for a class
+func (pc *PerformanceController) GetClassRemarks(c *gin.Context) {
+	classID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class ID"})
+		return
+	}
+
+	facultyID, _ := c.Get("userId")
+	facultyObjID := facultyID.(primitive.ObjectID)
+
+	match := bson.M{
+		"classId":   classID,
+		"facultyId": facultyObjID,
+	}
+	if studentID := c.Query("studentId"); studentID != "" {
+		studentObjID, err := primitive.ObjectIDFromHex(studentID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid student ID"})
+			return
+		}
+		match["studentId"] = studentObjID
+	}
+	if category := c.Query("category"); category != "" {
+		match["category"] = category
+	}
+
+	pipeline := []bson.M{
+		{
+			"$match": match,
+		},
+		{
+			"$lookup": bson.M{
+				"from":         "users",
+				"localField":   "studentId",
+				"foreignField": "_id",
+				"as":           "student",
+			},
+		},
+		{
+			"$unwind": "$student",
+		},
+		{
+			"$project": bson.M{
+				"student.password": 0,
+			},
+		},
+		{
+			"$sort": bson.M{"createdAt": -1},
+		},
+	}
+
+	cursor, err := pc.db.Collection("remarks").Aggregate(context.Background(), pipeline)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching remarks"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var remarks []bson.M
+	if err := cursor.All(context.Background(), &remarks); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding remarks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, remarks)
+}
+
+// UpdateRemark edits a remark written by the requesting faculty
+func (pc *PerformanceController) UpdateRemark(c *gin.Context) {
+	remarkID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid remark ID"})
+		return
+	}
+
+	facultyID, _ := c.Get("userId")
+	facultyObjID := facultyID.(primitive.ObjectID)
+
+	var input struct {
+		Content    string `json:"content"`
+		Category   string `json:"category"`
+		Visibility string `json:"visibility"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	update := bson.M{"$set": bson.M{
+		"updatedAt": time.Now(),
+	}}
+
+	if input.Content != "" {
+		update["$set"].(bson.M)["content"] = input.Content
+	}
+	if input.Category != "" {
+		if !validRemarkCategories[input.Category] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid remark category"})
+			return
+		}
+		update["$set"].(bson.M)["category"] = input.Category
+	}
+	if input.Visibility != "" {
+		if !validRemarkVisibilities[input.Visibility] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid remark visibility"})
+			return
+		}
+		update["$set"].(bson.M)["visibility"] = input.Visibility
+	}
+
+	result := pc.db.Collection("remarks").FindOneAndUpdate(
+		context.Background(),
+		bson.M{"_id": remarkID, "facultyId": facultyObjID},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+
+	var updatedRemark models.Remark
+	if err := result.Decode(&updatedRemark); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Remark not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating remark"})
+		return
+	}
+
+	c.JSON(http.StatusOK, updatedRemark)
+}
+
+// DeleteRemark deletes a remark written by the requesting faculty
+func (pc *PerformanceController) DeleteRemark(c *gin.Context) {
+	remarkID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid remark ID"})
+		return
+	}
+
+	facultyID, _ := c.Get("userId")
+	facultyObjID := facultyID.(primitive.ObjectID)
+
+	result, err := pc.db.Collection("remarks").DeleteOne(context.Background(), bson.M{
+		"_id":       remarkID,
+		"facultyId": facultyObjID,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting remark"})
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Remark not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Remark deleted successfully"})
+}
+
+// ReplyToRemark adds a reply to a remark thread. Students may reply to remarks
+// visible to them; faculty may reply to their own remarks.
+func (pc *PerformanceController) ReplyToRemark(c *gin.Context) {
+	remarkID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid remark ID"})
+		return
+	}
+
+	var input struct {
+		Content string `json:"content" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	userID, _ := c.Get("userId")
+	userObjID := userID.(primitive.ObjectID)
+	userType, _ := c.Get("userType")
+
+	filter := bson.M{"_id": remarkID}
+	if userType == "student" {
+		filter["studentId"] = userObjID
+		filter["visibility"] = bson.M{"$ne": "faculty"}
+	} else {
+		filter["facultyId"] = userObjID
+	}
+
+	reply := models.RemarkReply{
+		ID:         primitive.NewObjectID(),
+		AuthorID:   userObjID,
+		AuthorType: userType.(string),
+		Content:    input.Content,
+		CreatedAt:  time.Now(),
+	}
+
+	result, err := pc.db.Collection("remarks").UpdateOne(context.Background(), filter, bson.M{
+		"$push": bson.M{"replies": reply},
+		"$set":  bson.M{"updatedAt": reply.CreatedAt},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error recording reply"})
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Remark not found"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, reply)
+}
+
+// AcknowledgeRemark marks a remark as read by the student
+func (pc *PerformanceController) AcknowledgeRemark(c *gin.Context) {
+	remarkID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid remark ID"})
+		return
+	}
+
+	studentID, _ := c.Get("userId")
+	studentObjID := studentID.(primitive.ObjectID)
+
+	now := time.Now()
+	result := pc.db.Collection("remarks").FindOneAndUpdate(
+		context.Background(),
+		bson.M{
+			"_id":        remarkID,
+			"studentId":  studentObjID,
+			"visibility": bson.M{"$ne": "faculty"},
+		},
+		bson.M{"$set": bson.M{
+			"acknowledgedAt": now,
+			"updatedAt":      now,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+
+	var updatedRemark models.Remark
+	if err := result.Decode(&updatedRemark); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Remark not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error acknowledging remark"})
+		return
+	}
+
+	c.JSON(http.StatusOK, updatedRemark)
+}
@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"classscheduling/models"
+)
+
+const (
+	// avatarMaxBytes is the largest avatar that can be uploaded
+	avatarMaxBytes = 2 << 20
+	// avatarMaxPixels bounds the width and height of an avatar
+	avatarMaxPixels = 4096
+	// avatarURLPrefix is where uploaded avatars are served from
+	avatarURLPrefix = "/uploads/avatars/"
+)
+
+// avatarTypes are the accepted avatar image types and their extensions
+var avatarTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+}
+
+// UploadDir is the directory uploaded files are stored in and served from
+// under /uploads, set by UPLOAD_DIR
+func UploadDir() string {
+	if dir := os.Getenv("UPLOAD_DIR"); dir != "" {
+		return dir
+	}
+	return "uploads"
+}
+
+type ProfileController struct {
+	db *mongo.Database
+}
+
+func NewProfileController(db *mongo.Database) *ProfileController {
+	return &ProfileController{db: db}
+}
+
+// loadMe loads the authenticated user. ok is false once an error has been
+// written.
+func (pc *ProfileController) loadMe(c *gin.Context) (user models.User, ok bool) {
+	userID, _ := c.Get("userId")
+	err := pc.db.Collection("users").FindOne(context.Background(),
+		bson.M{"_id": userID, "deletedAt": nil},
+		options.FindOne().SetProjection(bson.M{"password": 0}),
+	).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return user, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching user"})
+		return user, false
+	}
+	return user, true
+}
+
+// profileResponse is a user with the profile fields that apply to them and
+// the ones they can change themselves
+func profileResponse(user models.User) gin.H {
+	fields, editable := []string{}, []string{}
+	for name, field := range models.ProfileFields {
+		if !field.AppliesTo(user.UserType) {
+			continue
+		}
+		fields = append(fields, name)
+		if field.SelfEdit {
+			editable = append(editable, name)
+		}
+	}
+	sort.Strings(fields)
+	sort.Strings(editable)
+	return gin.H{"user": user, "fields": fields, "editable": editable}
+}
+
+// GetMe returns the authenticated user's account and profile
+func (pc *ProfileController) GetMe(c *gin.Context) {
+	user, ok := pc.loadMe(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, profileResponse(user))
+}
+
+// UpdateMe changes the authenticated user's own profile. Only fields marked
+// as self-editable in models.ProfileFields can be changed here; the others
+// are kept by user managers.
+func (pc *ProfileController) UpdateMe(c *gin.Context) {
+	var input models.ProfileInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+	user, ok := pc.loadMe(c)
+	if !ok {
+		return
+	}
+
+	set, unset, err := input.Changes(user.UserType, true)
+	if err != nil {
+		var profileErr *models.ProfileError
+		if errors.As(err, &profileErr) && profileErr.Forbidden {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "field": profileErr.Field})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(set) == 0 && len(unset) == 0 {
+		c.JSON(http.StatusOK, profileResponse(user))
+		return
+	}
+
+	set["updatedAt"] = time.Now()
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	var updated models.User
+	err = pc.db.Collection("users").FindOneAndUpdate(context.Background(),
+		bson.M{"_id": user.ID, "deletedAt": nil},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"password": 0}),
+	).Decode(&updated)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating profile"})
+		return
+	}
+
+	changed := make([]string, 0, len(set)+len(unset))
+	for name := range set {
+		if name != "updatedAt" {
+			changed = append(changed, name)
+		}
+	}
+	for name := range unset {
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+	recordActivity(pc.db, c, models.Activity{
+		Type:        "profile_updated",
+		UserID:      &user.ID,
+		Username:    user.Username,
+		Description: fmt.Sprintf("%s updated their profile: %s", user.Username, strings.Join(changed, ", ")),
+	})
+
+	c.JSON(http.StatusOK, profileResponse(updated))
+}
+
+// removeAvatar deletes an avatar file from disk. Only files under the avatar
+// directory are touched, whatever the stored path says.
+func removeAvatar(avatar string) {
+	if !strings.HasPrefix(avatar, avatarURLPrefix) {
+		return
+	}
+	name := path.Base(avatar)
+	if err := os.Remove(filepath.Join(UploadDir(), "avatars", name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing avatar %s: %v", name, err)
+	}
+}
+
+// UploadAvatar stores a PNG, JPEG or GIF picture sent as the avatar form
+// field as the authenticated user's avatar, replacing any previous one
+func (pc *ProfileController) UploadAvatar(c *gin.Context) {
+	// Leave room for the rest of the multipart body
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, avatarMaxBytes+64<<10)
+	header, err := c.FormFile("avatar")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "The picture must be at most 2 MB"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload a picture in the avatar field"})
+		return
+	}
+	if header.Size > avatarMaxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "The picture must be at most 2 MB"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read the picture"})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, avatarMaxBytes+1))
+	if err != nil || len(data) > avatarMaxBytes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read the picture"})
+		return
+	}
+
+	// Trust the content, not the file name or the declared type
+	ext, ok := avatarTypes[http.DetectContentType(data)]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The picture must be a PNG, JPEG or GIF image"})
+		return
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The picture could not be read as an image"})
+		return
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > avatarMaxPixels || config.Height > avatarMaxPixels {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("The picture must be at most %dx%d pixels", avatarMaxPixels, avatarMaxPixels)})
+		return
+	}
+
+	user, ok := pc.loadMe(c)
+	if !ok {
+		return
+	}
+
+	// Each upload gets a new name, so browsers do not show a cached picture
+	dir := filepath.Join(UploadDir(), "avatars")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Printf("Error creating avatar directory: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving picture"})
+		return
+	}
+	name := fmt.Sprintf("%s-%d%s", user.ID.Hex(), time.Now().UnixNano(), ext)
+	tmp := filepath.Join(dir, "."+name)
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		log.Printf("Error saving avatar: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving picture"})
+		return
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		os.Remove(tmp)
+		log.Printf("Error saving avatar: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving picture"})
+		return
+	}
+
+	avatar := avatarURLPrefix + name
+	var updated models.User
+	err = pc.db.Collection("users").FindOneAndUpdate(context.Background(),
+		bson.M{"_id": user.ID, "deletedAt": nil},
+		bson.M{"$set": bson.M{"avatar": avatar, "updatedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"password": 0}),
+	).Decode(&updated)
+	if err != nil {
+		removeAvatar(avatar)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving picture"})
+		return
+	}
+	removeAvatar(user.Avatar)
+
+	c.JSON(http.StatusOK, profileResponse(updated))
+}
+
+// DeleteAvatar removes the authenticated user's avatar
+func (pc *ProfileController) DeleteAvatar(c *gin.Context) {
+	user, ok := pc.loadMe(c)
+	if !ok {
+		return
+	}
+	if user.Avatar == "" {
+		c.JSON(http.StatusOK, profileResponse(user))
+		return
+	}
+
+	var updated models.User
+	err := pc.db.Collection("users").FindOneAndUpdate(context.Background(),
+		bson.M{"_id": user.ID, "deletedAt": nil},
+		bson.M{"$unset": bson.M{"avatar": ""}, "$set": bson.M{"updatedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"password": 0}),
+	).Decode(&updated)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error removing picture"})
+		return
+	}
+	removeAvatar(user.Avatar)
+
+	c.JSON(http.StatusOK, profileResponse(updated))
+}
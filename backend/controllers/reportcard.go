@@ -0,0 +1,510 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"classscheduling/models"
+	"classscheduling/pdf"
+)
+
+var (
+	// reportCardTermPattern is what a term may look like, since it ends up
+	// in file names
+	reportCardTermPattern = regexp.MustCompile(`^[A-Za-z0-9 _-]{1,32}$`)
+	// filenameUnsafe matches what must not go into a file name
+	filenameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+)
+
+type ReportCardController struct {
+	db *mongo.Database
+}
+
+func NewReportCardController(db *mongo.Database) *ReportCardController {
+	return &ReportCardController{db: db}
+}
+
+// reportCard is everything printed on one student's report card for a term
+type reportCard struct {
+	Student   models.User
+	Term      string
+	Classes   []reportCardClass
+	Average   float64 // weighted average across classes with grades
+	Attended  int
+	Sessions  int
+	Generated time.Time
+}
+
+type reportCardClass struct {
+	Name        string
+	Faculty     string
+	Percent     float64
+	Grade       string
+	Assessments int
+	Attended    int
+	Sessions    int
+	Remarks     []models.Remark
+}
+
+// letterGrade maps a percentage onto a letter grade
+func letterGrade(percent float64) string {
+	switch {
+	case percent >= 90:
+		return "A"
+	case percent >= 80:
+		return "B"
+	case percent >= 70:
+		return "C"
+	case percent >= 60:
+		return "D"
+	default:
+		return "F"
+	}
+}
+
+// weightedPercent averages the percentage of each record by its weight
+func weightedPercent(records []models.Performance) (float64, bool) {
+	var sum, weights float64
+	for _, r := range records {
+		if r.TotalMarks <= 0 {
+			continue
+		}
+		w := r.Weight
+		if w <= 0 {
+			w = 1
+		}
+		sum += w * r.Score / r.TotalMarks * 100
+		weights += w
+	}
+	if weights == 0 {
+		return 0, false
+	}
+	return sum / weights, true
+}
+
+// buildReportCard gathers the classes, grades, attendance and remarks of a
+// student for a term
+func (rc *ReportCardController) buildReportCard(ctx context.Context, studentID primitive.ObjectID, term string) (*reportCard, error) {
+	var student models.User
+	err := rc.db.Collection("users").FindOne(ctx, bson.M{
+		"_id":       studentID,
+		"userType":  "student",
+		"deletedAt": nil,
+	}).Decode(&student)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := rc.db.Collection("classes").Find(ctx,
+		bson.M{"enrolled": studentID, "term": term, "deletedAt": nil},
+		options.Find().SetSort(bson.M{"name": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var classes []models.Class
+	if err := cursor.All(ctx, &classes); err != nil {
+		return nil, err
+	}
+
+	card := &reportCard{Student: student, Term: term, Generated: time.Now()}
+	var percentSum float64
+	var graded int
+
+	for _, class := range classes {
+		entry := reportCardClass{Name: class.Name, Grade: "-"}
+
+		var faculty models.User
+		if err := rc.db.Collection("users").FindOne(ctx, bson.M{"_id": class.FacultyID}).Decode(&faculty); err == nil {
+			entry.Faculty = faculty.Username
+		}
+
+		cursor, err := rc.db.Collection("performance").Find(ctx, bson.M{
+			"classId":   class.ID,
+			"studentId": studentID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		var records []models.Performance
+		if err := cursor.All(ctx, &records); err != nil {
+			return nil, err
+		}
+		entry.Assessments = len(records)
+		if percent, ok := weightedPercent(records); ok {
+			entry.Percent = percent
+			entry.Grade = letterGrade(percent)
+			percentSum += percent
+			graded++
+		}
+
+		entry.Sessions, err = countDocuments(ctx, rc.db.Collection("attendance"), bson.M{
+			"classId": class.ID,
+			"$or": []bson.M{
+				{"present": studentID},
+				{"absent": studentID},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		entry.Attended, err = countDocuments(ctx, rc.db.Collection("attendance"), bson.M{
+			"classId": class.ID,
+			"present": studentID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		card.Sessions += entry.Sessions
+		card.Attended += entry.Attended
+
+		cursor, err = rc.db.Collection("remarks").Find(ctx,
+			bson.M{
+				"classId":    class.ID,
+				"studentId":  studentID,
+				"visibility": bson.M{"$ne": "faculty"},
+			},
+			options.Find().SetSort(bson.M{"createdAt": 1}),
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := cursor.All(ctx, &entry.Remarks); err != nil {
+			return nil, err
+		}
+
+		card.Classes = append(card.Classes, entry)
+	}
+
+	if graded > 0 {
+		card.Average = percentSum / float64(graded)
+	}
+	return card, nil
+}
+
+func countDocuments(ctx context.Context, coll *mongo.Collection, filter bson.M) (int, error) {
+	n, err := coll.CountDocuments(ctx, filter)
+	return int(n), err
+}
+
+func attendancePercent(attended, sessions int) string {
+	if sessions == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(attended)/float64(sessions)*100)
+}
+
+// renderReportCard lays out a report card as a PDF
+func renderReportCard(card *reportCard) ([]byte, error) {
+	const (
+		left   = 50.0
+		right  = pdf.PageWidth - 50
+		bottom = pdf.PageHeight - 60
+	)
+
+	doc := pdf.New(fmt.Sprintf("Report Card - %s - %s", card.Student.Username, card.Term))
+	doc.AddPage()
+	y := 60.0
+
+	newPageIfNeeded := func(space float64) {
+		if y+space > bottom {
+			doc.AddPage()
+			y = 60
+		}
+	}
+
+	doc.Text(left, y, 20, true, "Report Card")
+	doc.TextRight(right, y, 10, false, "Term: "+card.Term)
+	y += 24
+	doc.Text(left, y, 11, false, "Student: "+card.Student.Username)
+	doc.TextRight(right, y, 10, false, "Roll number: "+card.Student.RollNumber)
+	y += 16
+	doc.Text(left, y, 10, false, "Email: "+card.Student.Email)
+	y += 12
+	doc.Line(left, y, right, y, 1)
+	y += 24
+
+	// Grades table
+	columns := []struct {
+		title string
+		x     float64
+	}{
+		{"Class", left + 4},
+		{"Faculty", 215},
+		{"Assessments", 330},
+		{"Score", 410},
+		{"Grade", 460},
+		{"Attendance", right - 4},
+	}
+	doc.FillRect(left, y-13, right-left, 18, 0.9)
+	for i, col := range columns {
+		if i == len(columns)-1 {
+			doc.TextRight(col.x, y, 10, true, col.title)
+			continue
+		}
+		doc.Text(col.x, y, 10, true, col.title)
+	}
+	y += 20
+
+	if len(card.Classes) == 0 {
+		doc.Text(left+4, y, 10, false, "No classes found for this term.")
+		y += 18
+	}
+	for _, class := range card.Classes {
+		newPageIfNeeded(18)
+		score := "-"
+		if class.Grade != "-" {
+			score = fmt.Sprintf("%.1f%%", class.Percent)
+		}
+		doc.Text(columns[0].x, y, 10, false, truncate(class.Name, 150, 10))
+		doc.Text(columns[1].x, y, 10, false, truncate(class.Faculty, 110, 10))
+		doc.Text(columns[2].x, y, 10, false, fmt.Sprintf("%d", class.Assessments))
+		doc.Text(columns[3].x, y, 10, false, score)
+		doc.Text(columns[4].x, y, 10, true, class.Grade)
+		doc.TextRight(columns[5].x, y, 10, false, attendancePercent(class.Attended, class.Sessions))
+		y += 6
+		doc.Line(left, y, right, y, 0.3)
+		y += 14
+	}
+
+	y += 6
+	newPageIfNeeded(40)
+	overall := "-"
+	if card.Average > 0 {
+		overall = fmt.Sprintf("%.1f%% (%s)", card.Average, letterGrade(card.Average))
+	}
+	doc.Text(left, y, 11, true, "Overall average: "+overall)
+	y += 16
+	doc.Text(left, y, 11, true, fmt.Sprintf("Overall attendance: %s (%d of %d sessions)",
+		attendancePercent(card.Attended, card.Sessions), card.Attended, card.Sessions))
+	y += 30
+
+	// Remarks
+	hasRemarks := false
+	for _, class := range card.Classes {
+		if len(class.Remarks) > 0 {
+			hasRemarks = true
+			break
+		}
+	}
+	if hasRemarks {
+		newPageIfNeeded(40)
+		doc.Text(left, y, 14, true, "Faculty Remarks")
+		y += 20
+		for _, class := range card.Classes {
+			for _, remark := range class.Remarks {
+				lines := pdf.Wrap(remark.Content, 10, right-left-12, false)
+				newPageIfNeeded(16 + float64(len(lines))*13)
+				heading := class.Name
+				if remark.Category != "" {
+					heading += " - " + remark.Category
+				}
+				doc.Text(left, y, 10, true, heading)
+				doc.TextRight(right, y, 9, false, remark.CreatedAt.Format("02 Jan 2006"))
+				y += 14
+				for _, line := range lines {
+					newPageIfNeeded(13)
+					doc.Text(left+12, y, 10, false, line)
+					y += 13
+				}
+				y += 8
+			}
+		}
+	}
+
+	doc.Text(left, pdf.PageHeight-30, 8, false, "Generated on "+card.Generated.Format("02 Jan 2006 15:04"))
+
+	return doc.Bytes()
+}
+
+// truncate shortens s with an ellipsis so that it fits within width
+func truncate(s string, width, size float64) string {
+	if pdf.TextWidth(s, size, false) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && pdf.TextWidth(string(runes)+"...", size, false) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+// reportCardTerm reads the term query parameter, answering with an error if
+// it is missing or malformed
+func reportCardTerm(c *gin.Context) (string, bool) {
+	term := c.Query("term")
+	if term == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Term is required"})
+		return "", false
+	}
+	if !reportCardTermPattern.MatchString(term) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid term"})
+		return "", false
+	}
+	return term, true
+}
+
+// filenamePart replaces anything but letters, digits, dots, dashes and
+// underscores, so that user data cannot add directories to a file name
+func filenamePart(s string) string {
+	return filenameUnsafe.ReplaceAllString(s, "_")
+}
+
+func reportCardFilename(student models.User, term string) string {
+	name := student.RollNumber
+	if name == "" {
+		name = student.Username
+	}
+	return fmt.Sprintf("report-card-%s-%s.pdf", filenamePart(name), filenamePart(term))
+}
+
+// sendReportCard builds and writes a single report card as a PDF download
+func (rc *ReportCardController) sendReportCard(c *gin.Context, studentID primitive.ObjectID) {
+	term, ok := reportCardTerm(c)
+	if !ok {
+		return
+	}
+
+	card, err := rc.buildReportCard(context.Background(), studentID, term)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Student not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error building report card"})
+		return
+	}
+
+	data, err := renderReportCard(card)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error rendering report card"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", reportCardFilename(card.Student, term)))
+	c.Data(http.StatusOK, "application/pdf", data)
+}
+
+// GetMyReportCard returns the requesting student's report card for a term
+func (rc *ReportCardController) GetMyReportCard(c *gin.Context) {
+	studentID, _ := c.Get("userId")
+	rc.sendReportCard(c, studentID.(primitive.ObjectID))
+}
+
+// GetStudentReportCard returns a student's report card for a term (admin only)
+func (rc *ReportCardController) GetStudentReportCard(c *gin.Context) {
+	studentID, err := primitive.ObjectIDFromHex(c.Param("studentId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid student ID"})
+		return
+	}
+
+	// Department admins can only see report cards of their own students
+	count, err := rc.db.Collection("users").CountDocuments(context.Background(), scopeFilter(c, bson.M{"_id": studentID, "deletedAt": nil}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Student not found"})
+		return
+	}
+
+	rc.sendReportCard(c, studentID)
+}
+
+// GenerateTermReportCards returns a ZIP with the report card of every student
+// enrolled in a class in the given term (admin only)
+func (rc *ReportCardController) GenerateTermReportCards(c *gin.Context) {
+	ctx := context.Background()
+	term, ok := reportCardTerm(c)
+	if !ok {
+		return
+	}
+
+	studentIDs, err := rc.db.Collection("classes").Distinct(ctx, "enrolled", scopeFilter(c, bson.M{"term": term, "deletedAt": nil}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching term enrollments"})
+		return
+	}
+	if len(studentIDs) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No students enrolled in this term"})
+		return
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	var skipped []string
+	names := make(map[string]bool)
+
+	ids := make([]primitive.ObjectID, 0, len(studentIDs))
+	for _, id := range studentIDs {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			ids = append(ids, oid)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Hex() < ids[j].Hex() })
+
+	for _, studentID := range ids {
+		card, err := rc.buildReportCard(ctx, studentID, term)
+		if err == mongo.ErrNoDocuments {
+			// Enrollment points at a user that no longer exists
+			skipped = append(skipped, studentID.Hex())
+			continue
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error building report cards"})
+			return
+		}
+
+		data, err := renderReportCard(card)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error rendering report cards"})
+			return
+		}
+
+		name := reportCardFilename(card.Student, term)
+		if names[name] {
+			name = fmt.Sprintf("report-card-%s-%s.pdf", studentID.Hex(), filenamePart(term))
+		}
+		names[name] = true
+
+		w, err := archive.Create(name)
+		if err == nil {
+			_, err = w.Write(data)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error writing report card archive"})
+			return
+		}
+	}
+
+	if len(skipped) > 0 {
+		w, err := archive.Create("skipped.txt")
+		if err == nil {
+			for _, id := range skipped {
+				fmt.Fprintf(w, "%s: student not found\n", id)
+			}
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error writing report card archive"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("report-cards-%s.zip", filenamePart(term))))
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
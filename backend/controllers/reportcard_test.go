@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"classscheduling/models"
+)
+
+func TestReportCardFilename(t *testing.T) {
+	tests := []struct {
+		student models.User
+		term    string
+		want    string
+	}{
+		{models.User{RollNumber: "CS-042"}, "2025-fall", "report-card-CS-042-2025-fall.pdf"},
+		{models.User{Username: "alice"}, "2025 fall", "report-card-alice-2025_fall.pdf"},
+		{models.User{RollNumber: "../../x"}, "2025-fall", "report-card-.._.._x-2025-fall.pdf"},
+		{models.User{RollNumber: `a/b\c`}, "../etc", "report-card-a_b_c-.._etc.pdf"},
+		{models.User{RollNumber: "x\"\r\n;y"}, "2025-fall", "report-card-x____y-2025-fall.pdf"},
+	}
+	for _, tt := range tests {
+		got := reportCardFilename(tt.student, tt.term)
+		if got != tt.want {
+			t.Errorf("reportCardFilename(%q, %q) = %q, want %q", tt.student.RollNumber+tt.student.Username, tt.term, got, tt.want)
+		}
+		if strings.ContainsAny(got, `/\"`) {
+			t.Errorf("%q is not a plain file name", got)
+		}
+	}
+}
+
+func TestReportCardTerm(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := map[string]int{
+		"2025-fall":             http.StatusOK,
+		"Spring 2026":           http.StatusOK,
+		"":                      http.StatusBadRequest,
+		"../../x":               http.StatusBadRequest,
+		"a/b":                   http.StatusBadRequest,
+		strings.Repeat("a", 33): http.StatusBadRequest,
+	}
+	for term, want := range tests {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodGet, "/?term="+url.QueryEscape(term), nil)
+		if _, ok := reportCardTerm(c); ok != (want == http.StatusOK) || rec.Code != want {
+			t.Errorf("term %q: ok = %v, status %d, want %d", term, ok, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"classscheduling/models"
+	"classscheduling/report"
+)
+
+const (
+	// reportJobTTL is how long a job and its output are kept
+	reportJobTTL = 24 * time.Hour
+	// reportJobTimeout bounds the time a job may take to build and render
+	reportJobTimeout = 30 * time.Minute
+	// reportJobsPerUser is how many unfinished jobs one user may have
+	reportJobsPerUser = 3
+	// reportChunkSize is the size of the report_files chunks, well below the
+	// document size limit
+	reportChunkSize = 4 << 20
+)
+
+// reportJobSlots bounds the number of jobs running at once; the others wait
+// for a slot
+var reportJobSlots = make(chan struct{}, 2)
+
+// CreateReportJob starts exporting a report in the background. The client
+// polls the job until it has completed and then downloads it.
+func (rc *ReportController) CreateReportJob(c *gin.Context) {
+	var input struct {
+		Report string            `json:"report" binding:"required"`
+		Format string            `json:"format" binding:"required"`
+		Params map[string]string `json:"params"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	def, ok := reportRequest(c, input.Report)
+	if !ok {
+		return
+	}
+	if !report.ValidFormat(input.Format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv, xlsx or pdf"})
+		return
+	}
+	params := make(map[string]string)
+	for _, name := range reportParamNames {
+		if value := input.Params[name]; value != "" {
+			params[name] = value
+		}
+	}
+	scope := departmentScope(c)
+	if _, err := parseReportParams(params, scope); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	userID, _ := c.Get("userId")
+	unfinished, err := rc.db.Collection("report_jobs").CountDocuments(ctx, bson.M{
+		"requestedBy": userID,
+		"status":      bson.M{"$in": []string{"pending", "running"}},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating report job"})
+		return
+	}
+	if unfinished >= reportJobsPerUser {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Wait for your other report jobs to finish first"})
+		return
+	}
+
+	now := time.Now()
+	job := models.ReportJob{
+		Report:       def.Name,
+		Format:       input.Format,
+		Params:       params,
+		DepartmentID: scope,
+		RequestedBy:  userID.(primitive.ObjectID),
+		Status:       "pending",
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(reportJobTTL),
+	}
+	result, err := rc.db.Collection("report_jobs").InsertOne(ctx, job)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating report job"})
+		return
+	}
+	job.ID = result.InsertedID.(primitive.ObjectID)
+
+	go rc.runJob(job)
+
+	c.Header("Location", "/api/admin/report-jobs/"+job.ID.Hex())
+	c.JSON(http.StatusAccepted, job)
+}
+
+// runJob builds and renders a job's report and stores the output in chunks
+func (rc *ReportController) runJob(job models.ReportJob) {
+	reportJobSlots <- struct{}{}
+	defer func() { <-reportJobSlots }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), reportJobTimeout)
+	defer cancel()
+	jobs := rc.db.Collection("report_jobs")
+	fail := func(message string, err error) {
+		log.Printf("Report job %s failed: %s: %v", job.ID.Hex(), message, err)
+		_, err = jobs.UpdateOne(context.Background(), bson.M{"_id": job.ID}, bson.M{"$set": bson.M{
+			"status":      "failed",
+			"error":       message,
+			"completedAt": time.Now(),
+		}})
+		if err != nil {
+			log.Printf("Error updating report job %s: %v", job.ID.Hex(), err)
+		}
+	}
+
+	started := time.Now()
+	if _, err := jobs.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": bson.M{"status": "running", "startedAt": started}}); err != nil {
+		fail("Could not start the job", err)
+		return
+	}
+
+	def, _ := findReport(job.Report)
+	p, err := parseReportParams(job.Params, job.DepartmentID)
+	if err != nil {
+		fail(err.Error(), err)
+		return
+	}
+	t, err := buildReport(ctx, rc.db, def, p)
+	if err != nil {
+		fail("Could not build the report", err)
+		return
+	}
+	if job.Format == "pdf" && len(t.Rows) > reportPDFMaxRows {
+		fail(fmt.Sprintf("The report has %d rows, more than fit in a PDF (%d); export it as CSV or XLSX", len(t.Rows), reportPDFMaxRows), nil)
+		return
+	}
+	var buf bytes.Buffer
+	if err := report.Render(&buf, job.Format, t); err != nil {
+		fail("Could not render the report", err)
+		return
+	}
+
+	data := buf.Bytes()
+	for n := 0; n*reportChunkSize < len(data); n++ {
+		end := (n + 1) * reportChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		_, err := rc.db.Collection("report_files").InsertOne(ctx, models.ReportFile{
+			JobID:     job.ID,
+			N:         n,
+			Data:      data[n*reportChunkSize : end],
+			ExpiresAt: job.ExpiresAt,
+		})
+		if err != nil {
+			fail("Could not store the report", err)
+			return
+		}
+	}
+
+	_, err = jobs.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": bson.M{
+		"status":      "completed",
+		"rows":        len(t.Rows),
+		"size":        len(data),
+		"filename":    exportFilename(def, p, job.Format),
+		"completedAt": time.Now(),
+	}})
+	if err != nil {
+		log.Printf("Error updating report job %s: %v", job.ID.Hex(), err)
+		return
+	}
+	recordActivity(rc.db, nil, models.Activity{
+		Type:        "report_exported",
+		ActorID:     &job.RequestedBy,
+		Description: fmt.Sprintf("Exported the %s report as %s (%d rows)", def.Name, job.Format, len(t.Rows)),
+	})
+	log.Printf("Report job %s completed: %s as %s, %d rows in %s", job.ID.Hex(), def.Name, job.Format, len(t.Rows), time.Since(started).Round(time.Millisecond))
+}
+
+// FailInterruptedJobs marks the jobs that were pending or running when the
+// server stopped as failed, since nothing will pick them up again
+func (rc *ReportController) FailInterruptedJobs() {
+	result, err := rc.db.Collection("report_jobs").UpdateMany(context.Background(),
+		bson.M{"status": bson.M{"$in": []string{"pending", "running"}}},
+		bson.M{"$set": bson.M{
+			"status":      "failed",
+			"error":       "Interrupted by a server restart",
+			"completedAt": time.Now(),
+		}})
+	if err != nil {
+		log.Printf("Warning: Could not fail interrupted report jobs: %v", err)
+		return
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("Marked %d interrupted report jobs as failed", result.ModifiedCount)
+	}
+}
+
+// findOwnJob loads a job requested by the authenticated user. ok is false
+// once an error has been written.
+func (rc *ReportController) findOwnJob(c *gin.Context) (job models.ReportJob, ok bool) {
+	jobID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return job, false
+	}
+	userID, _ := c.Get("userId")
+	err = rc.db.Collection("report_jobs").FindOne(context.Background(), bson.M{"_id": jobID, "requestedBy": userID}).Decode(&job)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Report job not found"})
+		return job, false
+	}
+	return job, true
+}
+
+// GetReportJobs lists the authenticated user's report jobs, newest first.
+// Jobs are kept for reportJobTTL.
+func (rc *ReportController) GetReportJobs(c *gin.Context) {
+	ctx := context.Background()
+	userID, _ := c.Get("userId")
+	cursor, err := rc.db.Collection("report_jobs").Find(ctx, bson.M{"requestedBy": userID},
+		options.Find().SetSort(bson.M{"createdAt": -1}).SetLimit(50))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching report jobs"})
+		return
+	}
+	jobs := []models.ReportJob{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching report jobs"})
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// GetReportJob returns the status of one of the user's report jobs
+func (rc *ReportController) GetReportJob(c *gin.Context) {
+	job, ok := rc.findOwnJob(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// DownloadReportJob sends the output of a completed report job
+func (rc *ReportController) DownloadReportJob(c *gin.Context) {
+	job, ok := rc.findOwnJob(c)
+	if !ok {
+		return
+	}
+	if job.Status != "completed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "The report job has not completed", "status": job.Status})
+		return
+	}
+
+	ctx := context.Background()
+	cursor, err := rc.db.Collection("report_files").Find(ctx, bson.M{"jobId": job.ID},
+		options.Find().SetSort(bson.M{"n": 1}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching report"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", job.Filename))
+	c.Header("Content-Length", fmt.Sprint(job.Size))
+	c.Header("Content-Type", report.ContentType(job.Format))
+	c.Status(http.StatusOK)
+	for cursor.Next(ctx) {
+		var chunk models.ReportFile
+		if err := cursor.Decode(&chunk); err != nil {
+			// The header has been sent, so the download is cut short instead
+			log.Printf("Error sending report job %s: %v", job.ID.Hex(), err)
+			return
+		}
+		if _, err := c.Writer.Write(chunk.Data); err != nil {
+			return
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		log.Printf("Error sending report job %s: %v", job.ID.Hex(), err)
+	}
+}
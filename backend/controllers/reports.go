@@ -0,0 +1,583 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"classscheduling/middleware"
+	"classscheduling/models"
+	"classscheduling/report"
+)
+
+const (
+	// reportSyncMaxRows is the most rows a report can have to be returned
+	// directly; larger ones have to be exported as a job
+	reportSyncMaxRows = 5000
+	// reportPDFMaxRows bounds PDF exports, which are built in memory and
+	// stop being readable long before this
+	reportPDFMaxRows = 20000
+)
+
+// reportParamNames are the parameters a report definition can take
+var reportParamNames = []string{"term", "departmentId", "startDate", "endDate"}
+
+// reportParams are the parsed parameters of a report. Department is always
+// set for department admins. End is exclusive.
+type reportParams struct {
+	Term       string
+	Department *primitive.ObjectID
+	Start      *time.Time
+	End        *time.Time
+}
+
+// reportDefinition is a named report that can be previewed as JSON or
+// rendered in any report format
+type reportDefinition struct {
+	Name        string   `json:"name"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Params      []string `json:"params"`
+	Permission  string   `json:"-"` // needed on top of reports.export
+
+	build func(ctx context.Context, db *mongo.Database, p reportParams) (*report.Table, error)
+}
+
+var reportDefinitions = []reportDefinition{
+	{
+		Name:        "enrollment",
+		Title:       "Enrollment",
+		Description: "Capacity, enrollment and fill rate of each class",
+		Params:      []string{"term", "departmentId"},
+		Permission:  models.PermStatisticsView,
+		build:       buildEnrollmentReport,
+	},
+	{
+		Name:        "attendance",
+		Title:       "Attendance",
+		Description: "Sessions attended and missed by each student in each class",
+		Params:      []string{"term", "departmentId", "startDate", "endDate"},
+		Permission:  models.PermStatisticsView,
+		build:       buildAttendanceReport,
+	},
+	{
+		Name:        "grades",
+		Title:       "Grades",
+		Description: "Every recorded assessment score",
+		Params:      []string{"term", "departmentId", "startDate", "endDate"},
+		Permission:  models.PermReportCardsAll,
+		build:       buildGradesReport,
+	},
+	{
+		Name:        "activity",
+		Title:       "Activity",
+		Description: "The activity log",
+		Params:      []string{"departmentId", "startDate", "endDate"},
+		Permission:  models.PermActivityView,
+		build:       buildActivityReport,
+	},
+	{
+		Name:        "schedule-changes",
+		Title:       "Schedule Changes",
+		Description: "Cancelled and rescheduled sessions",
+		Params:      []string{"term", "departmentId", "startDate", "endDate"},
+		Permission:  models.PermStatisticsView,
+		build:       buildScheduleChangesReport,
+	},
+}
+
+// findReport returns the definition with the given name
+func findReport(name string) (reportDefinition, bool) {
+	for _, def := range reportDefinitions {
+		if def.Name == name {
+			return def, true
+		}
+	}
+	return reportDefinition{}, false
+}
+
+// parseReportParams reads report parameters given as strings. A department
+// admin's scope is their department, which they cannot report outside of.
+// Errors are meant for the client.
+func parseReportParams(values map[string]string, scope *primitive.ObjectID) (reportParams, error) {
+	p := reportParams{Term: strings.TrimSpace(values["term"]), Department: scope}
+	if value := values["departmentId"]; value != "" {
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return p, errors.New("Invalid department ID")
+		}
+		if scope != nil && id != *scope {
+			return p, errors.New("You can only report on your own department")
+		}
+		p.Department = &id
+	}
+	if value := values["startDate"]; value != "" {
+		start, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return p, errors.New("Invalid start date format")
+		}
+		p.Start = &start
+	}
+	if value := values["endDate"]; value != "" {
+		end, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return p, errors.New("Invalid end date format")
+		}
+		end = end.AddDate(0, 0, 1)
+		p.End = &end
+	}
+	if p.Start != nil && p.End != nil && !p.Start.Before(*p.End) {
+		return p, errors.New("Start date must not be after end date")
+	}
+	return p, nil
+}
+
+// window adds the date range to filter as a condition on field
+func (p reportParams) window(filter bson.M, field string) bson.M {
+	if p.Start == nil && p.End == nil {
+		return filter
+	}
+	window := bson.M{}
+	if p.Start != nil {
+		window["$gte"] = *p.Start
+	}
+	if p.End != nil {
+		window["$lt"] = *p.End
+	}
+	filter[field] = window
+	return filter
+}
+
+// subtitle describes the parameters for the top of a report
+func (p reportParams) subtitle(ctx context.Context, db *mongo.Database) string {
+	var parts []string
+	if p.Term != "" {
+		parts = append(parts, "Term "+p.Term)
+	}
+	if p.Department != nil {
+		var department models.Department
+		if err := db.Collection("departments").FindOne(ctx, bson.M{"_id": *p.Department}).Decode(&department); err == nil {
+			parts = append(parts, "Department "+department.Code)
+		}
+	}
+	if p.Start != nil {
+		parts = append(parts, "From "+p.Start.Format("2006-01-02"))
+	}
+	if p.End != nil {
+		parts = append(parts, "Until "+p.End.AddDate(0, 0, -1).Format("2006-01-02"))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// reportClass is a class as the reports label it
+type reportClass struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Name      string             `bson:"name"`
+	Code      string             `bson:"code"`
+	Term      string             `bson:"term"`
+	Status    string             `bson:"status"`
+	FacultyID primitive.ObjectID `bson:"facultyId"`
+	Capacity  int                `bson:"capacity"`
+	Enrolled  int                `bson:"enrolled"`
+}
+
+// reportClasses returns the classes in the report's term and department
+// that are not deleted, by name, and their IDs
+func reportClasses(ctx context.Context, db *mongo.Database, p reportParams) (map[primitive.ObjectID]reportClass, []primitive.ObjectID, []reportClass, error) {
+	filter := bson.M{"deletedAt": nil}
+	if p.Term != "" {
+		filter["term"] = p.Term
+	}
+	if p.Department != nil {
+		filter["departmentId"] = *p.Department
+	}
+	cursor, err := db.Collection("classes").Aggregate(ctx, []bson.M{
+		{"$match": filter},
+		{"$project": bson.M{
+			"name":      1,
+			"code":      1,
+			"term":      1,
+			"status":    1,
+			"facultyId": 1,
+			"capacity":  1,
+			"enrolled":  bson.M{"$size": bson.M{"$ifNull": []interface{}{"$enrolled", []interface{}{}}}},
+		}},
+		{"$sort": bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}},
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var list []reportClass
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, nil, nil, err
+	}
+	classes := make(map[primitive.ObjectID]reportClass, len(list))
+	ids := make([]primitive.ObjectID, len(list))
+	for i, class := range list {
+		classes[class.ID] = class
+		ids[i] = class.ID
+	}
+	return classes, ids, list, nil
+}
+
+// reportUsers returns the users with the given IDs, deleted or not
+func reportUsers(ctx context.Context, db *mongo.Database, ids []primitive.ObjectID) (map[primitive.ObjectID]models.User, error) {
+	cursor, err := db.Collection("users").Find(ctx, bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"username": 1, "rollNumber": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var list []models.User
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	users := make(map[primitive.ObjectID]models.User, len(list))
+	for _, user := range list {
+		users[user.ID] = user
+	}
+	return users, nil
+}
+
+// roundRate rounds a rate to two decimals for display
+func roundRate(v *float64) *float64 {
+	if v == nil {
+		return nil
+	}
+	r := round2(*v)
+	return &r
+}
+
+func buildEnrollmentReport(ctx context.Context, db *mongo.Database, p reportParams) (*report.Table, error) {
+	_, _, classes, err := reportClasses(ctx, db, p)
+	if err != nil {
+		return nil, err
+	}
+	facultyIDs := make([]primitive.ObjectID, len(classes))
+	for i, class := range classes {
+		facultyIDs[i] = class.FacultyID
+	}
+	faculty, err := reportUsers(ctx, db, facultyIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &report.Table{Columns: []report.Column{
+		{Title: "Code"}, {Title: "Class", Width: 2.5}, {Title: "Term"}, {Title: "Faculty", Width: 1.5},
+		{Title: "Status"}, {Title: "Capacity", Width: 0.8}, {Title: "Enrolled", Width: 0.8}, {Title: "Fill rate (%)", Width: 0.9},
+	}}
+	for _, class := range classes {
+		t.Rows = append(t.Rows, []interface{}{
+			class.Code, class.Name, class.Term, faculty[class.FacultyID].Username, class.Status,
+			class.Capacity, class.Enrolled, roundRate(rate(float64(class.Enrolled), float64(class.Capacity))),
+		})
+	}
+	return t, nil
+}
+
+func buildAttendanceReport(ctx context.Context, db *mongo.Database, p reportParams) (*report.Table, error) {
+	classes, classIDs, _, err := reportClasses(ctx, db, p)
+	if err != nil {
+		return nil, err
+	}
+	mark := func(field string, present int) bson.M {
+		return bson.M{"$map": bson.M{
+			"input": bson.M{"$ifNull": []interface{}{"$" + field, []interface{}{}}},
+			"in":    bson.M{"student": "$$this", "present": present},
+		}}
+	}
+	var counts []struct {
+		ID struct {
+			ClassID   primitive.ObjectID `bson:"classId"`
+			StudentID primitive.ObjectID `bson:"student"`
+		} `bson:"_id"`
+		Present  int `bson:"present"`
+		Sessions int `bson:"sessions"`
+	}
+	cursor, err := db.Collection("attendance").Aggregate(ctx, []bson.M{
+		{"$match": p.window(bson.M{"classId": bson.M{"$in": classIDs}}, "date")},
+		{"$project": bson.M{"classId": 1, "marks": bson.M{"$concatArrays": []interface{}{mark("present", 1), mark("absent", 0)}}}},
+		{"$unwind": "$marks"},
+		{"$group": bson.M{
+			"_id":      bson.M{"classId": "$classId", "student": "$marks.student"},
+			"present":  bson.M{"$sum": "$marks.present"},
+			"sessions": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+	studentIDs := make([]primitive.ObjectID, len(counts))
+	for i, count := range counts {
+		studentIDs[i] = count.ID.StudentID
+	}
+	students, err := reportUsers(ctx, db, studentIDs)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := classes[counts[i].ID.ClassID], classes[counts[j].ID.ClassID]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.ID != b.ID {
+			return a.ID.Hex() < b.ID.Hex()
+		}
+		return students[counts[i].ID.StudentID].Username < students[counts[j].ID.StudentID].Username
+	})
+
+	t := &report.Table{Columns: []report.Column{
+		{Title: "Code"}, {Title: "Class", Width: 2}, {Title: "Student", Width: 1.5}, {Title: "Roll number"},
+		{Title: "Present", Width: 0.8}, {Title: "Absent", Width: 0.8}, {Title: "Sessions", Width: 0.8}, {Title: "Rate (%)", Width: 0.8},
+	}}
+	for _, count := range counts {
+		class, student := classes[count.ID.ClassID], students[count.ID.StudentID]
+		t.Rows = append(t.Rows, []interface{}{
+			class.Code, class.Name, student.Username, student.RollNumber,
+			count.Present, count.Sessions - count.Present, count.Sessions,
+			roundRate(rate(float64(count.Present), float64(count.Sessions))),
+		})
+	}
+	return t, nil
+}
+
+func buildGradesReport(ctx context.Context, db *mongo.Database, p reportParams) (*report.Table, error) {
+	classes, classIDs, _, err := reportClasses(ctx, db, p)
+	if err != nil {
+		return nil, err
+	}
+	var records []models.Performance
+	cursor, err := db.Collection("performance").Find(ctx,
+		p.window(bson.M{"classId": bson.M{"$in": classIDs}}, "date"),
+		options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	studentIDs := make([]primitive.ObjectID, len(records))
+	for i, record := range records {
+		studentIDs[i] = record.StudentID
+	}
+	students, err := reportUsers(ctx, db, studentIDs)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := classes[records[i].ClassID], classes[records[j].ClassID]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.ID != b.ID {
+			return a.ID.Hex() < b.ID.Hex()
+		}
+		return students[records[i].StudentID].Username < students[records[j].StudentID].Username
+	})
+
+	t := &report.Table{Columns: []report.Column{
+		{Title: "Code"}, {Title: "Class", Width: 1.8}, {Title: "Student", Width: 1.4}, {Title: "Roll number"},
+		{Title: "Assessment", Width: 1.6}, {Title: "Score", Width: 0.7}, {Title: "Total", Width: 0.7},
+		{Title: "Percent", Width: 0.8}, {Title: "Weight", Width: 0.7}, {Title: "Date", Width: 1.3},
+	}}
+	for _, record := range records {
+		class, student := classes[record.ClassID], students[record.StudentID]
+		weight := record.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		t.Rows = append(t.Rows, []interface{}{
+			class.Code, class.Name, student.Username, student.RollNumber, record.AssessmentName,
+			record.Score, record.TotalMarks, roundRate(rate(record.Score, record.TotalMarks)), weight, record.Date,
+		})
+	}
+	return t, nil
+}
+
+func buildActivityReport(ctx context.Context, db *mongo.Database, p reportParams) (*report.Table, error) {
+	filter := p.window(bson.M{}, "timestamp")
+	// As in the activity log, a department only sees activity about its users
+	if p.Department != nil {
+		userIDs, err := db.Collection("users").Distinct(ctx, "_id", bson.M{"departmentId": *p.Department})
+		if err != nil {
+			return nil, err
+		}
+		filter["userId"] = bson.M{"$in": userIDs}
+	}
+	var entries []models.Activity
+	cursor, err := db.Collection("activity").Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	t := &report.Table{Columns: []report.Column{
+		{Title: "Time", Width: 1.3}, {Title: "Type", Width: 1.3}, {Title: "User", Width: 1.2},
+		{Title: "Description", Width: 3.5}, {Title: "IP"},
+	}}
+	for _, entry := range entries {
+		t.Rows = append(t.Rows, []interface{}{entry.Timestamp, entry.Type, entry.Username, entry.Description, entry.IP})
+	}
+	return t, nil
+}
+
+func buildScheduleChangesReport(ctx context.Context, db *mongo.Database, p reportParams) (*report.Table, error) {
+	classes, classIDs, _, err := reportClasses(ctx, db, p)
+	if err != nil {
+		return nil, err
+	}
+	var changes []models.ScheduleChange
+	cursor, err := db.Collection("schedule_changes").Find(ctx,
+		p.window(bson.M{"classId": bson.M{"$in": classIDs}}, "originalDate"),
+		options.Find().SetSort(bson.D{{Key: "originalDate", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &changes); err != nil {
+		return nil, err
+	}
+
+	t := &report.Table{Columns: []report.Column{
+		{Title: "Code"}, {Title: "Class", Width: 2}, {Title: "Type"}, {Title: "Original date", Width: 1.3},
+		{Title: "New date", Width: 1.3}, {Title: "Reason", Width: 2.5}, {Title: "Recorded", Width: 1.3},
+	}}
+	for _, change := range changes {
+		class := classes[change.ClassID]
+		t.Rows = append(t.Rows, []interface{}{
+			class.Code, class.Name, change.Type, change.OriginalDate, change.NewDate, change.Reason, change.CreatedAt,
+		})
+	}
+	return t, nil
+}
+
+// buildReport runs a definition and fills in the table's title
+func buildReport(ctx context.Context, db *mongo.Database, def reportDefinition, p reportParams) (*report.Table, error) {
+	t, err := def.build(ctx, db, p)
+	if err != nil {
+		return nil, err
+	}
+	t.Title = def.Title + " Report"
+	t.Subtitle = p.subtitle(ctx, db)
+	t.Generated = time.Now()
+	if t.Rows == nil {
+		t.Rows = [][]interface{}{}
+	}
+	return t, nil
+}
+
+// exportFilename names an exported report after the definition, the term
+// and the date
+func exportFilename(def reportDefinition, p reportParams, format string) string {
+	name := def.Name
+	if p.Term != "" {
+		name += "-" + p.Term
+	}
+	return fmt.Sprintf("%s-%s.%s", name, time.Now().Format("2006-01-02"), format)
+}
+
+type ReportController struct {
+	db *mongo.Database
+}
+
+func NewReportController(db *mongo.Database) *ReportController {
+	return &ReportController{db: db}
+}
+
+// GetReportDefinitions lists the reports the user can export, with their
+// parameters and formats
+func (rc *ReportController) GetReportDefinitions(c *gin.Context) {
+	defs := make([]reportDefinition, 0, len(reportDefinitions))
+	for _, def := range reportDefinitions {
+		if middleware.HasPermission(c, def.Permission) {
+			defs = append(defs, def)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"reports": defs, "formats": report.Formats, "maxRows": reportSyncMaxRows})
+}
+
+// reportRequest looks up a report by name and checks that the user may see
+// it. ok is false once an error has been written.
+func reportRequest(c *gin.Context, name string) (def reportDefinition, ok bool) {
+	def, found := findReport(name)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Report not found"})
+		return def, false
+	}
+	if !middleware.HasPermission(c, def.Permission) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return def, false
+	}
+	return def, true
+}
+
+// GetReport builds a report from the query parameters and returns it as
+// JSON, or downloads it in the format given by the format parameter.
+// Reports of more than reportSyncMaxRows rows are refused with 413 and
+// have to be exported as a job.
+func (rc *ReportController) GetReport(c *gin.Context) {
+	def, ok := reportRequest(c, c.Param("name"))
+	if !ok {
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && !report.ValidFormat(format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json, csv, xlsx or pdf"})
+		return
+	}
+	values := make(map[string]string)
+	for _, name := range reportParamNames {
+		values[name] = c.Query(name)
+	}
+	p, err := parseReportParams(values, departmentScope(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	t, err := buildReport(ctx, rc.db, def, p)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error building report"})
+		return
+	}
+	if len(t.Rows) > reportSyncMaxRows {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("The report has %d rows; export it as a job instead", len(t.Rows)),
+			"rows":  len(t.Rows),
+		})
+		return
+	}
+	if format == "json" {
+		c.JSON(http.StatusOK, t)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := report.Render(&buf, format, t); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error rendering report"})
+		return
+	}
+	recordActivity(rc.db, c, models.Activity{
+		Type:        "report_exported",
+		Description: fmt.Sprintf("Exported the %s report as %s (%d rows)", def.Name, format, len(t.Rows)),
+	})
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFilename(def, p, format)))
+	c.Data(http.StatusOK, report.ContentType(format), buf.Bytes())
+}
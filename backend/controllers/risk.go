@@ -0,0 +1,630 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"classscheduling/middleware"
+	"classscheduling/models"
+)
+
+// Weights and thresholds used by the risk engine. Each factor contributes at
+// most its weight in points, so the total score is always between 0 and 100.
+const (
+	riskAttendanceWeight   = 40.0
+	riskGradeAverageWeight = 20.0
+	riskGradeTrendWeight   = 15.0
+	riskMissingWeight      = 25.0
+
+	riskAttendanceTarget = 75.0 // percentage below which attendance starts to count
+	riskAttendanceFloor  = 40.0 // percentage at which attendance contributes its full weight
+	riskGradePass        = 60.0 // average percentage below which grades start to count
+	riskGradeFloor       = 30.0 // average percentage at which grades contribute their full weight
+	riskTrendFloor       = -10.0
+
+	riskMediumScore = 35.0
+	riskHighScore   = 60.0
+)
+
+type RiskController struct {
+	db *mongo.Database
+}
+
+func NewRiskController(db *mongo.Database) *RiskController {
+	return &RiskController{db: db}
+}
+
+// studentRiskInput holds the raw numbers the risk score is computed from
+type studentRiskInput struct {
+	Present          int
+	Sessions         int
+	Percents         []float64 // assessment percentages in date order
+	Missing          int
+	TotalAssessments int
+}
+
+// computeRisk turns a student's attendance and grades into a score with the
+// factors that contributed to it
+func computeRisk(in studentRiskInput) (float64, []models.RiskFactor) {
+	var factors []models.RiskFactor
+
+	if in.Sessions > 0 {
+		pct := float64(in.Present) / float64(in.Sessions) * 100
+		points := riskAttendanceWeight * clamp((riskAttendanceTarget-pct)/(riskAttendanceTarget-riskAttendanceFloor))
+		if points > 0 {
+			factors = append(factors, models.RiskFactor{
+				Name:        "attendance",
+				Value:       round2(pct),
+				Points:      round2(points),
+				Description: fmt.Sprintf("Attended %d of %d sessions (%.1f%%), below the %.0f%% target", in.Present, in.Sessions, pct, riskAttendanceTarget),
+			})
+		}
+	}
+
+	if len(in.Percents) > 0 {
+		var sum float64
+		for _, p := range in.Percents {
+			sum += p
+		}
+		avg := sum / float64(len(in.Percents))
+		points := riskGradeAverageWeight * clamp((riskGradePass-avg)/(riskGradePass-riskGradeFloor))
+		if points > 0 {
+			factors = append(factors, models.RiskFactor{
+				Name:        "grade_average",
+				Value:       round2(avg),
+				Points:      round2(points),
+				Description: fmt.Sprintf("Average score of %.1f%% across %d assessments", avg, len(in.Percents)),
+			})
+		}
+
+		slope := trendSlope(in.Percents)
+		points = riskGradeTrendWeight * clamp(slope/riskTrendFloor)
+		if points > 0 {
+			factors = append(factors, models.RiskFactor{
+				Name:        "grade_trend",
+				Value:       round2(slope),
+				Points:      round2(points),
+				Description: fmt.Sprintf("Scores falling by %.1f percentage points per assessment", -slope),
+			})
+		}
+	}
+
+	if in.Missing > 0 && in.TotalAssessments > 0 {
+		ratio := float64(in.Missing) / float64(in.TotalAssessments)
+		factors = append(factors, models.RiskFactor{
+			Name:        "missing_assessments",
+			Value:       float64(in.Missing),
+			Points:      round2(riskMissingWeight * ratio),
+			Description: fmt.Sprintf("Missing %d of %d assessments", in.Missing, in.TotalAssessments),
+		})
+	}
+
+	var score float64
+	for _, f := range factors {
+		score += f.Points
+	}
+	sortFactors(factors)
+	return round2(score), factors
+}
+
+// riskLevel maps a score onto low, medium or high
+func riskLevel(score float64) string {
+	switch {
+	case score >= riskHighScore:
+		return "high"
+	case score >= riskMediumScore:
+		return "medium"
+	default:
+		return "low"
+	}
+}
+
+// trendSlope returns the least-squares slope of the values against their index
+func trendSlope(values []float64) float64 {
+	n := float64(len(values))
+	if n < 2 {
+		return 0
+	}
+	var sumX, sumY, sumXY, sumXX float64
+	for i, y := range values {
+		x := float64(i)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	return (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+}
+
+func clamp(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// Evaluate recomputes risk flags for every active class matching the filter
+// and returns the number of students evaluated
+func (rc *RiskController) Evaluate(ctx context.Context, classFilter bson.M) (int, error) {
+	filter := bson.M{"status": "active", "deletedAt": nil}
+	for k, v := range classFilter {
+		filter[k] = v
+	}
+
+	cursor, err := rc.db.Collection("classes").Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	var classes []models.Class
+	if err := cursor.All(ctx, &classes); err != nil {
+		return 0, err
+	}
+
+	evaluated := 0
+	for _, class := range classes {
+		n, err := rc.evaluateClass(ctx, class)
+		if err != nil {
+			return evaluated, err
+		}
+		evaluated += n
+	}
+	return evaluated, nil
+}
+
+func (rc *RiskController) evaluateClass(ctx context.Context, class models.Class) (int, error) {
+	if len(class.Enrolled) == 0 {
+		return 0, nil
+	}
+
+	inputs := make(map[primitive.ObjectID]*studentRiskInput, len(class.Enrolled))
+	for _, id := range class.Enrolled {
+		inputs[id] = &studentRiskInput{}
+	}
+
+	// Attendance
+	cursor, err := rc.db.Collection("attendance").Find(ctx, bson.M{"classId": class.ID})
+	if err != nil {
+		return 0, err
+	}
+	var sessions []models.Attendance
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return 0, err
+	}
+	for _, s := range sessions {
+		for _, id := range s.Present {
+			if in, ok := inputs[id]; ok {
+				in.Present++
+				in.Sessions++
+			}
+		}
+		for _, id := range s.Absent {
+			if in, ok := inputs[id]; ok {
+				in.Sessions++
+			}
+		}
+	}
+
+	// Performance
+	cursor, err = rc.db.Collection("performance").Find(ctx,
+		bson.M{"classId": class.ID},
+		options.Find().SetSort(bson.D{{Key: "date", Value: 1}}),
+	)
+	if err != nil {
+		return 0, err
+	}
+	var records []models.Performance
+	if err := cursor.All(ctx, &records); err != nil {
+		return 0, err
+	}
+	assessments := make(map[string]bool)
+	taken := make(map[primitive.ObjectID]map[string]bool)
+	for _, r := range records {
+		assessments[r.AssessmentName] = true
+		in, ok := inputs[r.StudentID]
+		if !ok {
+			continue
+		}
+		if r.TotalMarks > 0 {
+			in.Percents = append(in.Percents, r.Score/r.TotalMarks*100)
+		}
+		if taken[r.StudentID] == nil {
+			taken[r.StudentID] = make(map[string]bool)
+		}
+		taken[r.StudentID][r.AssessmentName] = true
+	}
+	for id, in := range inputs {
+		in.TotalAssessments = len(assessments)
+		in.Missing = len(assessments) - len(taken[id])
+	}
+
+	now := time.Now()
+	for studentID, in := range inputs {
+		score, factors := computeRisk(*in)
+		level := riskLevel(score)
+		key := bson.M{"studentId": studentID, "classId": class.ID}
+
+		if level == "low" {
+			// Close out any earlier flag once the student has recovered
+			_, err := rc.db.Collection("risk_flags").UpdateOne(ctx,
+				bson.M{"studentId": studentID, "classId": class.ID, "status": bson.M{"$ne": "resolved"}},
+				bson.M{"$set": bson.M{
+					"score":       score,
+					"level":       level,
+					"factors":     factors,
+					"status":      "resolved",
+					"evaluatedAt": now,
+					"updatedAt":   now,
+				}},
+			)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		// A flag that was previously resolved is reopened when the risk returns
+		var existing models.RiskFlag
+		err := rc.db.Collection("risk_flags").FindOne(ctx, key).Decode(&existing)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return 0, err
+		}
+		set := bson.M{
+			"score":       score,
+			"level":       level,
+			"factors":     factors,
+			"evaluatedAt": now,
+			"updatedAt":   now,
+		}
+		if err == nil && existing.Status == "resolved" {
+			set["status"] = "open"
+		}
+		_, err = rc.db.Collection("risk_flags").UpdateOne(ctx, key,
+			bson.M{
+				"$set": set,
+				"$setOnInsert": bson.M{
+					"status":        "open",
+					"interventions": []models.RiskIntervention{},
+					"createdAt":     now,
+				},
+			},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(inputs), nil
+}
+
+// StartNightly runs Evaluate once a day at the given hour (server local time)
+func (rc *RiskController) StartNightly(hour int) {
+	go func() {
+		for {
+			now := time.Now()
+			next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, now.Location())
+			if !next.After(now) {
+				next = next.Add(24 * time.Hour)
+			}
+			time.Sleep(time.Until(next))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			n, err := rc.Evaluate(ctx, nil)
+			cancel()
+			if err != nil {
+				log.Printf("Nightly risk evaluation failed: %v", err)
+				continue
+			}
+			log.Printf("Nightly risk evaluation completed for %d students", n)
+		}
+	}()
+}
+
+// listFlags returns flags matching the filter with student and class details
+func (rc *RiskController) listFlags(c *gin.Context, match bson.M) {
+	if level := c.Query("level"); level != "" {
+		match["level"] = level
+	} else {
+		match["level"] = bson.M{"$in": []string{"medium", "high"}}
+	}
+	if status := c.Query("status"); status != "" {
+		match["status"] = status
+	} else {
+		match["status"] = bson.M{"$ne": "resolved"}
+	}
+
+	pipeline := []bson.M{
+		{"$match": match},
+		{
+			"$lookup": bson.M{
+				"from":         "users",
+				"localField":   "studentId",
+				"foreignField": "_id",
+				"as":           "student",
+			},
+		},
+		{"$unwind": "$student"},
+		{
+			"$lookup": bson.M{
+				"from":         "classes",
+				"localField":   "classId",
+				"foreignField": "_id",
+				"as":           "class",
+			},
+		},
+		{"$unwind": "$class"},
+		{"$match": bson.M{"student.deletedAt": nil, "class.deletedAt": nil}},
+		{
+			"$project": bson.M{
+				"student.password": 0,
+				"class.enrolled":   0,
+			},
+		},
+		{"$sort": bson.D{{Key: "score", Value: -1}, {Key: "evaluatedAt", Value: -1}}},
+	}
+
+	cursor, err := rc.db.Collection("risk_flags").Aggregate(context.Background(), pipeline)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching risk flags"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var flags []bson.M
+	if err := cursor.All(context.Background(), &flags); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding risk flags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, flags)
+}
+
+// facultyClassIDs returns the IDs of the classes taught by a faculty member
+func (rc *RiskController) facultyClassIDs(ctx context.Context, facultyID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	cursor, err := rc.db.Collection("classes").Find(ctx,
+		bson.M{"facultyId": facultyID, "deletedAt": nil},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var classes []models.Class
+	if err := cursor.All(ctx, &classes); err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, 0, len(classes))
+	for _, class := range classes {
+		ids = append(ids, class.ID)
+	}
+	return ids, nil
+}
+
+// GetFacultyRiskFlags returns open risk flags for the requesting faculty's classes
+func (rc *RiskController) GetFacultyRiskFlags(c *gin.Context) {
+	facultyID, _ := c.Get("userId")
+	facultyObjID := facultyID.(primitive.ObjectID)
+
+	classIDs, err := rc.facultyClassIDs(context.Background(), facultyObjID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching faculty classes"})
+		return
+	}
+
+	match := bson.M{"classId": bson.M{"$in": classIDs}}
+	if classID := c.Query("classId"); classID != "" {
+		classObjID, err := primitive.ObjectIDFromHex(classID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class ID"})
+			return
+		}
+		match["classId"] = bson.M{"$in": intersectIDs(classIDs, classObjID)}
+	}
+
+	rc.listFlags(c, match)
+}
+
+// GetRiskFlags returns open risk flags across all classes in the admin's scope
+func (rc *RiskController) GetRiskFlags(c *gin.Context) {
+	match := bson.M{}
+	if classID := c.Query("classId"); classID != "" {
+		classObjID, err := primitive.ObjectIDFromHex(classID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class ID"})
+			return
+		}
+		match["classId"] = classObjID
+	}
+	if studentID := c.Query("studentId"); studentID != "" {
+		studentObjID, err := primitive.ObjectIDFromHex(studentID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid student ID"})
+			return
+		}
+		match["studentId"] = studentObjID
+	}
+
+	// Department admins only see flags for their department's classes
+	if department := departmentScope(c); department != nil {
+		classIDs, err := rc.db.Collection("classes").Distinct(context.Background(), "_id", bson.M{"departmentId": *department})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching classes"})
+			return
+		}
+		if classObjID, ok := match["classId"]; ok {
+			match["classId"] = bson.M{"$in": classIDs, "$eq": classObjID}
+		} else {
+			match["classId"] = bson.M{"$in": classIDs}
+		}
+	}
+
+	rc.listFlags(c, match)
+}
+
+func intersectIDs(ids []primitive.ObjectID, id primitive.ObjectID) []primitive.ObjectID {
+	for _, candidate := range ids {
+		if candidate == id {
+			return []primitive.ObjectID{id}
+		}
+	}
+	return []primitive.ObjectID{}
+}
+
+// EvaluateFacultyRisk recomputes risk flags for the requesting faculty's classes on demand
+func (rc *RiskController) EvaluateFacultyRisk(c *gin.Context) {
+	facultyID, _ := c.Get("userId")
+	facultyObjID := facultyID.(primitive.ObjectID)
+
+	n, err := rc.Evaluate(context.Background(), bson.M{"facultyId": facultyObjID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error evaluating risk"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Risk evaluation completed", "evaluated": n})
+}
+
+// EvaluateRisk recomputes risk flags for all active classes in the admin's scope on demand
+func (rc *RiskController) EvaluateRisk(c *gin.Context) {
+	n, err := rc.Evaluate(context.Background(), scopeFilter(c, bson.M{}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error evaluating risk"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Risk evaluation completed", "evaluated": n})
+}
+
+// findFacultyFlag loads a flag and verifies the requesting faculty teaches its
+// class. Admins who see all flags may manage any flag in their scope.
+func (rc *RiskController) findFacultyFlag(c *gin.Context) (*models.RiskFlag, bool) {
+	flagID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid flag ID"})
+		return nil, false
+	}
+
+	var flag models.RiskFlag
+	err = rc.db.Collection("risk_flags").FindOne(context.Background(), bson.M{"_id": flagID}).Decode(&flag)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Risk flag not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching risk flag"})
+		return nil, false
+	}
+
+	classFilter := bson.M{"_id": flag.ClassID, "deletedAt": nil}
+	if middleware.HasPermission(c, models.PermRiskViewAll) {
+		classFilter = scopeFilter(c, classFilter)
+	} else {
+		facultyID, _ := c.Get("userId")
+		classFilter["facultyId"] = facultyID.(primitive.ObjectID)
+	}
+	count, err := rc.db.Collection("classes").CountDocuments(context.Background(), classFilter)
+	if err != nil || count == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to manage this risk flag"})
+		return nil, false
+	}
+
+	return &flag, true
+}
+
+// AcknowledgeRiskFlag marks a risk flag as seen by the class faculty
+func (rc *RiskController) AcknowledgeRiskFlag(c *gin.Context) {
+	flag, ok := rc.findFacultyFlag(c)
+	if !ok {
+		return
+	}
+
+	facultyID, _ := c.Get("userId")
+	now := time.Now()
+	result := rc.db.Collection("risk_flags").FindOneAndUpdate(
+		context.Background(),
+		bson.M{"_id": flag.ID},
+		bson.M{"$set": bson.M{
+			"status":         "acknowledged",
+			"acknowledgedBy": facultyID.(primitive.ObjectID),
+			"acknowledgedAt": now,
+			"updatedAt":      now,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+
+	var updated models.RiskFlag
+	if err := result.Decode(&updated); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error acknowledging risk flag"})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// AddRiskIntervention records an intervention taken against a risk flag
+func (rc *RiskController) AddRiskIntervention(c *gin.Context) {
+	var input struct {
+		Type string `json:"type" binding:"required"`
+		Note string `json:"note" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	validTypes := map[string]bool{"meeting": true, "email": true, "referral": true, "other": true}
+	if !validTypes[input.Type] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid intervention type"})
+		return
+	}
+
+	flag, ok := rc.findFacultyFlag(c)
+	if !ok {
+		return
+	}
+
+	facultyID, _ := c.Get("userId")
+	intervention := models.RiskIntervention{
+		ID:        primitive.NewObjectID(),
+		FacultyID: facultyID.(primitive.ObjectID),
+		Type:      input.Type,
+		Note:      input.Note,
+		CreatedAt: time.Now(),
+	}
+
+	update := bson.M{
+		"$push": bson.M{"interventions": intervention},
+		"$set":  bson.M{"updatedAt": intervention.CreatedAt},
+	}
+	// Recording an intervention implies the flag has been seen
+	if flag.Status == "open" {
+		update["$set"].(bson.M)["status"] = "acknowledged"
+		update["$set"].(bson.M)["acknowledgedBy"] = intervention.FacultyID
+		update["$set"].(bson.M)["acknowledgedAt"] = intervention.CreatedAt
+	}
+
+	_, err := rc.db.Collection("risk_flags").UpdateOne(context.Background(), bson.M{"_id": flag.ID}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error recording intervention"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, intervention)
+}
+
+// sortFactors orders factors by their contribution, largest first
+func sortFactors(factors []models.RiskFactor) {
+	sort.Slice(factors, func(i, j int) bool { return factors[i].Points > factors[j].Points })
+}
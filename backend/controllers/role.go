@@ -0,0 +1,378 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"classscheduling/middleware"
+	"classscheduling/models"
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,31}$`)
+
+type RoleController struct {
+	db *mongo.Database
+}
+
+func NewRoleController(db *mongo.Database) *RoleController {
+	return &RoleController{db: db}
+}
+
+// validatePermissions returns the first unknown permission, if any
+func validatePermissions(permissions []string) (string, bool) {
+	for _, permission := range permissions {
+		if _, ok := models.Permissions[permission]; !ok {
+			return permission, false
+		}
+	}
+	return "", true
+}
+
+// dedupe returns the values sorted and without duplicates
+func dedupe(values []string) []string {
+	seen := make(map[string]bool)
+	result := []string{}
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPermissions lists every permission that can be granted
+func (rc *RoleController) GetPermissions(c *gin.Context) {
+	permissions := make([]gin.H, 0, len(models.Permissions))
+	for name, description := range models.Permissions {
+		permissions = append(permissions, gin.H{"name": name, "description": description})
+	}
+	sort.Slice(permissions, func(i, j int) bool {
+		return permissions[i]["name"].(string) < permissions[j]["name"].(string)
+	})
+
+	c.JSON(http.StatusOK, permissions)
+}
+
+// GetRoles lists all roles
+func (rc *RoleController) GetRoles(c *gin.Context) {
+	ctx := context.Background()
+	cursor, err := rc.db.Collection("roles").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching roles"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	roles := []models.Role{}
+	if err := cursor.All(ctx, &roles); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding roles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, roles)
+}
+
+// CreateRole creates a custom role
+func (rc *RoleController) CreateRole(c *gin.Context) {
+	// Roles apply across departments
+	if !requireGlobalAdmin(c) {
+		return
+	}
+
+	var input struct {
+		Name        string   `json:"name" binding:"required"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	input.Name = strings.ToLower(strings.TrimSpace(input.Name))
+	if !roleNamePattern.MatchString(input.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role names must be 2-32 lowercase letters, digits, dashes or underscores"})
+		return
+	}
+	if permission, ok := validatePermissions(input.Permissions); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown permission: %s", permission)})
+		return
+	}
+
+	now := time.Now()
+	role := models.Role{
+		Name:        input.Name,
+		Description: input.Description,
+		Permissions: dedupe(input.Permissions),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	result, err := rc.db.Collection("roles").InsertOne(context.Background(), role)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role name already taken"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating role"})
+		return
+	}
+	role.ID = result.InsertedID.(primitive.ObjectID)
+
+	recordActivity(rc.db, c, models.Activity{
+		Type:        "role_created",
+		Description: fmt.Sprintf("Role %s created with permissions %v", role.Name, role.Permissions),
+	})
+
+	c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole changes a role's description or permissions. Role names cannot
+// be changed because users refer to roles by name.
+func (rc *RoleController) UpdateRole(c *gin.Context) {
+	// Roles apply across departments
+	if !requireGlobalAdmin(c) {
+		return
+	}
+
+	roleID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+
+	var input struct {
+		Description *string  `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	ctx := context.Background()
+	var role models.Role
+	if err := rc.db.Collection("roles").FindOne(ctx, bson.M{"_id": roleID}).Decode(&role); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching role"})
+		return
+	}
+
+	update := bson.M{"updatedAt": time.Now()}
+	if input.Description != nil {
+		update["description"] = *input.Description
+	}
+	if input.Permissions != nil {
+		if permission, ok := validatePermissions(input.Permissions); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown permission: %s", permission)})
+			return
+		}
+		// Stripping the admin role of role management would lock everyone out
+		if role.Name == "admin" && !containsString(input.Permissions, models.PermRolesManage) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The admin role must keep the roles.manage permission"})
+			return
+		}
+		update["permissions"] = dedupe(input.Permissions)
+	}
+
+	err = rc.db.Collection("roles").FindOneAndUpdate(ctx,
+		bson.M{"_id": roleID},
+		bson.M{"$set": update},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating role"})
+		return
+	}
+
+	recordActivity(rc.db, c, models.Activity{
+		Type:        "role_updated",
+		Description: fmt.Sprintf("Role %s updated, permissions %v", role.Name, role.Permissions),
+	})
+
+	c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole deletes a custom role and removes it from every user holding it
+func (rc *RoleController) DeleteRole(c *gin.Context) {
+	// Roles apply across departments
+	if !requireGlobalAdmin(c) {
+		return
+	}
+
+	roleID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+
+	ctx := context.Background()
+	var role models.Role
+	if err := rc.db.Collection("roles").FindOne(ctx, bson.M{"_id": roleID}).Decode(&role); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching role"})
+		return
+	}
+	if role.System {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Built-in roles cannot be deleted"})
+		return
+	}
+
+	if _, err := rc.db.Collection("roles").DeleteOne(ctx, bson.M{"_id": roleID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting role"})
+		return
+	}
+
+	_, err = rc.db.Collection("users").UpdateMany(ctx,
+		bson.M{"roles": role.Name},
+		bson.M{"$pull": bson.M{"roles": role.Name}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Role deleted but there was an error removing it from users"})
+		return
+	}
+
+	recordActivity(rc.db, c, models.Activity{
+		Type:        "role_deleted",
+		Description: fmt.Sprintf("Role %s deleted", role.Name),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted successfully"})
+}
+
+// canGrantRoles answers with 403 and returns false when the user may not
+// grant one of the roles. Department admins cannot grant more than they hold
+// themselves, or the admin role, which would reach past their department.
+func canGrantRoles(c *gin.Context, roles []models.Role) bool {
+	if departmentScope(c) == nil {
+		return true
+	}
+	for _, role := range roles {
+		if role.Name == "admin" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only a global admin can grant the admin role"})
+			return false
+		}
+		for _, permission := range role.Permissions {
+			if !middleware.HasPermission(c, permission) {
+				c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("You cannot grant the %s role, which has permissions you do not hold", role.Name)})
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SetUserRoles replaces the roles a user holds. An empty list puts the user
+// back on the default role for their user type.
+func (rc *RoleController) SetUserRoles(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var input struct {
+		Roles []string `json:"roles"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil || input.Roles == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	ctx := context.Background()
+	var user models.User
+	if err := rc.db.Collection("users").FindOne(ctx, scopeFilter(c, bson.M{"_id": userID, "deletedAt": nil})).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching user"})
+		return
+	}
+
+	roleNames := dedupe(input.Roles)
+	user.Roles = roleNames
+
+	cursor, err := rc.db.Collection("roles").Find(ctx, bson.M{"name": bson.M{"$in": user.RoleNames()}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching roles"})
+		return
+	}
+	var roles []models.Role
+	if err := cursor.All(ctx, &roles); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding roles"})
+		return
+	}
+	if len(roleNames) > 0 && len(roles) != len(roleNames) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
+
+	if !canGrantRoles(c, roles) {
+		return
+	}
+
+	// Admins cannot take away their own ability to manage roles
+	currentUserID, _ := c.Get("userId")
+	if currentUserID.(primitive.ObjectID) == userID {
+		canManage := false
+		for _, role := range roles {
+			if containsString(role.Permissions, models.PermRolesManage) {
+				canManage = true
+			}
+		}
+		if !canManage {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot remove your own permission to manage roles"})
+			return
+		}
+	}
+
+	update := bson.M{"$set": bson.M{"roles": roleNames, "updatedAt": time.Now()}}
+	if len(roleNames) == 0 {
+		update = bson.M{"$unset": bson.M{"roles": ""}, "$set": bson.M{"updatedAt": time.Now()}}
+	}
+	if _, err := rc.db.Collection("users").UpdateOne(ctx, bson.M{"_id": userID}, update); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating user roles"})
+		return
+	}
+
+	recordActivity(rc.db, c, models.Activity{
+		Type:        "user_roles_updated",
+		UserID:      &user.ID,
+		Username:    user.Username,
+		Description: fmt.Sprintf("Roles for %s set to %v", user.Username, user.RoleNames()),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"id": user.ID, "roles": user.RoleNames()})
+}
@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// meeting is one weekly session of a class, with times in minutes after
+// midnight
+type meeting struct {
+	Day   time.Weekday
+	Start int
+	End   int
+}
+
+// hours returns the length of the meeting in hours
+func (m meeting) hours() float64 {
+	return float64(m.End-m.Start) / 60
+}
+
+// slot labels the meeting's time slot, e.g. "Monday 09:00-10:30"
+func (m meeting) slot() string {
+	return fmt.Sprintf("%s %02d:%02d-%02d:%02d", m.Day, m.Start/60, m.Start%60, m.End/60, m.End%60)
+}
+
+// scheduleDays maps day names and their usual abbreviations
+var scheduleDays = map[string]time.Weekday{
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tues": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "weds": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+	"sun": time.Sunday, "sunday": time.Sunday,
+}
+
+// scheduleLetters are the one-letter days of compact forms such as "MWF"
+// and "TTh", where R is also Thursday
+var scheduleLetters = map[byte]time.Weekday{
+	'm': time.Monday, 't': time.Tuesday, 'w': time.Wednesday, 'r': time.Thursday,
+	'f': time.Friday, 's': time.Saturday, 'u': time.Sunday,
+}
+
+var (
+	scheduleTimeRange = regexp.MustCompile(`(\d{1,2})(?:[:.](\d{2}))?(?:\s*([ap])\.?m\b\.?)?\s*(?:-|–|to)\s*(\d{1,2})(?:[:.](\d{2}))?(?:\s*([ap])\.?m\b\.?)?`)
+	scheduleDayRange  = regexp.MustCompile(`([a-z]+)\s*(?:-|–|to)\s*([a-z]+)`)
+	scheduleWord      = regexp.MustCompile(`[a-z]+`)
+)
+
+// parseSchedule reads a class schedule into its weekly meetings. Schedules
+// set through the timetable are documents with day, startTime and endTime;
+// older ones are free text such as "Mon/Wed 10:00-11:30", "MWF 9-10am" or
+// "Tuesday 14:00 - 15:30, Thursday 09:00 - 10:30". ok is false when no
+// meeting can be read from the schedule.
+func parseSchedule(schedule interface{}) (meetings []meeting, ok bool) {
+	switch s := schedule.(type) {
+	case string:
+		meetings = parseScheduleText(s)
+	case primitive.D:
+		meetings = parseScheduleDocument(s.Map())
+	case bson.M:
+		meetings = parseScheduleDocument(s)
+	case map[string]interface{}:
+		meetings = parseScheduleDocument(s)
+	}
+	return meetings, len(meetings) > 0
+}
+
+func parseScheduleDocument(doc map[string]interface{}) []meeting {
+	day, _ := doc["day"].(string)
+	startTime, _ := doc["startTime"].(string)
+	endTime, _ := doc["endTime"].(string)
+	weekday, ok := scheduleDays[strings.ToLower(strings.TrimSpace(day))]
+	if !ok {
+		return nil
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(startTime))
+	if err != nil {
+		return nil
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(endTime))
+	if err != nil {
+		return nil
+	}
+	m := meeting{Day: weekday, Start: start.Hour()*60 + start.Minute(), End: end.Hour()*60 + end.Minute()}
+	if m.End <= m.Start {
+		return nil
+	}
+	return []meeting{m}
+}
+
+// parseScheduleText reads free text schedules. The text is split on commas,
+// semicolons, slashes and ampersands; days in a part without a time share
+// the time of the next part that has one, so "Mon/Wed 10-11" meets on both
+// days.
+func parseScheduleText(text string) []meeting {
+	var meetings []meeting
+	var days []time.Weekday
+	for _, part := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return strings.ContainsRune(",;/&", r) }) {
+		loc := scheduleTimeRange.FindStringSubmatchIndex(part)
+		if loc == nil {
+			days = append(days, scheduleTextDays(part)...)
+			continue
+		}
+		days = append(days, scheduleTextDays(part[:loc[0]]+" "+part[loc[1]:])...)
+		start, end, ok := scheduleTimes(scheduleTimeRange.FindStringSubmatch(part))
+		if !ok {
+			days = nil
+			continue
+		}
+		seen := make(map[time.Weekday]bool)
+		for _, day := range days {
+			if !seen[day] {
+				seen[day] = true
+				meetings = append(meetings, meeting{Day: day, Start: start, End: end})
+			}
+		}
+		days = nil
+	}
+	return meetings
+}
+
+// scheduleTextDays returns the days named in text, expanding ranges such as
+// "Mon-Fri"
+func scheduleTextDays(text string) []time.Weekday {
+	var days []time.Weekday
+	for _, match := range scheduleDayRange.FindAllStringSubmatch(text, -1) {
+		from, okFrom := scheduleDays[match[1]]
+		to, okTo := scheduleDays[match[2]]
+		if !okFrom || !okTo {
+			continue
+		}
+		for day := from; ; day = (day + 1) % 7 {
+			days = append(days, day)
+			if day == to {
+				break
+			}
+		}
+		text = strings.Replace(text, match[0], " ", 1)
+	}
+	for _, word := range scheduleWord.FindAllString(text, -1) {
+		if day, ok := scheduleDays[word]; ok {
+			days = append(days, day)
+			continue
+		}
+		days = append(days, scheduleCompactDays(word)...)
+	}
+	return days
+}
+
+// scheduleCompactDays reads a word such as "mwf" or "tth" as one letter per
+// day, or returns nil when the word is not made of day letters only
+func scheduleCompactDays(word string) []time.Weekday {
+	if len(word) < 2 {
+		return nil
+	}
+	var days []time.Weekday
+	for i := 0; i < len(word); i++ {
+		if word[i] == 't' && i+1 < len(word) && word[i+1] == 'h' {
+			days = append(days, time.Thursday)
+			i++
+			continue
+		}
+		day, ok := scheduleLetters[word[i]]
+		if !ok {
+			return nil
+		}
+		days = append(days, day)
+	}
+	return days
+}
+
+// scheduleTimes converts a scheduleTimeRange match into minutes after
+// midnight. A start without am or pm takes the end's when that keeps it
+// before the end, so "1-2pm" is 13:00-14:00 but "11-1pm" is 11:00-13:00.
+func scheduleTimes(match []string) (start, end int, ok bool) {
+	clock := func(hour, minute, half string) (int, bool) {
+		h, _ := strconv.Atoi(hour)
+		m := 0
+		if minute != "" {
+			m, _ = strconv.Atoi(minute)
+		}
+		if m > 59 {
+			return 0, false
+		}
+		switch half {
+		case "a":
+			if h < 1 || h > 12 {
+				return 0, false
+			}
+			h %= 12
+		case "p":
+			if h < 1 || h > 12 {
+				return 0, false
+			}
+			h = h%12 + 12
+		}
+		if h > 23 {
+			return 0, false
+		}
+		return h*60 + m, true
+	}
+
+	end, ok = clock(match[4], match[5], match[6])
+	if !ok {
+		return 0, 0, false
+	}
+	startHalf := match[3]
+	if startHalf == "" && match[6] != "" {
+		if s, ok := clock(match[1], match[2], match[6]); ok && s < end {
+			startHalf = match[6]
+		}
+	}
+	start, ok = clock(match[1], match[2], startHalf)
+	if !ok || end <= start {
+		return 0, 0, false
+	}
+	return start, end, true
+}
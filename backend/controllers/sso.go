@@ -0,0 +1,390 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+
+	"classscheduling/middleware"
+	"classscheduling/models"
+	"classscheduling/sso"
+)
+
+const (
+	ssoStateTTL = 10 * time.Minute
+	// ssoLoginTTL is how long the browser has to redeem the one-time login
+	// code it is sent back with after the provider's callback
+	ssoLoginTTL = 2 * time.Minute
+)
+
+// Errors shown to the user when a single sign-on cannot be completed
+var (
+	errSSONoAccount       = errors.New("No account is linked to this identity. Please contact an administrator")
+	errSSOEmailTaken      = errors.New("An account with this email address already exists. Log in with your password to use it")
+	errSSOEmailRequired   = errors.New("The identity provider did not share an email address")
+	errSSORollNumber      = errors.New("The identity provider did not share a roll number")
+	errSSOAdminNotAllowed = errors.New("Admin accounts cannot be created through single sign-on")
+)
+
+var usernameUnsafe = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// ssoJITProvisioning reports whether unknown single sign-on users get an
+// account created on first login. Set SSO_JIT_PROVISIONING=false to only
+// allow users who already have an account.
+func ssoJITProvisioning() bool {
+	return os.Getenv("SSO_JIT_PROVISIONING") != "false"
+}
+
+// GetSSOProviders lists the configured single sign-on providers
+func (ac *AuthController) GetSSOProviders(c *gin.Context) {
+	providers := []gin.H{}
+	for name := range ac.providers {
+		providers = append(providers, gin.H{
+			"name":     name,
+			"loginUrl": "/api/auth/sso/" + name + "/login",
+		})
+	}
+	sort.Slice(providers, func(i, j int) bool {
+		return providers[i]["name"].(string) < providers[j]["name"].(string)
+	})
+
+	c.JSON(http.StatusOK, providers)
+}
+
+// SSOLogin redirects the browser to the identity provider
+func (ac *AuthController) SSOLogin(c *gin.Context) {
+	provider, ok := ac.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown single sign-on provider"})
+		return
+	}
+
+	state, err := sso.RandomString(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting single sign-on"})
+		return
+	}
+	nonce, err := sso.RandomString(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting single sign-on"})
+		return
+	}
+	verifier, challenge, err := sso.NewPKCE()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting single sign-on"})
+		return
+	}
+
+	now := time.Now()
+	_, err = ac.db.Collection("sso_states").InsertOne(context.Background(), models.SSOState{
+		ID:           middleware.HashToken(state),
+		Provider:     provider.Name(),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    now.Add(ssoStateTTL),
+		CreatedAt:    now,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting single sign-on"})
+		return
+	}
+
+	authURL, err := provider.AuthURL(c.Request.Context(), state, nonce, challenge)
+	if err != nil {
+		log.Printf("Error contacting single sign-on provider %s: %v", provider.Name(), err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Single sign-on provider is unavailable"})
+		return
+	}
+	if hint := c.Query("login_hint"); hint != "" {
+		authURL += "&login_hint=" + url.QueryEscape(hint)
+	}
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// ssoRedirect sends the browser back to the frontend to finish logging in
+func ssoRedirect(c *gin.Context, params url.Values) {
+	c.Redirect(http.StatusFound, appURL()+"/frontend/sso-callback.html?"+params.Encode())
+}
+
+// SSOCallback completes the provider's authorization code flow. It does not
+// start a session itself; the browser is sent to the frontend with a
+// short-lived one-time code that it redeems through CompleteSSO, so tokens
+// never appear in URLs.
+func (ac *AuthController) SSOCallback(c *gin.Context) {
+	provider, ok := ac.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown single sign-on provider"})
+		return
+	}
+
+	fail := func(message string) {
+		ssoRedirect(c, url.Values{"error": {message}})
+	}
+
+	// The state is single-use and must belong to this provider
+	var state models.SSOState
+	err := ac.db.Collection("sso_states").FindOneAndDelete(context.Background(), bson.M{
+		"_id":       middleware.HashToken(c.Query("state")),
+		"provider":  provider.Name(),
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}).Decode(&state)
+	if err != nil {
+		fail("Single sign-on request has expired. Please try again")
+		return
+	}
+
+	if errorCode := c.Query("error"); errorCode != "" {
+		fail("Sign-in was cancelled or refused by the identity provider")
+		return
+	}
+
+	identity, err := provider.Exchange(c.Request.Context(), c.Query("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		log.Printf("Error completing single sign-on with %s: %v", provider.Name(), err)
+		fail("Could not verify your identity with the provider")
+		return
+	}
+
+	ctx := context.Background()
+	user, err := ac.findOrProvisionSSOUser(ctx, c, identity)
+	if err != nil {
+		switch err {
+		case errSSONoAccount, errSSOEmailTaken, errSSOEmailRequired, errSSORollNumber, errSSOAdminNotAllowed:
+			fail(err.Error())
+		default:
+			log.Printf("Error provisioning single sign-on user from %s: %v", provider.Name(), err)
+			fail("Error signing in")
+		}
+		return
+	}
+
+	code, err := createUserToken(ctx, ac.db, user.ID, "sso_login", ssoLoginTTL)
+	if err != nil {
+		fail("Error signing in")
+		return
+	}
+
+	ssoRedirect(c, url.Values{"code": {code}})
+}
+
+// CompleteSSO redeems the one-time code from SSOCallback and logs the user
+// in, subject to the same account checks as a password login
+func (ac *AuthController) CompleteSSO(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	ctx := context.Background()
+	stored, err := consumeUserToken(ctx, ac.db, input.Code, "sso_login")
+	if err != nil {
+		if err == errInvalidUserToken {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on code is invalid or has expired"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var user models.User
+	if err := ac.db.Collection("users").FindOne(ctx, bson.M{"_id": stored.UserID, "deletedAt": nil}).Decode(&user); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on code is invalid or has expired"})
+		return
+	}
+
+	recordActivity(ac.db, c, models.Activity{
+		Type:        "sso_login",
+		UserID:      &user.ID,
+		Username:    user.Username,
+		Description: fmt.Sprintf("%s logged in through %s", user.Username, user.SSOProvider),
+	})
+
+	ac.finishLogin(c, user)
+}
+
+// findOrProvisionSSOUser returns the account for an identity. Accounts are
+// matched by provider and subject first, then linked by verified email
+// address, and otherwise created just in time.
+func (ac *AuthController) findOrProvisionSSOUser(ctx context.Context, c *gin.Context, identity *sso.Identity) (models.User, error) {
+	var user models.User
+	err := ac.db.Collection("users").FindOne(ctx, bson.M{
+		"ssoProvider": identity.Provider,
+		"ssoSubject":  identity.Subject,
+		"deletedAt":   nil,
+	}).Decode(&user)
+	if err == nil {
+		return user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return user, err
+	}
+
+	if identity.Email == "" {
+		return user, errSSOEmailRequired
+	}
+
+	// Link an existing account, but only when the provider vouches for the
+	// email address and the account is not linked elsewhere already
+	err = ac.db.Collection("users").FindOne(ctx, bson.M{"email": identity.Email, "deletedAt": nil}).Decode(&user)
+	if err == nil {
+		if !identity.EmailVerified || user.SSOSubject != "" {
+			return user, errSSOEmailTaken
+		}
+		return ac.linkSSOUser(ctx, c, user, identity)
+	}
+	if err != mongo.ErrNoDocuments {
+		return user, err
+	}
+
+	if !ssoJITProvisioning() {
+		return user, errSSONoAccount
+	}
+	return ac.provisionSSOUser(ctx, c, identity)
+}
+
+// linkSSOUser links an existing account to an identity
+func (ac *AuthController) linkSSOUser(ctx context.Context, c *gin.Context, user models.User, identity *sso.Identity) (models.User, error) {
+	now := time.Now()
+	set := bson.M{
+		"ssoProvider": identity.Provider,
+		"ssoSubject":  identity.Subject,
+		"updatedAt":   now,
+	}
+	if !user.EmailVerified {
+		set["emailVerified"] = true
+		set["emailVerifiedAt"] = now
+		user.EmailVerified = true
+	}
+
+	result, err := ac.db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": user.ID, "ssoSubject": bson.M{"$exists": false}},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return user, err
+	}
+	if result.MatchedCount == 0 {
+		return user, errSSOEmailTaken
+	}
+	user.SSOProvider = identity.Provider
+	user.SSOSubject = identity.Subject
+
+	recordActivity(ac.db, c, models.Activity{
+		Type:        "sso_linked",
+		UserID:      &user.ID,
+		Username:    user.Username,
+		Description: fmt.Sprintf("%s was linked to %s", user.Username, identity.Provider),
+	})
+	return user, nil
+}
+
+// provisionSSOUser creates an account for an identity seen for the first time
+func (ac *AuthController) provisionSSOUser(ctx context.Context, c *gin.Context, identity *sso.Identity) (models.User, error) {
+	var user models.User
+	if identity.UserType == "admin" {
+		return user, errSSOAdminNotAllowed
+	}
+	if !models.ValidUserType(identity.UserType) {
+		return user, errSSONoAccount
+	}
+	if identity.UserType == "student" && identity.RollNumber == "" {
+		return user, errSSORollNumber
+	}
+
+	username, err := ac.uniqueUsername(ctx, identity)
+	if err != nil {
+		return user, err
+	}
+
+	// The account can only be used through the provider until the user sets
+	// a password with the reset flow
+	password, err := sso.RandomString(32)
+	if err != nil {
+		return user, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return user, err
+	}
+
+	now := time.Now()
+	user = models.User{
+		Username:      username,
+		Email:         identity.Email,
+		Password:      string(hashedPassword),
+		UserType:      identity.UserType,
+		RollNumber:    identity.RollNumber,
+		EmailVerified: identity.EmailVerified,
+		SSOProvider:   identity.Provider,
+		SSOSubject:    identity.Subject,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if identity.EmailVerified {
+		user.EmailVerifiedAt = &now
+	}
+
+	result, err := ac.db.Collection("users").InsertOne(ctx, user)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return user, errSSOEmailTaken
+		}
+		return user, err
+	}
+	user.ID = result.InsertedID.(primitive.ObjectID)
+
+	recordActivity(ac.db, c, models.Activity{
+		Type:        "sso_provisioned",
+		UserID:      &user.ID,
+		Username:    user.Username,
+		Description: fmt.Sprintf("%s account %s was created through %s", user.UserType, user.Username, identity.Provider),
+	})
+	return user, nil
+}
+
+// uniqueUsername derives a free username from the identity's preferred
+// username or email address
+func (ac *AuthController) uniqueUsername(ctx context.Context, identity *sso.Identity) (string, error) {
+	base := identity.Username
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+	base = strings.Trim(usernameUnsafe.ReplaceAllString(strings.ToLower(base), ""), "._-")
+	if len(base) < 3 {
+		base = "user"
+	}
+
+	for i := 0; i < 100; i++ {
+		username := base
+		if i > 0 {
+			username = fmt.Sprintf("%s%d", base, i+1)
+		}
+		count, err := ac.db.Collection("users").CountDocuments(ctx, bson.M{"username": username})
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return username, nil
+		}
+	}
+	return "", errors.New("no free username")
+}
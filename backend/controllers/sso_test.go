@@ -0,0 +1,363 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"classscheduling/middleware"
+	"classscheduling/models"
+	"classscheduling/ratelimit"
+	"classscheduling/sso"
+)
+
+// ssoFlow drives the single sign-on flow through the in-process mock
+// identity provider. MongoDB is the driver's mock deployment, so each test
+// queues the replies to the commands the handlers send, in order.
+type ssoFlow struct {
+	mt     *mtest.T
+	idp    *sso.MockIdP
+	router *gin.Engine
+}
+
+func newSSOFlow(t *testing.T, mt *mtest.T) *ssoFlow {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_URL", "http://app.test")
+
+	idp, err := sso.NewMockIdP("http://idp.test/mock-idp", "mock-client", "mock-secret", nil)
+	if err != nil {
+		t.Fatalf("NewMockIdP: %v", err)
+	}
+	providers := map[string]sso.Provider{"mock": idp.Provider("http://app.test")}
+	ac := NewAuthController(mt.Client.Database("test"), nil, ratelimit.NewMemoryLimiter(), providers)
+
+	router := gin.New()
+	router.GET("/api/auth/sso/:provider/login", ac.SSOLogin)
+	router.GET("/api/auth/sso/:provider/callback", ac.SSOCallback)
+	router.POST("/api/auth/sso/complete", ac.CompleteSSO)
+	return &ssoFlow{mt: mt, idp: idp, router: router}
+}
+
+// start begins a sign-in as the given mock user and returns the state that
+// was stored and the callback URL the identity provider redirects back to
+func (f *ssoFlow) start(t *testing.T, username string) (models.SSOState, *url.URL) {
+	t.Helper()
+	f.mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/auth/sso/mock/login?login_hint="+username, nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login returned %d: %s", rec.Code, rec.Body.String())
+	}
+	authURL := rec.Header().Get("Location")
+
+	var state models.SSOState
+	insert := f.command(t, "insert", "sso_states")
+	if err := bson.Unmarshal(insert.Lookup("documents").Array().Index(0).Value().Document(), &state); err != nil {
+		t.Fatalf("decoding stored state: %v", err)
+	}
+	sent, _ := url.Parse(authURL)
+	if state.ID != middleware.HashToken(sent.Query().Get("state")) {
+		t.Fatal("stored state does not match the state sent to the provider")
+	}
+
+	rec = httptest.NewRecorder()
+	f.idp.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, authURL, nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("authorize returned %d: %s", rec.Code, rec.Body.String())
+	}
+	callback, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid callback URL: %v", err)
+	}
+	return state, callback
+}
+
+// callback delivers the provider's redirect, answering the state lookup
+// with state and the following commands with replies, and returns the
+// parameters the browser is sent to the frontend with
+func (f *ssoFlow) callback(t *testing.T, callback *url.URL, state *models.SSOState, replies ...bson.D) url.Values {
+	t.Helper()
+	f.mt.ClearEvents()
+	f.mt.AddMockResponses(append([]bson.D{findAndModifyReply(t, state)}, replies...)...)
+
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("callback returned %d: %s", rec.Code, rec.Body.String())
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), "http://app.test/frontend/sso-callback.html?") {
+		t.Fatalf("callback redirected to %q", rec.Header().Get("Location"))
+	}
+	return location.Query()
+}
+
+// command returns the last command of the given name sent to a collection
+func (f *ssoFlow) command(t *testing.T, name, collection string) bson.Raw {
+	t.Helper()
+	return lastCommand(t, f.mt, name, collection)
+}
+
+// sent reports whether a command of the given name was sent to a collection
+func (f *ssoFlow) sent(name, collection string) bool {
+	return commandSent(f.mt, name, collection)
+}
+
+// lastCommand returns the last command of the given name sent to a
+// collection of the mock deployment
+func lastCommand(t *testing.T, mt *mtest.T, name, collection string) bson.Raw {
+	t.Helper()
+	var found *event.CommandStartedEvent
+	for _, e := range mt.GetAllStartedEvents() {
+		if e.CommandName == name && e.Command.Lookup(name).StringValue() == collection {
+			found = e
+		}
+	}
+	if found == nil {
+		t.Fatalf("no %s on %s was sent", name, collection)
+	}
+	return found.Command
+}
+
+// commandSent reports whether a command of the given name was sent to a
+// collection of the mock deployment
+func commandSent(mt *mtest.T, name, collection string) bool {
+	for _, e := range mt.GetAllStartedEvents() {
+		if e.CommandName == name && e.Command.Lookup(name).StringValue() == collection {
+			return true
+		}
+	}
+	return false
+}
+
+func toDoc(t *testing.T, v interface{}) bson.D {
+	t.Helper()
+	data, err := bson.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// findAndModifyReply answers a findAndModify with the document, or with no
+// match when it is nil
+func findAndModifyReply(t *testing.T, v interface{}) bson.D {
+	if v == nil || v == (*models.SSOState)(nil) {
+		return mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil})
+	}
+	return mtest.CreateSuccessResponse(bson.E{Key: "value", Value: toDoc(t, v)})
+}
+
+// found answers a find with the given documents
+func found(t *testing.T, docs ...interface{}) bson.D {
+	batch := make([]bson.D, len(docs))
+	for i, doc := range docs {
+		batch[i] = toDoc(t, doc)
+	}
+	return mtest.CreateCursorResponse(0, "test.users", mtest.FirstBatch, batch...)
+}
+
+// ok answers a write
+func ok() bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+}
+
+// loginCodeReplies answer createUserToken storing the one-time login code
+func loginCodeReplies() []bson.D {
+	return []bson.D{ok(), ok()}
+}
+
+func TestSSOCallbackRejectsMismatchedState(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("state", func(mt *mtest.T) {
+		t := mt.T
+		f := newSSOFlow(t, mt)
+		_, callback := f.start(t, "sso.student")
+
+		// A state the server never issued matches no stored state
+		query := callback.Query()
+		query.Set("state", "forged-state")
+		callback.RawQuery = query.Encode()
+		params := f.callback(t, callback, nil)
+
+		if params.Get("code") != "" || !strings.Contains(params.Get("error"), "expired") {
+			t.Fatalf("callback params = %v, want an expired request error", params)
+		}
+		lookup := f.command(t, "findAndModify", "sso_states")
+		if id := lookup.Lookup("query", "_id").StringValue(); id != middleware.HashToken("forged-state") {
+			t.Errorf("state looked up by %q, want the hash of the returned state", id)
+		}
+		if f.sent("find", "users") {
+			t.Error("users were looked up for a forged state")
+		}
+	})
+
+	mt.Run("nonce", func(mt *mtest.T) {
+		t := mt.T
+		f := newSSOFlow(t, mt)
+		state, callback := f.start(t, "sso.student")
+
+		// The ID token carries the nonce of the original request
+		state.Nonce = "other-nonce"
+		params := f.callback(t, callback, &state)
+
+		if params.Get("code") != "" || params.Get("error") != "Could not verify your identity with the provider" {
+			t.Fatalf("callback params = %v, want a verification error", params)
+		}
+		if f.sent("find", "users") {
+			t.Error("users were looked up for an unverified identity")
+		}
+	})
+}
+
+func TestSSOCallbackJITProvisioning(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("off", func(mt *mtest.T) {
+		t := mt.T
+		t.Setenv("SSO_JIT_PROVISIONING", "false")
+		f := newSSOFlow(t, mt)
+		state, callback := f.start(t, "sso.student")
+
+		params := f.callback(t, callback, &state, found(t), found(t))
+
+		if params.Get("error") != errSSONoAccount.Error() {
+			t.Fatalf("callback params = %v, want %q", params, errSSONoAccount)
+		}
+		if f.sent("insert", "users") {
+			t.Error("an account was created with provisioning off")
+		}
+	})
+
+	mt.Run("on", func(mt *mtest.T) {
+		t := mt.T
+		t.Setenv("SSO_JIT_PROVISIONING", "true")
+		f := newSSOFlow(t, mt)
+		state, callback := f.start(t, "sso.student")
+
+		replies := []bson.D{
+			found(t), // by provider and subject
+			found(t), // by email
+			mtest.CreateCursorResponse(0, "test.users", mtest.FirstBatch), // username is free
+			ok(), // insert user
+			ok(), // activity
+		}
+		params := f.callback(t, callback, &state, append(replies, loginCodeReplies()...)...)
+
+		if params.Get("code") == "" {
+			t.Fatalf("callback params = %v, want a login code", params)
+		}
+		var user models.User
+		insert := f.command(t, "insert", "users")
+		if err := bson.Unmarshal(insert.Lookup("documents").Array().Index(0).Value().Document(), &user); err != nil {
+			t.Fatal(err)
+		}
+		if user.Username != "sso.student" || user.Email != "sso.student@example.edu" || user.UserType != "student" ||
+			user.RollNumber != "SSO001" || !user.EmailVerified {
+			t.Errorf("provisioned user = %+v", user)
+		}
+		if user.SSOProvider != "mock" || user.SSOSubject != "mock-student-1" {
+			t.Errorf("provisioned user linked to %s/%s, want mock/mock-student-1", user.SSOProvider, user.SSOSubject)
+		}
+	})
+}
+
+func TestSSOCallbackLinksAccountByEmail(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("link", func(mt *mtest.T) {
+		t := mt.T
+		f := newSSOFlow(t, mt)
+		state, callback := f.start(t, "sso.faculty")
+
+		existing := models.User{
+			ID:        primitive.NewObjectID(),
+			Username:  "jdoe",
+			Email:     "sso.faculty@example.edu",
+			UserType:  "faculty",
+			CreatedAt: time.Now(),
+		}
+		replies := []bson.D{
+			found(t),           // by provider and subject
+			found(t, existing), // by email
+			ok(),               // link
+			ok(),               // activity
+		}
+		params := f.callback(t, callback, &state, append(replies, loginCodeReplies()...)...)
+
+		if params.Get("code") == "" {
+			t.Fatalf("callback params = %v, want a login code", params)
+		}
+		if f.sent("insert", "users") {
+			t.Error("a new account was created instead of linking the existing one")
+		}
+		update := f.command(t, "update", "users").Lookup("updates").Array().Index(0).Value().Document()
+		if id := update.Lookup("q", "_id").ObjectID(); id != existing.ID {
+			t.Errorf("linked %s, want %s", id.Hex(), existing.ID.Hex())
+		}
+		set := update.Lookup("u", "$set").Document()
+		if set.Lookup("ssoProvider").StringValue() != "mock" || set.Lookup("ssoSubject").StringValue() != "mock-faculty-1" {
+			t.Errorf("link set %v", set)
+		}
+		if !set.Lookup("emailVerified").Boolean() {
+			t.Error("the provider-verified email was not marked as verified")
+		}
+	})
+}
+
+func TestSSOCompleteRejectsDisabledUser(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("disabled", func(mt *mtest.T) {
+		t := mt.T
+		f := newSSOFlow(t, mt)
+		state, callback := f.start(t, "sso.student")
+
+		user := models.User{
+			ID:          primitive.NewObjectID(),
+			Username:    "sso.student",
+			Email:       "sso.student@example.edu",
+			UserType:    "student",
+			Disabled:    true,
+			SSOProvider: "mock",
+			SSOSubject:  "mock-student-1",
+		}
+		params := f.callback(t, callback, &state, append([]bson.D{found(t, user)}, loginCodeReplies()...)...)
+		code := params.Get("code")
+		if code == "" {
+			t.Fatalf("callback params = %v, want a login code", params)
+		}
+
+		f.mt.ClearEvents()
+		f.mt.AddMockResponses(
+			findAndModifyReply(t, models.UserToken{UserID: user.ID, Type: "sso_login", ExpiresAt: time.Now().Add(time.Minute)}),
+			found(t, user),
+			ok(), // activity
+		)
+		body, _ := json.Marshal(gin.H{"code": code})
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/sso/complete", strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		f.router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "disabled") {
+			t.Fatalf("complete returned %d: %s, want 403 for a disabled account", rec.Code, rec.Body.String())
+		}
+		if f.sent("insert", "sessions") {
+			t.Error("a session was started for a disabled account")
+		}
+	})
+}
@@ -0,0 +1,453 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"classscheduling/models"
+)
+
+const (
+	// statisticsDefaultDays is the window covered when no dates are given
+	statisticsDefaultDays = 30
+	// statisticsMaxBuckets bounds the time series, so a long range needs a
+	// coarser interval
+	statisticsMaxBuckets = 400
+)
+
+// statisticsIntervals are the bucket sizes of the time series
+var statisticsIntervals = map[string]bool{"day": true, "week": true, "month": true}
+
+// bucketStart returns the start of the bucket containing t. Weeks start on
+// Monday; all buckets are in UTC.
+func bucketStart(t time.Time, interval string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch interval {
+	case "week":
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return day
+}
+
+// nextBucket returns the start of the bucket after the one starting at t
+func nextBucket(t time.Time, interval string) time.Time {
+	switch interval {
+	case "week":
+		return t.AddDate(0, 0, 7)
+	case "month":
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// statisticsBucket is one period of the statistics time series. Rates are
+// null for periods without any data.
+type statisticsBucket struct {
+	Start          time.Time `json:"start"`
+	ActiveUsers    int       `json:"activeUsers"`
+	NewUsers       int64     `json:"newUsers"`
+	AttendanceRate *float64  `json:"attendanceRate"`
+	AverageGrade   *float64  `json:"averageGrade"`
+	Cancellations  int64     `json:"cancellations"`
+	Reschedules    int64     `json:"reschedules"`
+
+	active     map[primitive.ObjectID]bool
+	present    int64
+	absent     int64
+	gradeSum   float64
+	gradeCount int64
+}
+
+// statisticsSeries is the time series of a statistics window
+type statisticsSeries struct {
+	interval string
+	buckets  []*statisticsBucket
+}
+
+// statisticsBucketCount counts the buckets between start and end, stopping
+// once there are more than statisticsMaxBuckets
+func statisticsBucketCount(start, end time.Time, interval string) int {
+	n := 0
+	for t := bucketStart(start, interval); t.Before(end) && n <= statisticsMaxBuckets; t = nextBucket(t, interval) {
+		n++
+	}
+	return n
+}
+
+// newStatisticsSeries allocates every bucket of the window, so callers check
+// statisticsBucketCount first
+func newStatisticsSeries(start, end time.Time, interval string) *statisticsSeries {
+	series := &statisticsSeries{interval: interval}
+	for t := bucketStart(start, interval); t.Before(end); t = nextBucket(t, interval) {
+		series.buckets = append(series.buckets, &statisticsBucket{Start: t, active: map[primitive.ObjectID]bool{}})
+	}
+	return series
+}
+
+// at returns the bucket containing t, or nil when t is outside the window
+func (s *statisticsSeries) at(t time.Time) *statisticsBucket {
+	start := bucketStart(t, s.interval)
+	for _, bucket := range s.buckets {
+		if bucket.Start.Equal(start) {
+			return bucket
+		}
+	}
+	return nil
+}
+
+// day returns the bucket containing a day grouped with $dateToString
+func (s *statisticsSeries) day(key string) *statisticsBucket {
+	t, err := time.Parse("2006-01-02", key)
+	if err != nil {
+		return nil
+	}
+	return s.at(t)
+}
+
+// dayKey groups documents by the UTC day of field
+func dayKey(field string) bson.M {
+	return bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$" + field}}
+}
+
+// rate returns part as a percentage of whole, or nil when whole is zero
+func rate(part, whole float64) *float64 {
+	if whole == 0 {
+		return nil
+	}
+	r := part / whole * 100
+	return &r
+}
+
+// statisticsWindow reads the startDate and endDate query parameters, as
+// YYYY-MM-DD, into a window that includes the whole end day. Without them
+// the window is the last statisticsDefaultDays days. ok is false once an
+// error has been written.
+func statisticsWindow(c *gin.Context) (start, end time.Time, ok bool) {
+	startDate, endDate := c.Query("startDate"), c.Query("endDate")
+	if startDate == "" && endDate == "" {
+		end = bucketStart(time.Now(), "day").AddDate(0, 0, 1)
+		return end.AddDate(0, 0, -statisticsDefaultDays), end, true
+	}
+
+	start, err := time.Parse("2006-01-02", startDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start date format"})
+		return start, end, false
+	}
+	end, err = time.Parse("2006-01-02", endDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end date format"})
+		return start, end, false
+	}
+	end = end.AddDate(0, 0, 1)
+	if !start.Before(end) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start date must not be after end date"})
+		return start, end, false
+	}
+	return start, end, true
+}
+
+// GetStatistics returns user, class, attendance, grade and schedule change
+// statistics for the window given by startDate and endDate, with a time
+// series in day, week or month buckets chosen by the interval parameter.
+// Department admins only see their department.
+func (uc *UserController) GetStatistics(c *gin.Context) {
+	ctx := context.Background()
+	start, end, ok := statisticsWindow(c)
+	if !ok {
+		return
+	}
+	interval := c.DefaultQuery("interval", "day")
+	if !statisticsIntervals[interval] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "interval must be day, week or month"})
+		return
+	}
+	if statisticsBucketCount(start, end, interval) > statisticsMaxBuckets {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Date range is too long for this interval"})
+		return
+	}
+	series := newStatisticsSeries(start, end, interval)
+	window := bson.M{"$gte": start, "$lt": end}
+	fail := func(message string) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+
+	// Users now, and users created in the window
+	userFilter := scopeFilter(c, bson.M{"deletedAt": nil, "userType": bson.M{"$ne": models.UserTypeService}})
+	var users []struct {
+		ID        primitive.ObjectID `bson:"_id"`
+		UserType  string             `bson:"userType"`
+		CreatedAt time.Time          `bson:"createdAt"`
+	}
+	cursor, err := uc.db.Collection("users").Find(ctx, userFilter,
+		options.Find().SetProjection(bson.M{"userType": 1, "createdAt": 1}))
+	if err != nil {
+		fail("Error fetching user statistics")
+		return
+	}
+	if err := cursor.All(ctx, &users); err != nil {
+		fail("Error fetching user statistics")
+		return
+	}
+	userCounts := make(map[string]int64)
+	newUsers := make(map[string]int64)
+	userTypes := make(map[primitive.ObjectID]string, len(users))
+	for _, userType := range models.UserTypes {
+		userCounts[userType] = 0
+		newUsers[userType] = 0
+	}
+	for _, user := range users {
+		userCounts[user.UserType]++
+		userTypes[user.ID] = user.UserType
+		if !user.CreatedAt.Before(start) && user.CreatedAt.Before(end) {
+			newUsers[user.UserType]++
+			if bucket := series.at(user.CreatedAt); bucket != nil {
+				bucket.NewUsers++
+			}
+		}
+	}
+
+	// Users who signed in, or kept a session going, during the window
+	var sessions []struct {
+		UserID     primitive.ObjectID `bson:"userId"`
+		CreatedAt  time.Time          `bson:"createdAt"`
+		LastUsedAt time.Time          `bson:"lastUsedAt"`
+	}
+	cursor, err = uc.db.Collection("sessions").Find(ctx,
+		bson.M{"$or": []bson.M{{"createdAt": window}, {"lastUsedAt": window}}},
+		options.Find().SetProjection(bson.M{"userId": 1, "createdAt": 1, "lastUsedAt": 1}))
+	if err != nil {
+		fail("Error fetching active users")
+		return
+	}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		fail("Error fetching active users")
+		return
+	}
+	activeUsers := make(map[string]int)
+	active := make(map[primitive.ObjectID]bool)
+	for _, session := range sessions {
+		userType, ok := userTypes[session.UserID]
+		if !ok {
+			continue
+		}
+		if !active[session.UserID] {
+			active[session.UserID] = true
+			activeUsers[userType]++
+		}
+		for _, t := range []time.Time{session.CreatedAt, session.LastUsedAt} {
+			if bucket := series.at(t); bucket != nil && !t.Before(start) && t.Before(end) {
+				bucket.active[session.UserID] = true
+			}
+		}
+	}
+
+	// Active classes with their real enrollment
+	var classes []struct {
+		ID       primitive.ObjectID `bson:"_id" json:"id"`
+		Name     string             `bson:"name" json:"name"`
+		Code     string             `bson:"code,omitempty" json:"code,omitempty"`
+		Enrolled int                `bson:"enrolled" json:"enrolled"`
+		Capacity int                `bson:"capacity" json:"capacity"`
+		FillRate *float64           `bson:"-" json:"fillRate"`
+	}
+	cursor, err = uc.db.Collection("classes").Aggregate(ctx, []bson.M{
+		{"$match": scopeFilter(c, bson.M{"status": "active", "deletedAt": nil})},
+		{"$project": bson.M{
+			"name":     1,
+			"code":     1,
+			"capacity": 1,
+			"enrolled": bson.M{"$size": bson.M{"$ifNull": []interface{}{"$enrolled", []interface{}{}}}},
+		}},
+		{"$sort": bson.M{"name": 1}},
+	})
+	if err != nil {
+		fail("Error fetching class statistics")
+		return
+	}
+	if err := cursor.All(ctx, &classes); err != nil {
+		fail("Error processing class statistics")
+		return
+	}
+	var totalEnrolled, totalCapacity int
+	for i := range classes {
+		totalEnrolled += classes[i].Enrolled
+		totalCapacity += classes[i].Capacity
+		classes[i].FillRate = rate(float64(classes[i].Enrolled), float64(classes[i].Capacity))
+	}
+	averageClassSize := 0.0
+	if len(classes) > 0 {
+		averageClassSize = float64(totalEnrolled) / float64(len(classes))
+	}
+
+	// Attendance, grades and schedule changes only count for classes that
+	// are not deleted, and for department admins only their department's
+	recordFilter := bson.M{}
+	if departmentScope(c) != nil {
+		classIDs, err := uc.db.Collection("classes").Distinct(ctx, "_id", scopeFilter(c, bson.M{"deletedAt": nil}))
+		if err != nil {
+			fail("Error fetching class statistics")
+			return
+		}
+		recordFilter["classId"] = bson.M{"$in": classIDs}
+	} else if err := excludeDeletedClasses(ctx, uc.db, recordFilter); err != nil {
+		fail("Error fetching class statistics")
+		return
+	}
+	matchIn := func(field string) bson.M {
+		match := bson.M{field: window}
+		for k, v := range recordFilter {
+			match[k] = v
+		}
+		return match
+	}
+
+	var attendanceDays []struct {
+		Day     string `bson:"_id"`
+		Present int64  `bson:"present"`
+		Absent  int64  `bson:"absent"`
+	}
+	cursor, err = uc.db.Collection("attendance").Aggregate(ctx, []bson.M{
+		{"$match": matchIn("date")},
+		{"$group": bson.M{
+			"_id":     dayKey("date"),
+			"present": bson.M{"$sum": bson.M{"$size": bson.M{"$ifNull": []interface{}{"$present", []interface{}{}}}}},
+			"absent":  bson.M{"$sum": bson.M{"$size": bson.M{"$ifNull": []interface{}{"$absent", []interface{}{}}}}},
+		}},
+	})
+	if err != nil {
+		fail("Error fetching attendance statistics")
+		return
+	}
+	if err := cursor.All(ctx, &attendanceDays); err != nil {
+		fail("Error fetching attendance statistics")
+		return
+	}
+	var present, absent int64
+	for _, day := range attendanceDays {
+		present += day.Present
+		absent += day.Absent
+		if bucket := series.day(day.Day); bucket != nil {
+			bucket.present += day.Present
+			bucket.absent += day.Absent
+		}
+	}
+
+	var gradeDays []struct {
+		Day        string  `bson:"_id"`
+		PercentSum float64 `bson:"percentSum"`
+		Count      int64   `bson:"count"`
+	}
+	gradeMatch := matchIn("date")
+	gradeMatch["totalMarks"] = bson.M{"$gt": 0}
+	cursor, err = uc.db.Collection("performance").Aggregate(ctx, []bson.M{
+		{"$match": gradeMatch},
+		{"$group": bson.M{
+			"_id":        dayKey("date"),
+			"percentSum": bson.M{"$sum": bson.M{"$multiply": []interface{}{bson.M{"$divide": []interface{}{"$score", "$totalMarks"}}, 100}}},
+			"count":      bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		fail("Error fetching grade statistics")
+		return
+	}
+	if err := cursor.All(ctx, &gradeDays); err != nil {
+		fail("Error fetching grade statistics")
+		return
+	}
+	var gradeSum float64
+	var gradeCount int64
+	for _, day := range gradeDays {
+		gradeSum += day.PercentSum
+		gradeCount += day.Count
+		if bucket := series.day(day.Day); bucket != nil {
+			bucket.gradeSum += day.PercentSum
+			bucket.gradeCount += day.Count
+		}
+	}
+
+	// Schedule changes count in the period of the session they change
+	var changeDays []struct {
+		ID struct {
+			Day  string `bson:"day"`
+			Type string `bson:"type"`
+		} `bson:"_id"`
+		Count int64 `bson:"count"`
+	}
+	cursor, err = uc.db.Collection("schedule_changes").Aggregate(ctx, []bson.M{
+		{"$match": matchIn("originalDate")},
+		{"$group": bson.M{
+			"_id":   bson.M{"day": dayKey("originalDate"), "type": "$type"},
+			"count": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		fail("Error fetching schedule change statistics")
+		return
+	}
+	if err := cursor.All(ctx, &changeDays); err != nil {
+		fail("Error fetching schedule change statistics")
+		return
+	}
+	var cancellations, reschedules int64
+	for _, day := range changeDays {
+		bucket := series.day(day.ID.Day)
+		switch day.ID.Type {
+		case "cancellation":
+			cancellations += day.Count
+			if bucket != nil {
+				bucket.Cancellations += day.Count
+			}
+		case "reschedule":
+			reschedules += day.Count
+			if bucket != nil {
+				bucket.Reschedules += day.Count
+			}
+		}
+	}
+
+	for _, bucket := range series.buckets {
+		bucket.ActiveUsers = len(bucket.active)
+		bucket.AttendanceRate = rate(float64(bucket.present), float64(bucket.present+bucket.absent))
+		if bucket.gradeCount > 0 {
+			average := bucket.gradeSum / float64(bucket.gradeCount)
+			bucket.AverageGrade = &average
+		}
+	}
+	var averageGrade *float64
+	if gradeCount > 0 {
+		average := gradeSum / float64(gradeCount)
+		averageGrade = &average
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"startDate":         start,
+		"endDate":           end,
+		"interval":          interval,
+		"userCounts":        userCounts,
+		"newUsers":          newUsers,
+		"activeUsers":       len(active),
+		"activeUsersByType": activeUsers,
+		"totalClasses":      len(classes),
+		"totalEnrolled":     totalEnrolled,
+		"totalCapacity":     totalCapacity,
+		"fillRate":          rate(float64(totalEnrolled), float64(totalCapacity)),
+		"averageClassSize":  averageClassSize,
+		"classEnrollment":   classes,
+		"attendanceRate":    rate(float64(present), float64(present+absent)),
+		"averageGrade":      averageGrade,
+		"cancellations":     cancellations,
+		"reschedules":       reschedules,
+		"series":            series.buckets,
+	})
+}
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestStatisticsBucketCount(t *testing.T) {
+	day := func(s string) time.Time {
+		t, _ := time.Parse("2006-01-02", s)
+		return t
+	}
+	tests := []struct {
+		start, end string
+		interval   string
+		want       int
+	}{
+		{"2026-01-01", "2026-01-08", "day", 7},
+		{"2026-01-01", "2027-01-01", "month", 12},
+		{"0001-01-01", "9999-12-31", "day", statisticsMaxBuckets + 1},
+		{"0001-01-01", "9999-12-31", "month", statisticsMaxBuckets + 1},
+	}
+	for _, tt := range tests {
+		if got := statisticsBucketCount(day(tt.start), day(tt.end), tt.interval); got != tt.want {
+			t.Errorf("statisticsBucketCount(%s, %s, %s) = %d, want %d", tt.start, tt.end, tt.interval, got, tt.want)
+		}
+	}
+}
+
+func TestStatisticsRejectsLongRanges(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("day", func(mt *mtest.T) {
+		t := mt.T
+		uc := NewUserController(mt.Client.Database("test"), &recordingMailer{})
+		router := gin.New()
+		router.GET("/api/admin/statistics", uc.GetStatistics)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+			"/api/admin/statistics?startDate=0001-01-01&endDate=9999-12-31&interval=day", nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body.String())
+		}
+		if len(mt.GetAllStartedEvents()) > 0 {
+			t.Fatal("the database was queried")
+		}
+	})
+}
@@ -0,0 +1,402 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"classscheduling/models"
+)
+
+// Deleted users and classes are kept with a deletedAt time until an admin
+// restores or purges them. Purging removes everything that refers to them,
+// dependent records first, so that a purge which fails part way can simply
+// be run again.
+
+// GetDeletedUsers returns a page of deleted users, most recently deleted first
+func (uc *UserController) GetDeletedUsers(c *gin.Context) {
+	spec := userListSpec
+	spec.Sorts = map[string]string{"deletedAt": "deletedAt"}
+	for key, path := range userListSpec.Sorts {
+		spec.Sorts[key] = path
+	}
+	spec.DefaultSort = "-deletedAt"
+	query, ok := parseList(c, spec)
+	if !ok {
+		return
+	}
+
+	opts := options.Find().SetProjection(bson.M{
+		"password": 0,
+	})
+
+	var users []models.User
+	page, err := query.Find(context.Background(), uc.db.Collection("users"), scopeFilter(c, bson.M{"deletedAt": bson.M{"$ne": nil}}), &users, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// RestoreUser undoes the deletion of a user and re-enrolls a student in the
+// classes they were in, where those still exist and have room
+func (uc *UserController) RestoreUser(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	ctx := context.Background()
+	var user models.User
+	err = uc.db.Collection("users").FindOneAndUpdate(ctx,
+		scopeFilter(c, bson.M{"_id": userID, "deletedAt": bson.M{"$ne": nil}}),
+		bson.M{
+			"$unset": bson.M{"deletedAt": "", "deletedBy": "", "archivedEnrollments": ""},
+			"$set":   bson.M{"updatedAt": time.Now()},
+		},
+	).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deleted user not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error restoring user"})
+		return
+	}
+
+	recordActivity(uc.db, c, models.Activity{
+		Type:        "user_restored",
+		UserID:      &user.ID,
+		Username:    user.Username,
+		Description: fmt.Sprintf("User %s restored", user.Username),
+	})
+
+	// Classes that were deleted, closed or filled up in the meantime are
+	// reported rather than failing the restore
+	notRejoined := []primitive.ObjectID{}
+	for _, classID := range user.ArchivedEnrollments {
+		result, err := uc.db.Collection("classes").UpdateOne(ctx,
+			bson.M{
+				"_id":       classID,
+				"status":    "active",
+				"enrolled":  bson.M{"$ne": user.ID},
+				"deletedAt": nil,
+				"$expr":     bson.M{"$lt": []interface{}{"$enrolledCount", "$capacity"}},
+			},
+			bson.M{
+				"$push": bson.M{"enrolled": user.ID},
+				"$inc":  bson.M{"enrolledCount": 1},
+				"$set":  bson.M{"updatedAt": time.Now()},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "User restored but there was an error restoring their class enrollments"})
+			return
+		}
+		if result.ModifiedCount == 0 {
+			notRejoined = append(notRejoined, classID)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "User restored successfully",
+		"notRejoined": notRejoined,
+	})
+}
+
+// PurgeUser permanently removes a deleted user and every record about them
+func (uc *UserController) PurgeUser(c *gin.Context) {
+	if !requireGlobalAdmin(c) {
+		return
+	}
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	ctx := context.Background()
+	var user models.User
+	err = uc.db.Collection("users").FindOne(ctx, bson.M{"_id": userID, "deletedAt": bson.M{"$ne": nil}}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deleted user not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching user"})
+		return
+	}
+
+	// Classes cannot be left without a faculty member, even deleted ones
+	count, err := uc.db.Collection("classes").CountDocuments(ctx, bson.M{"facultyId": userID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking faculty classes"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot purge faculty who still have classes; reassign or purge them first"})
+		return
+	}
+
+	if err := purgeUserData(ctx, uc.db, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error purging user"})
+		return
+	}
+	removeAvatar(user.Avatar)
+
+	recordActivity(uc.db, c, models.Activity{
+		Type:        "user_purged",
+		Username:    user.Username,
+		Description: fmt.Sprintf("User %s purged", user.Username),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "User purged successfully"})
+}
+
+// purgeUserData deletes a user and everything that refers to them. The
+// activity log is kept as an audit trail.
+func purgeUserData(ctx context.Context, db *mongo.Database, userID primitive.ObjectID) error {
+	deletes := []struct {
+		collection string
+		filter     bson.M
+	}{
+		{"sessions", bson.M{"userId": userID}},
+		{"refresh_tokens", bson.M{"userId": userID}},
+		{"user_tokens", bson.M{"userId": userID}},
+		{"api_keys", bson.M{"userId": userID}},
+		{"guardian_links", bson.M{"$or": []bson.M{{"guardianId": userID}, {"studentId": userID}}}},
+		{"performance", bson.M{"studentId": userID}},
+		{"remarks", bson.M{"$or": []bson.M{{"studentId": userID}, {"facultyId": userID}}}},
+		{"risk_flags", bson.M{"studentId": userID}},
+	}
+	for _, d := range deletes {
+		if _, err := db.Collection(d.collection).DeleteMany(ctx, d.filter); err != nil {
+			return err
+		}
+	}
+
+	updates := []struct {
+		collection string
+		filter     bson.M
+		update     bson.M
+	}{
+		{"classes", bson.M{"enrolled": userID}, bson.M{
+			"$pull": bson.M{"enrolled": userID},
+			"$inc":  bson.M{"enrolledCount": -1},
+		}},
+		{"attendance", bson.M{"$or": []bson.M{{"present": userID}, {"absent": userID}}}, bson.M{
+			"$pull": bson.M{"present": userID, "absent": userID},
+		}},
+		{"remarks", bson.M{"replies.authorId": userID}, bson.M{
+			"$pull": bson.M{"replies": bson.M{"authorId": userID}},
+		}},
+		{"risk_flags", bson.M{"interventions.facultyId": userID}, bson.M{
+			"$pull": bson.M{"interventions": bson.M{"facultyId": userID}},
+		}},
+	}
+	for _, u := range updates {
+		if _, err := db.Collection(u.collection).UpdateMany(ctx, u.filter, u.update); err != nil {
+			return err
+		}
+	}
+
+	_, err := db.Collection("users").DeleteOne(ctx, bson.M{"_id": userID})
+	return err
+}
+
+// GetDeletedClasses returns a page of deleted classes, most recently deleted
+// first
+func (cc *ClassController) GetDeletedClasses(c *gin.Context) {
+	spec := classListSpec
+	spec.Sorts = map[string]string{"deletedAt": "deletedAt"}
+	for key, path := range classListSpec.Sorts {
+		spec.Sorts[key] = path
+	}
+	spec.DefaultSort = "-deletedAt"
+	query, ok := parseList(c, spec)
+	if !ok {
+		return
+	}
+
+	listClasses(c, cc.db, query, scopeFilter(c, bson.M{"deletedAt": bson.M{"$ne": nil}}))
+}
+
+// RestoreClass undoes the deletion of a class. Its students are enrolled
+// again as they were when it was deleted.
+func (cc *ClassController) RestoreClass(c *gin.Context) {
+	classID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class ID"})
+		return
+	}
+
+	ctx := context.Background()
+	var class models.Class
+	err = cc.db.Collection("classes").FindOne(ctx, scopeFilter(c, bson.M{"_id": classID, "deletedAt": bson.M{"$ne": nil}})).Decode(&class)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deleted class not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching class"})
+		return
+	}
+
+	count, err := cc.db.Collection("users").CountDocuments(ctx, bson.M{"_id": class.FacultyID, "deletedAt": nil})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching faculty"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The class's faculty member is deleted; restore them first"})
+		return
+	}
+
+	err = cc.db.Collection("classes").FindOneAndUpdate(ctx,
+		bson.M{"_id": classID, "deletedAt": bson.M{"$ne": nil}},
+		bson.M{
+			"$unset": bson.M{"deletedAt": "", "deletedBy": ""},
+			"$set":   bson.M{"updatedAt": time.Now()},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&class)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deleted class not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error restoring class"})
+		return
+	}
+
+	recordActivity(cc.db, c, models.Activity{
+		Type:        "class_restored",
+		Description: fmt.Sprintf("Class %s restored", class.Name),
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Class restored successfully",
+		"class":   class,
+	})
+}
+
+// PurgeClass permanently removes a deleted class and every record about it,
+// in one transaction. Without confirm=true nothing is removed and the impact
+// is returned instead. A database without transactions only purges classes
+// that nothing refers to.
+func (cc *ClassController) PurgeClass(c *gin.Context) {
+	if !requireGlobalAdmin(c) {
+		return
+	}
+	classID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class ID"})
+		return
+	}
+
+	ctx := context.Background()
+	var class models.Class
+	err = cc.db.Collection("classes").FindOne(ctx, bson.M{"_id": classID, "deletedAt": bson.M{"$ne": nil}}).Decode(&class)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deleted class not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching class"})
+		return
+	}
+
+	impact, err := classImpact(ctx, cc.db, class)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error counting class records"})
+		return
+	}
+	if c.Query("confirm") != "true" {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  "Purging a class must be confirmed with confirm=true",
+			"impact": impact,
+		})
+		return
+	}
+
+	err = withTransaction(ctx, cc.db, func(ctx context.Context) error {
+		return purgeClassData(ctx, cc.db, classID)
+	})
+	if err == errNoTransactions {
+		if impact.Dependents() > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":  "The database does not support transactions, so a class with attendance, grades, remarks or schedule changes cannot be purged",
+				"impact": impact,
+			})
+			return
+		}
+		err = purgeClassData(ctx, cc.db, classID)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error purging class"})
+		return
+	}
+
+	recordActivity(cc.db, c, models.Activity{
+		Type:        "class_purged",
+		Description: fmt.Sprintf("Class %s purged", class.Name),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Class purged successfully"})
+}
+
+// purgeClassData deletes a class and everything that refers to it
+func purgeClassData(ctx context.Context, db *mongo.Database, classID primitive.ObjectID) error {
+	for _, collection := range []string{"attendance", "performance", "remarks", "risk_flags", "schedule_changes"} {
+		if _, err := db.Collection(collection).DeleteMany(ctx, bson.M{"classId": classID}); err != nil {
+			return err
+		}
+	}
+
+	_, err := db.Collection("users").UpdateMany(ctx,
+		bson.M{"archivedEnrollments": classID},
+		bson.M{"$pull": bson.M{"archivedEnrollments": classID}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("classes").DeleteOne(ctx, bson.M{"_id": classID})
+	return err
+}
+
+// errNoTransactions is returned by withTransaction when the database is a
+// standalone server, which cannot run transactions
+var errNoTransactions = errors.New("the database does not support transactions")
+
+// withTransaction runs fn in a transaction, retrying it on transient errors.
+// The context passed to fn must be used for every operation in it.
+func withTransaction(ctx context.Context, db *mongo.Database, fn func(ctx context.Context) error) error {
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	// IllegalOperation: transaction numbers are only allowed on a replica set
+	// member or mongos
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) && commandErr.Code == 20 {
+		return errNoTransactions
+	}
+	return err
+}
@@ -0,0 +1,634 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"classscheduling/middleware"
+	"classscheduling/models"
+	"classscheduling/totp"
+)
+
+const recoveryCodeCount = 10
+
+var errInvalidTOTPCode = errors.New("invalid two-factor code")
+
+// totpIssuer is the account issuer shown in authenticator apps
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Class Scheduling System"
+}
+
+// mfaRequiredFor reports whether the MFA policy makes two-factor
+// authentication mandatory for the user type
+func mfaRequiredFor(ctx context.Context, db *mongo.Database, userType string) (bool, error) {
+	var policy models.MFAPolicy
+	err := db.Collection("settings").FindOne(ctx, bson.M{"_id": "mfa_policy"}).Decode(&policy)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, role := range policy.RequiredRoles {
+		if role == userType {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// respondWithChallenge writes a challenge response when the user has to pass
+// a second factor, or enroll in one, before getting a session. It returns
+// false when the user can be logged in straight away.
+func (ac *AuthController) respondWithChallenge(c *gin.Context, user models.User) bool {
+	purpose := ""
+	if user.TOTPEnabled {
+		purpose = "mfa"
+	} else {
+		required, err := mfaRequiredFor(context.Background(), ac.db, user.UserType)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return true
+		}
+		if required {
+			purpose = "mfa_enroll"
+		}
+	}
+	if purpose == "" {
+		return false
+	}
+
+	challenge, err := middleware.GenerateChallengeToken(user.ID, purpose)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+		return true
+	}
+
+	response := gin.H{
+		"challengeToken": challenge,
+		"expiresIn":      int(middleware.ChallengeTokenTTL.Seconds()),
+	}
+	if purpose == "mfa" {
+		response["mfaRequired"] = true
+	} else {
+		response["mfaEnrollmentRequired"] = true
+	}
+	c.JSON(http.StatusOK, response)
+	return true
+}
+
+// userFromChallenge loads the user a challenge token was issued to
+func (ac *AuthController) userFromChallenge(c *gin.Context, challenge, purpose string) (*models.User, bool) {
+	userID, err := middleware.ParseChallengeToken(challenge, purpose)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge token"})
+		return nil, false
+	}
+
+	var user models.User
+	err = ac.db.Collection("users").FindOne(context.Background(), bson.M{"_id": userID, "deletedAt": nil}).Decode(&user)
+	if err != nil || user.Disabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge token"})
+		return nil, false
+	}
+	return &user, true
+}
+
+// currentUser loads the authenticated user
+func (ac *AuthController) currentUser(c *gin.Context) (*models.User, bool) {
+	userID, _ := c.Get("userId")
+
+	var user models.User
+	err := ac.db.Collection("users").FindOne(context.Background(), bson.M{"_id": userID.(primitive.ObjectID), "deletedAt": nil}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching user"})
+		return nil, false
+	}
+	return &user, true
+}
+
+// checkTOTP validates a code for the user and records its time step so that
+// the same code cannot be replayed
+func (ac *AuthController) checkTOTP(ctx context.Context, user *models.User, secret, code string) error {
+	step, ok := totp.Validate(secret, code, time.Now(), 1)
+	if !ok || step <= user.TOTPLastStep {
+		return errInvalidTOTPCode
+	}
+
+	// Only one request can move the step forward
+	result, err := ac.db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": user.ID, "$or": []bson.M{
+			{"totpLastStep": bson.M{"$exists": false}},
+			{"totpLastStep": bson.M{"$lt": step}},
+		}},
+		bson.M{"$set": bson.M{"totpLastStep": step}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errInvalidTOTPCode
+	}
+	user.TOTPLastStep = step
+	return nil
+}
+
+// useRecoveryCode consumes one of the user's recovery codes
+func (ac *AuthController) useRecoveryCode(ctx context.Context, user *models.User, code string) error {
+	hash := middleware.HashToken(code)
+	result, err := ac.db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": user.ID, "recoveryCodes": hash},
+		bson.M{"$pull": bson.M{"recoveryCodes": hash}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return errInvalidTOTPCode
+	}
+	return nil
+}
+
+// generateRecoveryCodes returns new plain recovery codes and their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = middleware.HashToken(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// beginEnrollment stores a pending secret for the user and returns what the
+// authenticator app needs
+func (ac *AuthController) beginEnrollment(ctx context.Context, user *models.User) (gin.H, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ac.db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"totpPendingSecret": secret, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"secret":          secret,
+		"provisioningUri": totp.ProvisioningURI(totpIssuer(), user.Username, secret),
+	}, nil
+}
+
+// completeEnrollment checks a code against the pending secret, enables
+// two-factor authentication and returns fresh recovery codes
+func (ac *AuthController) completeEnrollment(ctx context.Context, user *models.User, code string) ([]string, error) {
+	if user.TOTPPendingSecret == "" {
+		return nil, errInvalidTOTPCode
+	}
+	if err := ac.checkTOTP(ctx, user, user.TOTPPendingSecret, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ac.db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$set": bson.M{
+				"totpEnabled":   true,
+				"totpSecret":    user.TOTPPendingSecret,
+				"recoveryCodes": hashes,
+				"updatedAt":     time.Now(),
+			},
+			"$unset": bson.M{"totpPendingSecret": ""},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	user.TOTPEnabled = true
+	user.TOTPSecret = user.TOTPPendingSecret
+	user.TOTPPendingSecret = ""
+	return codes, nil
+}
+
+// VerifyTwoFactor completes a login with a TOTP code or a recovery code
+func (ac *AuthController) VerifyTwoFactor(c *gin.Context) {
+	var input struct {
+		ChallengeToken string `json:"challengeToken" binding:"required"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recoveryCode"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil || (input.Code == "" && input.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	user, ok := ac.userFromChallenge(c, input.ChallengeToken, "mfa")
+	if !ok {
+		return
+	}
+
+	// Second factor guesses count against the same per-account limit as passwords
+	if !ac.checkLoginRate(c, user.Username) {
+		return
+	}
+	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(*user.LockedUntil).Seconds())+1))
+		c.JSON(http.StatusLocked, gin.H{"error": "Account is temporarily locked due to too many failed login attempts"})
+		return
+	}
+
+	ctx := context.Background()
+	var err error
+	if input.RecoveryCode != "" {
+		err = ac.useRecoveryCode(ctx, user, input.RecoveryCode)
+	} else {
+		err = ac.checkTOTP(ctx, user, user.TOTPSecret, input.Code)
+	}
+	if err != nil {
+		if err == errInvalidTOTPCode {
+			failures := ac.recordFailedLogin(c, *user)
+			time.Sleep(ac.policy.failureDelay(failures))
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := ac.clearFailedLogins(*user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if input.RecoveryCode != "" {
+		recordActivity(ac.db, c, models.Activity{
+			Type:        "recovery_code_used",
+			UserID:      &user.ID,
+			Username:    user.Username,
+			Description: fmt.Sprintf("%s logged in with a recovery code", user.Username),
+		})
+	}
+
+	ac.respondWithSession(c, *user)
+}
+
+// SetupTwoFactor starts enrollment for the logged-in user
+func (ac *AuthController) SetupTwoFactor(c *gin.Context) {
+	user, ok := ac.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	setup, err := ac.beginEnrollment(context.Background(), user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting two-factor setup"})
+		return
+	}
+
+	c.JSON(http.StatusOK, setup)
+}
+
+// EnableTwoFactor confirms enrollment for the logged-in user with a code from
+// the authenticator app
+func (ac *AuthController) EnableTwoFactor(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	user, ok := ac.currentUser(c)
+	if !ok {
+		return
+	}
+
+	codes, err := ac.completeEnrollment(context.Background(), user, input.Code)
+	if err != nil {
+		if err == errInvalidTOTPCode {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid two-factor code"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error enabling two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Two-factor authentication enabled",
+		"recoveryCodes": codes,
+	})
+}
+
+// EnrollTwoFactor starts enrollment for a user whose role requires
+// two-factor authentication, using the challenge token from Login
+func (ac *AuthController) EnrollTwoFactor(c *gin.Context) {
+	var input struct {
+		ChallengeToken string `json:"challengeToken" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	user, ok := ac.userFromChallenge(c, input.ChallengeToken, "mfa_enroll")
+	if !ok {
+		return
+	}
+
+	setup, err := ac.beginEnrollment(context.Background(), user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting two-factor setup"})
+		return
+	}
+
+	c.JSON(http.StatusOK, setup)
+}
+
+// ConfirmTwoFactorEnrollment finishes mandatory enrollment and logs the user in
+func (ac *AuthController) ConfirmTwoFactorEnrollment(c *gin.Context) {
+	var input struct {
+		ChallengeToken string `json:"challengeToken" binding:"required"`
+		Code           string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	user, ok := ac.userFromChallenge(c, input.ChallengeToken, "mfa_enroll")
+	if !ok {
+		return
+	}
+
+	codes, err := ac.completeEnrollment(context.Background(), user, input.Code)
+	if err != nil {
+		if err == errInvalidTOTPCode {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid two-factor code"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error enabling two-factor authentication"})
+		return
+	}
+
+	tokens, err := ac.startSession(c, *user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         tokens.AccessToken,
+		"refreshToken":  tokens.RefreshToken,
+		"expiresIn":     tokens.ExpiresIn,
+		"recoveryCodes": codes,
+		"user": gin.H{
+			"id":            user.ID,
+			"username":      user.Username,
+			"email":         user.Email,
+			"userType":      user.UserType,
+			"emailVerified": user.EmailVerified,
+			"totpEnabled":   user.TOTPEnabled,
+		},
+	})
+}
+
+// DisableTwoFactor turns two-factor authentication off for the logged-in
+// user after confirming their password and a current code
+func (ac *AuthController) DisableTwoFactor(c *gin.Context) {
+	var input struct {
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	user, ok := ac.currentUser(c)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	ctx := context.Background()
+	required, err := mfaRequiredFor(ctx, ac.db, user.UserType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if required {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for your role"})
+		return
+	}
+
+	if !user.ValidatePassword(input.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		return
+	}
+	if err := ac.checkTOTP(ctx, user, user.TOTPSecret, input.Code); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	if err := disableTwoFactor(ctx, ac.db, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error disabling two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the logged-in user's recovery codes
+func (ac *AuthController) RegenerateRecoveryCodes(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	user, ok := ac.currentUser(c)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	ctx := context.Background()
+	if err := ac.checkTOTP(ctx, user, user.TOTPSecret, input.Code); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating recovery codes"})
+		return
+	}
+
+	_, err = ac.db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"recoveryCodes": hashes, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+func disableTwoFactor(ctx context.Context, db *mongo.Database, userID primitive.ObjectID) error {
+	_, err := db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{
+			"$set": bson.M{"totpEnabled": false, "updatedAt": time.Now()},
+			"$unset": bson.M{
+				"totpSecret":        "",
+				"totpPendingSecret": "",
+				"totpLastStep":      "",
+				"recoveryCodes":     "",
+			},
+		},
+	)
+	return err
+}
+
+// ResetUserTwoFactor removes a user's second factor so they can enroll again,
+// e.g. after losing their phone (admin only)
+func (uc *UserController) ResetUserTwoFactor(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var user models.User
+	err = uc.db.Collection("users").FindOne(context.Background(), scopeFilter(c, bson.M{"_id": userID, "deletedAt": nil})).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching user"})
+		return
+	}
+
+	if err := disableTwoFactor(context.Background(), uc.db, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resetting two-factor authentication"})
+		return
+	}
+
+	recordActivity(uc.db, c, models.Activity{
+		Type:        "mfa_reset",
+		UserID:      &user.ID,
+		Username:    user.Username,
+		Description: fmt.Sprintf("Two-factor authentication reset for %s by an admin", user.Username),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication reset successfully"})
+}
+
+// GetMFAPolicy returns the roles that must use two-factor authentication (admin only)
+func (uc *UserController) GetMFAPolicy(c *gin.Context) {
+	policy := models.MFAPolicy{RequiredRoles: []string{}}
+	err := uc.db.Collection("settings").FindOne(context.Background(), bson.M{"_id": "mfa_policy"}).Decode(&policy)
+	if err != nil && err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// UpdateMFAPolicy sets the roles that must use two-factor authentication (admin only)
+func (uc *UserController) UpdateMFAPolicy(c *gin.Context) {
+	var input struct {
+		RequiredRoles []string `json:"requiredRoles"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil || input.RequiredRoles == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+
+	for _, role := range input.RequiredRoles {
+		if !models.ValidUserType(role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user type"})
+			return
+		}
+	}
+
+	policy := models.MFAPolicy{
+		ID:            "mfa_policy",
+		RequiredRoles: input.RequiredRoles,
+		UpdatedAt:     time.Now(),
+	}
+	_, err := uc.db.Collection("settings").ReplaceOne(context.Background(),
+		bson.M{"_id": "mfa_policy"},
+		policy,
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating policy"})
+		return
+	}
+
+	recordActivity(uc.db, c, models.Activity{
+		Type:        "mfa_policy_updated",
+		Description: fmt.Sprintf("Two-factor authentication required for: %v", input.RequiredRoles),
+	})
+
+	c.JSON(http.StatusOK, policy)
+}
@@ -2,6 +2,10 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"time"
 
@@ -11,25 +15,31 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"classscheduling/listing"
+	"classscheduling/mailer"
+	"classscheduling/middleware"
 	"classscheduling/models"
 )
 
 type UserController struct {
-	db *mongo.Database
+	db   *mongo.Database
+	mail mailer.Mailer
 }
 
-func NewUserController(db *mongo.Database) *UserController {
-	return &UserController{db: db}
+func NewUserController(db *mongo.Database, mail mailer.Mailer) *UserController {
+	return &UserController{db: db, mail: mail}
 }
 
 // CreateUser creates a new user (admin only)
 func (uc *UserController) CreateUser(c *gin.Context) {
 	var input struct {
-		Username   string `json:"username" binding:"required"`
-		Email      string `json:"email" binding:"required,email"`
-		Password   string `json:"password" binding:"required,min=6"`
-		UserType   string `json:"userType" binding:"required"`
-		RollNumber string `json:"rollNumber"`
+		Username     string              `json:"username" binding:"required"`
+		Email        string              `json:"email" binding:"required,email"`
+		Password     string              `json:"password" binding:"required,min=6"`
+		UserType     string              `json:"userType" binding:"required"`
+		RollNumber   string              `json:"rollNumber"`
+		DepartmentID *primitive.ObjectID `json:"departmentId"`
+		models.ProfileInput
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -37,11 +47,22 @@ func (uc *UserController) CreateUser(c *gin.Context) {
 		return
 	}
 
+	// Department admins can only add users to their own department
+	department, ok := resolveDepartment(c, uc.db, input.DepartmentID)
+	if !ok {
+		return
+	}
+
 	// Validate user type
-	if input.UserType != "student" && input.UserType != "faculty" && input.UserType != "admin" {
+	if !models.ValidUserType(input.UserType) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user type"})
 		return
 	}
+	// Admin users get the admin role, which department admins cannot grant
+	if input.UserType == "admin" && departmentScope(c) != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only a global admin can grant the admin role"})
+		return
+	}
 
 	// Validate roll number for students
 	if input.UserType == "student" && input.RollNumber == "" {
@@ -49,6 +70,12 @@ func (uc *UserController) CreateUser(c *gin.Context) {
 		return
 	}
 
+	profile, _, err := input.ProfileInput.Changes(input.UserType, false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Check if username is already taken
 	count, err := uc.db.Collection("users").CountDocuments(context.Background(), bson.M{"username": input.Username})
 	if err != nil {
@@ -73,14 +100,24 @@ func (uc *UserController) CreateUser(c *gin.Context) {
 
 	// Create new user
 	user := models.User{
-		Username:   input.Username,
-		Email:      input.Email,
-		Password:   input.Password,
-		UserType:   input.UserType,
-		RollNumber: input.RollNumber,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
-	}
+		Username:     input.Username,
+		Email:        input.Email,
+		Password:     input.Password,
+		UserType:     input.UserType,
+		RollNumber:   input.RollNumber,
+		DepartmentID: department,
+		// Accounts provisioned by an admin are trusted
+		EmailVerified: true,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	user.Phone, _ = profile["phone"].(string)
+	user.Program, _ = profile["program"].(string)
+	user.Year, _ = profile["year"].(int)
+	user.Section, _ = profile["section"].(string)
+	user.Designation, _ = profile["designation"].(string)
+	user.Office, _ = profile["office"].(string)
 
 	// Hash password
 	if err := user.HashPassword(); err != nil {
@@ -101,126 +138,29 @@ func (uc *UserController) CreateUser(c *gin.Context) {
 	c.JSON(http.StatusCreated, user)
 }
 
-// GetStatistics returns system statistics for admin dashboard
-func (uc *UserController) GetStatistics(c *gin.Context) {
-	ctx := context.Background()
-	startDate := c.Query("startDate")
-	endDate := c.Query("endDate")
-
-	// Parse dates if provided
-	var timeFilter bson.M
-	if startDate != "" && endDate != "" {
-		start, err := time.Parse("2006-01-02", startDate)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start date format"})
-			return
-		}
-		end, err := time.Parse("2006-01-02", endDate)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end date format"})
-			return
-		}
-		// Add one day to end date to include the full day
-		end = end.Add(24 * time.Hour)
-		timeFilter = bson.M{
-			"createdAt": bson.M{
-				"$gte": start,
-				"$lt":  end,
-			},
-		}
-	}
-
-	// Get user counts by type
-	userStats := make(map[string]int64)
-	userTypes := []string{"student", "faculty", "admin"}
-	for _, userType := range userTypes {
-		filter := bson.M{"userType": userType}
-		if timeFilter != nil {
-			filter = bson.M{
-				"$and": []bson.M{
-					filter,
-					timeFilter,
-				},
-			}
-		}
-		count, err := uc.db.Collection("users").CountDocuments(ctx, filter)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching user statistics"})
-			return
-		}
-		userStats[userType] = count
-	}
-
-	// Get class statistics
-	classFilter := bson.M{"status": "active"}
-	if timeFilter != nil {
-		classFilter = bson.M{
-			"$and": []bson.M{
-				classFilter,
-				timeFilter,
-			},
-		}
-	}
-
-	// Get active classes with enrollment info
-	classPipeline := mongo.Pipeline{
-		primitive.D{
-			{Key: "$match", Value: classFilter},
-		},
-		primitive.D{
-			{Key: "$project", Value: bson.M{
-				"name":     1,
-				"enrolled": 1,
-				"capacity": 1,
-			}},
-		},
-	}
-
-	cursor, err := uc.db.Collection("classes").Aggregate(ctx, classPipeline)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching class statistics"})
-		return
-	}
-	defer cursor.Close(ctx)
+// activityListSpec is what the activity log can be filtered, sorted and
+// searched by
+var activityListSpec = listing.Spec{
+	Filters: map[string]listing.Field{
+		"type":   {Path: "type"},
+		"userId": {Path: "userId", Kind: listing.ObjectID},
+	},
+	Sorts: map[string]string{
+		"timestamp": "timestamp",
+		"type":      "type",
+	},
+	Search:      []string{"username", "description"},
+	DefaultSort: "-timestamp",
+}
 
-	var classes []struct {
-		Name     string `bson:"name"`
-		Enrolled int    `bson:"enrolled"`
-		Capacity int    `bson:"capacity"`
-	}
-	if err := cursor.All(ctx, &classes); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error processing class statistics"})
+// GetActivity returns a page of system activity for admin dashboard, newest
+// first
+func (uc *UserController) GetActivity(c *gin.Context) {
+	query, ok := parseList(c, activityListSpec)
+	if !ok {
 		return
 	}
 
-	// Calculate average class size
-	var totalEnrolled int
-	classEnrollment := make([]map[string]interface{}, len(classes))
-	for i, class := range classes {
-		totalEnrolled += class.Enrolled
-		classEnrollment[i] = map[string]interface{}{
-			"name":     class.Name,
-			"enrolled": class.Enrolled,
-			"capacity": class.Capacity,
-		}
-	}
-
-	averageClassSize := 0.0
-	if len(classes) > 0 {
-		averageClassSize = float64(totalEnrolled) / float64(len(classes))
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"userCounts":       userStats,
-		"totalClasses":     len(classes),
-		"averageClassSize": averageClassSize,
-		"classEnrollment":  classEnrollment,
-		"activeUsers":      userStats["student"] + userStats["faculty"], // Active users based on student + faculty count
-	})
-}
-
-// GetActivity returns recent system activity for admin dashboard
-func (uc *UserController) GetActivity(c *gin.Context) {
 	ctx := context.Background()
 	startDate := c.Query("startDate")
 	endDate := c.Query("endDate")
@@ -248,60 +188,73 @@ func (uc *UserController) GetActivity(c *gin.Context) {
 		}
 	}
 
-	pipeline := mongo.Pipeline{
-		primitive.D{
-			{Key: "$match", Value: filter},
-		},
-		primitive.D{
-			{Key: "$sort", Value: bson.M{"timestamp": -1}},
-		},
-		primitive.D{
-			{Key: "$limit", Value: 50},
-		},
+	// Department admins only see activity about users in their department
+	if department := departmentScope(c); department != nil {
+		userIDs, err := uc.db.Collection("users").Distinct(ctx, "_id", bson.M{"departmentId": *department})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching activity logs"})
+			return
+		}
+		filter["userId"] = bson.M{"$in": userIDs}
 	}
 
-	cursor, err := uc.db.Collection("activity").Aggregate(ctx, pipeline)
+	var activities []bson.M
+	page, err := query.Find(ctx, uc.db.Collection("activity"), filter, &activities)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching activity logs"})
 		return
 	}
-	defer cursor.Close(ctx)
 
-	var activities []bson.M
-	if err := cursor.All(ctx, &activities); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error processing activity logs"})
-		return
-	}
+	c.JSON(http.StatusOK, page)
+}
 
-	c.JSON(http.StatusOK, activities)
+// userListSpec is what the user list can be filtered, sorted and searched by
+var userListSpec = listing.Spec{
+	Filters: map[string]listing.Field{
+		"type":           {Path: "userType"},
+		"role":           {Path: "roles"},
+		"departmentId":   {Path: "departmentId", Kind: listing.ObjectID},
+		"disabled":       {Path: "disabled", Kind: listing.Bool},
+		"approvalStatus": {Path: "approvalStatus"},
+	},
+	Sorts: map[string]string{
+		"username":   "username",
+		"email":      "email",
+		"rollNumber": "rollNumber",
+		"userType":   "userType",
+		"createdAt":  "createdAt",
+	},
+	Search:      []string{"username", "email", "rollNumber"},
+	DefaultSort: "username",
 }
 
-// GetUsers returns a list of users, optionally filtered by user type
+// GetUsers returns a page of users, optionally filtered by user type,
+// role, department or status and searched by username, email or roll number
 func (uc *UserController) GetUsers(c *gin.Context) {
-	userType := c.Query("type")
-	filter := bson.M{}
-	if userType != "" {
-		filter["userType"] = userType
+	query, ok := parseList(c, userListSpec)
+	if !ok {
+		return
 	}
 
 	opts := options.Find().SetProjection(bson.M{
 		"password": 0,
 	})
 
-	cursor, err := uc.db.Collection("users").Find(context.Background(), filter, opts)
+	var users []models.User
+	page, err := query.Find(context.Background(), uc.db.Collection("users"), scopeFilter(c, bson.M{"deletedAt": nil}), &users, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching users"})
 		return
 	}
-	defer cursor.Close(context.Background())
 
-	var users []models.User
-	if err := cursor.All(context.Background(), &users); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding users"})
-		return
-	}
+	c.JSON(http.StatusOK, page)
+}
 
-	c.JSON(http.StatusOK, users)
+// isSelf reports whether the given user is the authenticated user
+func isSelf(c *gin.Context, userID primitive.ObjectID) bool {
+	currentUserID, _ := c.Get("userId")
+	id, ok := currentUserID.(primitive.ObjectID)
+	return ok && id == userID
 }
 
 // GetUser returns details of a specific user
@@ -312,10 +265,21 @@ func (uc *UserController) GetUser(c *gin.Context) {
 		return
 	}
 
+	// Users can look themselves up; other accounts need the permission to
+	// view users, and department admins only see their own department
+	filter := bson.M{"_id": userID, "deletedAt": nil}
+	if !isSelf(c, userID) {
+		if !middleware.HasPermission(c, models.PermUsersView) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only view your own account"})
+			return
+		}
+		filter = scopeFilter(c, filter)
+	}
+
 	var user models.User
 	err = uc.db.Collection("users").FindOne(
 		context.Background(),
-		bson.M{"_id": userID},
+		filter,
 		options.FindOne().SetProjection(bson.M{"password": 0}),
 	).Decode(&user)
 
@@ -331,19 +295,29 @@ func (uc *UserController) GetUser(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
-// UpdateUser updates an existing user
+// UpdateUser updates an existing user. Users can change their own account;
+// other accounts need the permission to manage users.
 func (uc *UserController) UpdateUser(c *gin.Context) {
 	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 		return
 	}
+	self := isSelf(c, userID)
+	if !self && !middleware.HasPermission(c, models.PermUsersManage) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only change your own account"})
+		return
+	}
 
 	var input struct {
-		Username string `json:"username"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
-		UserType string `json:"userType"`
+		Username        string              `json:"username"`
+		Email           string              `json:"email"`
+		Password        string              `json:"password"`
+		CurrentPassword string              `json:"currentPassword"`
+		UserType        string              `json:"userType"`
+		Disabled        *bool               `json:"disabled"`
+		DepartmentID    *primitive.ObjectID `json:"departmentId"`
+		models.ProfileInput
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -353,7 +327,7 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 
 	// Get current user for password and type validation
 	var currentUser models.User
-	err = uc.db.Collection("users").FindOne(context.Background(), bson.M{"_id": userID}).Decode(&currentUser)
+	err = uc.db.Collection("users").FindOne(context.Background(), bson.M{"_id": userID, "deletedAt": nil}).Decode(&currentUser)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
@@ -363,6 +337,20 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	// Department admins can only manage users in their own department
+	if !self && !inScope(c, currentUser.DepartmentID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// A signed-in session alone is not enough to take the account over
+	usernameChanged := input.Username != "" && input.Username != currentUser.Username
+	emailChanged := input.Email != "" && input.Email != currentUser.Email
+	if self && (input.Password != "" || usernameChanged || emailChanged) && !currentUser.ValidatePassword(input.CurrentPassword) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
 	update := bson.M{"$set": bson.M{
 		"updatedAt": time.Now(),
 	}}
@@ -405,9 +393,18 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 			return
 		}
 		update["$set"].(bson.M)["email"] = input.Email
+		// The new address has to be confirmed like the first one was
+		if emailChanged {
+			update["$set"].(bson.M)["emailVerified"] = false
+			update["$unset"] = bson.M{"emailVerifiedAt": ""}
+		}
 	}
 
 	if input.Password != "" {
+		if len(input.Password) < 6 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 6 characters"})
+			return
+		}
 		// Create temporary user to hash password
 		tempUser := models.User{Password: input.Password}
 		if err := tempUser.HashPassword(); err != nil {
@@ -418,32 +415,240 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 	}
 
 	if input.UserType != "" {
-		// Only admin can change user type
-		userType, _ := c.Get("userType")
-		if userType != "admin" {
+		// Only user managers can change user type
+		if !middleware.HasPermission(c, models.PermUsersManage) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "Only admin can change user type"})
 			return
 		}
+		if !models.ValidUserType(input.UserType) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user type"})
+			return
+		}
+		if input.UserType == "admin" && currentUser.UserType != "admin" && departmentScope(c) != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only a global admin can grant the admin role"})
+			return
+		}
 		update["$set"].(bson.M)["userType"] = input.UserType
 	}
 
+	if input.Disabled != nil {
+		// Only user managers can disable or enable accounts
+		if !middleware.HasPermission(c, models.PermUsersManage) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only admin can disable accounts"})
+			return
+		}
+		update["$set"].(bson.M)["disabled"] = *input.Disabled
+	}
+
+	if input.DepartmentID != nil {
+		// Only user managers can move users between departments
+		if !middleware.HasPermission(c, models.PermUsersManage) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only admin can change departments"})
+			return
+		}
+		department, ok := resolveDepartment(c, uc.db, input.DepartmentID)
+		if !ok {
+			return
+		}
+		update["$set"].(bson.M)["departmentId"] = department
+	}
+
+	// Profile fields; without the permission to manage users only the
+	// self-editable ones can be changed
+	userType := currentUser.UserType
+	if input.UserType != "" {
+		userType = input.UserType
+	}
+	set, unset, err := input.ProfileInput.Changes(userType, !middleware.HasPermission(c, models.PermUsersManage))
+	if err != nil {
+		var profileErr *models.ProfileError
+		if errors.As(err, &profileErr) && profileErr.Forbidden {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for field, value := range set {
+		update["$set"].(bson.M)[field] = value
+	}
+	if len(unset) > 0 {
+		if update["$unset"] == nil {
+			update["$unset"] = bson.M{}
+		}
+		for field, value := range unset {
+			update["$unset"].(bson.M)[field] = value
+		}
+	}
+
 	result := uc.db.Collection("users").FindOneAndUpdate(
 		context.Background(),
-		bson.M{"_id": userID},
+		bson.M{"_id": userID, "deletedAt": nil},
 		update,
 		options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"password": 0}),
 	)
 
 	var updatedUser models.User
 	if err := result.Decode(&updatedUser); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating user"})
 		return
 	}
 
+	// A disabled account, or one whose password someone else changed, loses
+	// every session it had. Users changing their own password stay signed
+	// in here and are signed out everywhere else.
+	var revoke bson.M
+	switch {
+	case updatedUser.Disabled || (input.Password != "" && !self):
+		revoke = bson.M{"userId": userID}
+	case input.Password != "":
+		revoke = bson.M{"userId": userID}
+		if sessionID, ok := c.Get("sessionId"); ok {
+			revoke["_id"] = bson.M{"$ne": sessionID}
+		}
+	}
+	if revoke != nil {
+		if err := revokeSessions(context.Background(), uc.db, revoke); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "User updated but there was an error revoking their sessions"})
+			return
+		}
+	}
+
+	if input.Password != "" {
+		activity := models.Activity{
+			Type:        "password_changed",
+			UserID:      &updatedUser.ID,
+			Username:    updatedUser.Username,
+			Description: fmt.Sprintf("%s changed their password", updatedUser.Username),
+		}
+		if !self {
+			activity.Type = "password_reset"
+			activity.Description = fmt.Sprintf("Password of %s reset by an admin", updatedUser.Username)
+		}
+		recordActivity(uc.db, c, activity)
+	}
+
+	if emailChanged {
+		uc.emailChanged(currentUser.Email, updatedUser)
+	}
+
 	c.JSON(http.StatusOK, updatedUser)
 }
 
-// DeleteUser deletes a user
+// emailChanged asks the user to verify their new address and tells the old
+// one about the change, in case it was not theirs to make. A failure to
+// send does not undo the update.
+func (uc *UserController) emailChanged(previous string, user models.User) {
+	ctx := context.Background()
+	if err := sendVerificationEmail(ctx, uc.db, uc.mail, user); err != nil {
+		log.Printf("Error sending verification email to user %s: %v", user.ID.Hex(), err)
+	}
+	err := uc.mail.Send(ctx, mailer.Message{
+		To:      previous,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf("Hello %s,\n\nThe email address of your account was changed to %s. If you did not make this change, contact an administrator right away.\n",
+			user.Username, user.Email),
+	})
+	if err != nil {
+		log.Printf("Error notifying user %s of their email change: %v", user.ID.Hex(), err)
+	}
+}
+
+// ResetUserPassword sets a new password for another user, e.g. when their
+// account may be compromised, and signs them out everywhere. Without a
+// password in the body a temporary one is generated and returned. (admin only)
+func (uc *UserController) ResetUserPassword(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if isSelf(c, userID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Change your own password from your profile"})
+		return
+	}
+
+	var input struct {
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+		return
+	}
+	generated := input.Password == ""
+	if generated {
+		if input.Password, err = generateTemporaryPassword(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating password"})
+			return
+		}
+	} else if len(input.Password) < 6 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 6 characters"})
+		return
+	}
+
+	hashed := models.User{Password: input.Password}
+	if err := hashed.HashPassword(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error hashing password"})
+		return
+	}
+
+	// The new password also lifts a lockout from guessing the old one
+	ctx := context.Background()
+	var user models.User
+	err = uc.db.Collection("users").FindOneAndUpdate(ctx,
+		scopeFilter(c, bson.M{"_id": userID, "deletedAt": nil}),
+		bson.M{
+			"$set":   bson.M{"password": hashed.Password, "failedLogins": 0, "updatedAt": time.Now()},
+			"$unset": bson.M{"lockedUntil": ""},
+		},
+	).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resetting password"})
+		return
+	}
+
+	// Reset links emailed earlier would let whoever has them set the
+	// password again
+	_, err = uc.db.Collection("user_tokens").DeleteMany(ctx, bson.M{
+		"userId": userID,
+		"type":   "password_reset",
+		"usedAt": bson.M{"$exists": false},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password reset but there was an error invalidating reset links"})
+		return
+	}
+	if err := revokeSessions(ctx, uc.db, bson.M{"userId": userID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password reset but there was an error revoking their sessions"})
+		return
+	}
+
+	recordActivity(uc.db, c, models.Activity{
+		Type:        "password_reset",
+		UserID:      &user.ID,
+		Username:    user.Username,
+		Description: fmt.Sprintf("Password of %s reset by an admin", user.Username),
+	})
+
+	response := gin.H{"message": "Password reset successfully"}
+	if generated {
+		response["temporaryPassword"] = input.Password
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// DeleteUser soft-deletes a user. The account stops working at once, but it
+// and everything that refers to it are kept until an admin restores or
+// purges it. Students give up their seats, which they get back on restore
+// if the class still has room.
 func (uc *UserController) DeleteUser(c *gin.Context) {
 	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
@@ -451,9 +656,9 @@ func (uc *UserController) DeleteUser(c *gin.Context) {
 		return
 	}
 
-	// Get user's type before deletion for cleanup
+	ctx := context.Background()
 	var user models.User
-	err = uc.db.Collection("users").FindOne(context.Background(), bson.M{"_id": userID}).Decode(&user)
+	err = uc.db.Collection("users").FindOne(ctx, scopeFilter(c, bson.M{"_id": userID, "deletedAt": nil})).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
@@ -465,13 +670,11 @@ func (uc *UserController) DeleteUser(c *gin.Context) {
 
 	// Check if user has any active classes if they are faculty
 	if user.UserType == "faculty" {
-		count, err := uc.db.Collection("classes").CountDocuments(
-			context.Background(),
-			bson.M{
-				"facultyId": userID,
-				"status":    "active",
-			},
-		)
+		count, err := uc.db.Collection("classes").CountDocuments(ctx, bson.M{
+			"facultyId": userID,
+			"status":    "active",
+			"deletedAt": nil,
+		})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking faculty classes"})
 			return
@@ -482,23 +685,50 @@ func (uc *UserController) DeleteUser(c *gin.Context) {
 		}
 	}
 
-	// Delete user
-	result, err := uc.db.Collection("users").DeleteOne(context.Background(), bson.M{"_id": userID})
+	// Remember the student's classes so that restoring them can re-enroll them
+	enrollments, err := uc.db.Collection("classes").Distinct(ctx, "_id", bson.M{"enrolled": userID})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting user"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching enrollments"})
 		return
 	}
 
-	if result.DeletedCount == 0 {
+	now := time.Now()
+	set := bson.M{"deletedAt": now, "updatedAt": now}
+	if actorID, ok := c.Get("userId"); ok {
+		set["deletedBy"] = actorID
+	}
+	if len(enrollments) > 0 {
+		set["archivedEnrollments"] = enrollments
+	}
+	result, err := uc.db.Collection("users").UpdateOne(ctx, bson.M{"_id": userID, "deletedAt": nil}, bson.M{"$set": set})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting user"})
+		return
+	}
+	if result.MatchedCount == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	// Cleanup associated data
-	if user.UserType == "student" {
-		// Remove student from all enrolled classes
-		_, err = uc.db.Collection("classes").UpdateMany(
-			context.Background(),
+	recordActivity(uc.db, c, models.Activity{
+		Type:        "user_deleted",
+		UserID:      &user.ID,
+		Username:    user.Username,
+		Description: fmt.Sprintf("User %s deleted", user.Username),
+	})
+
+	// Revoke any sessions the user still had. API keys are kept, since a
+	// deleted user cannot authenticate with them, and work again on restore.
+	if err := revokeSessions(ctx, uc.db, bson.M{"userId": userID}); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "User deleted but there was an error revoking their sessions",
+		})
+		return
+	}
+
+	// Free the student's seats
+	if len(enrollments) > 0 {
+		_, err = uc.db.Collection("classes").UpdateMany(ctx,
 			bson.M{"enrolled": userID},
 			bson.M{
 				"$pull": bson.M{"enrolled": userID},
@@ -506,7 +736,6 @@ func (uc *UserController) DeleteUser(c *gin.Context) {
 			},
 		)
 		if err != nil {
-			// Log error but don't return it since user is already deleted
 			c.JSON(http.StatusOK, gin.H{
 				"message": "User deleted but there was an error updating their class enrollments",
 			})
@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"classscheduling/mailer"
+	"classscheduling/models"
+)
+
+// recordingMailer keeps the messages it is asked to send
+type recordingMailer struct {
+	sent []mailer.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// updateSelf sends PUT /users/:id as the user themselves
+func updateSelf(t *testing.T, mt *mtest.T, mail mailer.Mailer, user models.User, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	uc := NewUserController(mt.Client.Database("test"), mail)
+
+	router := gin.New()
+	router.PUT("/api/users/:id", func(c *gin.Context) {
+		c.Set("userId", user.ID)
+		c.Set("username", user.Username)
+		c.Set("sessionId", primitive.NewObjectID())
+		c.Set("permissions", map[string]bool{})
+		c.Next()
+	}, uc.UpdateUser)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/api/users/"+user.ID.Hex(), strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func testUser(t *testing.T) models.User {
+	t.Helper()
+	user := models.User{
+		ID:            primitive.NewObjectID(),
+		Username:      "alice",
+		Email:         "alice@example.com",
+		Password:      "secret1",
+		UserType:      "student",
+		EmailVerified: true,
+	}
+	if err := user.HashPassword(); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestUpdateUserRequiresCurrentPassword(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for name, body := range map[string]string{
+		"email":    `{"email": "mallory@example.com"}`,
+		"username": `{"username": "mallory", "currentPassword": "wrong"}`,
+		"password": `{"password": "newpass1"}`,
+	} {
+		mt.Run(name, func(mt *mtest.T) {
+			t := mt.T
+			user := testUser(t)
+			mt.AddMockResponses(found(t, user))
+
+			rec := updateSelf(t, mt, &recordingMailer{}, user, body)
+			if rec.Code != http.StatusForbidden {
+				t.Fatalf("status = %d, want 403: %s", rec.Code, rec.Body.String())
+			}
+			if commandSent(mt, "findAndModify", "users") {
+				t.Fatal("the user was updated")
+			}
+		})
+	}
+}
+
+func TestUpdateUserEmailChange(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("self", func(mt *mtest.T) {
+		t := mt.T
+		user := testUser(t)
+		updated := user
+		updated.Email = "alice@new.example.com"
+		updated.EmailVerified = false
+		mt.AddMockResponses(
+			found(t, user),
+			found(t),
+			findAndModifyReply(t, updated),
+			ok(), ok(), // verification token
+		)
+		mail := &recordingMailer{}
+
+		rec := updateSelf(t, mt, mail, user, `{"email": "alice@new.example.com", "currentPassword": "secret1"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+
+		update := lastCommand(t, mt, "findAndModify", "users").Lookup("update")
+		if verified, ok := update.Document().Lookup("$set", "emailVerified").BooleanOK(); !ok || verified {
+			t.Errorf("update = %s, want emailVerified false", update)
+		}
+		if _, err := update.Document().LookupErr("$unset", "emailVerifiedAt"); err != nil {
+			t.Errorf("update = %s, want emailVerifiedAt unset", update)
+		}
+
+		if len(mail.sent) != 2 {
+			t.Fatalf("sent %d messages, want 2", len(mail.sent))
+		}
+		if mail.sent[0].To != updated.Email || !strings.Contains(mail.sent[0].Body, "verify-email.html?token=") {
+			t.Errorf("verification = %+v", mail.sent[0])
+		}
+		if mail.sent[1].To != user.Email || !strings.Contains(mail.sent[1].Body, updated.Email) {
+			t.Errorf("notice = %+v", mail.sent[1])
+		}
+	})
+}
+
+func TestDepartmentAdminCannotMakeAdmins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	department := primitive.NewObjectID()
+
+	serve := func(mt *mtest.T, method, path, body string) *httptest.ResponseRecorder {
+		uc := NewUserController(mt.Client.Database("test"), &recordingMailer{})
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("userId", primitive.NewObjectID())
+			c.Set("sessionId", primitive.NewObjectID())
+			c.Set("departmentId", department)
+			c.Set("permissions", map[string]bool{models.PermUsersManage: true})
+		})
+		router.POST("/api/users", uc.CreateUser)
+		router.PUT("/api/users/:id", uc.UpdateUser)
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	mt.Run("create", func(mt *mtest.T) {
+		t := mt.T
+		rec := serve(mt, http.MethodPost, "/api/users",
+			`{"username": "mallory", "email": "mallory@example.com", "password": "secret1", "userType": "admin"}`)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("status = %d, want 403: %s", rec.Code, rec.Body.String())
+		}
+		if commandSent(mt, "insert", "users") {
+			t.Fatal("the user was created")
+		}
+	})
+
+	mt.Run("update", func(mt *mtest.T) {
+		t := mt.T
+		user := testUser(t)
+		user.DepartmentID = &department
+		mt.AddMockResponses(found(t, user))
+
+		rec := serve(mt, http.MethodPut, "/api/users/"+user.ID.Hex(), `{"userType": "admin"}`)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("status = %d, want 403: %s", rec.Code, rec.Body.String())
+		}
+		if commandSent(mt, "findAndModify", "users") {
+			t.Fatal("the user was updated")
+		}
+	})
+}
@@ -0,0 +1,791 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	netmail "net/mail"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+
+	"classscheduling/mailer"
+	"classscheduling/middleware"
+	"classscheduling/models"
+	"classscheduling/report"
+	"classscheduling/sso"
+)
+
+const (
+	maxImportRows = 10000
+	maxImportSize = 10 << 20
+)
+
+// How imported accounts get their first password
+const (
+	ImportPasswordsEmail    = "email"    // email each new user a link to choose one
+	ImportPasswordsGenerate = "generate" // generate temporary passwords and report them
+)
+
+// userCSVColumns are the columns of a user export, which can be imported
+// again as is
+var userCSVColumns = []string{"username", "email", "type", "rollNumber", "department", "classes", "disabled", "createdAt"}
+
+// UserImportRow is one row of a user import file
+type UserImportRow struct {
+	Line       int      `json:"line"`
+	Username   string   `json:"username"`
+	Email      string   `json:"email"`
+	UserType   string   `json:"type"`
+	RollNumber string   `json:"rollNumber,omitempty"`
+	Department string   `json:"department,omitempty"` // department code
+	Classes    []string `json:"classes,omitempty"`    // codes of classes to enroll in
+}
+
+// UserImportOptions controls how an import is applied
+type UserImportOptions struct {
+	DryRun    bool
+	Passwords string // ImportPasswordsEmail or ImportPasswordsGenerate
+	// Scope limits the import to one department, for department admins
+	Scope *primitive.ObjectID
+	// Enroll allows rows to enroll students in classes
+	Enroll  bool
+	ActorID *primitive.ObjectID
+}
+
+// UserImportResult is the outcome of one row
+type UserImportResult struct {
+	Line              int                 `json:"line"`
+	Username          string              `json:"username"`
+	Action            string              `json:"action"` // create, update, or skip when the row has errors
+	UserID            *primitive.ObjectID `json:"userId,omitempty"`
+	TemporaryPassword string              `json:"temporaryPassword,omitempty"`
+	Enrolled          []string            `json:"enrolled,omitempty"`
+	Errors            []string            `json:"errors,omitempty"`
+	Warnings          []string            `json:"warnings,omitempty"`
+}
+
+// UserImportReport summarizes an import. Nothing is written unless every
+// row is valid.
+type UserImportReport struct {
+	DryRun  bool               `json:"dryRun"`
+	Valid   bool               `json:"valid"`
+	Created int                `json:"created"`
+	Updated int                `json:"updated"`
+	Failed  int                `json:"failed"`
+	Rows    []UserImportResult `json:"rows"`
+}
+
+// userImportPlan is what applying a valid row will do
+type userImportPlan struct {
+	row           UserImportRow
+	existing      *models.User
+	department    *primitive.ObjectID
+	setDepartment bool
+	classes       []models.Class
+	password      string // plain, reported only when generated
+	hash          string
+}
+
+type UserImportController struct {
+	db   *mongo.Database
+	mail mailer.Mailer
+}
+
+func NewUserImportController(db *mongo.Database, mail mailer.Mailer) *UserImportController {
+	return &UserImportController{db: db, mail: mail}
+}
+
+// importColumn maps a header to the column it names, accepting the usual
+// spellings such as "Roll Number" and "roll_number"
+func importColumn(header string) string {
+	key := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '_' || r == '-' {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, strings.TrimSpace(header))
+
+	switch key {
+	case "username":
+		return "username"
+	case "email":
+		return "email"
+	case "type", "usertype":
+		return "type"
+	case "rollnumber", "rollno", "roll":
+		return "rollNumber"
+	case "department", "departmentcode":
+		return "department"
+	case "classes", "classcodes":
+		return "classes"
+	}
+	return ""
+}
+
+// ParseUserCSV reads a user import file. The first row names the columns;
+// username, email and type are required and unknown columns are ignored.
+// Class codes are separated by semicolons, commas, pipes or spaces.
+func ParseUserCSV(r io.Reader) ([]UserImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		if column := importColumn(strings.TrimPrefix(name, "\ufeff")); column != "" {
+			columns[column] = i
+		}
+	}
+	for _, required := range []string{"username", "email", "type"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("the file has no %s column", required)
+		}
+	}
+
+	var rows []UserImportRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("the file has more than %d rows", maxImportRows)
+		}
+
+		get := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(report.CSVValue(record[i]))
+		}
+		line, _ := reader.FieldPos(0)
+		row := UserImportRow{
+			Line:       line,
+			Username:   get("username"),
+			Email:      get("email"),
+			UserType:   strings.ToLower(get("type")),
+			RollNumber: get("rollNumber"),
+			Department: strings.ToUpper(get("department")),
+		}
+		for _, code := range strings.FieldsFunc(get("classes"), func(r rune) bool {
+			return r == ';' || r == ',' || r == '|' || unicode.IsSpace(r)
+		}) {
+			row.Classes = append(row.Classes, strings.ToUpper(code))
+		}
+		rows = append(rows, row)
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("the file has no rows")
+	}
+	return rows, nil
+}
+
+// Import validates rows against existing users, departments and classes and,
+// unless this is a dry run or a row has errors, creates the new users,
+// updates the ones matched by roll number and enrolls students in their
+// classes
+func (ic *UserImportController) Import(ctx context.Context, rows []UserImportRow, opts UserImportOptions) (*UserImportReport, error) {
+	plans, report, err := ic.planImport(ctx, rows, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.DryRun || !report.Valid {
+		return report, nil
+	}
+
+	if err := hashImportPasswords(plans, opts.Passwords); err != nil {
+		return nil, err
+	}
+
+	report.Created, report.Updated = 0, 0
+	for i, plan := range plans {
+		result := &report.Rows[i]
+		userID, err := ic.applyImportRow(ctx, plan, opts, result)
+		if err != nil {
+			result.Action = "skip"
+			result.Errors = append(result.Errors, err.Error())
+			report.Failed++
+			continue
+		}
+		result.UserID = &userID
+		if plan.existing == nil {
+			report.Created++
+		} else {
+			report.Updated++
+		}
+
+		for _, class := range plan.classes {
+			enrolled, err := ic.enrollImported(ctx, class, userID)
+			if err != nil {
+				return nil, err
+			}
+			if enrolled {
+				result.Enrolled = append(result.Enrolled, class.Code)
+			} else if !isEnrolled(class, userID) {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("class %s is full", class.Code))
+			}
+		}
+	}
+
+	recordActivity(ic.db, nil, models.Activity{
+		Type:        "users_imported",
+		ActorID:     opts.ActorID,
+		Description: fmt.Sprintf("Imported users: %d created, %d updated, %d failed", report.Created, report.Updated, report.Failed),
+	})
+	return report, nil
+}
+
+// planImport checks every row and works out what applying it will do
+func (ic *UserImportController) planImport(ctx context.Context, rows []UserImportRow, opts UserImportOptions) ([]userImportPlan, *UserImportReport, error) {
+	var usernames, emails, rollNumbers, classCodes []string
+	for _, row := range rows {
+		usernames = append(usernames, row.Username)
+		emails = append(emails, row.Email)
+		if row.RollNumber != "" {
+			rollNumbers = append(rollNumbers, row.RollNumber)
+		}
+		classCodes = append(classCodes, row.Classes...)
+	}
+
+	// Load everything the rows refer to up front rather than row by row
+	cursor, err := ic.db.Collection("users").Find(ctx, bson.M{"$or": []bson.M{
+		{"username": bson.M{"$in": usernames}},
+		{"email": bson.M{"$in": emails}},
+		{"rollNumber": bson.M{"$in": rollNumbers}},
+	}}, options.Find().SetProjection(bson.M{"password": 0}))
+	if err != nil {
+		return nil, nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, nil, err
+	}
+	byUsername := make(map[string]*models.User)
+	byEmail := make(map[string]*models.User)
+	byRollNumber := make(map[string][]*models.User)
+	for i := range users {
+		user := &users[i]
+		byUsername[user.Username] = user
+		byEmail[user.Email] = user
+		if user.RollNumber != "" {
+			byRollNumber[user.RollNumber] = append(byRollNumber[user.RollNumber], user)
+		}
+	}
+
+	cursor, err = ic.db.Collection("departments").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, nil, err
+	}
+	var departments []models.Department
+	if err := cursor.All(ctx, &departments); err != nil {
+		return nil, nil, err
+	}
+	departmentByCode := make(map[string]primitive.ObjectID)
+	for _, department := range departments {
+		departmentByCode[strings.ToUpper(department.Code)] = department.ID
+	}
+
+	classByCode := make(map[string]models.Class)
+	if len(classCodes) > 0 {
+		cursor, err = ic.db.Collection("classes").Find(ctx, bson.M{"code": bson.M{"$in": classCodes}})
+		if err != nil {
+			return nil, nil, err
+		}
+		var classes []models.Class
+		if err := cursor.All(ctx, &classes); err != nil {
+			return nil, nil, err
+		}
+		for _, class := range classes {
+			classByCode[class.Code] = class
+		}
+	}
+
+	report := &UserImportReport{DryRun: opts.DryRun, Valid: true}
+	plans := make([]userImportPlan, 0, len(rows))
+	seenUsername := make(map[string]int)
+	seenEmail := make(map[string]int)
+	seenRollNumber := make(map[string]int)
+	seats := make(map[string]int)
+
+	for _, row := range rows {
+		plan := userImportPlan{row: row}
+		result := UserImportResult{Line: row.Line, Username: row.Username}
+		fail := func(format string, args ...interface{}) {
+			result.Errors = append(result.Errors, fmt.Sprintf(format, args...))
+		}
+
+		if row.Username == "" {
+			fail("username is required")
+		} else if line, ok := seenUsername[strings.ToLower(row.Username)]; ok {
+			fail("username is repeated from line %d", line)
+		} else {
+			seenUsername[strings.ToLower(row.Username)] = row.Line
+		}
+
+		if row.Email == "" {
+			fail("email is required")
+		} else if address, err := netmail.ParseAddress(row.Email); err != nil || address.Address != row.Email {
+			fail("email %q is not a valid address", row.Email)
+		} else if line, ok := seenEmail[strings.ToLower(row.Email)]; ok {
+			fail("email is repeated from line %d", line)
+		} else {
+			seenEmail[strings.ToLower(row.Email)] = row.Line
+		}
+
+		if !models.ValidUserType(row.UserType) {
+			fail("type must be one of %s", strings.Join(models.UserTypes, ", "))
+		} else if row.UserType == "admin" && opts.Scope != nil {
+			fail("only a global admin can import admins")
+		}
+		if row.UserType == "student" && row.RollNumber == "" {
+			fail("roll number is required for students")
+		}
+
+		// Rows are matched to existing users by roll number, or without one
+		// by both username and email, so that an export can be imported again
+		if row.RollNumber == "" {
+			if user, ok := byUsername[row.Username]; ok && byEmail[row.Email] == user {
+				plan.existing = user
+			}
+		} else {
+			if line, ok := seenRollNumber[row.RollNumber]; ok {
+				fail("roll number is repeated from line %d", line)
+			}
+			seenRollNumber[row.RollNumber] = row.Line
+
+			switch matches := byRollNumber[row.RollNumber]; {
+			case len(matches) > 1:
+				fail("roll number %s belongs to more than one user", row.RollNumber)
+			case len(matches) == 1:
+				plan.existing = matches[0]
+			}
+		}
+		if plan.existing != nil {
+			if plan.existing.DeletedAt != nil {
+				fail("the matching user %s is deleted; restore it first", plan.existing.Username)
+			} else if !departmentContains(opts.Scope, plan.existing.DepartmentID) {
+				fail("the matching user %s is outside your department", plan.existing.Username)
+			} else if plan.existing.UserType != row.UserType {
+				fail("the matching user %s is a %s", plan.existing.Username, plan.existing.UserType)
+			}
+		}
+
+		if user, ok := byUsername[row.Username]; ok && (plan.existing == nil || user.ID != plan.existing.ID) {
+			fail("username %s is already taken", row.Username)
+		}
+		if user, ok := byEmail[row.Email]; ok && (plan.existing == nil || user.ID != plan.existing.ID) {
+			fail("email %s is already registered", row.Email)
+		}
+
+		switch {
+		case row.Department != "":
+			id, ok := departmentByCode[row.Department]
+			if !ok {
+				fail("unknown department %s", row.Department)
+			} else if !departmentContains(opts.Scope, &id) {
+				fail("department %s is outside your department", row.Department)
+			}
+			plan.department, plan.setDepartment = &id, true
+		case opts.Scope != nil:
+			plan.department, plan.setDepartment = opts.Scope, true
+		}
+
+		if len(row.Classes) > 0 {
+			if row.UserType != "student" {
+				fail("only students can be enrolled in classes")
+			} else if !opts.Enroll {
+				fail("you are not allowed to manage enrollments")
+			}
+		}
+		for _, code := range row.Classes {
+			class, ok := classByCode[code]
+			switch {
+			case !ok:
+				fail("unknown class %s", code)
+				continue
+			case class.DeletedAt != nil || class.Status != "active":
+				fail("class %s is not active", code)
+				continue
+			case !departmentContains(opts.Scope, class.DepartmentID):
+				fail("class %s is outside your department", code)
+				continue
+			}
+			plan.classes = append(plan.classes, class)
+			if plan.existing != nil && isEnrolled(class, plan.existing.ID) {
+				continue
+			}
+			seats[code]++
+			if class.EnrolledCount+seats[code] > class.Capacity {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("class %s is full", code))
+			}
+		}
+
+		switch {
+		case len(result.Errors) > 0:
+			result.Action = "skip"
+			report.Valid = false
+			report.Failed++
+		case plan.existing != nil:
+			result.Action = "update"
+			id := plan.existing.ID
+			result.UserID = &id
+			report.Updated++
+		default:
+			result.Action = "create"
+			report.Created++
+		}
+		plans = append(plans, plan)
+		report.Rows = append(report.Rows, result)
+	}
+
+	return plans, report, nil
+}
+
+// applyImportRow writes one planned row and returns the user's ID
+func (ic *UserImportController) applyImportRow(ctx context.Context, plan userImportPlan, opts UserImportOptions, result *UserImportResult) (primitive.ObjectID, error) {
+	now := time.Now()
+	row := plan.row
+
+	if plan.existing != nil {
+		set := bson.M{
+			"username":  row.Username,
+			"email":     row.Email,
+			"updatedAt": now,
+		}
+		if plan.setDepartment {
+			set["departmentId"] = plan.department
+		}
+		_, err := ic.db.Collection("users").UpdateOne(ctx, bson.M{"_id": plan.existing.ID}, bson.M{"$set": set})
+		if mongo.IsDuplicateKeyError(err) {
+			return primitive.NilObjectID, errors.New("username or email was taken while importing")
+		}
+		return plan.existing.ID, err
+	}
+
+	// Accounts provisioned by an admin are trusted
+	user := models.User{
+		Username:      row.Username,
+		Email:         row.Email,
+		Password:      plan.hash,
+		UserType:      row.UserType,
+		RollNumber:    row.RollNumber,
+		DepartmentID:  plan.department,
+		EmailVerified: true,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	inserted, err := ic.db.Collection("users").InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return primitive.NilObjectID, errors.New("username or email was taken while importing")
+	}
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	user.ID = inserted.InsertedID.(primitive.ObjectID)
+
+	if opts.Passwords == ImportPasswordsGenerate {
+		result.TemporaryPassword = plan.password
+	} else if err := sendAccountSetupEmail(ctx, ic.db, ic.mail, user); err != nil {
+		log.Printf("Error emailing set-password link to %s: %v", user.Username, err)
+		result.Warnings = append(result.Warnings, "could not email a set-password link; send a password reset instead")
+	}
+	return user.ID, nil
+}
+
+// hashImportPasswords gives every new user a password. Hashing is slow on
+// purpose, so large imports hash on every CPU.
+func hashImportPasswords(plans []userImportPlan, mode string) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	work := make(chan int)
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				// Without a generated password the account can only be used
+				// once the user sets one through the emailed link
+				var password string
+				var err error
+				if mode == ImportPasswordsGenerate {
+					password, err = generateTemporaryPassword()
+				} else {
+					password, err = sso.RandomString(32)
+				}
+				var hash []byte
+				if err == nil {
+					hash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+				}
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					continue
+				}
+				if mode == ImportPasswordsGenerate {
+					plans[i].password = password
+				}
+				plans[i].hash = string(hash)
+			}
+		}()
+	}
+	for i := range plans {
+		if plans[i].existing == nil {
+			work <- i
+		}
+	}
+	close(work)
+	wg.Wait()
+	return firstErr
+}
+
+// generateTemporaryPassword returns a random password that is easy to read
+// out and type, without look-alike characters
+func generateTemporaryPassword() (string, error) {
+	const alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789"
+	password := make([]byte, 12)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		password[i] = alphabet[n.Int64()]
+	}
+	return string(password), nil
+}
+
+// enrollImported enrolls a student in a class unless they already are or the
+// class is full, reporting whether they were enrolled
+func (ic *UserImportController) enrollImported(ctx context.Context, class models.Class, studentID primitive.ObjectID) (bool, error) {
+	// Check capacity and enrollment in the update itself so concurrent
+	// enrollments cannot overfill the class
+	result, err := ic.db.Collection("classes").UpdateOne(ctx,
+		bson.M{
+			"_id":      class.ID,
+			"status":   "active",
+			"enrolled": bson.M{"$ne": studentID},
+			"$expr":    bson.M{"$lt": []interface{}{"$enrolledCount", "$capacity"}},
+		},
+		bson.M{
+			"$push": bson.M{"enrolled": studentID},
+			"$inc":  bson.M{"enrolledCount": 1},
+			"$set":  bson.M{"updatedAt": time.Now()},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// isEnrolled reports whether a student was enrolled in a class when it was
+// loaded
+func isEnrolled(class models.Class, studentID primitive.ObjectID) bool {
+	for _, enrolled := range class.Enrolled {
+		if enrolled == studentID {
+			return true
+		}
+	}
+	return false
+}
+
+// Export writes the users matching filter as CSV in the format Import reads.
+// Deleted users are left out.
+func (ic *UserImportController) Export(ctx context.Context, w io.Writer, filter bson.M, order bson.D) error {
+	cursor, err := ic.db.Collection("departments").Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	var departments []models.Department
+	if err := cursor.All(ctx, &departments); err != nil {
+		return err
+	}
+	departmentCodes := make(map[primitive.ObjectID]string)
+	for _, department := range departments {
+		departmentCodes[department.ID] = department.Code
+	}
+
+	// Only classes with a code can be listed, since the codes are what an
+	// import enrolls students by
+	cursor, err = ic.db.Collection("classes").Find(ctx,
+		bson.M{"code": bson.M{"$exists": true}, "status": "active", "deletedAt": nil},
+		options.Find().SetProjection(bson.M{"code": 1, "enrolled": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	var classes []models.Class
+	if err := cursor.All(ctx, &classes); err != nil {
+		return err
+	}
+	classCodes := make(map[primitive.ObjectID][]string)
+	for _, class := range classes {
+		for _, studentID := range class.Enrolled {
+			classCodes[studentID] = append(classCodes[studentID], class.Code)
+		}
+	}
+
+	cursor, err = ic.db.Collection("users").Find(ctx, bson.M{"$and": []bson.M{filter, {"deletedAt": nil}}},
+		options.Find().SetSort(order).SetProjection(bson.M{"password": 0}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	out := csv.NewWriter(w)
+	if err := out.Write(userCSVColumns); err != nil {
+		return err
+	}
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+		department := ""
+		if user.DepartmentID != nil {
+			department = departmentCodes[*user.DepartmentID]
+		}
+		codes := classCodes[user.ID]
+		sort.Strings(codes)
+
+		err := out.Write([]string{
+			report.CSVCell(user.Username),
+			report.CSVCell(user.Email),
+			user.UserType,
+			report.CSVCell(user.RollNumber),
+			report.CSVCell(department),
+			report.CSVCell(strings.Join(codes, ";")),
+			strconv.FormatBool(user.Disabled),
+			user.CreatedAt.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// ImportUsers creates and updates users from an uploaded CSV file, sent as
+// the "file" field of a multipart form or as the request body. With
+// dryRun=true the file is only checked. passwords=generate returns
+// temporary passwords for new users instead of emailing them a link.
+func (ic *UserImportController) ImportUsers(c *gin.Context) {
+	opts := UserImportOptions{
+		DryRun:    c.Query("dryRun") == "true",
+		Passwords: c.DefaultQuery("passwords", ImportPasswordsEmail),
+		Scope:     departmentScope(c),
+		Enroll:    middleware.HasPermission(c, models.PermEnrollmentsManage),
+	}
+	if opts.Passwords != ImportPasswordsEmail && opts.Passwords != ImportPasswordsGenerate {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "passwords must be email or generate"})
+		return
+	}
+	if actorID, ok := c.Get("userId"); ok {
+		id := actorID.(primitive.ObjectID)
+		opts.ActorID = &id
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+	var source io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Upload the CSV file as the file field"})
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read the uploaded file"})
+			return
+		}
+		defer file.Close()
+		source = file
+	}
+
+	rows, err := ParseUserCSV(source)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "The file is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid CSV file: " + err.Error()})
+		return
+	}
+
+	report, err := ic.Import(context.Background(), rows, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error importing users"})
+		return
+	}
+	if !report.Valid && !report.DryRun {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  "Some rows have errors, so nothing was imported",
+			"report": report,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// ExportUsers downloads the users matching the same filters and search as
+// the user list as a CSV file that can be imported again
+func (ic *UserImportController) ExportUsers(c *gin.Context) {
+	query, ok := parseList(c, userListSpec)
+	if !ok {
+		return
+	}
+	base := scopeFilter(c, bson.M{"userType": bson.M{"$ne": models.UserTypeService}})
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="users-%s.csv"`, time.Now().Format("2006-01-02")))
+	c.Status(http.StatusOK)
+	if err := ic.Export(context.Background(), c.Writer, query.Match(base), query.Sort()); err != nil {
+		// The header has been sent, so the download is cut short instead
+		log.Printf("Error exporting users: %v", err)
+		c.Abort()
+	}
+}
@@ -0,0 +1,427 @@
+package controllers
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"classscheduling/models"
+)
+
+// teachingLoadMaxHours is the weekly contact hours above which a faculty
+// member is reported as overloaded, unless the maxHours parameter says
+// otherwise
+const teachingLoadMaxHours = 18.0
+
+type WorkloadController struct {
+	db *mongo.Database
+}
+
+func NewWorkloadController(db *mongo.Database) *WorkloadController {
+	return &WorkloadController{db: db}
+}
+
+// workloadClass is a class as the workload reports see it, with its
+// enrollment counted from the enrolled list
+type workloadClass struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Name      string             `bson:"name"`
+	Code      string             `bson:"code"`
+	FacultyID primitive.ObjectID `bson:"facultyId"`
+	Schedule  interface{}        `bson:"schedule"`
+	Term      string             `bson:"term"`
+	Capacity  int                `bson:"capacity"`
+	Enrolled  int                `bson:"enrolled"`
+}
+
+// workloadClasses returns the classes that are not deleted or cancelled,
+// in the given term when it is not empty and in the caller's department
+func (wc *WorkloadController) workloadClasses(ctx context.Context, c *gin.Context, term string) ([]workloadClass, error) {
+	filter := scopeFilter(c, bson.M{"deletedAt": nil, "status": bson.M{"$ne": "cancelled"}})
+	if term != "" {
+		filter["term"] = term
+	}
+	cursor, err := wc.db.Collection("classes").Aggregate(ctx, []bson.M{
+		{"$match": filter},
+		{"$project": bson.M{
+			"name":      1,
+			"code":      1,
+			"facultyId": 1,
+			"schedule":  1,
+			"term":      1,
+			"capacity":  1,
+			"enrolled":  bson.M{"$size": bson.M{"$ifNull": []interface{}{"$enrolled", []interface{}{}}}},
+		}},
+		{"$sort": bson.M{"term": 1, "name": 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var classes []workloadClass
+	if err := cursor.All(ctx, &classes); err != nil {
+		return nil, err
+	}
+	return classes, nil
+}
+
+// workloadFaculty returns the faculty in the caller's department together
+// with anyone else teaching one of the classes, by ID
+func (wc *WorkloadController) workloadFaculty(ctx context.Context, c *gin.Context, classes []workloadClass) (map[primitive.ObjectID]models.User, error) {
+	teaching := make([]primitive.ObjectID, 0, len(classes))
+	for _, class := range classes {
+		teaching = append(teaching, class.FacultyID)
+	}
+	filter := bson.M{"$or": []bson.M{
+		scopeFilter(c, bson.M{"userType": "faculty", "deletedAt": nil}),
+		{"_id": bson.M{"$in": teaching}},
+	}}
+	cursor, err := wc.db.Collection("users").Find(ctx, filter,
+		options.Find().SetProjection(bson.M{"username": 1, "email": 1, "userType": 1, "departmentId": 1, "deletedAt": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	faculty := make(map[primitive.ObjectID]models.User, len(users))
+	for _, user := range users {
+		faculty[user.ID] = user
+	}
+	return faculty, nil
+}
+
+// TeachingLoadRow is one faculty member's load in one term
+type TeachingLoadRow struct {
+	FacultyID           primitive.ObjectID `json:"facultyId"`
+	Username            string             `json:"username"`
+	Email               string             `json:"email"`
+	Term                string             `json:"term"`
+	Sections            int                `json:"sections"`
+	Students            int                `json:"students"`
+	WeeklyHours         float64            `json:"weeklyHours"`
+	UnscheduledSections int                `json:"unscheduledSections"` // sections whose schedule could not be read
+	Cancellations       int64              `json:"cancellations"`
+	Reschedules         int64              `json:"reschedules"`
+	Overloaded          bool               `json:"overloaded"`
+}
+
+// GetTeachingLoad reports, per faculty member and term, the sections and
+// students taught, the weekly contact hours read from the class schedules
+// and the sessions cancelled or rescheduled. The term parameter limits the
+// report to one term; faculty without classes are listed with no load.
+// With format=csv the report is downloaded as CSV.
+func (wc *WorkloadController) GetTeachingLoad(c *gin.Context) {
+	ctx := context.Background()
+	term := c.Query("term")
+	maxHours := teachingLoadMaxHours
+	if value := c.Query("maxHours"); value != "" {
+		hours, err := strconv.ParseFloat(value, 64)
+		if err != nil || hours <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "maxHours must be a positive number"})
+			return
+		}
+		maxHours = hours
+	}
+
+	classes, err := wc.workloadClasses(ctx, c, term)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching classes"})
+		return
+	}
+	faculty, err := wc.workloadFaculty(ctx, c, classes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching faculty"})
+		return
+	}
+
+	type loadKey struct {
+		faculty primitive.ObjectID
+		term    string
+	}
+	rows := make(map[loadKey]*TeachingLoadRow)
+	row := func(facultyID primitive.ObjectID, term string) *TeachingLoadRow {
+		key := loadKey{facultyID, term}
+		if rows[key] == nil {
+			user := faculty[facultyID]
+			rows[key] = &TeachingLoadRow{FacultyID: facultyID, Username: user.Username, Email: user.Email, Term: term}
+		}
+		return rows[key]
+	}
+
+	classRows := make(map[primitive.ObjectID]*TeachingLoadRow, len(classes))
+	classIDs := make([]primitive.ObjectID, 0, len(classes))
+	teaching := make(map[primitive.ObjectID]bool)
+	for _, class := range classes {
+		teaching[class.FacultyID] = true
+		load := row(class.FacultyID, class.Term)
+		load.Sections++
+		load.Students += class.Enrolled
+		if meetings, ok := parseSchedule(class.Schedule); ok {
+			for _, m := range meetings {
+				load.WeeklyHours += m.hours()
+			}
+		} else {
+			load.UnscheduledSections++
+		}
+		classRows[class.ID] = load
+		classIDs = append(classIDs, class.ID)
+	}
+	for id, user := range faculty {
+		if user.UserType == "faculty" && user.DeletedAt == nil && !teaching[id] {
+			row(id, term)
+		}
+	}
+
+	var changes []struct {
+		ID struct {
+			ClassID primitive.ObjectID `bson:"classId"`
+			Type    string             `bson:"type"`
+		} `bson:"_id"`
+		Count int64 `bson:"count"`
+	}
+	cursor, err := wc.db.Collection("schedule_changes").Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"classId": bson.M{"$in": classIDs}}},
+		{"$group": bson.M{"_id": bson.M{"classId": "$classId", "type": "$type"}, "count": bson.M{"$sum": 1}}},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching schedule changes"})
+		return
+	}
+	if err := cursor.All(ctx, &changes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching schedule changes"})
+		return
+	}
+	for _, change := range changes {
+		load := classRows[change.ID.ClassID]
+		switch change.ID.Type {
+		case "cancellation":
+			load.Cancellations += change.Count
+		case "reschedule":
+			load.Reschedules += change.Count
+		}
+	}
+
+	report := make([]TeachingLoadRow, 0, len(rows))
+	for _, load := range rows {
+		load.Overloaded = load.WeeklyHours > maxHours
+		report = append(report, *load)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].WeeklyHours != report[j].WeeklyHours {
+			return report[i].WeeklyHours > report[j].WeeklyHours
+		}
+		if report[i].Username != report[j].Username {
+			return report[i].Username < report[j].Username
+		}
+		return report[i].Term < report[j].Term
+	})
+
+	if c.Query("format") == "csv" {
+		records := make([][]string, 0, len(report))
+		for _, load := range report {
+			records = append(records, []string{
+				load.Username,
+				load.Email,
+				load.Term,
+				strconv.Itoa(load.Sections),
+				strconv.Itoa(load.Students),
+				strconv.FormatFloat(load.WeeklyHours, 'f', 2, 64),
+				strconv.Itoa(load.UnscheduledSections),
+				strconv.FormatInt(load.Cancellations, 10),
+				strconv.FormatInt(load.Reschedules, 10),
+				strconv.FormatBool(load.Overloaded),
+			})
+		}
+		writeCSV(c, reportFilename("teaching-load", term), []string{
+			"username", "email", "term", "sections", "students", "weeklyHours",
+			"unscheduledSections", "cancellations", "reschedules", "overloaded",
+		}, records)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"term":     term,
+		"maxHours": maxHours,
+		"items":    report,
+	})
+}
+
+// UtilizationClass is the fill rate of one class
+type UtilizationClass struct {
+	ID       primitive.ObjectID `json:"id"`
+	Name     string             `json:"name"`
+	Code     string             `json:"code,omitempty"`
+	Faculty  string             `json:"faculty"`
+	Term     string             `json:"term"`
+	Capacity int                `json:"capacity"`
+	Enrolled int                `json:"enrolled"`
+	FillRate *float64           `json:"fillRate"`
+	Slots    []string           `json:"slots"`
+}
+
+// UtilizationSlot is the combined fill rate of the classes meeting in one
+// weekly time slot
+type UtilizationSlot struct {
+	Slot     string   `json:"slot"`
+	Classes  int      `json:"classes"`
+	Capacity int      `json:"capacity"`
+	Enrolled int      `json:"enrolled"`
+	FillRate *float64 `json:"fillRate"`
+
+	meeting meeting
+}
+
+// GetUtilization reports the fill rate of each class, and of each weekly
+// time slot across the classes that meet in it. Classes whose schedule
+// cannot be read have no slots and are counted as unscheduled. The term
+// parameter limits the report to one term. With format=csv the classes, or
+// the slots with table=slots, are downloaded as CSV.
+func (wc *WorkloadController) GetUtilization(c *gin.Context) {
+	ctx := context.Background()
+	term := c.Query("term")
+	table := c.DefaultQuery("table", "classes")
+	if table != "classes" && table != "slots" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "table must be classes or slots"})
+		return
+	}
+
+	classes, err := wc.workloadClasses(ctx, c, term)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching classes"})
+		return
+	}
+	faculty, err := wc.workloadFaculty(ctx, c, classes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching faculty"})
+		return
+	}
+
+	classReport := make([]UtilizationClass, 0, len(classes))
+	slots := make(map[string]*UtilizationSlot)
+	unscheduled := 0
+	for _, class := range classes {
+		item := UtilizationClass{
+			ID:       class.ID,
+			Name:     class.Name,
+			Code:     class.Code,
+			Faculty:  faculty[class.FacultyID].Username,
+			Term:     class.Term,
+			Capacity: class.Capacity,
+			Enrolled: class.Enrolled,
+			FillRate: rate(float64(class.Enrolled), float64(class.Capacity)),
+			Slots:    []string{},
+		}
+		meetings, ok := parseSchedule(class.Schedule)
+		if !ok {
+			unscheduled++
+		}
+		for _, m := range meetings {
+			label := m.slot()
+			item.Slots = append(item.Slots, label)
+			if slots[label] == nil {
+				slots[label] = &UtilizationSlot{Slot: label, meeting: m}
+			}
+			slots[label].Classes++
+			slots[label].Capacity += class.Capacity
+			slots[label].Enrolled += class.Enrolled
+		}
+		classReport = append(classReport, item)
+	}
+
+	slotReport := make([]UtilizationSlot, 0, len(slots))
+	for _, slot := range slots {
+		slot.FillRate = rate(float64(slot.Enrolled), float64(slot.Capacity))
+		slotReport = append(slotReport, *slot)
+	}
+	// Slots run through the week from Monday
+	sort.Slice(slotReport, func(i, j int) bool {
+		a, b := slotReport[i].meeting, slotReport[j].meeting
+		if a.Day != b.Day {
+			return (a.Day+6)%7 < (b.Day+6)%7
+		}
+		if a.Start != b.Start {
+			return a.Start < b.Start
+		}
+		return a.End < b.End
+	})
+
+	if c.Query("format") == "csv" {
+		percent := func(r *float64) string {
+			if r == nil {
+				return ""
+			}
+			return strconv.FormatFloat(*r, 'f', 1, 64)
+		}
+		if table == "slots" {
+			records := make([][]string, 0, len(slotReport))
+			for _, slot := range slotReport {
+				records = append(records, []string{
+					slot.Slot,
+					strconv.Itoa(slot.Classes),
+					strconv.Itoa(slot.Capacity),
+					strconv.Itoa(slot.Enrolled),
+					percent(slot.FillRate),
+				})
+			}
+			writeCSV(c, reportFilename("utilization-slots", term), []string{
+				"slot", "classes", "capacity", "enrolled", "fillRate",
+			}, records)
+			return
+		}
+		records := make([][]string, 0, len(classReport))
+		for _, class := range classReport {
+			records = append(records, []string{
+				class.Code,
+				class.Name,
+				class.Faculty,
+				class.Term,
+				strconv.Itoa(class.Capacity),
+				strconv.Itoa(class.Enrolled),
+				percent(class.FillRate),
+				strings.Join(class.Slots, ";"),
+			})
+		}
+		writeCSV(c, reportFilename("utilization-classes", term), []string{
+			"code", "name", "faculty", "term", "capacity", "enrolled", "fillRate", "slots",
+		}, records)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"term":        term,
+		"classes":     classReport,
+		"slots":       slotReport,
+		"unscheduled": unscheduled,
+	})
+}
+
+// reportFilename names a report download after the report, the term if
+// any and the current date
+func reportFilename(report, term string) string {
+	if term != "" {
+		report += "-" + term
+	}
+	return fmt.Sprintf("%s-%s.csv", report, time.Now().Format("2006-01-02"))
+}
+
+// writeCSV sends a header and records as a CSV download
+func writeCSV(c *gin.Context, filename string, header []string, records [][]string) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+	out := csv.NewWriter(c.Writer)
+	out.Write(header)
+	out.WriteAll(records)
+}
@@ -0,0 +1,433 @@
+// Package listing implements pagination, filtering, sorting and search for
+// list endpoints, so that every list accepts the same query parameters:
+//
+//	limit     page size, DefaultLimit unless given, at most MaxLimit
+//	offset    number of items to skip; page=N is the same as offset=(N-1)*limit
+//	cursor    the nextCursor of the previous page, instead of offset
+//	sort      a sortable field, prefixed with - for descending order
+//	search    case-insensitive text matched against the searchable fields
+//
+// plus one parameter per filterable field. Only the fields a Spec lists can
+// be filtered or sorted on.
+package listing
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// DefaultLimit is the page size when none is requested
+	DefaultLimit = 50
+	// MaxLimit is the largest page size a client can request
+	MaxLimit = 500
+	// maxSearchLength bounds the search text, which is matched with a regex
+	maxSearchLength = 100
+)
+
+// Kind is how a filter parameter's value is parsed
+type Kind int
+
+const (
+	String   Kind = iota // exact match; a comma-separated list matches any
+	ObjectID             // a hex object ID
+	Bool                 // true or false
+	Int                  // a whole number
+)
+
+// Field is a filterable field
+type Field struct {
+	Path string // document field, dotted for embedded documents
+	Kind Kind
+}
+
+// Spec lists what a list endpoint allows clients to filter, sort and
+// search on
+type Spec struct {
+	Filters     map[string]Field  // query parameter -> field
+	Sorts       map[string]string // sort key -> document field
+	Search      []string          // fields matched by the search parameter
+	DefaultSort string            // sort key, prefixed with - for descending
+}
+
+// Query is a parsed list request
+type Query struct {
+	// Filter holds the requested filters and search. It is combined with the
+	// endpoint's own filter, so it can never widen what a user may see.
+	Filter bson.M
+	Limit  int
+	Offset int
+
+	sortKey  string
+	sortPath string
+	desc     bool
+	after    *position
+}
+
+// position is where a page ended, encoded in the cursor
+type position struct {
+	Sort  string             `bson:"s"`
+	Value interface{}        `bson:"v"`
+	ID    primitive.ObjectID `bson:"id"`
+}
+
+// Page is one page of a list
+type Page struct {
+	Items      interface{} `json:"items"`
+	Total      int64       `json:"total"`
+	Limit      int         `json:"limit"`
+	Offset     int         `json:"offset"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+// Parse reads a list request's query parameters. Errors describe the bad
+// parameter and can be shown to the client.
+func Parse(values url.Values, spec Spec) (*Query, error) {
+	q := &Query{Filter: bson.M{}, Limit: DefaultLimit}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return nil, errors.New("limit must be a positive number")
+		}
+		if limit > MaxLimit {
+			limit = MaxLimit
+		}
+		q.Limit = limit
+	}
+
+	if v := values.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return nil, errors.New("offset must be zero or a positive number")
+		}
+		q.Offset = offset
+	} else if v := values.Get("page"); v != "" {
+		page, err := strconv.Atoi(v)
+		if err != nil || page < 1 {
+			return nil, errors.New("page must be a positive number")
+		}
+		q.Offset = (page - 1) * q.Limit
+	}
+
+	sort := values.Get("sort")
+	if sort == "" {
+		sort = spec.DefaultSort
+	}
+	if sort != "" {
+		key := strings.TrimPrefix(sort, "-")
+		path, ok := spec.Sorts[key]
+		if !ok {
+			return nil, fmt.Errorf("cannot sort by %q", key)
+		}
+		q.sortKey = sort
+		q.sortPath = path
+		q.desc = strings.HasPrefix(sort, "-")
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		if q.Offset > 0 {
+			return nil, errors.New("cursor cannot be combined with offset or page")
+		}
+		after, err := decodeCursor(v)
+		if err != nil || after.Sort != q.sortKey {
+			return nil, errors.New("invalid cursor")
+		}
+		q.after = after
+	}
+
+	for param, field := range spec.Filters {
+		v := values.Get(param)
+		if v == "" {
+			continue
+		}
+		value, err := parseValue(v, field.Kind)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s", param)
+		}
+		q.Filter[field.Path] = value
+	}
+
+	if search := strings.TrimSpace(values.Get("search")); search != "" && len(spec.Search) > 0 {
+		if len(search) > maxSearchLength {
+			return nil, fmt.Errorf("search must be at most %d characters", maxSearchLength)
+		}
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(search), Options: "i"}
+		or := make([]bson.M, 0, len(spec.Search))
+		for _, path := range spec.Search {
+			or = append(or, bson.M{path: pattern})
+		}
+		q.Filter["$or"] = or
+	}
+
+	return q, nil
+}
+
+func parseValue(v string, kind Kind) (interface{}, error) {
+	switch kind {
+	case ObjectID:
+		return primitive.ObjectIDFromHex(v)
+	case Bool:
+		return strconv.ParseBool(v)
+	case Int:
+		return strconv.Atoi(v)
+	}
+	if values := strings.Split(v, ","); len(values) > 1 {
+		return bson.M{"$in": values}, nil
+	}
+	return v, nil
+}
+
+// Uses reports whether the query filters, searches or sorts on a field
+// under prefix, for endpoints that only join in related documents when
+// they are needed
+func (q *Query) Uses(prefix string) bool {
+	if strings.HasPrefix(q.sortPath, prefix) {
+		return true
+	}
+	for path, value := range q.Filter {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+		if path == "$or" {
+			for _, clause := range value.([]bson.M) {
+				for searched := range clause {
+					if strings.HasPrefix(searched, prefix) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Match combines the endpoint's filter with the requested one, for callers
+// that read every matching document rather than a page, such as exports
+func (q *Query) Match(base bson.M) bson.M {
+	if base == nil {
+		base = bson.M{}
+	}
+	if len(q.Filter) == 0 {
+		return base
+	}
+	if len(base) == 0 {
+		return q.Filter
+	}
+	return bson.M{"$and": []bson.M{base, q.Filter}}
+}
+
+// Sort orders by the sort field, then by _id so that pages are stable
+func (q *Query) Sort() bson.D {
+	order := 1
+	if q.desc {
+		order = -1
+	}
+	if q.sortPath == "" {
+		return bson.D{{Key: "_id", Value: order}}
+	}
+	return bson.D{{Key: q.sortPath, Value: order}, {Key: "_id", Value: order}}
+}
+
+// afterCursor matches the documents that come after the cursor's position
+func (q *Query) afterCursor() bson.M {
+	if q.after == nil {
+		return nil
+	}
+	idOp := "$gt"
+	if q.desc {
+		idOp = "$lt"
+	}
+	nextID := bson.M{"_id": bson.M{idOp: q.after.ID}}
+	if q.sortPath == "" {
+		return nextID
+	}
+
+	// Missing values sort before all others and cannot be compared with $gt
+	// or $lt, so they are matched separately
+	sameValue := bson.M{q.sortPath: q.after.Value, "_id": nextID["_id"]}
+	switch {
+	case q.after.Value == nil && q.desc:
+		return sameValue
+	case q.after.Value == nil:
+		return bson.M{"$or": []bson.M{{q.sortPath: bson.M{"$ne": nil}}, sameValue}}
+	case q.desc:
+		return bson.M{"$or": []bson.M{{q.sortPath: bson.M{"$lt": q.after.Value}}, {q.sortPath: nil}, sameValue}}
+	}
+	return bson.M{"$or": []bson.M{{q.sortPath: bson.M{"$gt": q.after.Value}}, sameValue}}
+}
+
+// pageMatch is the filter for the documents on the requested page
+func (q *Query) pageMatch(base bson.M) bson.M {
+	match := q.Match(base)
+	if after := q.afterCursor(); after != nil {
+		if len(match) == 0 {
+			return after
+		}
+		return bson.M{"$and": []bson.M{match, after}}
+	}
+	return match
+}
+
+// Find returns a page of the documents in coll matching base and the query,
+// decoded into out, which must point to a slice. opts may set a projection.
+func (q *Query) Find(ctx context.Context, coll *mongo.Collection, base bson.M, out interface{}, opts ...*options.FindOptions) (*Page, error) {
+	total, err := coll.CountDocuments(ctx, q.Match(base))
+	if err != nil {
+		return nil, err
+	}
+
+	findOpts := options.MergeFindOptions(opts...).
+		SetSort(q.Sort()).
+		SetLimit(int64(q.Limit + 1))
+	if q.after == nil && q.Offset > 0 {
+		findOpts.SetSkip(int64(q.Offset))
+	}
+	cursor, err := coll.Find(ctx, q.pageMatch(base), findOpts)
+	if err != nil {
+		return nil, err
+	}
+	return q.page(ctx, cursor, total, out)
+}
+
+// Aggregate returns a page of the documents in coll matching base and the
+// query. join runs between base and the query's filter, for lookups that
+// filters, search or sort depend on; shape runs on the page's documents
+// only and must keep _id and the sort field.
+func (q *Query) Aggregate(ctx context.Context, coll *mongo.Collection, base bson.M, join, shape []bson.M, out interface{}) (*Page, error) {
+	if base == nil {
+		base = bson.M{}
+	}
+	prefix := append([]bson.M{{"$match": base}}, join...)
+
+	count := append(append([]bson.M{}, prefix...), bson.M{"$match": q.Filter}, bson.M{"$count": "total"})
+	cursor, err := coll.Aggregate(ctx, count)
+	if err != nil {
+		return nil, err
+	}
+	var counted []struct {
+		Total int64 `bson:"total"`
+	}
+	if err := cursor.All(ctx, &counted); err != nil {
+		return nil, err
+	}
+	var total int64
+	if len(counted) > 0 {
+		total = counted[0].Total
+	}
+
+	pipeline := append(append([]bson.M{}, prefix...), bson.M{"$match": q.pageMatch(nil)}, bson.M{"$sort": q.Sort()})
+	if q.after == nil && q.Offset > 0 {
+		pipeline = append(pipeline, bson.M{"$skip": q.Offset})
+	}
+	pipeline = append(pipeline, bson.M{"$limit": q.Limit + 1})
+	pipeline = append(pipeline, shape...)
+
+	cursor, err = coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return q.page(ctx, cursor, total, out)
+}
+
+// page decodes up to Limit documents into out and works out the next cursor
+func (q *Query) page(ctx context.Context, cursor *mongo.Cursor, total int64, out interface{}) (*Page, error) {
+	var docs []bson.Raw
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	page := &Page{Total: total, Limit: q.Limit, Offset: q.Offset}
+	if q.after != nil {
+		page.Offset = 0
+	}
+	if len(docs) > q.Limit {
+		docs = docs[:q.Limit]
+		next, err := q.cursorAfter(docs[len(docs)-1])
+		if err != nil {
+			return nil, err
+		}
+		page.NextCursor = next
+	}
+
+	slice := reflect.ValueOf(out).Elem()
+	items := reflect.MakeSlice(slice.Type(), 0, len(docs))
+	for _, doc := range docs {
+		item := reflect.New(slice.Type().Elem())
+		if err := bson.Unmarshal(doc, item.Interface()); err != nil {
+			return nil, err
+		}
+		items = reflect.Append(items, item.Elem())
+	}
+	slice.Set(items)
+	page.Items = out
+	return page, nil
+}
+
+// cursorAfter encodes the position of the last document on a page
+func (q *Query) cursorAfter(doc bson.Raw) (string, error) {
+	id, ok := doc.Lookup("_id").ObjectIDOK()
+	if !ok {
+		return "", errors.New("listing: documents need an ObjectID _id for cursors")
+	}
+	after := position{Sort: q.sortKey, ID: id}
+	if q.sortPath != "" {
+		if value, err := doc.LookupErr(strings.Split(q.sortPath, ".")...); err == nil {
+			var decoded struct {
+				V interface{} `bson:"v"`
+			}
+			raw, err := bson.Marshal(bson.D{{Key: "v", Value: value}})
+			if err != nil {
+				return "", err
+			}
+			if err := bson.Unmarshal(raw, &decoded); err != nil {
+				return "", err
+			}
+			after.Value = decoded.V
+		}
+	}
+	raw, err := bson.Marshal(after)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeCursor(s string) (*position, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var after position
+	if err := bson.Unmarshal(raw, &after); err != nil {
+		return nil, err
+	}
+	// The value is compared with $gt and $lt, so a crafted cursor must not
+	// carry documents, arrays or anything else a field value can't be
+	if !scalar(after.Value) {
+		return nil, errors.New("listing: cursor value is not a scalar")
+	}
+	return &after, nil
+}
+
+// scalar reports whether v is a value a sortable field can hold
+func scalar(v interface{}) bool {
+	switch v.(type) {
+	case nil, string, bool, int32, int64, float64,
+		primitive.DateTime, primitive.ObjectID, primitive.Decimal128:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package listing
+
+import (
+	"encoding/base64"
+	"net/url"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var testSpec = Spec{
+	Sorts:       map[string]string{"name": "name", "createdAt": "createdAt"},
+	DefaultSort: "name",
+}
+
+func encode(t *testing.T, doc interface{}) string {
+	t.Helper()
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	id := primitive.NewObjectID()
+	tests := []struct {
+		sort  string
+		value interface{}
+	}{
+		{"name", "alice"},
+		{"name", nil},
+		{"-createdAt", primitive.NewDateTimeFromTime(time.Now())},
+	}
+	for _, tt := range tests {
+		q, err := Parse(url.Values{"sort": {tt.sort}}, testSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc, err := bson.Marshal(bson.M{"_id": id, "name": tt.value, "createdAt": tt.value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor, err := q.cursorAfter(doc)
+		if err != nil {
+			t.Fatalf("cursorAfter: %v", err)
+		}
+		parsed, err := Parse(url.Values{"sort": {tt.sort}, "cursor": {cursor}}, testSpec)
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", tt.sort, err)
+		}
+		if parsed.after.ID != id || parsed.after.Value != tt.value {
+			t.Errorf("after = %+v, want %v", parsed.after, tt.value)
+		}
+	}
+}
+
+func TestCursorRejectsOperators(t *testing.T) {
+	id := primitive.NewObjectID()
+	tests := map[string]interface{}{
+		"document": bson.M{"$ne": nil},
+		"array":    bson.A{"a", "b"},
+		"regex":    primitive.Regex{Pattern: ".*"},
+		"code":     primitive.JavaScript("sleep(1000)"),
+	}
+	for name, value := range tests {
+		t.Run(name, func(t *testing.T) {
+			cursor := encode(t, bson.M{"s": "name", "v": value, "id": id})
+			if _, err := Parse(url.Values{"cursor": {cursor}}, testSpec); err == nil {
+				t.Fatal("Parse accepted a cursor with a non-scalar value")
+			}
+		})
+	}
+}
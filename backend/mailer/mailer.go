@@ -0,0 +1,179 @@
+// Package mailer sends transactional email such as password reset and email
+// verification links. The SMTP implementation is used in production; the
+// stdout and file implementations let local development and tests see the
+// messages without a mail server.
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FromEnv builds the mailer selected by MAIL_DRIVER: "smtp", "file" or
+// "stdout" (the default). Messages carry account tokens, so with
+// APP_ENV=production only smtp is accepted rather than writing them where
+// logs are kept.
+func FromEnv() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@classscheduling.local"
+	}
+
+	driver := os.Getenv("MAIL_DRIVER")
+	if driver != "smtp" && os.Getenv("APP_ENV") == "production" {
+		return nil, fmt.Errorf("MAIL_DRIVER must be smtp when APP_ENV is production, not %q", driver)
+	}
+
+	switch driver {
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return &FileMailer{Dir: dir, From: from}, nil
+	case "", "stdout":
+		return &WriterMailer{W: os.Stdout, From: from}, nil
+	}
+	return nil, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+}
+
+// format renders a message in RFC 5322 form
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// validate rejects header injection through the recipient or subject
+func validate(msg Message) error {
+	if msg.To == "" {
+		return fmt.Errorf("mailer: missing recipient")
+	}
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return fmt.Errorf("mailer: invalid header value")
+	}
+	return nil
+}
+
+// SMTPMailer sends mail through an SMTP server using STARTTLS when offered
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := validate(msg); err != nil {
+		return err
+	}
+	if m.Host == "" {
+		return fmt.Errorf("mailer: SMTP_HOST is not configured")
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(net.JoinHostPort(m.Host, m.Port), auth, m.From, []string{msg.To}, format(m.From, msg))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WriterMailer writes messages to a writer, stdout by default
+type WriterMailer struct {
+	W    io.Writer
+	From string
+
+	mu sync.Mutex
+}
+
+func (m *WriterMailer) Send(ctx context.Context, msg Message) error {
+	if err := validate(msg); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := fmt.Fprintf(m.W, "----- email -----\r\n%s----- end email -----\r\n", format(m.From, msg))
+	return err
+}
+
+// FileMailer writes each message to its own .eml file in Dir
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := validate(msg); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), sanitize(msg.To))
+	return os.WriteFile(filepath.Join(m.Dir, name), format(m.From, msg), 0o644)
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
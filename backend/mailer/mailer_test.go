@@ -0,0 +1,32 @@
+package mailer
+
+import "testing"
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		env    string
+		driver string
+		ok     bool
+	}{
+		{"development", "", true},
+		{"development", "stdout", true},
+		{"development", "file", true},
+		{"development", "smtp", true},
+		{"development", "sendmail", false},
+		{"production", "smtp", true},
+		{"production", "", false},
+		{"production", "stdout", false},
+		{"production", "file", false},
+	}
+	for _, tt := range tests {
+		t.Setenv("APP_ENV", tt.env)
+		t.Setenv("MAIL_DRIVER", tt.driver)
+		mail, err := FromEnv()
+		if tt.ok && (err != nil || mail == nil) {
+			t.Errorf("%s/%q: FromEnv = %v, %v", tt.env, tt.driver, mail, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s/%q: FromEnv accepted the driver", tt.env, tt.driver)
+		}
+	}
+}
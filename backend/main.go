@@ -1,15 +1,23 @@
 package main
 
 import (
+	"classscheduling/controllers"
+	"classscheduling/mailer"
+	"classscheduling/middleware"
+	"classscheduling/models"
+	"classscheduling/ratelimit"
 	"classscheduling/routes"
+	"classscheduling/sso"
 	"context"
 	"log"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -63,6 +71,15 @@ func initDB() {
 			Keys:    map[string]interface{}{"email": 1},
 			Options: options.Index().SetUnique(true),
 		},
+		{
+			// Bulk imports match existing students by roll number
+			Keys: map[string]interface{}{"rollNumber": 1},
+		},
+		{
+			// Only deleted users have deletedAt
+			Keys:    map[string]interface{}{"deletedAt": 1},
+			Options: options.Index().SetSparse(true),
+		},
 	}
 
 	_, err = db.Collection("users").Indexes().CreateMany(ctx, userIndexes)
@@ -81,15 +98,314 @@ func initDB() {
 		{
 			Keys: map[string]interface{}{"status": 1},
 		},
+		{
+			Keys: map[string]interface{}{"term": 1},
+		},
+		{
+			Keys: map[string]interface{}{"code": 1},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+				"code": bson.M{"$exists": true},
+			}),
+		},
+		{
+			Keys:    map[string]interface{}{"deletedAt": 1},
+			Options: options.Index().SetSparse(true),
+		},
 	}
 
 	_, err = db.Collection("classes").Indexes().CreateMany(ctx, classIndexes)
 	if err != nil {
 		log.Printf("Warning: Could not create class indexes: %v", err)
 	}
+
+	// Create indexes for risk_flags collection
+	riskIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "studentId", Value: 1}, {Key: "classId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: map[string]interface{}{"level": 1},
+		},
+	}
+
+	_, err = db.Collection("risk_flags").Indexes().CreateMany(ctx, riskIndexes)
+	if err != nil {
+		log.Printf("Warning: Could not create risk flag indexes: %v", err)
+	}
+
+	// Create indexes for sessions and refresh_tokens collections
+	sessionIndexes := []mongo.IndexModel{
+		{
+			Keys: map[string]interface{}{"userId": 1},
+		},
+		{
+			// Drop sessions once they can no longer be refreshed
+			Keys:    map[string]interface{}{"expiresAt": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	_, err = db.Collection("sessions").Indexes().CreateMany(ctx, sessionIndexes)
+	if err != nil {
+		log.Printf("Warning: Could not create session indexes: %v", err)
+	}
+
+	refreshTokenIndexes := []mongo.IndexModel{
+		{
+			Keys:    map[string]interface{}{"tokenHash": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: map[string]interface{}{"sessionId": 1},
+		},
+		{
+			Keys:    map[string]interface{}{"expiresAt": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	_, err = db.Collection("refresh_tokens").Indexes().CreateMany(ctx, refreshTokenIndexes)
+	if err != nil {
+		log.Printf("Warning: Could not create refresh token indexes: %v", err)
+	}
+
+	// Create indexes for user_tokens collection
+	userTokenIndexes := []mongo.IndexModel{
+		{
+			Keys:    map[string]interface{}{"tokenHash": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "userId", Value: 1}, {Key: "type", Value: 1}},
+		},
+		{
+			Keys:    map[string]interface{}{"expiresAt": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	_, err = db.Collection("user_tokens").Indexes().CreateMany(ctx, userTokenIndexes)
+	if err != nil {
+		log.Printf("Warning: Could not create user token indexes: %v", err)
+	}
+
+	// Create indexes for activity and rate_limits collections
+	activityIndexes := []mongo.IndexModel{
+		{
+			Keys: map[string]interface{}{"timestamp": -1},
+		},
+		{
+			Keys: bson.D{{Key: "type", Value: 1}, {Key: "timestamp", Value: -1}},
+		},
+	}
+
+	_, err = db.Collection("activity").Indexes().CreateMany(ctx, activityIndexes)
+	if err != nil {
+		log.Printf("Warning: Could not create activity indexes: %v", err)
+	}
+
+	_, err = db.Collection("rate_limits").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    map[string]interface{}{"expiresAt": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		log.Printf("Warning: Could not create rate limit indexes: %v", err)
+	}
+
+	// Create index for roles collection
+	_, err = db.Collection("roles").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    map[string]interface{}{"name": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Printf("Warning: Could not create role indexes: %v", err)
+	}
+
+	// Create index for departments collection
+	_, err = db.Collection("departments").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    map[string]interface{}{"code": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Printf("Warning: Could not create department indexes: %v", err)
+	}
+
+	_, err = db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "approvalStatus", Value: 1}, {Key: "createdAt", Value: 1}},
+		Options: options.Index().SetPartialFilterExpression(bson.M{
+			"approvalStatus": bson.M{"$exists": true},
+		}),
+	})
+	if err != nil {
+		log.Printf("Warning: Could not create approval index: %v", err)
+	}
+
+	for _, collection := range []string{"users", "classes"} {
+		_, err = db.Collection(collection).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: map[string]interface{}{"departmentId": 1},
+		})
+		if err != nil {
+			log.Printf("Warning: Could not create %s department index: %v", collection, err)
+		}
+	}
+
+	_, err = db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "ssoProvider", Value: 1}, {Key: "ssoSubject", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+			"ssoSubject": bson.M{"$exists": true},
+		}),
+	})
+	if err != nil {
+		log.Printf("Warning: Could not create SSO index: %v", err)
+	}
+
+	// Create indexes for api_keys collection
+	apiKeyIndexes := []mongo.IndexModel{
+		{
+			Keys:    map[string]interface{}{"keyHash": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: map[string]interface{}{"userId": 1},
+		},
+	}
+
+	_, err = db.Collection("api_keys").Indexes().CreateMany(ctx, apiKeyIndexes)
+	if err != nil {
+		log.Printf("Warning: Could not create API key indexes: %v", err)
+	}
+
+	// Create indexes for guardian_links collection
+	guardianLinkIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "guardianId", Value: 1}, {Key: "studentId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "studentId", Value: 1}, {Key: "status", Value: 1}},
+		},
+	}
+
+	_, err = db.Collection("guardian_links").Indexes().CreateMany(ctx, guardianLinkIndexes)
+	if err != nil {
+		log.Printf("Warning: Could not create guardian link indexes: %v", err)
+	}
+
+	// Drop abandoned single sign-on attempts
+	_, err = db.Collection("sso_states").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    map[string]interface{}{"expiresAt": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		log.Printf("Warning: Could not create SSO state indexes: %v", err)
+	}
+
+	// Report jobs and their output expire together
+	reportJobIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "requestedBy", Value: 1}, {Key: "createdAt", Value: -1}},
+		},
+		{
+			Keys:    map[string]interface{}{"expiresAt": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	_, err = db.Collection("report_jobs").Indexes().CreateMany(ctx, reportJobIndexes)
+	if err != nil {
+		log.Printf("Warning: Could not create report job indexes: %v", err)
+	}
+
+	reportFileIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "jobId", Value: 1}, {Key: "n", Value: 1}},
+		},
+		{
+			Keys:    map[string]interface{}{"expiresAt": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	_, err = db.Collection("report_files").Indexes().CreateMany(ctx, reportFileIndexes)
+	if err != nil {
+		log.Printf("Warning: Could not create report file indexes: %v", err)
+	}
+
+	seedRoles(ctx)
+}
+
+// seedRoles creates the built-in roles. Roles that already exist keep any
+// changes made by admins and only gain permissions introduced since the last
+// time they were seeded.
+func seedRoles(ctx context.Context) {
+	for _, role := range models.DefaultRoles() {
+		var existing models.Role
+		err := db.Collection("roles").FindOne(ctx, bson.M{"name": role.Name}).Decode(&existing)
+		if err == mongo.ErrNoDocuments {
+			now := time.Now()
+			role.SeededPermissions = role.Permissions
+			role.CreatedAt = now
+			role.UpdatedAt = now
+			if _, err := db.Collection("roles").InsertOne(ctx, role); err != nil {
+				log.Printf("Warning: Could not seed role %s: %v", role.Name, err)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("Warning: Could not seed role %s: %v", role.Name, err)
+			continue
+		}
+
+		seeded := make(map[string]bool)
+		for _, permission := range existing.SeededPermissions {
+			seeded[permission] = true
+		}
+		added := []string{}
+		for _, permission := range role.Permissions {
+			if !seeded[permission] {
+				added = append(added, permission)
+			}
+		}
+		if len(added) == 0 {
+			continue
+		}
+
+		_, err = db.Collection("roles").UpdateOne(ctx,
+			bson.M{"_id": existing.ID},
+			bson.M{
+				"$addToSet": bson.M{"permissions": bson.M{"$each": added}},
+				"$set":      bson.M{"seededPermissions": role.Permissions, "updatedAt": time.Now()},
+			},
+		)
+		if err != nil {
+			log.Printf("Warning: Could not seed role %s: %v", role.Name, err)
+		}
+	}
 }
 
-func setupRouter() *gin.Engine {
+// startScheduledJobs starts background jobs that run on a timer
+func startScheduledJobs(mail mailer.Mailer) {
+	// Nightly at-risk student evaluation, at 02:00 unless overridden
+	riskHour := 2
+	if h, err := strconv.Atoi(os.Getenv("RISK_EVALUATION_HOUR")); err == nil && h >= 0 && h < 24 {
+		riskHour = h
+	}
+	controllers.NewRiskController(db).StartNightly(riskHour)
+
+	// Attendance-shortage emails to guardians who opted in, after the risk
+	// evaluation
+	controllers.NewGuardianController(db, mail).StartAttendanceAlerts((riskHour + 1) % 24)
+
+	// Report jobs only run in the process that created them, so those left
+	// unfinished by the last run never will
+	controllers.NewReportController(db).FailInterruptedJobs()
+
+	// Signing key rotation
+	middleware.StartKeyRotation()
+}
+
+func setupRouter(mail mailer.Mailer) *gin.Engine {
 	router := gin.Default()
 
 	// Configure CORS
@@ -101,16 +417,42 @@ func setupRouter() *gin.Engine {
 		"http://localhost:3000", // Backend API alternative
 	}
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization"}
-	config.ExposeHeaders = []string{"Content-Length"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "X-API-Key"}
+	config.ExposeHeaders = []string{"Content-Length", "Content-Disposition", "Location"}
 	config.AllowCredentials = true
 	router.Use(cors.New(config))
 
 	// Serve static files from the frontend directory
 	router.Static("/frontend", "../frontend")
 
+	// Serve uploaded files such as avatars
+	router.Static("/uploads", controllers.UploadDir())
+
+	// Single sign-on providers
+	baseURL := os.Getenv("APP_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:3000"
+	}
+	providers := sso.FromEnv(baseURL)
+
+	// The mock identity provider lets the single sign-on flow be tried out
+	// without a real one. Anyone can sign in through it as any of its users,
+	// so the server refuses to start with it in production.
+	if os.Getenv("SSO_MOCK_IDP") == "true" {
+		if os.Getenv("APP_ENV") == "production" {
+			log.Fatal("SSO_MOCK_IDP must not be enabled when APP_ENV is production")
+		}
+		idp, err := sso.NewMockIdP(baseURL+"/mock-idp", "mock-client", "mock-secret", nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		router.Any("/mock-idp/*path", gin.WrapH(idp))
+		providers["mock"] = idp.Provider(baseURL)
+		log.Println("Warning: mock single sign-on provider is enabled")
+	}
+
 	// Setup routes with the controllers
-	routes.SetupRoutes(router, db)
+	routes.SetupRoutes(router, db, mail, ratelimit.FromEnv(db), providers)
 
 	return router
 }
@@ -119,8 +461,22 @@ func main() {
 	// Initialize database connection
 	initDB()
 
+	// Load the token signing keys; without them no one can log in
+	if err := middleware.InitTokens(); err != nil {
+		log.Fatalf("Could not load signing keys: %v", err)
+	}
+
+	// Without a working mailer account emails would be lost or leak
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatalf("Could not set up email: %v", err)
+	}
+
+	// Start background jobs
+	startScheduledJobs(mail)
+
 	// Setup router
-	router := setupRouter()
+	router := setupRouter(mail)
 
 	// Get port from environment variable or use default
 	port := os.Getenv("PORT")
@@ -1,129 +1,311 @@
 package middleware
 
 import (
-	"fmt"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"classscheduling/models"
 )
 
-type Claims struct {
-	UserID   primitive.ObjectID `json:"userId"`
-	Username string             `json:"username"`
-	UserType string             `json:"userType"`
-	jwt.StandardClaims
+// AccessTokenTTL returns how long access tokens are valid, 15 minutes unless
+// overridden with ACCESS_TOKEN_TTL (e.g. "10m")
+func AccessTokenTTL() time.Duration {
+	return durationFromEnv("ACCESS_TOKEN_TTL", 15*time.Minute)
 }
 
-func GenerateToken(userID primitive.ObjectID, username, userType string) (string, error) {
-	// Get JWT secret from environment variable or use default
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		jwtSecret = "your-256-bit-secret"
-	}
-
-	// Create the Claims
-	claims := Claims{
-		UserID:   userID,
-		Username: username,
-		UserType: userType,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(24 * time.Hour).Unix(), // Token expires in 24 hours
-			IssuedAt:  time.Now().Unix(),
-		},
+// RefreshTokenTTL returns how long a session can go without refreshing, 30
+// days unless overridden with REFRESH_TOKEN_TTL (e.g. "168h")
+func RefreshTokenTTL() time.Duration {
+	return durationFromEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
 	}
+	return fallback
+}
 
-	// Create token with claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+// NewOpaqueToken returns a random URL-safe token for refresh tokens and
+// other single-use secrets
+func NewOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
 
-	// Generate encoded token
-	return token.SignedString([]byte(jwtSecret))
+// HashToken returns the SHA-256 hash of an opaque token as stored in the database
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware authenticates requests with either a Bearer access token
+// from a login session or an API key, sent as a Bearer token or in the
+// X-API-Key header
+func AuthMiddleware(db *mongo.Database) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
+		apiKey := c.GetHeader("X-API-Key")
+		if authHeader == "" && apiKey == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
 			c.Abort()
 			return
 		}
 
-		// Check if the header starts with "Bearer "
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
-			c.Abort()
-			return
+		if authHeader != "" {
+			// Check if the header starts with "Bearer "
+			parts := strings.Split(authHeader, " ")
+			if len(parts) != 2 || parts[0] != "Bearer" {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
+				c.Abort()
+				return
+			}
+			if strings.HasPrefix(parts[1], models.APIKeyPrefix) {
+				apiKey = parts[1]
+			} else {
+				authenticateSession(c, db, parts[1])
+				return
+			}
 		}
 
-		tokenString := parts[1]
+		authenticateAPIKey(c, db, apiKey)
+	}
+}
 
-		// Get JWT secret from environment variable or use default
-		jwtSecret := os.Getenv("JWT_SECRET")
-		if jwtSecret == "" {
-			jwtSecret = "your-256-bit-secret"
-		}
+// authenticateSession validates an access token and its session
+func authenticateSession(c *gin.Context, db *mongo.Database, tokenString string) {
+	// Parse and validate the token
+	claims, err := tokens.ParseAccess(tokenString)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		c.Abort()
+		return
+	}
 
-		// Parse and validate the token
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(jwtSecret), nil
-		})
+	// The session must still be live; logout revokes it
+	ctx := context.Background()
+	count, err := db.Collection("sessions").CountDocuments(ctx, bson.M{
+		"_id":       claims.SessionID,
+		"userId":    claims.UserID,
+		"revokedAt": bson.M{"$exists": false},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		c.Abort()
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+		c.Abort()
+		return
+	}
+
+	user, ok := loadUser(c, db, claims.UserID)
+	if !ok {
+		return
+	}
+
+	// A token issued before the user's type changed must not keep the old type
+	if claims.UserType != user.UserType {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token is no longer valid"})
+		c.Abort()
+		return
+	}
+
+	c.Set("sessionId", claims.SessionID)
+	authorize(c, db, user, nil)
+}
 
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+// authenticateAPIKey validates an API key and records its use
+func authenticateAPIKey(c *gin.Context, db *mongo.Database, key string) {
+	ctx := context.Background()
+	now := time.Now()
+
+	var apiKey models.APIKey
+	err := db.Collection("api_keys").FindOne(ctx, bson.M{
+		"keyHash":   HashToken(key),
+		"revokedAt": bson.M{"$exists": false},
+		"$or": []bson.M{
+			{"expiresAt": bson.M{"$exists": false}},
+			{"expiresAt": bson.M{"$gt": now}},
+		},
+	}).Decode(&apiKey)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
 			c.Abort()
 			return
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		c.Abort()
+		return
+	}
 
-		if !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+	user, ok := loadUser(c, db, apiKey.UserID)
+	if !ok {
+		return
+	}
+
+	// Record use at most once a minute to keep writes off the hot path
+	_, err = db.Collection("api_keys").UpdateOne(ctx,
+		bson.M{
+			"_id": apiKey.ID,
+			"$or": []bson.M{
+				{"lastUsedAt": bson.M{"$exists": false}},
+				{"lastUsedAt": bson.M{"$lt": now.Add(-time.Minute)}},
+			},
+		},
+		bson.M{"$set": bson.M{"lastUsedAt": now, "lastUsedIp": c.ClientIP()}},
+	)
+	if err != nil {
+		log.Printf("Error recording use of API key %s: %v", apiKey.ID.Hex(), err)
+	}
+
+	c.Set("apiKeyId", apiKey.ID)
+	authorize(c, db, user, apiKey.Scopes)
+}
+
+// loadUser loads the authenticated user, who must still exist and be allowed
+// to sign in. ok is false once an error has been written.
+func loadUser(c *gin.Context, db *mongo.Database, userID primitive.ObjectID) (user models.User, ok bool) {
+	err := db.Collection("users").FindOne(context.Background(),
+		bson.M{"_id": userID, "deletedAt": nil},
+		options.FindOne().SetProjection(bson.M{"username": 1, "disabled": 1, "userType": 1, "roles": 1, "departmentId": 1}),
+	).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User no longer exists"})
 			c.Abort()
-			return
+			return user, false
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		c.Abort()
+		return user, false
+	}
+	if user.Disabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is disabled"})
+		c.Abort()
+		return user, false
+	}
+	return user, true
+}
 
-		// Set user information in the context
-		c.Set("userId", claims.UserID)
-		c.Set("username", claims.Username)
-		c.Set("userType", claims.UserType)
+// authorize loads the user's permissions, limited to scopes when they are
+// given, and sets the user information in the context
+func authorize(c *gin.Context, db *mongo.Database, user models.User, scopes []string) {
+	// Permissions come from the user's current roles, so role changes
+	// apply without logging in again
+	roles := user.RoleNames()
+	permissions, err := loadPermissions(context.Background(), db, roles)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		c.Abort()
+		return
+	}
+	if scopes != nil {
+		scoped := make(map[string]bool)
+		for _, scope := range scopes {
+			if permissions[scope] {
+				scoped[scope] = true
+			}
+		}
+		permissions = scoped
+	}
 
-		c.Next()
+	// Set user information in the context
+	c.Set("userId", user.ID)
+	c.Set("username", user.Username)
+	c.Set("userType", user.UserType)
+	c.Set("roles", roles)
+	c.Set("permissions", permissions)
+	if user.DepartmentID != nil {
+		c.Set("departmentId", *user.DepartmentID)
 	}
+
+	c.Next()
 }
 
-// RoleMiddleware checks if the user has the required role
-func RoleMiddleware(roles ...string) gin.HandlerFunc {
+// SessionMiddleware refuses requests authenticated with an API key, for
+// endpoints that manage the login session or credentials themselves
+func SessionMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userType, exists := c.Get("userType")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User type not found in context"})
+		if _, exists := c.Get("sessionId"); !exists {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint cannot be used with an API key"})
 			c.Abort()
 			return
 		}
+		c.Next()
+	}
+}
 
-		userTypeStr := userType.(string)
-		authorized := false
-		for _, role := range roles {
-			if userTypeStr == role {
-				authorized = true
-				break
-			}
+// loadPermissions returns the union of the permissions granted by the named roles
+func loadPermissions(ctx context.Context, db *mongo.Database, roles []string) (map[string]bool, error) {
+	cursor, err := db.Collection("roles").Find(ctx,
+		bson.M{"name": bson.M{"$in": roles}},
+		options.Find().SetProjection(bson.M{"permissions": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var found []models.Role
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+
+	permissions := make(map[string]bool)
+	for _, role := range found {
+		for _, permission := range role.Permissions {
+			permissions[permission] = true
 		}
+	}
+	return permissions, nil
+}
 
-		if !authorized {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+// HasPermission reports whether the authenticated user holds the permission
+func HasPermission(c *gin.Context, permission string) bool {
+	permissions, exists := c.Get("permissions")
+	if !exists {
+		return false
+	}
+	return permissions.(map[string]bool)[permission]
+}
+
+// PermissionMiddleware checks that the user holds every listed permission
+func PermissionMiddleware(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, exists := c.Get("permissions"); !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Permissions not found in context"})
 			c.Abort()
 			return
 		}
 
+		for _, permission := range permissions {
+			if !HasPermission(c, permission) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+				c.Abort()
+				return
+			}
+		}
+
 		c.Next()
 	}
 }
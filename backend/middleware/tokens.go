@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"classscheduling/token"
+)
+
+// ChallengeTokenTTL is how long the user has to complete the second step of login
+const ChallengeTokenTTL = 5 * time.Minute
+
+// tokens issues and validates the server's JWTs; set by InitTokens
+var tokens *token.Manager
+
+// productionMode reports whether APP_ENV is production
+func productionMode() bool {
+	return os.Getenv("APP_ENV") == "production"
+}
+
+// KeyRetention returns how long a replaced key still verifies tokens, long
+// enough for every token it signed to expire. Override with JWT_KEY_RETENTION.
+func KeyRetention() time.Duration {
+	fallback := AccessTokenTTL()
+	if ChallengeTokenTTL > fallback {
+		fallback = ChallengeTokenTTL
+	}
+	return durationFromEnv("JWT_KEY_RETENTION", fallback+time.Minute)
+}
+
+// KeyRotationInterval returns how often a new signing key is generated, 30
+// days unless overridden with JWT_KEY_ROTATION. Set it to "off" to only
+// rotate keys by hand.
+func KeyRotationInterval() time.Duration {
+	if os.Getenv("JWT_KEY_ROTATION") == "off" {
+		return 0
+	}
+	return durationFromEnv("JWT_KEY_ROTATION", 30*24*time.Hour)
+}
+
+// TokenConfig returns the issuer, audience and clock skew for our tokens from
+// JWT_ISSUER, JWT_AUDIENCE and JWT_CLOCK_SKEW
+func TokenConfig() token.Config {
+	issuer := os.Getenv("JWT_ISSUER")
+	if issuer == "" {
+		issuer = "classscheduling"
+	}
+	audience := os.Getenv("JWT_AUDIENCE")
+	if audience == "" {
+		audience = "classscheduling-api"
+	}
+	return token.Config{
+		Issuer:    issuer,
+		Audience:  audience,
+		AccessTTL: AccessTokenTTL(),
+		ClockSkew: durationFromEnv("JWT_CLOCK_SKEW", 30*time.Second),
+	}
+}
+
+// InitTokens loads the signing keys from JWT_KEY_DIR (default "keys"). Outside
+// production a key is generated when the directory has none; in production
+// missing key material is an error so that servers never start with keys
+// nobody has provisioned.
+func InitTokens() error {
+	dir := os.Getenv("JWT_KEY_DIR")
+	if dir == "" {
+		dir = "keys"
+	}
+
+	keys, err := token.OpenKeyRing(dir, KeyRetention())
+	if err != nil {
+		return err
+	}
+	if keys.Len() == 0 {
+		if productionMode() {
+			return fmt.Errorf("no signing keys found in %s; generate one with openssl genpkey -algorithm RSA -out %s/<kid>.pem", dir, dir)
+		}
+		if err := keys.Rotate(); err != nil {
+			return err
+		}
+	}
+
+	tokens = token.NewManager(keys, TokenConfig())
+	return nil
+}
+
+// StartKeyRotation rotates the signing key every KeyRotationInterval
+func StartKeyRotation() {
+	tokens.Keys().StartRotation(KeyRotationInterval())
+}
+
+// JWKS serves the public keys that verify our tokens as a JSON Web Key Set
+func JWKS(c *gin.Context) {
+	// Let verifiers cache the set, but not past a rotation check
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": tokens.Keys().JWKS()})
+}
+
+// GenerateToken issues an access token for a session
+func GenerateToken(userID primitive.ObjectID, username, userType string, sessionID primitive.ObjectID) (string, error) {
+	return tokens.IssueAccess(userID, username, userType, sessionID)
+}
+
+// GenerateChallengeToken issues a short-lived token for the second step of login
+func GenerateChallengeToken(userID primitive.ObjectID, purpose string) (string, error) {
+	return tokens.IssueChallenge(userID, purpose, ChallengeTokenTTL)
+}
+
+// ParseChallengeToken validates a challenge token for the given purpose and
+// returns the user it was issued to
+func ParseChallengeToken(tokenString, purpose string) (primitive.ObjectID, error) {
+	return tokens.ParseChallenge(tokenString, purpose)
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Activity is an entry in the audit log shown on the admin dashboard
+type Activity struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Type        string              `bson:"type" json:"type"` // e.g. login_failed, account_locked, account_unlocked
+	UserID      *primitive.ObjectID `bson:"userId,omitempty" json:"userId,omitempty"`
+	Username    string              `bson:"username,omitempty" json:"username,omitempty"`
+	ActorID     *primitive.ObjectID `bson:"actorId,omitempty" json:"actorId,omitempty"`
+	IP          string              `bson:"ip,omitempty" json:"ip,omitempty"`
+	Description string              `bson:"description" json:"description"`
+	Timestamp   time.Time           `bson:"timestamp" json:"timestamp"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKeyPrefix starts every API key so that keys are recognisable in
+// requests and when leaked
+const APIKeyPrefix = "csk_"
+
+// APIKey lets an automated client act as a user, or as a service account,
+// with a subset of its permissions. Only the key's hash is stored.
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID `bson:"userId" json:"userId"`
+	Name       string             `bson:"name" json:"name"`
+	Prefix     string             `bson:"prefix" json:"prefix"` // first characters of the key, to tell keys apart
+	KeyHash    string             `bson:"keyHash" json:"-"`
+	Scopes     []string           `bson:"scopes" json:"scopes"` // permissions the key may use
+	ExpiresAt  *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time         `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+	LastUsedIP string             `bson:"lastUsedIp,omitempty" json:"lastUsedIp,omitempty"`
+	RevokedAt  *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	CreatedBy  primitive.ObjectID `bson:"createdBy" json:"createdBy"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
@@ -9,13 +9,18 @@ import (
 type Class struct {
 	ID            primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
 	Name          string               `bson:"name" json:"name"`
+	Code          string               `bson:"code,omitempty" json:"code,omitempty"` // short unique code used in imports, e.g. CS101-A
 	FacultyID     primitive.ObjectID   `bson:"facultyId" json:"facultyId"`
 	Faculty       *User                `bson:"-" json:"faculty,omitempty"`
 	Schedule      string               `bson:"schedule" json:"schedule"`
+	Term          string               `bson:"term,omitempty" json:"term,omitempty"` // e.g. 2025-fall
+	DepartmentID  *primitive.ObjectID  `bson:"departmentId,omitempty" json:"departmentId,omitempty"`
 	Capacity      int                  `bson:"capacity" json:"capacity"`
 	Enrolled      []primitive.ObjectID `bson:"enrolled" json:"enrolled,omitempty"`
 	EnrolledCount int                  `bson:"enrolledCount" json:"enrolledCount"`
-	Status        string               `bson:"status" json:"status"` // active, cancelled, completed
+	Status        string               `bson:"status" json:"status"`                           // active, cancelled, completed
+	DeletedAt     *time.Time           `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"` // soft deletion, see User
+	DeletedBy     *primitive.ObjectID  `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
 	CreatedAt     time.Time            `bson:"createdAt" json:"createdAt"`
 	UpdatedAt     time.Time            `bson:"updatedAt" json:"updatedAt"`
 }
@@ -37,19 +42,32 @@ type Performance struct {
 	AssessmentName string             `bson:"assessmentName" json:"assessmentName"`
 	Score          float64            `bson:"score" json:"score"`
 	TotalMarks     float64            `bson:"totalMarks" json:"totalMarks"`
+	Weight         float64            `bson:"weight,omitempty" json:"weight,omitempty"` // relative weight in the final grade, 1 if unset
 	Date           time.Time          `bson:"date" json:"date"`
 	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
 	UpdatedAt      time.Time          `bson:"updatedAt" json:"updatedAt"`
 }
 
 type Remark struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	ClassID   primitive.ObjectID `bson:"classId" json:"classId"`
-	StudentID primitive.ObjectID `bson:"studentId" json:"studentId"`
-	FacultyID primitive.ObjectID `bson:"facultyId" json:"facultyId"`
-	Content   string             `bson:"content" json:"content"`
-	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
-	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ClassID        primitive.ObjectID `bson:"classId" json:"classId"`
+	StudentID      primitive.ObjectID `bson:"studentId" json:"studentId"`
+	FacultyID      primitive.ObjectID `bson:"facultyId" json:"facultyId"`
+	Content        string             `bson:"content" json:"content"`
+	Category       string             `bson:"category" json:"category"`     // academic, behaviour, commendation
+	Visibility     string             `bson:"visibility" json:"visibility"` // student, guardian (student and guardians), faculty
+	Replies        []RemarkReply      `bson:"replies" json:"replies"`
+	AcknowledgedAt *time.Time         `bson:"acknowledgedAt,omitempty" json:"acknowledgedAt,omitempty"`
+	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt      time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+type RemarkReply struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	AuthorID   primitive.ObjectID `bson:"authorId" json:"authorId"`
+	AuthorType string             `bson:"authorType" json:"authorType"` // student or faculty
+	Content    string             `bson:"content" json:"content"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
 }
 
 type ScheduleChange struct {
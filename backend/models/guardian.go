@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GuardianLink gives a guardian read access to a student's records. A link
+// requested by a guardian stays pending until the student or an admin
+// approves it; either side can revoke it later. There is at most one link
+// per guardian and student.
+type GuardianLink struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	GuardianID   primitive.ObjectID  `bson:"guardianId" json:"guardianId"`
+	StudentID    primitive.ObjectID  `bson:"studentId" json:"studentId"`
+	Guardian     *User               `bson:"-" json:"guardian,omitempty"`
+	Student      *User               `bson:"-" json:"student,omitempty"`
+	Relationship string              `bson:"relationship,omitempty" json:"relationship,omitempty"` // e.g. mother, father, guardian
+	Status       string              `bson:"status" json:"status"`                                 // pending, active, declined, revoked
+	RequestedBy  primitive.ObjectID  `bson:"requestedBy" json:"requestedBy"`
+	ApprovedBy   *primitive.ObjectID `bson:"approvedBy,omitempty" json:"approvedBy,omitempty"`
+	ApprovedAt   *time.Time          `bson:"approvedAt,omitempty" json:"approvedAt,omitempty"`
+	RevokedBy    *primitive.ObjectID `bson:"revokedBy,omitempty" json:"revokedBy,omitempty"`
+	RevokedAt    *time.Time          `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	// NotifyAttendance opts the guardian in to emails when the student's
+	// attendance in a class falls below the target
+	NotifyAttendance bool       `bson:"notifyAttendance" json:"notifyAttendance"`
+	LastNotifiedAt   *time.Time `bson:"lastNotifiedAt,omitempty" json:"lastNotifiedAt,omitempty"`
+	CreatedAt        time.Time  `bson:"createdAt" json:"createdAt"`
+	UpdatedAt        time.Time  `bson:"updatedAt" json:"updatedAt"`
+}
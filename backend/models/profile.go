@@ -0,0 +1,152 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ProfileField describes a profile field: the user types it applies to and
+// whether users can change it themselves. Fields users cannot change are
+// kept by user managers, e.g. a student's program comes from the registrar.
+type ProfileField struct {
+	UserTypes []string `json:"userTypes"`
+	SelfEdit  bool     `json:"selfEdit"`
+	MaxLength int      `json:"maxLength,omitempty"`
+}
+
+// ProfileFields are the profile fields by JSON name. Faculty departments are
+// the departmentId every user has.
+var ProfileFields = map[string]ProfileField{
+	"phone":       {UserTypes: []string{"student", "faculty", "admin", "guardian"}, SelfEdit: true, MaxLength: 20},
+	"program":     {UserTypes: []string{"student"}, MaxLength: 100},
+	"year":        {UserTypes: []string{"student"}},
+	"section":     {UserTypes: []string{"student"}, MaxLength: 50},
+	"designation": {UserTypes: []string{"faculty"}, MaxLength: 100},
+	"office":      {UserTypes: []string{"faculty"}, SelfEdit: true, MaxLength: 100},
+}
+
+// MaxStudyYear is the highest year of study a student can be in
+const MaxStudyYear = 10
+
+// phonePattern allows an optional leading + and digits with the usual
+// separators
+var phonePattern = regexp.MustCompile(`^\+?[0-9][0-9 ()-]*[0-9]$`)
+
+// AppliesTo reports whether the field is part of the given user type's
+// profile
+func (f ProfileField) AppliesTo(userType string) bool {
+	for _, t := range f.UserTypes {
+		if t == userType {
+			return true
+		}
+	}
+	return false
+}
+
+// ProfileInput is a change to profile fields; nil fields are left alone and
+// empty strings clear a field
+type ProfileInput struct {
+	Phone       *string `json:"phone"`
+	Program     *string `json:"program"`
+	Year        *int    `json:"year"`
+	Section     *string `json:"section"`
+	Designation *string `json:"designation"`
+	Office      *string `json:"office"`
+}
+
+// ProfileError is a profile change that is not allowed. Forbidden is set
+// when the user may not change the field rather than the value being invalid.
+type ProfileError struct {
+	Field     string
+	Message   string
+	Forbidden bool
+}
+
+func (e *ProfileError) Error() string {
+	return e.Message
+}
+
+// Changes validates the input for a user of the given type and returns the
+// fields to set and unset. With self set only fields users can change
+// themselves are allowed.
+func (p ProfileInput) Changes(userType string, self bool) (set, unset map[string]interface{}, err error) {
+	set, unset = map[string]interface{}{}, map[string]interface{}{}
+	strs := []struct {
+		name  string
+		value *string
+	}{
+		{"phone", p.Phone},
+		{"program", p.Program},
+		{"section", p.Section},
+		{"designation", p.Designation},
+		{"office", p.Office},
+	}
+	// Fields that do not apply can still be cleared, e.g. after a change of
+	// user type
+	check := func(name string, clearing bool) error {
+		field := ProfileFields[name]
+		if !clearing && !field.AppliesTo(userType) {
+			return &ProfileError{Field: name, Message: fmt.Sprintf("%s does not apply to %s accounts", name, userType)}
+		}
+		if self && !field.SelfEdit {
+			return &ProfileError{Field: name, Message: fmt.Sprintf("Only an administrator can change %s", name), Forbidden: true}
+		}
+		return nil
+	}
+
+	for _, str := range strs {
+		name, value := str.name, str.value
+		if value == nil {
+			continue
+		}
+		v := strings.TrimSpace(*value)
+		if err := check(name, v == ""); err != nil {
+			return nil, nil, err
+		}
+		if v == "" {
+			unset[name] = ""
+			continue
+		}
+		if max := ProfileFields[name].MaxLength; max > 0 && len([]rune(v)) > max {
+			return nil, nil, &ProfileError{Field: name, Message: fmt.Sprintf("%s must be at most %d characters", name, max)}
+		}
+		if strings.IndexFunc(v, unicode.IsControl) >= 0 {
+			return nil, nil, &ProfileError{Field: name, Message: fmt.Sprintf("%s contains invalid characters", name)}
+		}
+		if name == "phone" && !validPhone(v) {
+			return nil, nil, &ProfileError{Field: name, Message: "Invalid phone number"}
+		}
+		set[name] = v
+	}
+
+	if p.Year != nil {
+		if err := check("year", *p.Year == 0); err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case *p.Year == 0:
+			unset["year"] = ""
+		case *p.Year < 1 || *p.Year > MaxStudyYear:
+			return nil, nil, &ProfileError{Field: "year", Message: fmt.Sprintf("year must be between 1 and %d", MaxStudyYear)}
+		default:
+			set["year"] = *p.Year
+		}
+	}
+	return set, unset, nil
+}
+
+// validPhone accepts international numbers of 7 to 15 digits
+func validPhone(phone string) bool {
+	if !phonePattern.MatchString(phone) {
+		return false
+	}
+	digits := 0
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	return digits >= 7 && digits <= 15
+}
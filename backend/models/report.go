@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReportJob is an export of a named admin report that runs in the
+// background. Its output is stored in report_files in chunks and both
+// expire together.
+type ReportJob struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Report       string              `bson:"report" json:"report"`
+	Format       string              `bson:"format" json:"format"` // csv, xlsx or pdf
+	Params       map[string]string   `bson:"params" json:"params"`
+	DepartmentID *primitive.ObjectID `bson:"departmentId,omitempty" json:"departmentId,omitempty"` // scope of the requester
+	RequestedBy  primitive.ObjectID  `bson:"requestedBy" json:"requestedBy"`
+	Status       string              `bson:"status" json:"status"` // pending, running, completed, failed
+	Error        string              `bson:"error,omitempty" json:"error,omitempty"`
+	Rows         int                 `bson:"rows" json:"rows"`
+	Size         int64               `bson:"size" json:"size"`
+	Filename     string              `bson:"filename,omitempty" json:"filename,omitempty"`
+	CreatedAt    time.Time           `bson:"createdAt" json:"createdAt"`
+	StartedAt    *time.Time          `bson:"startedAt,omitempty" json:"startedAt,omitempty"`
+	CompletedAt  *time.Time          `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+	ExpiresAt    time.Time           `bson:"expiresAt" json:"expiresAt"`
+}
+
+// ReportFile is one chunk of a report job's output
+type ReportFile struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	JobID     primitive.ObjectID `bson:"jobId"`
+	N         int                `bson:"n"`
+	Data      []byte             `bson:"data"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+}
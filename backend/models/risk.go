@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RiskFactor explains one contribution to a student's risk score
+type RiskFactor struct {
+	Name        string  `bson:"name" json:"name"` // attendance, grade_average, grade_trend, missing_assessments
+	Value       float64 `bson:"value" json:"value"`
+	Points      float64 `bson:"points" json:"points"`
+	Description string  `bson:"description" json:"description"`
+}
+
+type RiskIntervention struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	FacultyID primitive.ObjectID `bson:"facultyId" json:"facultyId"`
+	Type      string             `bson:"type" json:"type"` // meeting, email, referral, other
+	Note      string             `bson:"note" json:"note"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+type RiskFlag struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	StudentID      primitive.ObjectID  `bson:"studentId" json:"studentId"`
+	ClassID        primitive.ObjectID  `bson:"classId" json:"classId"`
+	Score          float64             `bson:"score" json:"score"`
+	Level          string              `bson:"level" json:"level"`   // low, medium, high
+	Status         string              `bson:"status" json:"status"` // open, acknowledged, resolved
+	Factors        []RiskFactor        `bson:"factors" json:"factors"`
+	AcknowledgedBy *primitive.ObjectID `bson:"acknowledgedBy,omitempty" json:"acknowledgedBy,omitempty"`
+	AcknowledgedAt *time.Time          `bson:"acknowledgedAt,omitempty" json:"acknowledgedAt,omitempty"`
+	Interventions  []RiskIntervention  `bson:"interventions" json:"interventions"`
+	EvaluatedAt    time.Time           `bson:"evaluatedAt" json:"evaluatedAt"`
+	CreatedAt      time.Time           `bson:"createdAt" json:"createdAt"`
+	UpdatedAt      time.Time           `bson:"updatedAt" json:"updatedAt"`
+}
+
+// BeforeSave updates timestamps for RiskFlag
+func (r *RiskFlag) BeforeSave() {
+	now := time.Now()
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = now
+	}
+	r.UpdatedAt = now
+}
@@ -0,0 +1,187 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Permissions that can be granted to roles
+const (
+	PermUsersView         = "users.view"
+	PermUsersManage       = "users.manage"
+	PermRolesManage       = "roles.manage"
+	PermSecurityManage    = "security.manage"
+	PermClassCreate       = "class.create"
+	PermClassEdit         = "class.edit"
+	PermClassDelete       = "class.delete"
+	PermClassTeach        = "class.teach"
+	PermClassEnroll       = "class.enroll"
+	PermAttendanceMark    = "attendance.mark"
+	PermAttendanceView    = "attendance.view"
+	PermGradesEdit        = "grades.edit"
+	PermGradesView        = "grades.view"
+	PermRemarksManage     = "remarks.manage"
+	PermRemarksView       = "remarks.view"
+	PermRiskManage        = "risk.manage"
+	PermRiskViewAll       = "risk.view_all"
+	PermReportCardsView   = "reportcards.view"
+	PermReportCardsAll    = "reportcards.generate"
+	PermStatisticsView    = "statistics.view"
+	PermActivityView      = "activity.view"
+	PermCalendarManage    = "calendar.manage"
+	PermDepartmentsManage = "departments.manage"
+	PermEnrollmentsManage = "enrollments.manage"
+	PermAPIKeysManage     = "apikeys.manage"
+	PermGuardianView      = "guardian.view"
+	PermGuardianConsent   = "guardian.consent"
+	PermGuardiansManage   = "guardians.manage"
+	PermDataRepair        = "data.repair"
+	PermReportsExport     = "reports.export"
+)
+
+// Permissions describes every known permission
+var Permissions = map[string]string{
+	PermUsersView:         "View user accounts",
+	PermUsersManage:       "Create, update, disable, unlock and delete user accounts",
+	PermRolesManage:       "Manage roles and assign them to users",
+	PermSecurityManage:    "Manage security settings such as the two-factor policy",
+	PermClassCreate:       "Create classes",
+	PermClassEdit:         "Edit classes",
+	PermClassDelete:       "Delete classes",
+	PermClassTeach:        "View and change the schedule of classes one teaches",
+	PermClassEnroll:       "Enroll in and drop classes",
+	PermAttendanceMark:    "Mark attendance for classes one teaches",
+	PermAttendanceView:    "View one's own attendance",
+	PermGradesEdit:        "Record performance for classes one teaches",
+	PermGradesView:        "View one's own performance",
+	PermRemarksManage:     "Write and manage remarks on students",
+	PermRemarksView:       "View and reply to remarks about oneself",
+	PermRiskManage:        "Review at-risk flags for classes one teaches",
+	PermRiskViewAll:       "View and evaluate at-risk flags for all classes",
+	PermReportCardsView:   "Download one's own report card",
+	PermReportCardsAll:    "Generate report cards for any student",
+	PermStatisticsView:    "View system statistics",
+	PermActivityView:      "View the activity log",
+	PermCalendarManage:    "Manage holidays and the timetable",
+	PermDepartmentsManage: "Create, update and delete departments",
+	PermEnrollmentsManage: "Enroll students in and remove them from any class",
+	PermAPIKeysManage:     "Manage service accounts and revoke any API key",
+	PermGuardianView:      "Request links to students and view their attendance, performance and remarks",
+	PermGuardianConsent:   "Approve and revoke guardian access to one's records",
+	PermGuardiansManage:   "Link guardians to students and revoke their access",
+	PermDataRepair:        "Check the data for consistency problems and repair them",
+	PermReportsExport:     "Export admin reports as CSV, XLSX or PDF",
+}
+
+// UserTypes are the kinds of account a user can have. The type decides which
+// dashboard a user sees and whose data they own; what they may do is decided
+// by their roles.
+var UserTypes = []string{"student", "faculty", "admin", "guardian"}
+
+// UserTypeService is the user type of service accounts. It is not one of
+// UserTypes: service accounts cannot sign up or log in with a password and
+// only authenticate with API keys.
+const UserTypeService = "service"
+
+// ValidUserType reports whether t is one of UserTypes
+func ValidUserType(t string) bool {
+	for _, userType := range UserTypes {
+		if userType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Role bundles a set of permissions under a name
+type Role struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name        string             `bson:"name" json:"name"`
+	Description string             `bson:"description" json:"description"`
+	Permissions []string           `bson:"permissions" json:"permissions"`
+	System      bool               `bson:"system" json:"system"` // seeded roles cannot be renamed or deleted
+	// SeededPermissions records the defaults last applied at startup so that
+	// permissions added in later releases reach existing roles, while ones an
+	// admin removed stay removed
+	SeededPermissions []string  `bson:"seededPermissions,omitempty" json:"-"`
+	CreatedAt         time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt         time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// DefaultRoles returns the roles seeded at startup, one per user type and one
+// for service accounts
+func DefaultRoles() []Role {
+	return []Role{
+		{
+			Name:        "student",
+			Description: "Students enrolled in classes",
+			Permissions: []string{
+				PermClassEnroll,
+				PermAttendanceView,
+				PermGradesView,
+				PermRemarksView,
+				PermReportCardsView,
+				PermGuardianConsent,
+			},
+			System: true,
+		},
+		{
+			Name:        "faculty",
+			Description: "Faculty teaching classes",
+			Permissions: []string{
+				PermClassEdit,
+				PermClassTeach,
+				PermAttendanceMark,
+				PermGradesEdit,
+				PermRemarksManage,
+				PermRiskManage,
+			},
+			System: true,
+		},
+		{
+			Name:        "admin",
+			Description: "Administrators with full access",
+			Permissions: []string{
+				PermUsersView,
+				PermUsersManage,
+				PermRolesManage,
+				PermSecurityManage,
+				PermClassCreate,
+				PermClassEdit,
+				PermClassDelete,
+				PermRiskViewAll,
+				PermReportCardsAll,
+				PermStatisticsView,
+				PermActivityView,
+				PermCalendarManage,
+				PermDepartmentsManage,
+				PermEnrollmentsManage,
+				PermAPIKeysManage,
+				PermGuardiansManage,
+				PermDataRepair,
+				PermReportsExport,
+			},
+			System: true,
+		},
+		{
+			Name:        "guardian",
+			Description: "Parents and guardians with read access to linked students",
+			Permissions: []string{
+				PermGuardianView,
+			},
+			System: true,
+		},
+		{
+			Name:        "service",
+			Description: "Service accounts used by integrations such as the registrar sync",
+			Permissions: []string{
+				PermUsersView,
+				PermClassCreate,
+				PermClassEdit,
+				PermEnrollmentsManage,
+			},
+			System: true,
+		},
+	}
+}
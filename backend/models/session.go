@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session is a login on one device. Access tokens carry the session ID so
+// that revoking the session invalidates them immediately.
+type Session struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID `bson:"userId" json:"userId"`
+	UserAgent  string             `bson:"userAgent" json:"userAgent"`
+	IP         string             `bson:"ip" json:"ip"`
+	ExpiresAt  time.Time          `bson:"expiresAt" json:"expiresAt"`
+	RevokedAt  *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	LastUsedAt time.Time          `bson:"lastUsedAt" json:"lastUsedAt"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt  time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// RefreshToken is a single-use token that can be exchanged for a new access
+// token. Only the SHA-256 hash of the token is stored.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SessionID primitive.ObjectID `bson:"sessionId" json:"sessionId"`
+	UserID    primitive.ObjectID `bson:"userId" json:"userId"`
+	TokenHash string             `bson:"tokenHash" json:"-"`
+	ExpiresAt time.Time          `bson:"expiresAt" json:"expiresAt"`
+	UsedAt    *time.Time         `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// BeforeSave updates timestamps for Session
+func (s *Session) BeforeSave() {
+	now := time.Now()
+	if s.CreatedAt.IsZero() {
+		s.CreatedAt = now
+	}
+	s.UpdatedAt = now
+}
+
+// UserToken is a single-use, expiring token emailed to a user, such as a
+// password reset or email verification link. Only its hash is stored.
+type UserToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"userId" json:"userId"`
+	Type      string             `bson:"type" json:"type"` // password_reset, email_verification, sso_login
+	TokenHash string             `bson:"tokenHash" json:"-"`
+	ExpiresAt time.Time          `bson:"expiresAt" json:"expiresAt"`
+	UsedAt    *time.Time         `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// SSOState tracks a single sign-on attempt between the redirect to the
+// identity provider and its callback. It is keyed by the hash of the state
+// parameter and holds the secrets needed to complete the exchange.
+type SSOState struct {
+	ID           string    `bson:"_id"`
+	Provider     string    `bson:"provider"`
+	Nonce        string    `bson:"nonce"`
+	CodeVerifier string    `bson:"codeVerifier"`
+	ExpiresAt    time.Time `bson:"expiresAt"`
+	CreatedAt    time.Time `bson:"createdAt"`
+}
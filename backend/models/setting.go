@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// MFAPolicy lists the user types that must use two-factor authentication.
+// It is stored in the settings collection under the ID "mfa_policy".
+type MFAPolicy struct {
+	ID            string    `bson:"_id" json:"-"`
+	RequiredRoles []string  `bson:"requiredRoles" json:"requiredRoles"`
+	UpdatedAt     time.Time `bson:"updatedAt" json:"updatedAt"`
+}
@@ -8,14 +8,56 @@ import (
 )
 
 type User struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Username   string             `bson:"username" json:"username"`
-	Email      string             `bson:"email" json:"email"`
-	Password   string             `bson:"password" json:"-"`
-	UserType   string             `bson:"userType" json:"userType"` // student, faculty, or admin
-	RollNumber string             `bson:"rollNumber" json:"rollNumber"`
-	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
-	UpdatedAt  time.Time          `bson:"updatedAt" json:"updatedAt"`
+	ID              primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Username        string              `bson:"username" json:"username"`
+	Email           string              `bson:"email" json:"email"`
+	Password        string              `bson:"password" json:"-"`
+	UserType        string              `bson:"userType" json:"userType"` // student, faculty, admin, guardian, or service
+	RollNumber      string              `bson:"rollNumber" json:"rollNumber"`
+	Roles           []string            `bson:"roles,omitempty" json:"roles,omitempty"`
+	Description     string              `bson:"description,omitempty" json:"description,omitempty"` // what a service account is for
+	DepartmentID    *primitive.ObjectID `bson:"departmentId,omitempty" json:"departmentId,omitempty"`
+	Disabled        bool                `bson:"disabled,omitempty" json:"disabled,omitempty"`
+	EmailVerified   bool                `bson:"emailVerified" json:"emailVerified"`
+	EmailVerifiedAt *time.Time          `bson:"emailVerifiedAt,omitempty" json:"emailVerifiedAt,omitempty"`
+	FailedLogins    int                 `bson:"failedLogins,omitempty" json:"failedLogins,omitempty"`
+	LockedUntil     *time.Time          `bson:"lockedUntil,omitempty" json:"lockedUntil,omitempty"`
+
+	// Profile; which fields a user has depends on their type, see
+	// ProfileFields
+	Phone       string `bson:"phone,omitempty" json:"phone,omitempty"`
+	Program     string `bson:"program,omitempty" json:"program,omitempty"`         // students, e.g. BSc Computer Science
+	Year        int    `bson:"year,omitempty" json:"year,omitempty"`               // students, year of study
+	Section     string `bson:"section,omitempty" json:"section,omitempty"`         // students, section or batch
+	Designation string `bson:"designation,omitempty" json:"designation,omitempty"` // faculty, e.g. Associate Professor
+	Office      string `bson:"office,omitempty" json:"office,omitempty"`           // faculty
+	Avatar      string `bson:"avatar,omitempty" json:"avatar,omitempty"`           // URL path of the uploaded picture
+
+	// Signup approval; accounts without a status are approved
+	ApprovalStatus string              `bson:"approvalStatus,omitempty" json:"approvalStatus,omitempty"` // pending, approved, rejected
+	ApprovalReason string              `bson:"approvalReason,omitempty" json:"approvalReason,omitempty"`
+	ReviewedBy     *primitive.ObjectID `bson:"reviewedBy,omitempty" json:"reviewedBy,omitempty"`
+	ReviewedAt     *time.Time          `bson:"reviewedAt,omitempty" json:"reviewedAt,omitempty"`
+
+	// Single sign-on; set when the account is linked to an identity provider
+	SSOProvider string `bson:"ssoProvider,omitempty" json:"ssoProvider,omitempty"`
+	SSOSubject  string `bson:"ssoSubject,omitempty" json:"-"`
+
+	// Two-factor authentication
+	TOTPEnabled       bool     `bson:"totpEnabled,omitempty" json:"totpEnabled"`
+	TOTPSecret        string   `bson:"totpSecret,omitempty" json:"-"`
+	TOTPPendingSecret string   `bson:"totpPendingSecret,omitempty" json:"-"`
+	TOTPLastStep      int64    `bson:"totpLastStep,omitempty" json:"-"`
+	RecoveryCodes     []string `bson:"recoveryCodes,omitempty" json:"-"` // SHA-256 hashes
+
+	// Soft deletion; deleted users are left out of every query until an
+	// admin restores or purges them
+	DeletedAt           *time.Time           `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy           *primitive.ObjectID  `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+	ArchivedEnrollments []primitive.ObjectID `bson:"archivedEnrollments,omitempty" json:"-"` // classes to re-enroll a student in on restore
+
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
 }
 
 // HashPassword hashes the user's password before saving
@@ -34,6 +76,15 @@ func (u *User) ValidatePassword(password string) bool {
 	return err == nil
 }
 
+// RoleNames returns the roles the user holds. Users who have not been
+// assigned roles explicitly hold the role named after their user type.
+func (u *User) RoleNames() []string {
+	if len(u.Roles) == 0 {
+		return []string{u.UserType}
+	}
+	return u.Roles
+}
+
 // BeforeSave updates timestamps before saving to database
 func (u *User) BeforeSave() {
 	now := time.Now()
@@ -0,0 +1,200 @@
+// Package pdf is a minimal PDF writer used for generated documents such as
+// report cards. It supports text in the standard Helvetica fonts, lines and
+// filled rectangles on A4 pages, which is all our documents need, and has no
+// dependencies outside the standard library.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A4 page size in points
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+// Document is a PDF being built page by page. Coordinates passed to the
+// drawing methods are in points from the top-left corner of the page.
+type Document struct {
+	title string
+	pages []*bytes.Buffer
+	cur   *bytes.Buffer
+}
+
+// New creates an empty document with the given title
+func New(title string) *Document {
+	return &Document{title: title}
+}
+
+// AddPage starts a new page; drawing methods apply to it until the next call
+func (d *Document) AddPage() {
+	d.cur = &bytes.Buffer{}
+	d.pages = append(d.pages, d.cur)
+}
+
+// PageCount returns the number of pages added so far
+func (d *Document) PageCount() int {
+	return len(d.pages)
+}
+
+func (d *Document) page() *bytes.Buffer {
+	if d.cur == nil {
+		d.AddPage()
+	}
+	return d.cur
+}
+
+// Text draws a single line of text with its baseline at y
+func (d *Document) Text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.page(), "BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, PageHeight-y, escape(s))
+}
+
+// TextRight draws text so that it ends at x
+func (d *Document) TextRight(x, y, size float64, bold bool, s string) {
+	d.Text(x-TextWidth(s, size, bold), y, size, bold, s)
+}
+
+// Line draws a line between two points
+func (d *Document) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(d.page(), "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, PageHeight-y1, x2, PageHeight-y2)
+}
+
+// FillRect draws a rectangle filled with a gray level between 0 (black) and 1 (white)
+func (d *Document) FillRect(x, y, w, h, gray float64) {
+	fmt.Fprintf(d.page(), "q %.3f g %.2f %.2f %.2f %.2f re f Q\n", gray, x, PageHeight-y-h, w, h)
+}
+
+// Bytes renders the document
+func (d *Document) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := d.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Write renders the document to w
+func (d *Document) Write(w io.Writer) error {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+
+	var out bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1-4 are the catalog, page tree and fonts; each page then
+	// takes two objects, the page itself and its content stream.
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+i*2)
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, content := range d.pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			PageWidth, PageHeight, 6+i*2))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+	obj(fmt.Sprintf("<< /Title (%s) /Producer (classscheduling) >>", escape(d.title)))
+	info := len(offsets)
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, info, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// escape converts s to a WinAnsi PDF string literal body
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n' || r == '\r' || r == '\t':
+			b.WriteByte(' ')
+		case r < 32:
+		case r < 127:
+			b.WriteRune(r)
+		case r < 256:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// helveticaWidths are the glyph widths of Helvetica for ASCII 32-126, in
+// thousandths of the font size
+var helveticaWidths = [...]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// TextWidth estimates the width of s in points
+func TextWidth(s string, size float64, bold bool) float64 {
+	var units int
+	for _, r := range s {
+		if r >= 32 && r <= 126 {
+			units += helveticaWidths[r-32]
+		} else {
+			units += 556
+		}
+	}
+	width := float64(units) * size / 1000
+	if bold {
+		// Helvetica-Bold is on average about 6% wider
+		width *= 1.06
+	}
+	return width
+}
+
+// Wrap splits s into lines that fit within maxWidth
+func Wrap(s string, size, maxWidth float64, bold bool) []string {
+	var lines []string
+	for _, para := range strings.Split(s, "\n") {
+		words := strings.Fields(para)
+		if len(words) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		line := words[0]
+		for _, word := range words[1:] {
+			if TextWidth(line+" "+word, size, bold) > maxWidth {
+				lines = append(lines, line)
+				line = word
+				continue
+			}
+			line += " " + word
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,114 @@
+// Package ratelimit counts hits per key in fixed time windows. The in-memory
+// backend is enough for a single instance; the Mongo backend shares counters
+// between instances behind a load balancer.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Limiter records hits against a key
+type Limiter interface {
+	// Allow records a hit for key and reports whether the key is still
+	// within limit for the current window, along with the time until the
+	// window resets
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error)
+}
+
+// FromEnv returns the backend selected by RATE_LIMIT_BACKEND: "mongo" or
+// "memory" (the default)
+func FromEnv(db *mongo.Database) Limiter {
+	if os.Getenv("RATE_LIMIT_BACKEND") == "mongo" {
+		return NewMongoLimiter(db)
+	}
+	return NewMemoryLimiter()
+}
+
+// bucket returns the start and end of the window containing now
+func bucket(now time.Time, window time.Duration) (time.Time, time.Time) {
+	start := now.Truncate(window)
+	return start, start.Add(window)
+}
+
+// MemoryLimiter keeps counters in process memory
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	hits    int
+}
+
+type memoryEntry struct {
+	count   int
+	resetAt time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{entries: make(map[string]*memoryEntry)}
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	now := time.Now()
+	_, end := bucket(now, window)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Sweep expired entries now and then so the map does not grow forever
+	l.hits++
+	if l.hits%1000 == 0 {
+		for k, e := range l.entries {
+			if !now.Before(e.resetAt) {
+				delete(l.entries, k)
+			}
+		}
+	}
+
+	e, ok := l.entries[key]
+	if !ok || !now.Before(e.resetAt) {
+		e = &memoryEntry{resetAt: end}
+		l.entries[key] = e
+	}
+	e.count++
+
+	return e.count <= limit, e.resetAt.Sub(now), nil
+}
+
+// MongoLimiter keeps counters in the rate_limits collection. Each window is
+// its own document, removed by a TTL index once the window has passed.
+type MongoLimiter struct {
+	coll *mongo.Collection
+}
+
+func NewMongoLimiter(db *mongo.Database) *MongoLimiter {
+	return &MongoLimiter{coll: db.Collection("rate_limits")}
+}
+
+func (l *MongoLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	now := time.Now()
+	start, end := bucket(now, window)
+
+	var doc struct {
+		Count int `bson:"count"`
+	}
+	err := l.coll.FindOneAndUpdate(ctx,
+		bson.M{"_id": fmt.Sprintf("%s:%d", key, start.Unix())},
+		bson.M{
+			"$inc":         bson.M{"count": 1},
+			"$setOnInsert": bson.M{"expiresAt": end},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&doc)
+	if err != nil {
+		return false, 0, err
+	}
+
+	return doc.Count <= limit, end.Sub(now), nil
+}
@@ -0,0 +1,54 @@
+package report
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+	"time"
+)
+
+// CSVCell keeps a spreadsheet from running a cell as a formula, by
+// prefixing values that start like one with an apostrophe
+func CSVCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// CSVValue undoes CSVCell, for reading back a file this package wrote
+func CSVValue(s string) string {
+	if len(s) > 1 && s[0] == '\'' {
+		if unescaped := s[1:]; CSVCell(unescaped) == s {
+			return unescaped
+		}
+	}
+	return s
+}
+
+// WriteCSV writes the column titles and rows as CSV. Times are RFC 3339 in
+// UTC.
+func WriteCSV(w io.Writer, t *Table) error {
+	out := csv.NewWriter(w)
+	header := make([]string, len(t.Columns))
+	for i, column := range t.Columns {
+		header[i] = CSVCell(column.Title)
+	}
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	record := make([]string, len(t.Columns))
+	for _, row := range t.Rows {
+		for i := range record {
+			record[i] = ""
+			if i < len(row) {
+				record[i] = CSVCell(text(row[i], time.RFC3339))
+			}
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
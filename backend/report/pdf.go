@@ -0,0 +1,107 @@
+package report
+
+import (
+	"fmt"
+	"io"
+
+	"classscheduling/pdf"
+)
+
+// Layout of PDF tables, in points
+const (
+	pdfMargin     = 40.0
+	pdfFontSize   = 7.5
+	pdfRowHeight  = 12.0
+	pdfCellIndent = 3.0
+)
+
+// WritePDF writes the table as an A4 document, repeating the column titles
+// on every page. Cells that do not fit their column are cut short.
+func WritePDF(w io.Writer, t *Table) error {
+	doc := pdf.New(t.Title)
+	left, right := pdfMargin, pdf.PageWidth-pdfMargin
+	bottom := pdf.PageHeight - pdfMargin - 20
+
+	var shares float64
+	for _, column := range t.Columns {
+		shares += columnShare(column)
+	}
+	widths := make([]float64, len(t.Columns))
+	for i, column := range t.Columns {
+		widths[i] = (right - left) * columnShare(column) / shares
+	}
+
+	var y float64
+	header := func() {
+		doc.FillRect(left, y, right-left, pdfRowHeight, 0.9)
+		x := left
+		for i, column := range t.Columns {
+			doc.Text(x+pdfCellIndent, y+pdfRowHeight-3.5, pdfFontSize, true, fit(column.Title, widths[i]-2*pdfCellIndent, true))
+			x += widths[i]
+		}
+		y += pdfRowHeight
+	}
+	page := func() {
+		doc.AddPage()
+		y = pdfMargin
+		doc.TextRight(right, pdf.PageHeight-pdfMargin+10, 7, false, fmt.Sprintf("Page %d", doc.PageCount()))
+	}
+
+	page()
+	doc.Text(left, y+12, 14, true, t.Title)
+	y += 20
+	if t.Subtitle != "" {
+		doc.Text(left, y+8, 9, false, t.Subtitle)
+		y += 14
+	}
+	if !t.Generated.IsZero() {
+		doc.Text(left, pdf.PageHeight-pdfMargin+10, 7, false, "Generated on "+t.Generated.UTC().Format("02 Jan 2006 15:04 MST"))
+	}
+	y += 6
+	header()
+
+	for _, row := range t.Rows {
+		if y+pdfRowHeight > bottom {
+			page()
+			header()
+		}
+		x := left
+		for i := range t.Columns {
+			if i < len(row) {
+				s := fit(text(row[i], "2006-01-02 15:04"), widths[i]-2*pdfCellIndent, false)
+				if numeric(row[i]) {
+					doc.TextRight(x+widths[i]-pdfCellIndent, y+pdfRowHeight-3.5, pdfFontSize, false, s)
+				} else {
+					doc.Text(x+pdfCellIndent, y+pdfRowHeight-3.5, pdfFontSize, false, s)
+				}
+			}
+			x += widths[i]
+		}
+		y += pdfRowHeight
+		doc.Line(left, y, right, y, 0.2)
+	}
+	if len(t.Rows) == 0 {
+		doc.Text(left+pdfCellIndent, y+pdfRowHeight-3.5, pdfFontSize, false, "No data")
+	}
+
+	return doc.Write(w)
+}
+
+func columnShare(column Column) float64 {
+	if column.Width > 0 {
+		return column.Width
+	}
+	return 1
+}
+
+// fit shortens s with an ellipsis so that it fits within width
+func fit(s string, width float64, bold bool) string {
+	if pdf.TextWidth(s, pdfFontSize, bold) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && pdf.TextWidth(string(runes)+"...", pdfFontSize, bold) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
@@ -0,0 +1,115 @@
+// Package report renders tabular admin reports as CSV, XLSX or PDF. A report
+// is built once as a Table and can then be written in any of the formats.
+// Like the pdf package, it has no dependencies outside the standard library
+// and this module.
+package report
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Formats are the formats a table can be rendered in
+var Formats = []string{"csv", "xlsx", "pdf"}
+
+// Column is a column of a table. Width is its share of the page width in
+// PDF output; columns without one get a share of 1.
+type Column struct {
+	Title string  `json:"title"`
+	Width float64 `json:"-"`
+}
+
+// Table is a report ready to be rendered. Cells hold strings, integers,
+// floats, booleans, times or nil; a nil *float64 is an empty cell.
+type Table struct {
+	Title     string          `json:"title"`
+	Subtitle  string          `json:"subtitle,omitempty"`
+	Columns   []Column        `json:"columns"`
+	Rows      [][]interface{} `json:"rows"`
+	Generated time.Time       `json:"generated"`
+}
+
+// ValidFormat reports whether format is one of Formats
+func ValidFormat(format string) bool {
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// ContentType returns the MIME type of a format
+func ContentType(format string) string {
+	switch format {
+	case "xlsx":
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case "pdf":
+		return "application/pdf"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Render writes the table to w in the given format
+func Render(w io.Writer, format string, t *Table) error {
+	switch format {
+	case "csv":
+		return WriteCSV(w, t)
+	case "xlsx":
+		return WriteXLSX(w, t)
+	case "pdf":
+		return WritePDF(w, t)
+	}
+	return fmt.Errorf("report: unknown format %q", format)
+}
+
+// text formats a cell for the text based formats, CSV and PDF
+func text(v interface{}, layout string) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case *float64:
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "yes"
+		}
+		return "no"
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.UTC().Format(layout)
+	case *time.Time:
+		if v == nil || v.IsZero() {
+			return ""
+		}
+		return v.UTC().Format(layout)
+	}
+	return fmt.Sprint(v)
+}
+
+// numeric reports whether a cell holds a number, which PDF output aligns
+// to the right
+func numeric(v interface{}) bool {
+	switch v := v.(type) {
+	case int, int64, float64:
+		return true
+	case *float64:
+		return v != nil
+	}
+	return false
+}
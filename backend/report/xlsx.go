@@ -0,0 +1,155 @@
+package report
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The fixed parts of a workbook with a single sheet. Cell style 1 is the
+// bold header and style 2 a date and time.
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/><Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/></Types>`
+
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`
+
+	xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts><fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills><borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders><cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs><cellXfs count="3"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/><xf numFmtId="22" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/></cellXfs></styleSheet>`
+
+	xlsxSheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews><sheetData>`
+
+	xlsxSheetEnd = `</sheetData></worksheet>`
+)
+
+// excelEpoch is day zero of spreadsheet date serials
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// WriteXLSX writes the table as a workbook with one sheet, the column titles
+// in a frozen first row. Numbers and times are stored as such, so they can
+// be sorted and summed; times are in UTC.
+func WriteXLSX(w io.Writer, t *Table) error {
+	z := zip.NewWriter(w)
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, escapeXML(sheetName(t.Title)))},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, part := range parts {
+		f, err := z.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return err
+		}
+	}
+
+	f, err := z.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	b := bufio.NewWriter(f)
+	b.WriteString(xlsxSheetStart)
+	header := make([]interface{}, len(t.Columns))
+	for i, column := range t.Columns {
+		header[i] = column.Title
+	}
+	writeXLSXRow(b, 1, header, 1)
+	for i, row := range t.Rows {
+		writeXLSXRow(b, i+2, row, 0)
+	}
+	b.WriteString(xlsxSheetEnd)
+	if err := b.Flush(); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+func writeXLSXRow(b *bufio.Writer, n int, row []interface{}, style int) {
+	fmt.Fprintf(b, `<row r="%d">`, n)
+	for i, v := range row {
+		ref := columnName(i) + strconv.Itoa(n)
+		if tm, ok := v.(*time.Time); ok {
+			v = nil
+			if tm != nil {
+				v = *tm
+			}
+		}
+		switch v := v.(type) {
+		case nil:
+		case int, int64, float64:
+			fmt.Fprintf(b, `<c r="%s"><v>%s</v></c>`, ref, text(v, ""))
+		case *float64:
+			if v != nil {
+				fmt.Fprintf(b, `<c r="%s"><v>%s</v></c>`, ref, text(v, ""))
+			}
+		case bool:
+			value := 0
+			if v {
+				value = 1
+			}
+			fmt.Fprintf(b, `<c r="%s" t="b"><v>%d</v></c>`, ref, value)
+		case time.Time:
+			if !v.IsZero() {
+				serial := v.UTC().Sub(excelEpoch).Hours() / 24
+				fmt.Fprintf(b, `<c r="%s" s="2"><v>%s</v></c>`, ref, strconv.FormatFloat(serial, 'f', -1, 64))
+			}
+		default:
+			fmt.Fprintf(b, `<c r="%s" t="inlineStr"`, ref)
+			if style != 0 {
+				fmt.Fprintf(b, ` s="%d"`, style)
+			}
+			fmt.Fprintf(b, `><is><t xml:space="preserve">%s</t></is></c>`, escapeXML(text(v, "")))
+		}
+	}
+	b.WriteString(`</row>`)
+}
+
+// columnName returns the letters of the zero-based column i: A, B, ... Z, AA
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// sheetName makes a title usable as a sheet name, which is at most 31
+// characters and cannot contain some punctuation
+func sheetName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '-'
+		}
+		return r
+	}, title)
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+	if strings.TrimSpace(name) == "" {
+		name = "Report"
+	}
+	return name
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -5,17 +5,36 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"classscheduling/controllers"
+	"classscheduling/mailer"
 	"classscheduling/middleware"
+	"classscheduling/models"
+	"classscheduling/ratelimit"
+	"classscheduling/sso"
 )
 
-func SetupRoutes(router *gin.Engine, db *mongo.Database) {
+func SetupRoutes(router *gin.Engine, db *mongo.Database, mail mailer.Mailer, limiter ratelimit.Limiter, providers map[string]sso.Provider) {
 	// Initialize controllers
-	authController := controllers.NewAuthController(db)
-	userController := controllers.NewUserController(db)
+	authController := controllers.NewAuthController(db, mail, limiter, providers)
+	userController := controllers.NewUserController(db, mail)
 	classController := controllers.NewClassController(db)
 	attendanceController := controllers.NewAttendanceController(db)
 	performanceController := controllers.NewPerformanceController(db)
 	holidayController := controllers.NewHolidayController(db)
+	riskController := controllers.NewRiskController(db)
+	reportCardController := controllers.NewReportCardController(db)
+	roleController := controllers.NewRoleController(db)
+	departmentController := controllers.NewDepartmentController(db)
+	approvalController := controllers.NewApprovalController(db, mail)
+	apiKeyController := controllers.NewAPIKeyController(db)
+	guardianController := controllers.NewGuardianController(db, mail)
+	userImportController := controllers.NewUserImportController(db, mail)
+	consistencyController := controllers.NewConsistencyController(db)
+	workloadController := controllers.NewWorkloadController(db)
+	reportController := controllers.NewReportController(db)
+	profileController := controllers.NewProfileController(db)
+
+	// Public keys for verifying our tokens
+	router.GET("/.well-known/jwks.json", middleware.JWKS)
 
 	// Public routes
 	public := router.Group("/api")
@@ -24,65 +43,192 @@ func SetupRoutes(router *gin.Engine, db *mongo.Database) {
 		{
 			auth.POST("/login", authController.Login)
 			auth.POST("/signup", authController.Signup)
+			auth.POST("/refresh", authController.Refresh)
+			auth.POST("/forgot-password", authController.ForgotPassword)
+			auth.POST("/reset-password", authController.ResetPassword)
+			auth.POST("/verify-email", authController.VerifyEmail)
+			auth.POST("/resend-verification", authController.ResendVerification)
+			auth.POST("/2fa/verify", authController.VerifyTwoFactor)
+			auth.POST("/2fa/enroll", authController.EnrollTwoFactor)
+			auth.POST("/2fa/enroll/confirm", authController.ConfirmTwoFactorEnrollment)
+
+			// Single sign-on
+			auth.GET("/sso/providers", authController.GetSSOProviders)
+			auth.GET("/sso/:provider/login", authController.SSOLogin)
+			auth.GET("/sso/:provider/callback", authController.SSOCallback)
+			auth.POST("/sso/complete", authController.CompleteSSO)
 		}
+
+		// Departments are listed publicly so they can be picked at signup
+		public.GET("/departments", departmentController.GetDepartments)
 	}
 
 	// Protected routes
 	protected := router.Group("/api")
-	protected.Use(middleware.AuthMiddleware())
+	protected.Use(middleware.AuthMiddleware(db))
 	{
+		// Session routes
+		protected.POST("/auth/logout", middleware.SessionMiddleware(), authController.Logout)
+		protected.POST("/auth/logout-all", middleware.SessionMiddleware(), authController.LogoutAll)
+
+		// Two-factor authentication routes
+		protected.POST("/auth/2fa/setup", middleware.SessionMiddleware(), authController.SetupTwoFactor)
+		protected.POST("/auth/2fa/enable", middleware.SessionMiddleware(), authController.EnableTwoFactor)
+		protected.POST("/auth/2fa/disable", middleware.SessionMiddleware(), authController.DisableTwoFactor)
+		protected.POST("/auth/2fa/recovery-codes", middleware.SessionMiddleware(), authController.RegenerateRecoveryCodes)
+
+		// Personal API key routes; keys cannot be used to create more keys
+		protected.GET("/auth/api-keys", middleware.SessionMiddleware(), apiKeyController.GetMyAPIKeys)
+		protected.POST("/auth/api-keys", middleware.SessionMiddleware(), apiKeyController.CreateMyAPIKey)
+		protected.DELETE("/auth/api-keys/:id", middleware.SessionMiddleware(), apiKeyController.RevokeMyAPIKey)
+
+		// Self-service profile routes
+		protected.GET("/me", middleware.SessionMiddleware(), profileController.GetMe)
+		protected.PUT("/me", middleware.SessionMiddleware(), profileController.UpdateMe)
+		protected.POST("/me/avatar", middleware.SessionMiddleware(), profileController.UploadAvatar)
+		protected.DELETE("/me/avatar", middleware.SessionMiddleware(), profileController.DeleteAvatar)
+
 		// User routes
-		protected.GET("/users", middleware.RoleMiddleware("admin"), userController.GetUsers)
+		protected.GET("/users", middleware.PermissionMiddleware(models.PermUsersView), userController.GetUsers)
 		protected.GET("/users/:id", userController.GetUser)
-		protected.POST("/users", middleware.RoleMiddleware("admin"), userController.CreateUser)
-		protected.PUT("/users/:id", userController.UpdateUser)
-		protected.DELETE("/users/:id", middleware.RoleMiddleware("admin"), userController.DeleteUser)
+		protected.POST("/users", middleware.PermissionMiddleware(models.PermUsersManage), userController.CreateUser)
+		protected.PUT("/users/:id", middleware.SessionMiddleware(), userController.UpdateUser)
+		protected.DELETE("/users/:id", middleware.PermissionMiddleware(models.PermUsersManage), userController.DeleteUser)
+		protected.POST("/admin/users/import", middleware.PermissionMiddleware(models.PermUsersManage), userImportController.ImportUsers)
+		protected.GET("/admin/users/export", middleware.PermissionMiddleware(models.PermUsersView), userImportController.ExportUsers)
+		protected.GET("/admin/deleted-users", middleware.PermissionMiddleware(models.PermUsersManage), userController.GetDeletedUsers)
+		protected.POST("/admin/users/:id/restore", middleware.PermissionMiddleware(models.PermUsersManage), userController.RestoreUser)
+		protected.DELETE("/admin/users/:id/purge", middleware.PermissionMiddleware(models.PermUsersManage), userController.PurgeUser)
+		protected.GET("/admin/deleted-classes", middleware.PermissionMiddleware(models.PermClassDelete), classController.GetDeletedClasses)
+		protected.POST("/admin/classes/:id/restore", middleware.PermissionMiddleware(models.PermClassDelete), classController.RestoreClass)
+		protected.DELETE("/admin/classes/:id/purge", middleware.PermissionMiddleware(models.PermClassDelete), classController.PurgeClass)
+		protected.POST("/admin/users/:id/unlock", middleware.PermissionMiddleware(models.PermUsersManage), userController.UnlockUser)
+		protected.POST("/admin/users/:id/password-reset", middleware.PermissionMiddleware(models.PermUsersManage), userController.ResetUserPassword)
+		protected.POST("/admin/users/:id/2fa/reset", middleware.PermissionMiddleware(models.PermSecurityManage), userController.ResetUserTwoFactor)
+		protected.GET("/admin/security/mfa-policy", middleware.PermissionMiddleware(models.PermSecurityManage), userController.GetMFAPolicy)
+		protected.PUT("/admin/security/mfa-policy", middleware.PermissionMiddleware(models.PermSecurityManage), userController.UpdateMFAPolicy)
+
+		// Role routes
+		protected.GET("/admin/permissions", middleware.PermissionMiddleware(models.PermRolesManage), roleController.GetPermissions)
+		protected.GET("/admin/roles", middleware.PermissionMiddleware(models.PermRolesManage), roleController.GetRoles)
+		protected.POST("/admin/roles", middleware.PermissionMiddleware(models.PermRolesManage), roleController.CreateRole)
+		protected.PUT("/admin/roles/:id", middleware.PermissionMiddleware(models.PermRolesManage), roleController.UpdateRole)
+		protected.DELETE("/admin/roles/:id", middleware.PermissionMiddleware(models.PermRolesManage), roleController.DeleteRole)
+		protected.PUT("/admin/users/:id/roles", middleware.PermissionMiddleware(models.PermRolesManage), roleController.SetUserRoles)
+
+		// Signup approval routes
+		protected.GET("/admin/approvals", middleware.PermissionMiddleware(models.PermUsersManage), approvalController.GetApprovals)
+		protected.POST("/admin/approvals/:id/approve", middleware.PermissionMiddleware(models.PermUsersManage), approvalController.ApproveSignup)
+		protected.POST("/admin/approvals/:id/reject", middleware.PermissionMiddleware(models.PermUsersManage), approvalController.RejectSignup)
+
+		// Service account and API key routes
+		protected.GET("/admin/service-accounts", middleware.PermissionMiddleware(models.PermAPIKeysManage), apiKeyController.GetServiceAccounts)
+		protected.POST("/admin/service-accounts", middleware.SessionMiddleware(), middleware.PermissionMiddleware(models.PermAPIKeysManage), apiKeyController.CreateServiceAccount)
+		protected.GET("/admin/service-accounts/:id/api-keys", middleware.PermissionMiddleware(models.PermAPIKeysManage), apiKeyController.GetServiceAccountKeys)
+		protected.POST("/admin/service-accounts/:id/api-keys", middleware.SessionMiddleware(), middleware.PermissionMiddleware(models.PermAPIKeysManage), apiKeyController.CreateServiceAccountKey)
+		protected.DELETE("/admin/api-keys/:id", middleware.PermissionMiddleware(models.PermAPIKeysManage), apiKeyController.RevokeAPIKey)
+
+		// Guardian link routes
+		protected.GET("/admin/guardian-links", middleware.PermissionMiddleware(models.PermGuardiansManage), guardianController.GetGuardianLinks)
+		protected.POST("/admin/guardian-links", middleware.PermissionMiddleware(models.PermGuardiansManage), guardianController.CreateGuardianLink)
+		protected.POST("/admin/guardian-links/:id/approve", middleware.PermissionMiddleware(models.PermGuardiansManage), guardianController.ApproveGuardianLink)
+		protected.DELETE("/admin/guardian-links/:id", middleware.PermissionMiddleware(models.PermGuardiansManage), guardianController.RevokeGuardianLink)
+
+		// Department routes
+		protected.POST("/admin/departments", middleware.PermissionMiddleware(models.PermDepartmentsManage), departmentController.CreateDepartment)
+		protected.PUT("/admin/departments/:id", middleware.PermissionMiddleware(models.PermDepartmentsManage), departmentController.UpdateDepartment)
+		protected.DELETE("/admin/departments/:id", middleware.PermissionMiddleware(models.PermDepartmentsManage), departmentController.DeleteDepartment)
 
 		// Class routes
 		protected.GET("/classes", classController.GetClasses)
 		protected.GET("/classes/available", classController.GetAvailableClasses)
-		protected.POST("/classes", middleware.RoleMiddleware("admin"), classController.CreateClass)
+		protected.POST("/classes", middleware.PermissionMiddleware(models.PermClassCreate), classController.CreateClass)
 		protected.GET("/classes/:id", classController.GetClass)
-		protected.PUT("/classes/:id", middleware.RoleMiddleware("admin", "faculty"), classController.UpdateClass)
-		protected.DELETE("/classes/:id", middleware.RoleMiddleware("admin"), classController.DeleteClass)
+		protected.PUT("/classes/:id", middleware.PermissionMiddleware(models.PermClassEdit), classController.UpdateClass)
+		protected.DELETE("/classes/:id", middleware.PermissionMiddleware(models.PermClassDelete), classController.DeleteClass)
+		protected.GET("/classes/:id/delete-impact", middleware.PermissionMiddleware(models.PermClassDelete), classController.GetClassDeleteImpact)
 
 		// Faculty specific routes
-		protected.GET("/faculty/classes", middleware.RoleMiddleware("faculty"), classController.GetFacultyClasses)
-		protected.GET("/faculty/schedule", middleware.RoleMiddleware("faculty"), classController.GetFacultySchedule)
-		protected.POST("/faculty/class/cancel", middleware.RoleMiddleware("faculty"), classController.CancelClass)
-		protected.POST("/faculty/class/reschedule", middleware.RoleMiddleware("faculty"), classController.RescheduleClass)
-		protected.GET("/faculty/schedule-changes", middleware.RoleMiddleware("faculty"), classController.GetScheduleChanges)
+		protected.GET("/faculty/classes", middleware.PermissionMiddleware(models.PermClassTeach), classController.GetFacultyClasses)
+		protected.GET("/faculty/schedule", middleware.PermissionMiddleware(models.PermClassTeach), classController.GetFacultySchedule)
+		protected.POST("/faculty/class/cancel", middleware.PermissionMiddleware(models.PermClassTeach), classController.CancelClass)
+		protected.POST("/faculty/class/reschedule", middleware.PermissionMiddleware(models.PermClassTeach), classController.RescheduleClass)
+		protected.GET("/faculty/schedule-changes", middleware.PermissionMiddleware(models.PermClassTeach), classController.GetScheduleChanges)
+
+		// At-risk student routes
+		protected.GET("/faculty/risk", middleware.PermissionMiddleware(models.PermRiskManage), riskController.GetFacultyRiskFlags)
+		protected.POST("/faculty/risk/evaluate", middleware.PermissionMiddleware(models.PermRiskManage), riskController.EvaluateFacultyRisk)
+		protected.POST("/faculty/risk/:id/acknowledge", middleware.PermissionMiddleware(models.PermRiskManage), riskController.AcknowledgeRiskFlag)
+		protected.POST("/faculty/risk/:id/interventions", middleware.PermissionMiddleware(models.PermRiskManage), riskController.AddRiskIntervention)
 
 		// Student specific routes
-		protected.GET("/student/schedule", middleware.RoleMiddleware("student"), classController.GetStudentSchedule)
-		protected.GET("/student/enrollments", middleware.RoleMiddleware("student"), classController.GetStudentEnrollments)
-		protected.GET("/student/attendance", middleware.RoleMiddleware("student"), attendanceController.GetStudentAttendance)
-		protected.GET("/student/performance", middleware.RoleMiddleware("student"), performanceController.GetStudentPerformance)
-		protected.GET("/student/remarks", middleware.RoleMiddleware("student"), performanceController.GetStudentRemarks)
+		protected.GET("/student/schedule", middleware.PermissionMiddleware(models.PermClassEnroll), classController.GetStudentSchedule)
+		protected.GET("/student/enrollments", middleware.PermissionMiddleware(models.PermClassEnroll), classController.GetStudentEnrollments)
+		protected.GET("/student/attendance", middleware.PermissionMiddleware(models.PermAttendanceView), attendanceController.GetStudentAttendance)
+		protected.GET("/student/performance", middleware.PermissionMiddleware(models.PermGradesView), performanceController.GetStudentPerformance)
+		protected.GET("/student/remarks", middleware.PermissionMiddleware(models.PermRemarksView), performanceController.GetStudentRemarks)
+		protected.POST("/student/remarks/:id/replies", middleware.PermissionMiddleware(models.PermRemarksView), performanceController.ReplyToRemark)
+		protected.POST("/student/remarks/:id/acknowledge", middleware.PermissionMiddleware(models.PermRemarksView), performanceController.AcknowledgeRemark)
+		protected.GET("/student/report-card", middleware.PermissionMiddleware(models.PermReportCardsView), reportCardController.GetMyReportCard)
+		protected.GET("/student/guardians", middleware.PermissionMiddleware(models.PermGuardianConsent), guardianController.GetMyGuardians)
+		protected.POST("/student/guardians/:id/approve", middleware.PermissionMiddleware(models.PermGuardianConsent), guardianController.ApproveGuardian)
+		protected.POST("/student/guardians/:id/decline", middleware.PermissionMiddleware(models.PermGuardianConsent), guardianController.DeclineGuardian)
+		protected.DELETE("/student/guardians/:id", middleware.PermissionMiddleware(models.PermGuardianConsent), guardianController.RevokeGuardian)
+
+		// Guardian routes; guardians only read the records of students linked to them
+		protected.GET("/guardian/students", middleware.PermissionMiddleware(models.PermGuardianView), guardianController.GetMyStudents)
+		protected.POST("/guardian/students", middleware.PermissionMiddleware(models.PermGuardianView), guardianController.RequestStudentLink)
+		protected.DELETE("/guardian/students/:id", middleware.PermissionMiddleware(models.PermGuardianView), guardianController.RemoveStudentLink)
+		protected.PUT("/guardian/students/:id/notifications", middleware.PermissionMiddleware(models.PermGuardianView), guardianController.UpdateStudentNotifications)
+		protected.GET("/guardian/students/:id/attendance", middleware.PermissionMiddleware(models.PermGuardianView), guardianController.GetStudentAttendance)
+		protected.GET("/guardian/students/:id/performance", middleware.PermissionMiddleware(models.PermGuardianView), guardianController.GetStudentPerformance)
+		protected.GET("/guardian/students/:id/remarks", middleware.PermissionMiddleware(models.PermGuardianView), guardianController.GetStudentRemarks)
 
 		// Enrollment routes
-		protected.POST("/classes/:id/enroll", middleware.RoleMiddleware("student"), classController.EnrollInClass)
-		protected.POST("/classes/:id/drop", middleware.RoleMiddleware("student"), classController.DropClass)
+		protected.POST("/classes/:id/enroll", middleware.PermissionMiddleware(models.PermClassEnroll), classController.EnrollInClass)
+		protected.POST("/classes/:id/drop", middleware.PermissionMiddleware(models.PermClassEnroll), classController.DropClass)
+		protected.POST("/classes/:id/enrollments", middleware.PermissionMiddleware(models.PermEnrollmentsManage), classController.AddEnrollment)
+		protected.DELETE("/classes/:id/enrollments/:studentId", middleware.PermissionMiddleware(models.PermEnrollmentsManage), classController.RemoveEnrollment)
 
 		// Attendance routes
-		protected.POST("/classes/:id/attendance", middleware.RoleMiddleware("faculty"), attendanceController.MarkAttendance)
+		protected.POST("/classes/:id/attendance", middleware.PermissionMiddleware(models.PermAttendanceMark), attendanceController.MarkAttendance)
 		protected.GET("/classes/:id/attendance", attendanceController.GetAttendance)
 
 		// Performance routes
-		protected.POST("/faculty/class/:id/performance", middleware.RoleMiddleware("faculty"), performanceController.AddPerformance)
+		protected.POST("/faculty/class/:id/performance", middleware.PermissionMiddleware(models.PermGradesEdit), performanceController.AddPerformance)
 		protected.GET("/classes/:id/performance", performanceController.GetClassPerformance)
-		protected.POST("/faculty/class/:id/remarks", middleware.RoleMiddleware("faculty"), performanceController.AddRemarks)
+		protected.POST("/faculty/class/:id/remarks", middleware.PermissionMiddleware(models.PermRemarksManage), performanceController.AddRemarks)
+		protected.GET("/faculty/class/:id/remarks", middleware.PermissionMiddleware(models.PermRemarksManage), performanceController.GetClassRemarks)
+		protected.PUT("/faculty/remarks/:id", middleware.PermissionMiddleware(models.PermRemarksManage), performanceController.UpdateRemark)
+		protected.DELETE("/faculty/remarks/:id", middleware.PermissionMiddleware(models.PermRemarksManage), performanceController.DeleteRemark)
+		protected.POST("/faculty/remarks/:id/replies", middleware.PermissionMiddleware(models.PermRemarksManage), performanceController.ReplyToRemark)
 
 		// Admin specific routes
-		protected.GET("/admin/statistics", middleware.RoleMiddleware("admin"), userController.GetStatistics)
-		protected.GET("/admin/activity", middleware.RoleMiddleware("admin"), userController.GetActivity)
+		protected.GET("/admin/statistics", middleware.PermissionMiddleware(models.PermStatisticsView), userController.GetStatistics)
+		protected.GET("/admin/reports/teaching-load", middleware.PermissionMiddleware(models.PermStatisticsView), workloadController.GetTeachingLoad)
+		protected.GET("/admin/reports/utilization", middleware.PermissionMiddleware(models.PermStatisticsView), workloadController.GetUtilization)
+		protected.GET("/admin/reports", middleware.PermissionMiddleware(models.PermReportsExport), reportController.GetReportDefinitions)
+		protected.GET("/admin/reports/:name", middleware.PermissionMiddleware(models.PermReportsExport), reportController.GetReport)
+		protected.GET("/admin/report-jobs", middleware.PermissionMiddleware(models.PermReportsExport), reportController.GetReportJobs)
+		protected.POST("/admin/report-jobs", middleware.PermissionMiddleware(models.PermReportsExport), reportController.CreateReportJob)
+		protected.GET("/admin/report-jobs/:id", middleware.PermissionMiddleware(models.PermReportsExport), reportController.GetReportJob)
+		protected.GET("/admin/report-jobs/:id/download", middleware.PermissionMiddleware(models.PermReportsExport), reportController.DownloadReportJob)
+		protected.GET("/admin/activity", middleware.PermissionMiddleware(models.PermActivityView), userController.GetActivity)
+		protected.GET("/admin/consistency", middleware.PermissionMiddleware(models.PermDataRepair), consistencyController.GetConsistencyReport)
+		protected.POST("/admin/consistency/repair", middleware.PermissionMiddleware(models.PermDataRepair), consistencyController.RepairConsistency)
+		protected.GET("/admin/risk", middleware.PermissionMiddleware(models.PermRiskViewAll), riskController.GetRiskFlags)
+		protected.POST("/admin/risk/evaluate", middleware.PermissionMiddleware(models.PermRiskViewAll), riskController.EvaluateRisk)
+		protected.POST("/admin/risk/:id/acknowledge", middleware.PermissionMiddleware(models.PermRiskViewAll), riskController.AcknowledgeRiskFlag)
+		protected.POST("/admin/risk/:id/interventions", middleware.PermissionMiddleware(models.PermRiskViewAll), riskController.AddRiskIntervention)
+		protected.GET("/admin/report-cards", middleware.PermissionMiddleware(models.PermReportCardsAll), reportCardController.GenerateTermReportCards)
+		protected.GET("/admin/report-cards/:studentId", middleware.PermissionMiddleware(models.PermReportCardsAll), reportCardController.GetStudentReportCard)
 
 		// Holiday management routes
-		protected.GET("/admin/holidays", middleware.RoleMiddleware("admin"), holidayController.GetHolidays)
-		protected.POST("/admin/holidays", middleware.RoleMiddleware("admin"), holidayController.CreateHoliday)
-		protected.DELETE("/admin/holidays/:id", middleware.RoleMiddleware("admin"), holidayController.DeleteHoliday)
+		protected.GET("/admin/holidays", middleware.PermissionMiddleware(models.PermCalendarManage), holidayController.GetHolidays)
+		protected.POST("/admin/holidays", middleware.PermissionMiddleware(models.PermCalendarManage), holidayController.CreateHoliday)
+		protected.DELETE("/admin/holidays/:id", middleware.PermissionMiddleware(models.PermCalendarManage), holidayController.DeleteHoliday)
 
 		// Timetable management routes
-		protected.POST("/admin/timetable", middleware.RoleMiddleware("admin"), holidayController.UpdateTimetable)
+		protected.POST("/admin/timetable", middleware.PermissionMiddleware(models.PermCalendarManage), holidayController.UpdateTimetable)
 	}
 }
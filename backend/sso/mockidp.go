@@ -0,0 +1,339 @@
+package sso
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MockUser is an account known to the mock identity provider
+type MockUser struct {
+	Subject       string
+	Username      string
+	Name          string
+	Email         string
+	EmailVerified bool
+	Claims        map[string]interface{} // extra ID token claims, e.g. roles
+}
+
+// DefaultMockUsers are the accounts the mock provider offers when none are given
+func DefaultMockUsers() []MockUser {
+	return []MockUser{
+		{
+			Subject: "mock-student-1", Username: "sso.student", Name: "SSO Student",
+			Email: "sso.student@example.edu", EmailVerified: true,
+			Claims: map[string]interface{}{"roles": []string{"student"}, "student_id": "SSO001"},
+		},
+		{
+			Subject: "mock-faculty-1", Username: "sso.faculty", Name: "SSO Faculty",
+			Email: "sso.faculty@example.edu", EmailVerified: true,
+			Claims: map[string]interface{}{"roles": []string{"staff"}},
+		},
+	}
+}
+
+type mockGrant struct {
+	user          MockUser
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	expiresAt     time.Time
+}
+
+// MockIdP is a minimal in-process OpenID Connect provider for development and
+// tests. It implements discovery, the authorization code flow with PKCE and a
+// JWKS endpoint, and signs ID tokens with a key generated at startup. It must
+// never be enabled in production: anyone can sign in as any of its users.
+type MockIdP struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	users        []MockUser
+	key          *rsa.PrivateKey
+	kid          string
+
+	mu     sync.Mutex
+	grants map[string]mockGrant
+}
+
+// NewMockIdP returns a mock provider serving at issuer (the URL the handler is
+// mounted at) that accepts the given client credentials
+func NewMockIdP(issuer, clientID, clientSecret string, users []MockUser) (*MockIdP, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := RandomString(8)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		users = DefaultMockUsers()
+	}
+	return &MockIdP{
+		issuer:       strings.TrimRight(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		users:        users,
+		key:          key,
+		kid:          kid,
+		grants:       make(map[string]mockGrant),
+	}, nil
+}
+
+// Issuer returns the provider's issuer URL
+func (m *MockIdP) Issuer() string {
+	return m.issuer
+}
+
+// Client returns an HTTP client that calls the mock provider directly instead
+// of over the network, so the flow works without the server reaching itself
+func (m *MockIdP) Client() *http.Client {
+	return &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, r)
+		resp := rec.Result()
+		resp.Request = r
+		return resp, nil
+	})}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// Provider returns an OpenID Connect provider named "mock" that signs in
+// against this mock provider. Its roles claim maps staff to faculty and its
+// student_id claim holds the roll number.
+func (m *MockIdP) Provider(baseURL string) *OIDCProvider {
+	return NewOIDCProvider(OIDCConfig{
+		Name:            "mock",
+		Issuer:          m.issuer,
+		ClientID:        m.clientID,
+		ClientSecret:    m.clientSecret,
+		RedirectURL:     CallbackURL(baseURL, "mock"),
+		UserTypeClaim:   "roles",
+		UserTypeMap:     map[string]string{"staff": "faculty"},
+		RollNumberClaim: "student_id",
+		HTTPClient:      m.Client(),
+	})
+}
+
+// ServeHTTP routes requests relative to the issuer URL
+func (m *MockIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	issuerPath := ""
+	if u, err := url.Parse(m.issuer); err == nil {
+		issuerPath = strings.TrimRight(u.Path, "/")
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, issuerPath) {
+	case "/.well-known/openid-configuration":
+		m.discovery(w)
+	case "/authorize":
+		m.authorize(w, r)
+	case "/token":
+		m.token(w, r)
+	case "/jwks":
+		m.jwks(w)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (m *MockIdP) discovery(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                m.issuer,
+		"authorization_endpoint":                m.issuer + "/authorize",
+		"token_endpoint":                        m.issuer + "/token",
+		"jwks_uri":                              m.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "profile", "email"},
+	})
+}
+
+func (m *MockIdP) jwks(w http.ResponseWriter) {
+	pub := m.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": m.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+var mockPickerTemplate = template.Must(template.New("picker").Parse(`<!DOCTYPE html>
+<html><head><title>Mock identity provider</title></head>
+<body style="font-family: sans-serif; max-width: 30rem; margin: 3rem auto">
+<h1>Mock identity provider</h1>
+<p>Choose an account to sign in as. This provider is for development only.</p>
+<ul>
+{{range .Users}}<li><a href="{{$.Base}}&login_hint={{.Username}}">{{.Name}} ({{.Username}})</a></li>
+{{end}}</ul>
+</body></html>`))
+
+// authorize approves the request for the user named by login_hint, or shows
+// a page to pick one
+func (m *MockIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != m.clientID || q.Get("redirect_uri") == "" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	hint := q.Get("login_hint")
+	if hint == "" {
+		base := *r.URL
+		query := base.Query()
+		query.Del("login_hint")
+		base.RawQuery = query.Encode()
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		mockPickerTemplate.Execute(w, map[string]interface{}{
+			"Base":  template.URL(base.String()),
+			"Users": m.users,
+		})
+		return
+	}
+
+	var user *MockUser
+	for i := range m.users {
+		if m.users[i].Username == hint {
+			user = &m.users[i]
+			break
+		}
+	}
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	params := redirect.Query()
+	params.Set("state", q.Get("state"))
+
+	if user == nil {
+		params.Set("error", "access_denied")
+	} else {
+		code, err := RandomString(24)
+		if err != nil {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		m.mu.Lock()
+		m.grants[code] = mockGrant{
+			user:          *user,
+			clientID:      q.Get("client_id"),
+			redirectURI:   q.Get("redirect_uri"),
+			nonce:         q.Get("nonce"),
+			codeChallenge: q.Get("code_challenge"),
+			expiresAt:     time.Now().Add(time.Minute),
+		}
+		m.mu.Unlock()
+		params.Set("code", code)
+	}
+
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token redeems an authorization code for an ID token
+func (m *MockIdP) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != m.clientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(m.clientSecret)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	// Codes are single-use
+	code := r.PostForm.Get("code")
+	m.mu.Lock()
+	grant, found := m.grants[code]
+	delete(m.grants, code)
+	m.mu.Unlock()
+
+	if !found || time.Now().After(grant.expiresAt) ||
+		grant.clientID != clientID ||
+		grant.redirectURI != r.PostForm.Get("redirect_uri") ||
+		PKCEChallenge(r.PostForm.Get("code_verifier")) != grant.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{}
+	for k, v := range grant.user.Claims {
+		claims[k] = v
+	}
+	claims["iss"] = m.issuer
+	claims["sub"] = grant.user.Subject
+	claims["aud"] = clientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(5 * time.Minute).Unix()
+	claims["nonce"] = grant.nonce
+	claims["preferred_username"] = grant.user.Username
+	claims["name"] = grant.user.Name
+	claims["email"] = grant.user.Email
+	claims["email_verified"] = grant.user.EmailVerified
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.kid
+	idToken, err := token.SignedString(m.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": idToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
@@ -0,0 +1,128 @@
+package sso
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testBaseURL = "http://app.test"
+
+// signIn starts a sign-in with the provider and has the mock identity
+// provider approve it for the given user, returning the parameters it
+// redirects back to the callback with
+func signIn(t *testing.T, idp *MockIdP, p *OIDCProvider, username, nonce, challenge string) url.Values {
+	t.Helper()
+	authURL, err := p.AuthURL(context.Background(), "state-1", nonce, challenge)
+	if err != nil {
+		t.Fatalf("AuthURL: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	idp.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, authURL+"&login_hint="+url.QueryEscape(username), nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("authorize returned %d: %s", rec.Code, rec.Body.String())
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect: %v", err)
+	}
+	if callback := CallbackURL(testBaseURL, "mock"); !strings.HasPrefix(location.String(), callback+"?") {
+		t.Fatalf("redirected to %s, want %s", location, callback)
+	}
+	params := location.Query()
+	if params.Get("state") != "state-1" {
+		t.Fatalf("state = %q, want state-1", params.Get("state"))
+	}
+	return params
+}
+
+func newTestIdP(t *testing.T) (*MockIdP, *OIDCProvider) {
+	t.Helper()
+	idp, err := NewMockIdP("http://idp.test/mock-idp", "mock-client", "mock-secret", nil)
+	if err != nil {
+		t.Fatalf("NewMockIdP: %v", err)
+	}
+	return idp, idp.Provider(testBaseURL)
+}
+
+func TestMockIdPSignIn(t *testing.T) {
+	idp, p := newTestIdP(t)
+	tests := []struct {
+		username   string
+		userType   string
+		rollNumber string
+	}{
+		{"sso.student", "student", "SSO001"},
+		{"sso.faculty", "faculty", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			verifier, challenge, err := NewPKCE()
+			if err != nil {
+				t.Fatal(err)
+			}
+			params := signIn(t, idp, p, tt.username, "nonce-1", challenge)
+
+			identity, err := p.Exchange(context.Background(), params.Get("code"), verifier, "nonce-1")
+			if err != nil {
+				t.Fatalf("Exchange: %v", err)
+			}
+			if identity.Provider != "mock" || identity.Username != tt.username || !identity.EmailVerified {
+				t.Errorf("identity = %+v", identity)
+			}
+			if identity.UserType != tt.userType {
+				t.Errorf("UserType = %q, want %q", identity.UserType, tt.userType)
+			}
+			if identity.RollNumber != tt.rollNumber {
+				t.Errorf("RollNumber = %q, want %q", identity.RollNumber, tt.rollNumber)
+			}
+		})
+	}
+}
+
+func TestMockIdPRejectsInvalidExchange(t *testing.T) {
+	idp, p := newTestIdP(t)
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		params := signIn(t, idp, p, "sso.student", "nonce-1", challenge)
+		if _, err := p.Exchange(context.Background(), params.Get("code"), verifier, "other-nonce"); err == nil {
+			t.Fatal("Exchange accepted an ID token for another nonce")
+		}
+	})
+
+	t.Run("code verifier mismatch", func(t *testing.T) {
+		params := signIn(t, idp, p, "sso.student", "nonce-1", challenge)
+		other, _, err := NewPKCE()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Exchange(context.Background(), params.Get("code"), other, "nonce-1"); err == nil {
+			t.Fatal("Exchange accepted the wrong code verifier")
+		}
+	})
+
+	t.Run("code reuse", func(t *testing.T) {
+		params := signIn(t, idp, p, "sso.student", "nonce-1", challenge)
+		if _, err := p.Exchange(context.Background(), params.Get("code"), verifier, "nonce-1"); err != nil {
+			t.Fatalf("Exchange: %v", err)
+		}
+		if _, err := p.Exchange(context.Background(), params.Get("code"), verifier, "nonce-1"); err == nil {
+			t.Fatal("Exchange accepted a code twice")
+		}
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		params := signIn(t, idp, p, "nobody", "nonce-1", challenge)
+		if params.Get("error") != "access_denied" || params.Get("code") != "" {
+			t.Fatalf("callback params = %v, want access_denied", params)
+		}
+	})
+}
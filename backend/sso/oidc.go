@@ -0,0 +1,348 @@
+package sso
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// clockSkew is how far ID token times may be off from our clock
+const clockSkew = 2 * time.Minute
+
+// OIDCConfig configures an OpenID Connect provider
+type OIDCConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string // defaults to openid, profile and email
+
+	// UserTypeClaim names the claim holding the user's affiliation, either a
+	// string or a list of strings. UserTypeMap maps its values to user types;
+	// values that are already user types map to themselves.
+	UserTypeClaim   string
+	UserTypeMap     map[string]string
+	DefaultUserType string // used when no value maps; defaults to student
+	RollNumberClaim string
+
+	HTTPClient *http.Client // optional, for talking to the provider
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// OIDCProvider signs users in with the authorization code flow and PKCE
+type OIDCProvider struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]*rsa.PublicKey
+	keysAt    time.Time
+}
+
+// NewOIDCProvider returns a provider; discovery happens on first use
+func NewOIDCProvider(config OIDCConfig) *OIDCProvider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	}
+	if config.DefaultUserType == "" {
+		config.DefaultUserType = "student"
+	}
+	config.Issuer = strings.TrimRight(config.Issuer, "/")
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OIDCProvider{config: config, client: client}
+}
+
+// Name returns the provider name
+func (p *OIDCProvider) Name() string {
+	return p.config.Name
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover loads and caches the provider's metadata
+func (p *OIDCProvider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d discovery
+	if err := p.getJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimRight(d.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match %q", d.Issuer, p.config.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: missing endpoints")
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// key returns the signing key with the given ID, refetching the key set when
+// the ID is unknown so that provider key rotation is picked up
+func (p *OIDCProvider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	// Do not let unknown key IDs hammer the provider
+	if time.Since(p.keysAt) < 10*time.Second && p.keys != nil {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc jwks: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.keys = keys
+	p.keysAt = time.Now()
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// AuthURL returns the authorization endpoint URL for a sign-in
+func (p *OIDCProvider) AuthURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange redeems the authorization code and validates the ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("oidc token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return nil, fmt.Errorf("oidc token: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+
+	claims, err := p.validateIDToken(ctx, tokens.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return p.identity(claims), nil
+}
+
+// validateIDToken checks the ID token's signature, issuer, audience, times
+// and nonce
+func (p *OIDCProvider) validateIDToken(ctx context.Context, raw, nonce string) (jwt.MapClaims, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The issuer must match exactly what the provider advertises
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithLeeway(clockSkew),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	claims := jwt.MapClaims{}
+	_, err = parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("oidc id token: %w", err)
+	}
+
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, errors.New("oidc id token: nonce mismatch")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("oidc id token: missing subject")
+	}
+	return claims, nil
+}
+
+// identity maps ID token claims to an Identity
+func (p *OIDCProvider) identity(claims jwt.MapClaims) *Identity {
+	str := func(name string) string {
+		s, _ := claims[name].(string)
+		return s
+	}
+
+	identity := &Identity{
+		Provider: p.config.Name,
+		Subject:  str("sub"),
+		Email:    str("email"),
+		Username: str("preferred_username"),
+		Name:     str("name"),
+		UserType: p.config.DefaultUserType,
+	}
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+
+	if p.config.UserTypeClaim != "" {
+		var values []string
+		switch v := claims[p.config.UserTypeClaim].(type) {
+		case string:
+			values = []string{v}
+		case []interface{}:
+			for _, item := range v {
+				if s, ok := item.(string); ok {
+					values = append(values, s)
+				}
+			}
+		}
+		if userType := p.mapUserType(values); userType != "" {
+			identity.UserType = userType
+		}
+	}
+
+	if p.config.RollNumberClaim != "" {
+		switch v := claims[p.config.RollNumberClaim].(type) {
+		case string:
+			identity.RollNumber = v
+		case float64:
+			identity.RollNumber = fmt.Sprintf("%.0f", v)
+		}
+	}
+	return identity
+}
+
+// mapUserType picks the most privileged user type any of the values maps to
+func (p *OIDCProvider) mapUserType(values []string) string {
+	rank := map[string]int{"guardian": 1, "student": 2, "faculty": 3, "admin": 4}
+	best := ""
+	for _, value := range values {
+		userType, ok := p.config.UserTypeMap[value]
+		if !ok {
+			userType = value
+		}
+		if rank[userType] > rank[best] {
+			best = userType
+		}
+	}
+	return best
+}
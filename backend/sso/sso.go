@@ -0,0 +1,103 @@
+// Package sso provides single sign-on through external identity providers.
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"strings"
+)
+
+// Identity is what a provider asserts about a user after a successful sign-in
+type Identity struct {
+	Provider      string
+	Subject       string // stable, provider-unique user ID
+	Email         string
+	EmailVerified bool
+	Username      string // preferred username, may be empty
+	Name          string
+	UserType      string // student, faculty or admin, mapped from claims
+	RollNumber    string
+}
+
+// Provider is an external identity provider
+type Provider interface {
+	// Name identifies the provider in URLs and on linked accounts
+	Name() string
+	// AuthURL returns the URL to send the browser to for sign-in
+	AuthURL(ctx context.Context, state, nonce, codeChallenge string) (string, error)
+	// Exchange completes the sign-in with the code returned to the redirect
+	// URL and returns the validated identity
+	Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error)
+}
+
+// RandomString returns a URL-safe random string with n bytes of entropy
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewPKCE returns a PKCE code verifier and its S256 code challenge
+func NewPKCE() (verifier, challenge string, err error) {
+	verifier, err = RandomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	return verifier, PKCEChallenge(verifier), nil
+}
+
+// PKCEChallenge returns the S256 code challenge for a verifier
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// FromEnv builds the providers listed in SSO_PROVIDERS. Each provider NAME
+// is configured with SSO_<NAME>_ISSUER, SSO_<NAME>_CLIENT_ID,
+// SSO_<NAME>_CLIENT_SECRET, SSO_<NAME>_USERTYPE_CLAIM,
+// SSO_<NAME>_USERTYPE_MAP (e.g. "staff:faculty,student:student"),
+// SSO_<NAME>_DEFAULT_USERTYPE and SSO_<NAME>_ROLLNUMBER_CLAIM. Redirect URLs
+// are derived from baseURL.
+func FromEnv(baseURL string) map[string]Provider {
+	providers := make(map[string]Provider)
+	for _, name := range strings.Split(os.Getenv("SSO_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "SSO_" + strings.ToUpper(name) + "_"
+		providers[name] = NewOIDCProvider(OIDCConfig{
+			Name:            name,
+			Issuer:          os.Getenv(prefix + "ISSUER"),
+			ClientID:        os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret:    os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:     CallbackURL(baseURL, name),
+			UserTypeClaim:   os.Getenv(prefix + "USERTYPE_CLAIM"),
+			UserTypeMap:     parseMap(os.Getenv(prefix + "USERTYPE_MAP")),
+			DefaultUserType: os.Getenv(prefix + "DEFAULT_USERTYPE"),
+			RollNumberClaim: os.Getenv(prefix + "ROLLNUMBER_CLAIM"),
+		})
+	}
+	return providers
+}
+
+// CallbackURL is the redirect URL registered with a provider
+func CallbackURL(baseURL, name string) string {
+	return strings.TrimRight(baseURL, "/") + "/api/auth/sso/" + name + "/callback"
+}
+
+func parseMap(s string) map[string]string {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(pair, ":")
+		if ok {
+			m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return m
+}
@@ -0,0 +1,336 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// signingKeyBits is the size of generated RSA signing keys
+const signingKeyBits = 2048
+
+var errUnknownKey = errors.New("unknown signing key")
+
+// signingKey is an RSA key in the key ring. The newest key signs new tokens;
+// older keys only verify tokens they signed until verifyUntil.
+type signingKey struct {
+	kid         string
+	key         *rsa.PrivateKey
+	createdAt   time.Time
+	verifyUntil time.Time // zero for the signing key
+}
+
+// createdHeader is the PEM header recording when a key was generated
+const createdHeader = "Created"
+
+// KeyRing holds the RS256 keys used to sign and verify tokens. Keys are PEM
+// files in a directory, named <kid>.pem; the most recently created key is
+// the signing key. Several servers can share the directory.
+//
+// A key's creation time is read from its Created PEM header, or else from
+// the timestamp that starts generated key IDs. File times are not used, as
+// copying or restoring the directory changes them. Keys added by hand
+// without either sort as the oldest.
+type KeyRing struct {
+	dir       string
+	retention time.Duration
+
+	mu       sync.RWMutex
+	keys     []signingKey
+	loadedAt time.Time
+}
+
+// OpenKeyRing loads the keys in dir. A key that has been replaced keeps
+// verifying tokens for retention, which should cover the lifetime of every
+// token it signed. A missing directory gives an empty ring.
+func OpenKeyRing(dir string, retention time.Duration) (*KeyRing, error) {
+	ring := &KeyRing{dir: dir, retention: retention}
+	if err := ring.Reload(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return ring, nil
+}
+
+// Len returns the number of keys in the ring
+func (r *KeyRing) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.keys)
+}
+
+// Reload reads the key directory again, picking up keys added or rotated by
+// other servers
+func (r *KeyRing) Reload() error {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return err
+	}
+
+	keys := []signingKey{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".pem" {
+			continue
+		}
+		path := filepath.Join(r.dir, entry.Name())
+		key, created, err := readPrivateKey(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		kid := strings.TrimSuffix(entry.Name(), ".pem")
+		if created.IsZero() {
+			created = kidTime(kid)
+		}
+		keys = append(keys, signingKey{
+			kid:       kid,
+			key:       key,
+			createdAt: created,
+		})
+	}
+
+	// Newest first, by key ID when the times are equal so that every server
+	// picks the same signing key; each key verifies until its successor has
+	// been signing for the retention period
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].createdAt.Equal(keys[j].createdAt) {
+			return keys[i].createdAt.After(keys[j].createdAt)
+		}
+		return keys[i].kid > keys[j].kid
+	})
+	for i := 1; i < len(keys); i++ {
+		keys[i].verifyUntil = keys[i-1].createdAt.Add(r.retention)
+	}
+
+	r.mu.Lock()
+	r.keys = keys
+	r.loadedAt = time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+// kidFormat is the timestamp that starts the IDs of generated keys
+const kidFormat = "20060102T150405Z"
+
+// kidTime returns the time in a generated key ID, or the zero time
+func kidTime(kid string) time.Time {
+	if len(kid) < len(kidFormat) {
+		return time.Time{}
+	}
+	t, err := time.Parse(kidFormat, kid[:len(kidFormat)])
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// readPrivateKey reads an RSA key and the time in its Created header, which
+// is zero when the header is missing
+func readPrivateKey(path string) (*rsa.PrivateKey, time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, time.Time{}, errors.New("no PEM data")
+	}
+
+	var created time.Time
+	if value, ok := block.Headers[createdHeader]; ok {
+		if created, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return nil, time.Time{}, fmt.Errorf("invalid %s header: %w", createdHeader, err)
+		}
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		return key, created, err
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, time.Time{}, errors.New("not an RSA key")
+		}
+		return rsaKey, created, nil
+	}
+	return nil, time.Time{}, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+// Rotate generates a new signing key. Tokens signed with the previous key
+// stay valid until they expire.
+func (r *KeyRing) Rotate() error {
+	key, err := rsa.GenerateKey(rand.Reader, signingKeyBits)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	kid := fmt.Sprintf("%s-%x", now.Format(kidFormat), suffix)
+
+	if err := os.MkdirAll(r.dir, 0700); err != nil {
+		return err
+	}
+	// Write to a temporary name first so other servers never read half a key
+	tmp := filepath.Join(r.dir, "."+kid+".tmp")
+	block := &pem.Block{
+		Type:    "PRIVATE KEY",
+		Headers: map[string]string{createdHeader: now.Format(time.RFC3339Nano)},
+		Bytes:   der,
+	}
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(block), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(r.dir, kid+".pem")); err != nil {
+		return err
+	}
+	return r.Reload()
+}
+
+// Prune deletes keys that no longer verify any live token
+func (r *KeyRing) Prune() error {
+	r.mu.RLock()
+	var expired []string
+	for _, k := range r.keys {
+		if !k.verifyUntil.IsZero() && time.Now().After(k.verifyUntil) {
+			expired = append(expired, k.kid)
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, kid := range expired {
+		if err := os.Remove(filepath.Join(r.dir, kid+".pem")); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if len(expired) > 0 {
+		return r.Reload()
+	}
+	return nil
+}
+
+// StartRotation checks the key ring hourly, generating a new signing key once
+// the current one is older than every and removing keys past retention. An
+// interval of zero only reloads and prunes.
+func (r *KeyRing) StartRotation(every time.Duration) {
+	go func() {
+		for {
+			time.Sleep(time.Hour)
+
+			if err := r.Reload(); err != nil {
+				log.Printf("Error reloading signing keys: %v", err)
+				continue
+			}
+			current, err := r.signing()
+			if err == nil && every > 0 && time.Since(current.createdAt) >= every {
+				if err := r.Rotate(); err != nil {
+					log.Printf("Error rotating signing key: %v", err)
+					continue
+				}
+				log.Printf("Rotated signing key")
+			}
+			if err := r.Prune(); err != nil {
+				log.Printf("Error removing expired signing keys: %v", err)
+			}
+		}
+	}()
+}
+
+// signing returns the key that signs new tokens
+func (r *KeyRing) signing() (signingKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.keys) == 0 {
+		return signingKey{}, errors.New("no signing key")
+	}
+	return r.keys[0], nil
+}
+
+// publicKey returns the public key for a key ID, if it still verifies
+// tokens. An unknown ID may belong to a key another server has just rotated
+// in, so the directory is read again, at most every few seconds.
+func (r *KeyRing) publicKey(kid string) (*rsa.PublicKey, error) {
+	key, err := r.lookup(kid)
+	if err == errUnknownKey {
+		r.mu.RLock()
+		stale := time.Since(r.loadedAt) > 10*time.Second
+		r.mu.RUnlock()
+		if stale {
+			if err := r.Reload(); err != nil {
+				return nil, err
+			}
+			key, err = r.lookup(kid)
+		}
+	}
+	return key, err
+}
+
+func (r *KeyRing) lookup(kid string) (*rsa.PublicKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, k := range r.keys {
+		if k.kid != kid {
+			continue
+		}
+		if !k.verifyUntil.IsZero() && time.Now().After(k.verifyUntil) {
+			return nil, fmt.Errorf("signing key %q has been retired", kid)
+		}
+		return &k.key.PublicKey, nil
+	}
+	return nil, errUnknownKey
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS returns the public keys that currently verify tokens, for publishing
+// as a JSON Web Key Set
+func (r *KeyRing) JWKS() []JWK {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := []JWK{}
+	now := time.Now()
+	for _, k := range r.keys {
+		if !k.verifyUntil.IsZero() && now.After(k.verifyUntil) {
+			continue
+		}
+		keys = append(keys, JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: "RS256",
+			Kid: k.kid,
+			N:   base64.RawURLEncoding.EncodeToString(k.key.PublicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.PublicKey.E)).Bytes()),
+		})
+	}
+	return keys
+}
@@ -0,0 +1,157 @@
+// Package token issues and validates the JWTs used for API access and for
+// the second step of login. It has no dependency on the HTTP layer or the
+// database, so it can be used and tested on its own.
+package token
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalid is returned, wrapped, for any token that fails validation
+var ErrInvalid = errors.New("invalid token")
+
+// AccessClaims are carried by access tokens
+type AccessClaims struct {
+	UserID    primitive.ObjectID `json:"userId"`
+	Username  string             `json:"username"`
+	UserType  string             `json:"userType"`
+	SessionID primitive.ObjectID `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// ChallengeClaims identify a user who has passed the password step of login
+// but still has to complete a second factor
+type ChallengeClaims struct {
+	UserID  primitive.ObjectID `json:"userId"`
+	Purpose string             `json:"purpose"` // mfa or mfa_enroll
+	jwt.RegisteredClaims
+}
+
+// Config describes the tokens a Manager issues and accepts
+type Config struct {
+	Issuer    string        // iss of issued tokens; required when validating
+	Audience  string        // aud of issued tokens; required when validating
+	AccessTTL time.Duration // lifetime of access tokens
+	ClockSkew time.Duration // leeway for exp, nbf and iat
+	Now       func() time.Time
+}
+
+// Manager signs tokens with a key ring and validates them
+type Manager struct {
+	keys   *KeyRing
+	config Config
+}
+
+// NewManager returns a Manager using keys for signing and verification
+func NewManager(keys *KeyRing, config Config) *Manager {
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+	return &Manager{keys: keys, config: config}
+}
+
+// Keys returns the manager's key ring
+func (m *Manager) Keys() *KeyRing {
+	return m.keys
+}
+
+// AccessTTL returns the lifetime of access tokens
+func (m *Manager) AccessTTL() time.Duration {
+	return m.config.AccessTTL
+}
+
+func (m *Manager) registered(subject primitive.ObjectID, ttl time.Duration) jwt.RegisteredClaims {
+	now := m.config.Now()
+	return jwt.RegisteredClaims{
+		Issuer:    m.config.Issuer,
+		Subject:   subject.Hex(),
+		Audience:  jwt.ClaimStrings{m.config.Audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+}
+
+// sign signs claims with the ring's current key, naming it in the kid header
+func (m *Manager) sign(claims jwt.Claims) (string, error) {
+	key, err := m.keys.signing()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.key)
+}
+
+// parse verifies a token's signature, issuer, audience and times. Only RS256
+// is accepted so that a token cannot choose a weaker algorithm.
+func (m *Manager) parse(raw string, claims jwt.Claims) error {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(m.config.Issuer),
+		jwt.WithAudience(m.config.Audience),
+		jwt.WithLeeway(m.config.ClockSkew),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithTimeFunc(m.config.Now),
+	)
+	_, err := parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return m.keys.publicKey(kid)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return nil
+}
+
+// IssueAccess signs an access token for a session
+func (m *Manager) IssueAccess(userID primitive.ObjectID, username, userType string, sessionID primitive.ObjectID) (string, error) {
+	return m.sign(AccessClaims{
+		UserID:           userID,
+		Username:         username,
+		UserType:         userType,
+		SessionID:        sessionID,
+		RegisteredClaims: m.registered(userID, m.config.AccessTTL),
+	})
+}
+
+// ParseAccess validates an access token and returns its claims
+func (m *Manager) ParseAccess(raw string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	if err := m.parse(raw, claims); err != nil {
+		return nil, err
+	}
+	if claims.UserID.IsZero() || claims.SessionID.IsZero() || claims.Subject != claims.UserID.Hex() {
+		return nil, fmt.Errorf("%w: not an access token", ErrInvalid)
+	}
+	return claims, nil
+}
+
+// IssueChallenge signs a short-lived token for the second step of login
+func (m *Manager) IssueChallenge(userID primitive.ObjectID, purpose string, ttl time.Duration) (string, error) {
+	return m.sign(ChallengeClaims{
+		UserID:           userID,
+		Purpose:          purpose,
+		RegisteredClaims: m.registered(userID, ttl),
+	})
+}
+
+// ParseChallenge validates a challenge token for the given purpose and
+// returns the user it was issued to
+func (m *Manager) ParseChallenge(raw, purpose string) (primitive.ObjectID, error) {
+	claims := &ChallengeClaims{}
+	if err := m.parse(raw, claims); err != nil {
+		return primitive.NilObjectID, err
+	}
+	if purpose == "" || claims.Purpose != purpose || claims.UserID.IsZero() {
+		return primitive.NilObjectID, fmt.Errorf("%w: not a %s challenge", ErrInvalid, purpose)
+	}
+	return claims.UserID, nil
+}
@@ -0,0 +1,278 @@
+package token
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var testConfig = Config{
+	Issuer:    "classscheduling",
+	Audience:  "classscheduling-api",
+	AccessTTL: 15 * time.Minute,
+	ClockSkew: 30 * time.Second,
+}
+
+// newTestRing returns a key ring with one signing key in a temporary directory
+func newTestRing(t *testing.T, retention time.Duration) *KeyRing {
+	t.Helper()
+	ring, err := OpenKeyRing(t.TempDir(), retention)
+	if err != nil {
+		t.Fatalf("OpenKeyRing: %v", err)
+	}
+	if err := ring.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	return ring
+}
+
+// at returns a copy of config whose clock is fixed at now
+func at(config Config, now time.Time) Config {
+	config.Now = func() time.Time { return now }
+	return config
+}
+
+func issueAccess(t *testing.T, m *Manager) (string, primitive.ObjectID) {
+	t.Helper()
+	userID := primitive.NewObjectID()
+	raw, err := m.IssueAccess(userID, "alice", "student", primitive.NewObjectID())
+	if err != nil {
+		t.Fatalf("IssueAccess: %v", err)
+	}
+	return raw, userID
+}
+
+func TestAccessRoundTrip(t *testing.T) {
+	m := NewManager(newTestRing(t, time.Hour), testConfig)
+	raw, userID := issueAccess(t, m)
+
+	claims, err := m.ParseAccess(raw)
+	if err != nil {
+		t.Fatalf("ParseAccess: %v", err)
+	}
+	if claims.UserID != userID || claims.Username != "alice" || claims.UserType != "student" {
+		t.Errorf("claims = %+v", claims)
+	}
+	if _, err := m.ParseChallenge(raw, "mfa"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("an access token was accepted as a challenge: %v", err)
+	}
+}
+
+func TestIssuerAndAudienceMismatch(t *testing.T) {
+	ring := newTestRing(t, time.Hour)
+	raw, _ := issueAccess(t, NewManager(ring, testConfig))
+
+	otherIssuer := testConfig
+	otherIssuer.Issuer = "someone-else"
+	otherAudience := testConfig
+	otherAudience.Audience = "another-api"
+
+	for name, config := range map[string]Config{"issuer": otherIssuer, "audience": otherAudience} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewManager(ring, config).ParseAccess(raw); !errors.Is(err, ErrInvalid) {
+				t.Fatalf("ParseAccess = %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
+
+func TestExpiryAndLeeway(t *testing.T) {
+	ring := newTestRing(t, time.Hour)
+	issued := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	raw, _ := issueAccess(t, NewManager(ring, at(testConfig, issued)))
+	expires := issued.Add(testConfig.AccessTTL)
+
+	tests := []struct {
+		name  string
+		now   time.Time
+		valid bool
+	}{
+		{"fresh", issued.Add(time.Minute), true},
+		{"expired within leeway", expires.Add(20 * time.Second), true},
+		{"expired past leeway", expires.Add(31 * time.Second), false},
+		{"issued ahead within leeway", issued.Add(-20 * time.Second), true},
+		{"issued ahead past leeway", issued.Add(-31 * time.Second), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewManager(ring, at(testConfig, tt.now)).ParseAccess(raw)
+			if tt.valid && err != nil {
+				t.Fatalf("ParseAccess: %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalid) {
+				t.Fatalf("ParseAccess = %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
+
+func TestMissingExpiration(t *testing.T) {
+	m := NewManager(newTestRing(t, time.Hour), testConfig)
+	userID := primitive.NewObjectID()
+	now := time.Now()
+
+	// Correctly signed, but never expires
+	raw, err := m.sign(jwt.MapClaims{
+		"iss":    testConfig.Issuer,
+		"aud":    testConfig.Audience,
+		"sub":    userID.Hex(),
+		"iat":    now.Unix(),
+		"userId": userID.Hex(),
+		"sid":    primitive.NewObjectID().Hex(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ParseAccess(raw); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("ParseAccess = %v, want ErrInvalid for a token without exp", err)
+	}
+}
+
+func TestRejectsOtherAlgorithms(t *testing.T) {
+	ring := newTestRing(t, time.Hour)
+	m := NewManager(ring, testConfig)
+	key, err := ring.signing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := primitive.NewObjectID()
+	claims := AccessClaims{
+		UserID:           userID,
+		SessionID:        primitive.NewObjectID(),
+		RegisteredClaims: m.registered(userID, time.Minute),
+	}
+
+	// HS256 keyed with the public key, which anyone can fetch from the JWKS
+	public, err := x509.MarshalPKIXPublicKey(&key.key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hs.Header["kid"] = key.kid
+	hsRaw, err := hs.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	none.Header["kid"] = key.kid
+	noneRaw, err := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, raw := range map[string]string{"HS256": hsRaw, "none": noneRaw} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := m.ParseAccess(raw); !errors.Is(err, ErrInvalid) {
+				t.Fatalf("ParseAccess = %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	ring := newTestRing(t, time.Hour)
+	m := NewManager(ring, testConfig)
+	before, _ := issueAccess(t, m)
+	previous, _ := ring.signing()
+
+	if err := ring.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	current, _ := ring.signing()
+	if current.kid == previous.kid {
+		t.Fatal("Rotate did not change the signing key")
+	}
+
+	if _, err := m.ParseAccess(before); err != nil {
+		t.Fatalf("token signed with the previous key: %v", err)
+	}
+	after, _ := issueAccess(t, m)
+	parsed, _, err := jwt.NewParser().ParseUnverified(after, &AccessClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header["kid"] != current.kid {
+		t.Errorf("new token signed with %v, want %s", parsed.Header["kid"], current.kid)
+	}
+	if _, err := m.ParseAccess(after); err != nil {
+		t.Fatalf("token signed with the new key: %v", err)
+	}
+}
+
+func TestRotationRetiresPreviousKey(t *testing.T) {
+	// Without retention the previous key stops verifying as soon as it is
+	// replaced
+	ring := newTestRing(t, 0)
+	m := NewManager(ring, testConfig)
+	before, _ := issueAccess(t, m)
+
+	if err := ring.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if _, err := m.ParseAccess(before); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("ParseAccess = %v, want ErrInvalid for a retired key", err)
+	}
+}
+
+func TestSigningKeyIgnoresFileTimes(t *testing.T) {
+	ring := newTestRing(t, time.Hour)
+	if err := ring.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	current, _ := ring.signing()
+	previous := ring.keys[1]
+
+	// A copy or restore can leave the older key with the newer file time
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(ring.dir, previous.kid+".pem"), later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenKeyRing(ring.dir, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenKeyRing: %v", err)
+	}
+	if got, _ := reopened.signing(); got.kid != current.kid {
+		t.Fatalf("signing key = %s, want %s", got.kid, current.kid)
+	}
+}
+
+func TestKeyWithoutCreatedHeader(t *testing.T) {
+	ring := newTestRing(t, time.Hour)
+	generated, _ := ring.signing()
+
+	// A key added by hand is ordered by the timestamp in its ID
+	der, err := x509.MarshalPKCS8PrivateKey(generated.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid := time.Now().Add(time.Hour).UTC().Format(kidFormat) + "-manual"
+	if err := os.WriteFile(filepath.Join(ring.dir, kid+".pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got, _ := ring.signing(); got.kid != kid {
+		t.Fatalf("signing key = %s, want the newer manual key %s", got.kid, kid)
+	}
+
+	// Without a timestamp it sorts as the oldest
+	if err := os.Rename(filepath.Join(ring.dir, kid+".pem"), filepath.Join(ring.dir, "manual.pem")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got, _ := ring.signing(); got.kid != generated.kid {
+		t.Fatalf("signing key = %s, want %s", got.kid, generated.kid)
+	}
+}
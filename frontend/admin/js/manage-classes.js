@@ -22,8 +22,8 @@ async function loadClasses() {
             throw new Error('Failed to load classes');
         }
 
-        const classes = await response.json();
-        displayClasses(classes);
+        const page = await response.json();
+        displayClasses(page.items);
     } catch (error) {
         showError('Error loading classes: ' + error.message);
         if (error.message === 'Not authenticated') {
@@ -176,15 +176,26 @@ async function updateClass(classId, classData) {
 
 async function deleteClass(classId) {
     try {
-        if (!confirm('Are you sure you want to delete this class?')) {
-            return;
-        }
-
         const token = localStorage.getItem('token');
         if (!token) {
             throw new Error('Not authenticated');
         }
-        const response = await fetch(`${API_URL}/classes/${classId}`, {
+
+        // Show what the delete affects before asking for confirmation
+        const impactResponse = await fetch(`${API_URL}/classes/${classId}/delete-impact`, {
+            headers: {
+                'Authorization': `Bearer ${token}`
+            }
+        });
+        if (!impactResponse.ok) {
+            throw new Error('Failed to check what the delete affects');
+        }
+        const { impact } = await impactResponse.json();
+        if (!confirm(`Delete this class? ${impact.enrolled} enrolled students will lose it from their schedule, and its ${impact.attendance} attendance records, ${impact.performance} grades and ${impact.remarks} remarks will be hidden until it is restored.`)) {
+            return;
+        }
+
+        const response = await fetch(`${API_URL}/classes/${classId}?confirm=true`, {
             method: 'DELETE',
             headers: {
                 'Authorization': `Bearer ${token}`,
@@ -215,7 +226,7 @@ async function loadFaculties() {
         if (!token) {
             throw new Error('Not authenticated');
         }
-        const response = await fetch(`${API_URL}/users?type=faculty`, {
+        const response = await fetch(`${API_URL}/users?type=faculty&limit=500`, {
             headers: {
                 'Authorization': `Bearer ${token}`,
                 'Content-Type': 'application/json'
@@ -229,8 +240,8 @@ async function loadFaculties() {
             throw new Error('Failed to load faculties');
         }
 
-        const faculties = await response.json();
-        populateFacultyDropdown(faculties);
+        const page = await response.json();
+        populateFacultyDropdown(page.items);
     } catch (error) {
         showError('Error loading faculties: ' + error.message);
         if (error.message === 'Not authenticated') {
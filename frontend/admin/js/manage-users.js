@@ -22,8 +22,8 @@ async function loadUsers() {
             throw new Error('Failed to load users');
         }
 
-        const users = await response.json();
-        displayUsers(users);
+        const page = await response.json();
+        displayUsers(page.items);
     } catch (error) {
         showError('Error loading users: ' + error.message);
         if (error.message === 'Not authenticated') {
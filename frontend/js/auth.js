@@ -68,25 +68,14 @@ async function handleLogin(event) {
                 throw new Error(data.error || 'Invalid credentials');
             }
 
-            // Store token and user info
-            localStorage.setItem('token', data.token);
-            localStorage.setItem('currentUser', JSON.stringify(data.user));
-
-            const basePath = getBasePath();
-            // Redirect based on user type
-            switch(data.user.userType) {
-                case 'admin':
-                    window.location.href = basePath + 'admin-dashboard.html';
-                    break;
-                case 'faculty':
-                    window.location.href = basePath + 'faculty-dashboard.html';
-                    break;
-                case 'student':
-                    window.location.href = basePath + 'student-dashboard.html';
-                    break;
-                default:
-                    throw new Error('Invalid user type');
+            // Accounts with two-factor authentication need a second step
+            if (data.mfaRequired) {
+                data = await verifyTwoFactor(data.challengeToken);
+            } else if (data.mfaEnrollmentRequired) {
+                throw new Error('Your account requires two-factor authentication. Please contact an administrator to enroll.');
             }
+
+            completeLogin(data);
         } catch (networkError) {
             // Check if it's a network/server connection error
             if (!navigator.onLine) {
@@ -118,6 +107,59 @@ async function handleLogin(event) {
     }
 }
 
+// Store the session and go to the user's dashboard
+function completeLogin(data) {
+    localStorage.setItem('token', data.token);
+    localStorage.setItem('refreshToken', data.refreshToken);
+    localStorage.setItem('currentUser', JSON.stringify(data.user));
+
+    const basePath = getBasePath();
+    // Redirect based on user type
+    switch(data.user.userType) {
+        case 'admin':
+            window.location.href = basePath + 'admin-dashboard.html';
+            break;
+        case 'faculty':
+            window.location.href = basePath + 'faculty-dashboard.html';
+            break;
+        case 'student':
+            window.location.href = basePath + 'student-dashboard.html';
+            break;
+        case 'guardian':
+            window.location.href = basePath + 'guardian-dashboard.html';
+            break;
+        default:
+            throw new Error('Invalid user type');
+    }
+}
+
+// Show a sign-in button for each single sign-on provider
+async function loadSSOProviders() {
+    const container = document.getElementById('sso-providers');
+    if (!container) {
+        return;
+    }
+    try {
+        const response = await fetch(`${API_URL}/auth/sso/providers`);
+        if (!response.ok) {
+            return;
+        }
+        const providers = await response.json();
+        providers.forEach(provider => {
+            const link = document.createElement('a');
+            link.href = API_URL.replace(/\/api$/, '') + provider.loginUrl;
+            link.className = 'block w-full py-3 px-4 rounded-lg border border-gray-300 text-center font-medium text-gray-700 hover:bg-gray-50 transition-colors duration-200';
+            link.textContent = `Sign in with ${provider.name.toUpperCase()}`;
+            container.appendChild(link);
+        });
+        container.classList.toggle('hidden', providers.length === 0);
+    } catch (e) {
+        // Single sign-on is optional; the password form still works
+    }
+}
+
+loadSSOProviders();
+
 // Check authentication status
 function checkAuth() {
     const token = localStorage.getItem('token');
@@ -131,9 +173,76 @@ function checkAuth() {
     return currentUser;
 }
 
+// Ask for an authenticator or recovery code and finish logging in
+async function verifyTwoFactor(challengeToken) {
+    const code = prompt('Enter the 6-digit code from your authenticator app, or a recovery code:');
+    if (!code) {
+        throw new Error('Two-factor code is required');
+    }
+
+    const body = /^\d{6}$/.test(code.trim())
+        ? { challengeToken, code: code.trim() }
+        : { challengeToken, recoveryCode: code.trim() };
+
+    const response = await fetch(`${API_URL}/auth/2fa/verify`, {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify(body)
+    });
+
+    const data = await response.json();
+    if (!response.ok) {
+        throw new Error(data.error || 'Invalid two-factor code');
+    }
+    return data;
+}
+
+// Exchange the stored refresh token for a new token pair
+async function refreshAccessToken() {
+    const refreshToken = localStorage.getItem('refreshToken');
+    if (!refreshToken) {
+        return false;
+    }
+
+    try {
+        const response = await fetch(`${API_URL}/auth/refresh`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ refreshToken })
+        });
+        if (!response.ok) {
+            return false;
+        }
+        const data = await response.json();
+        localStorage.setItem('token', data.token);
+        localStorage.setItem('refreshToken', data.refreshToken);
+        return true;
+    } catch (e) {
+        return false;
+    }
+}
+
 // Logout function
-function logout() {
+async function logout() {
+    const token = localStorage.getItem('token');
+    if (token) {
+        try {
+            await fetch(`${API_URL}/auth/logout`, {
+                method: 'POST',
+                headers: {
+                    'Authorization': `Bearer ${token}`
+                }
+            });
+        } catch (e) {
+            // Clear local state even if the server cannot be reached
+        }
+    }
     localStorage.removeItem('token');
+    localStorage.removeItem('refreshToken');
     localStorage.removeItem('currentUser');
     window.location.href = getBasePath() + 'index.html';
 }
@@ -157,10 +266,17 @@ document.getElementById('userType')?.addEventListener('change', function() {
 });
 
 // Check authentication on page load if not on login page
-if (!window.location.pathname.includes('index.html') && !window.location.pathname.includes('signup.html')) {
+if (!window.location.pathname.includes('index.html') && !window.location.pathname.includes('signup.html') && !window.location.pathname.includes('sso-callback.html')) {
     document.addEventListener('DOMContentLoaded', function() {
         const currentUser = checkAuth();
         if (currentUser) {
+            // Access tokens are short-lived; refresh them before they expire
+            setInterval(async () => {
+                if (!(await refreshAccessToken())) {
+                    logout();
+                }
+            }, 10 * 60 * 1000);
+
             const currentPage = window.location.pathname.split('/').pop();
             // Only force redirect if user is on a dashboard page of the wrong type
             if (currentPage === 'admin-dashboard.html' && currentUser.userType !== 'admin') {
@@ -169,6 +285,8 @@ if (!window.location.pathname.includes('index.html') && !window.location.pathnam
                 window.location.href = `${currentUser.userType}-dashboard.html`;
             } else if (currentPage === 'student-dashboard.html' && currentUser.userType !== 'student') {
                 window.location.href = `${currentUser.userType}-dashboard.html`;
+            } else if (currentPage === 'guardian-dashboard.html' && currentUser.userType !== 'guardian') {
+                window.location.href = `${currentUser.userType}-dashboard.html`;
             }
         }
     });
@@ -0,0 +1,174 @@
+// At-risk students panel shared by the faculty and admin dashboards. The
+// page defines API_URL and calls initRiskPanel with the base path of its
+// risk endpoints, /faculty/risk or /admin/risk.
+let riskBasePath = null;
+let riskFlags = [];
+
+const riskLevelStyles = {
+    high: 'bg-red-100 text-red-700 border-red-200',
+    medium: 'bg-yellow-100 text-yellow-700 border-yellow-200',
+    low: 'bg-gray-100 text-gray-700 border-gray-200'
+};
+
+function initRiskPanel(basePath) {
+    riskBasePath = basePath;
+    document.getElementById('riskStatusFilter').addEventListener('change', loadRiskFlags);
+    document.getElementById('riskEvaluateBtn').addEventListener('click', evaluateRisk);
+    document.getElementById('interventionForm').addEventListener('submit', recordIntervention);
+    loadRiskFlags();
+}
+
+async function riskRequest(path, options = {}) {
+    const token = localStorage.getItem('token');
+    if (!token) {
+        window.location.href = 'index.html';
+        throw new Error('Not authenticated');
+    }
+    const response = await fetch(`${API_URL}${riskBasePath}${path}`, {
+        ...options,
+        headers: {
+            'Authorization': `Bearer ${token}`,
+            'Content-Type': 'application/json'
+        }
+    });
+    if (response.status === 401) {
+        window.location.href = 'index.html';
+        throw new Error('Not authenticated');
+    }
+    const data = await response.json().catch(() => ({}));
+    if (!response.ok) {
+        throw new Error(data.error || 'Request failed');
+    }
+    return data;
+}
+
+async function loadRiskFlags() {
+    const status = document.getElementById('riskStatusFilter').value;
+    try {
+        riskFlags = await riskRequest(status ? `?status=${status}` : '');
+        displayRiskFlags();
+    } catch (error) {
+        showError('Error loading at-risk students: ' + error.message);
+    }
+}
+
+async function evaluateRisk() {
+    try {
+        const result = await riskRequest('/evaluate', { method: 'POST' });
+        showSuccess(`Evaluated ${result.evaluated} students`);
+        loadRiskFlags();
+    } catch (error) {
+        showError('Error evaluating risk: ' + error.message);
+    }
+}
+
+function displayRiskFlags() {
+    const container = document.getElementById('riskContainer');
+    container.innerHTML = '';
+    if (!riskFlags.length) {
+        container.innerHTML = '<p class="text-gray-500">No students are flagged</p>';
+        return;
+    }
+
+    for (const flag of riskFlags) {
+        const card = document.createElement('div');
+        card.className = `p-4 rounded-lg border ${riskLevelStyles[flag.level] || riskLevelStyles.low}`;
+
+        const header = document.createElement('div');
+        header.className = 'flex justify-between items-start gap-2';
+        const who = document.createElement('div');
+        const name = document.createElement('p');
+        name.className = 'font-semibold';
+        name.textContent = flag.student.rollNumber
+            ? `${flag.student.username} (${flag.student.rollNumber})`
+            : flag.student.username;
+        const className = document.createElement('p');
+        className.className = 'text-sm';
+        className.textContent = flag.class.name;
+        who.append(name, className);
+        const badge = document.createElement('span');
+        badge.className = 'text-xs font-medium uppercase whitespace-nowrap';
+        badge.textContent = `${flag.level} · ${Math.round(flag.score)} · ${flag.status}`;
+        header.append(who, badge);
+
+        // Factors are sorted by their contribution to the score
+        const factors = document.createElement('ul');
+        factors.className = 'mt-2 text-sm list-disc list-inside';
+        for (const factor of flag.factors || []) {
+            const item = document.createElement('li');
+            item.textContent = `${factor.description} (+${Math.round(factor.points)})`;
+            factors.appendChild(item);
+        }
+
+        card.append(header, factors);
+
+        const interventions = flag.interventions || [];
+        if (interventions.length) {
+            const history = document.createElement('ul');
+            history.className = 'mt-2 text-xs text-gray-600 space-y-1';
+            for (const intervention of interventions) {
+                const item = document.createElement('li');
+                item.textContent = `${new Date(intervention.createdAt).toLocaleDateString()} ${intervention.type}: ${intervention.note}`;
+                history.appendChild(item);
+            }
+            card.appendChild(history);
+        }
+
+        const actions = document.createElement('div');
+        actions.className = 'mt-3 flex gap-2';
+        if (flag.status === 'open') {
+            const acknowledge = document.createElement('button');
+            acknowledge.className = 'text-sm px-3 py-1 rounded bg-white border hover:bg-gray-50';
+            acknowledge.textContent = 'Acknowledge';
+            acknowledge.addEventListener('click', () => acknowledgeRiskFlag(flag._id));
+            actions.appendChild(acknowledge);
+        }
+        const intervene = document.createElement('button');
+        intervene.className = 'text-sm px-3 py-1 rounded bg-white border hover:bg-gray-50';
+        intervene.textContent = 'Record Intervention';
+        intervene.addEventListener('click', () => showInterventionModal(flag._id));
+        actions.appendChild(intervene);
+        card.appendChild(actions);
+
+        container.appendChild(card);
+    }
+}
+
+async function acknowledgeRiskFlag(flagId) {
+    try {
+        await riskRequest(`/${flagId}/acknowledge`, { method: 'POST' });
+        showSuccess('Flag acknowledged');
+        loadRiskFlags();
+    } catch (error) {
+        showError('Error acknowledging flag: ' + error.message);
+    }
+}
+
+function showInterventionModal(flagId) {
+    document.getElementById('interventionForm').reset();
+    document.getElementById('interventionFlagId').value = flagId;
+    document.getElementById('interventionModal').classList.remove('hidden');
+}
+
+function hideInterventionModal() {
+    document.getElementById('interventionModal').classList.add('hidden');
+}
+
+async function recordIntervention(event) {
+    event.preventDefault();
+    const flagId = document.getElementById('interventionFlagId').value;
+    try {
+        await riskRequest(`/${flagId}/interventions`, {
+            method: 'POST',
+            body: JSON.stringify({
+                type: document.getElementById('interventionType').value,
+                note: document.getElementById('interventionNote').value.trim()
+            })
+        });
+        hideInterventionModal();
+        showSuccess('Intervention recorded');
+        loadRiskFlags();
+    } catch (error) {
+        showError('Error recording intervention: ' + error.message);
+    }
+}
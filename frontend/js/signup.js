@@ -78,8 +78,16 @@ async function handleSignup(event) {
                 throw new Error(data.error || 'Signup failed. Please try again.');
             }
 
+            // Accounts that must verify their email first get no token yet
+            if (!data.token) {
+                successElement.textContent = data.message;
+                successElement.classList.remove('hidden');
+                return;
+            }
+
             // Store token and user info
             localStorage.setItem('token', data.token);
+            localStorage.setItem('refreshToken', data.refreshToken);
             localStorage.setItem('currentUser', JSON.stringify(data.user));
 
             successElement.textContent = 'Signup successful! Redirecting...';
@@ -97,6 +105,9 @@ async function handleSignup(event) {
                     case 'student':
                         window.location.href = 'student-dashboard.html';
                         break;
+                    case 'guardian':
+                        window.location.href = 'guardian-dashboard.html';
+                        break;
                     default:
                         window.location.href = 'index.html';
                 }